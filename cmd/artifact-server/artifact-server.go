@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Kubeless artifact server binary.
+//
+// See github.com/kubeless/kubeless/pkg/artifacts
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/kubeless/kubeless/pkg/artifacts"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/kubeless/kubeless/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	globalUsage = `Stores the code of the functions. Artifacts are uploaded by the kubeless CLI and
+downloaded by the function pods. They can be saved in a directory (usually a mounted
+PersistentVolumeClaim) or in an S3 compatible bucket. The S3 credentials are read from
+the environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. Only the service
+accounts of a namespace can read and write its artifacts. The artifacts that are not
+used by any function nor revision are periodically removed.`
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "kubeless-artifact-server",
+	Short: "Kubeless artifact server",
+	Long:  globalUsage,
+	Run: func(cmd *cobra.Command, args []string) {
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		storeType, err := cmd.Flags().GetString("store")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		maxSize, err := cmd.Flags().GetInt64("max-size")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		gcInterval, err := cmd.Flags().GetDuration("gc-interval")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		gcMinAge, err := cmd.Flags().GetDuration("gc-min-age")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		var store artifacts.Store
+		switch storeType {
+		case "pvc":
+			dir, err := cmd.Flags().GetString("dir")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			store, err = artifacts.NewFileStore(dir)
+			if err != nil {
+				logrus.Fatalf("Unable to use directory %s: %v", dir, err)
+			}
+		case "s3":
+			endpoint, err := cmd.Flags().GetString("s3-endpoint")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			bucket, err := cmd.Flags().GetString("s3-bucket")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			region, err := cmd.Flags().GetString("s3-region")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			store, err = artifacts.NewS3Store(endpoint, bucket, region, credentials.NewEnvCredentials())
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		default:
+			logrus.Fatalf("Unknown store %s. Supported stores are pvc and s3", storeType)
+		}
+
+		kubeClient := utils.GetClient()
+		if gcInterval > 0 {
+			kubelessClient, err := utils.GetFunctionClientInCluster()
+			if err != nil {
+				logrus.Fatalf("Unable to get the functions client: %v", err)
+			}
+			go func() {
+				for range time.Tick(gcInterval) {
+					referenced, err := utils.ReferencedArtifacts(kubeClient, kubelessClient)
+					if err != nil {
+						logrus.Errorf("Unable to list the artifacts of the functions: %v", err)
+						continue
+					}
+					removed, err := artifacts.CollectGarbage(store, referenced, gcMinAge)
+					if err != nil {
+						logrus.Errorf("Unable to remove the unused artifacts: %v", err)
+					}
+					if removed > 0 {
+						logrus.Infof("Removed %d unused artifacts", removed)
+					}
+				}
+			}()
+		}
+
+		logrus.Infof("Serving artifacts from the %s store in port %d", storeType, port)
+		server := artifacts.NewServer(store, maxSize, &artifacts.TokenReviewAuthorizer{Client: kubeClient})
+		logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), server))
+	},
+}
+
+func init() {
+	rootCmd.Flags().Int("port", 8080, "Port in which the artifacts are served")
+	rootCmd.Flags().String("store", "pvc", "Where the artifacts are saved: pvc or s3")
+	rootCmd.Flags().String("dir", "/artifacts", "Directory in which the artifacts are saved when using the pvc store")
+	rootCmd.Flags().String("s3-endpoint", "https://s3.amazonaws.com", "URL of the S3 compatible service")
+	rootCmd.Flags().String("s3-bucket", "", "Bucket in which the artifacts are saved")
+	rootCmd.Flags().String("s3-region", "us-east-1", "Region of the bucket")
+	rootCmd.Flags().Int64("max-size", artifacts.DefaultMaxSize, "Maximum size of an artifact in bytes")
+	rootCmd.Flags().Duration("gc-interval", time.Hour, "How often the artifacts that are not used by any function are removed. 0 disables it")
+	rootCmd.Flags().Duration("gc-min-age", time.Hour, "Minimum age of an artifact to be removed, so the artifacts of the functions being deployed are kept")
+}
+
+func main() {
+	logrus.Infof("Running Kubeless artifact server version: %v", version.Version)
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Kubeless activator binary.
+//
+// See github.com/kubeless/kubeless/pkg/activator
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kubeless/kubeless/pkg/activator"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/kubeless/kubeless/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	globalUsage = `Receives the requests of the functions that have been scaled to zero,
+activates them and forwards the requests once the functions are ready.`
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "kubeless-function-activator",
+	Short: "Kubeless function activator",
+	Long:  globalUsage,
+	Run: func(cmd *cobra.Command, args []string) {
+		activationTimeout, err := cmd.Flags().GetDuration("activation-timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		syncPeriod, err := cmd.Flags().GetDuration("sync-period")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		healthPort, err := cmd.Flags().GetInt("health-port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		a := activator.NewActivator(utils.GetClient(), activationTimeout, syncPeriod)
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		go a.Run(stopCh)
+
+		http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		go func() {
+			logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", healthPort), nil))
+		}()
+
+		sigterm := make(chan os.Signal, 1)
+		signal.Notify(sigterm, syscall.SIGTERM)
+		signal.Notify(sigterm, syscall.SIGINT)
+		<-sigterm
+	},
+}
+
+func init() {
+	rootCmd.Flags().Duration("activation-timeout", 2*time.Minute, "Maximum time to wait for a function to be ready")
+	rootCmd.Flags().Duration("sync-period", 2*time.Second, "Time between checks of the functions scaled to zero")
+	rootCmd.Flags().Int("health-port", 8080, "Port of the health endpoint")
+}
+
+func main() {
+	logrus.Infof("Running Kubeless function activator version: %v", version.Version)
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -20,23 +20,31 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	monitoringv1alpha1 "github.com/coreos/prometheus-operator/pkg/client/monitoring/v1alpha1"
 	"github.com/kubeless/kubeless/pkg/controller"
+	"github.com/kubeless/kubeless/pkg/langruntime"
 	"github.com/kubeless/kubeless/pkg/utils"
 	"github.com/kubeless/kubeless/pkg/version"
+	"github.com/kubeless/kubeless/pkg/webhook"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
 	globalUsage = `` //TODO: adding explanation
 )
 
+// certCheckPeriod is how often the controller checks if the webhook certificate is about to expire
+const certCheckPeriod = 24 * time.Hour
+
 var rootCmd = &cobra.Command{
 	Use:   "kubeless-controller",
 	Short: "Kubeless controller",
@@ -63,11 +71,25 @@ var rootCmd = &cobra.Command{
 		}
 
 		functionController := controller.NewFunctionController(functionCfg, smclient)
+		sequenceController := controller.NewSequenceController(functionCfg)
 
 		stopCh := make(chan struct{})
 		defer close(stopCh)
 
 		go functionController.Run(stopCh)
+		go sequenceController.Run(stopCh)
+
+		webhookPort, err := cmd.Flags().GetInt("webhook-port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		webhookService, err := cmd.Flags().GetString("webhook-service")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if webhookPort > 0 {
+			startWebhook(functionCfg, webhookPort, webhookService)
+		}
 
 		sigterm := make(chan os.Signal, 1)
 		signal.Notify(sigterm, syscall.SIGTERM)
@@ -76,6 +98,52 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// startWebhook registers the admission and conversion webhooks for functions and serves them in the background
+func startWebhook(cfg controller.Config, port int, service string) {
+	apiExtensionsClientset := utils.GetAPIExtensionsClientInCluster()
+	config, err := utils.GetKubelessConfig(cfg.KubeCli, apiExtensionsClientset)
+	if err != nil {
+		logrus.Fatalf("Unable to read the configmap: %v", err)
+	}
+	lr := langruntime.New(config)
+	lr.ReadConfigMap()
+
+	namespace := config.ObjectMeta.Namespace
+	cert, caBundle, err := webhook.EnsureCertificate(cfg.KubeCli, namespace, service)
+	if err != nil {
+		logrus.Fatalf("Unable to get a certificate for the webhook: %v", err)
+	}
+	if err := webhook.RegisterWebhooks(cfg.KubeCli, namespace, service, caBundle); err != nil {
+		logrus.Fatalf("Unable to register the webhook: %v", err)
+	}
+	if err := webhook.RegisterConversion(apiExtensionsClientset, namespace, service, caBundle); err != nil {
+		// Clusters without support for conversion webhooks can still use the v1beta1 API
+		logrus.Warnf("Unable to register the conversion webhook of the function CRD: %v", err)
+	}
+	server := webhook.NewServer(lr)
+	go func() {
+		if err := server.ListenAndServeTLS(port, cert); err != nil {
+			logrus.Fatalf("Webhook server failed: %v", err)
+		}
+	}()
+	// The certificate is replaced before it expires and the webhooks are updated to trust the new one
+	go webhook.RenewCertificate(cfg.KubeCli, namespace, service, caBundle, certCheckPeriod, wait.NeverStop, func(cert tls.Certificate, caBundle []byte) {
+		logrus.Info("The webhook certificate has been renewed")
+		server.SetCertificate(cert)
+		if err := webhook.RegisterWebhooks(cfg.KubeCli, namespace, service, caBundle); err != nil {
+			logrus.Errorf("Unable to update the webhook configurations: %v", err)
+		}
+		if err := webhook.RegisterConversion(apiExtensionsClientset, namespace, service, caBundle); err != nil {
+			logrus.Warnf("Unable to update the conversion webhook of the function CRD: %v", err)
+		}
+	})
+}
+
+func init() {
+	rootCmd.Flags().Int("webhook-port", 8443, "Port of the admission webhook validating functions. Set it to 0 to disable the webhook")
+	rootCmd.Flags().String("webhook-service", "kubeless-controller-webhook", "Service in the controller namespace that exposes the admission webhook")
+}
+
 func main() {
 	logrus.Infof("Running Kubeless controller manager version: %v", version.Version)
 	if err := rootCmd.Execute(); err != nil {
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/kubeless/kubeless/pkg/utils"
 	"github.com/spf13/cobra"
 	"k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/api/core/v1"
@@ -83,6 +84,21 @@ func getHorizontalAutoscaleDefinition(name, ns, metric string, min, max int32, v
 		if err != nil {
 			return v2beta1.HorizontalPodAutoscaler{}, err
 		}
+	case "concurrency":
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return v2beta1.HorizontalPodAutoscaler{}, err
+		}
+		// Average number of requests being processed by each replica, reported by the function proxy
+		m = []v2beta1.MetricSpec{
+			{
+				Type: v2beta1.PodsMetricSourceType,
+				Pods: &v2beta1.PodsMetricSource{
+					MetricName:         "function_inflight_requests",
+					TargetAverageValue: q,
+				},
+			},
+		}
 	default:
 		return v2beta1.HorizontalPodAutoscaler{}, fmt.Errorf("metric %s is not supported", metric)
 	}
@@ -99,7 +115,7 @@ func getHorizontalAutoscaleDefinition(name, ns, metric string, min, max int32, v
 		},
 		Spec: v2beta1.HorizontalPodAutoscalerSpec{
 			ScaleTargetRef: v2beta1.CrossVersionObjectReference{
-				APIVersion: "apps/v1beta1",
+				APIVersion: utils.DeploymentAPIVersion,
 				Kind:       "Deployment",
 				Name:       name,
 			},
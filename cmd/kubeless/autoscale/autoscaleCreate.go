@@ -46,8 +46,8 @@ var autoscaleCreateCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		if metric != "cpu" && metric != "qps" {
-			logrus.Fatalf("only supported metrics: cpu, qps")
+		if metric != "cpu" && metric != "qps" && metric != "concurrency" {
+			logrus.Fatalf("only supported metrics: cpu, qps, concurrency")
 		}
 
 		value, err := cmd.Flags().GetString("value")
@@ -77,7 +77,7 @@ var autoscaleCreateCmd = &cobra.Command{
 func init() {
 	autoscaleCreateCmd.Flags().Int32("min", 1, "minimum number of replicas")
 	autoscaleCreateCmd.Flags().Int32("max", 1, "maximum number of replicas")
-	autoscaleCreateCmd.Flags().String("metric", "cpu", "metric to use for calculating the autoscale. Supported metrics: cpu, qps")
-	autoscaleCreateCmd.Flags().String("value", "", "value of the average of the metric across all replicas. If metric is cpu, value is a number represented as percentage. If metric is qps, value must be in format of Quantity")
+	autoscaleCreateCmd.Flags().String("metric", "cpu", "metric to use for calculating the autoscale. Supported metrics: cpu, qps, concurrency")
+	autoscaleCreateCmd.Flags().String("value", "", "value of the average of the metric across all replicas. If metric is cpu, value is a number represented as percentage. If metric is qps or concurrency, value must be in format of Quantity")
 	autoscaleCreateCmd.MarkFlagRequired("value")
 }
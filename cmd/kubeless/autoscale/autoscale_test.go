@@ -54,4 +54,15 @@ func TestGetHorizontalAutoscaleDefinition(t *testing.T) {
 		hpa.Spec.Metrics[0].Object.TargetValue.String() != "10" {
 		t.Error("Unexpected metric")
 	}
+
+	metric = "concurrency"
+	hpa, err = getHorizontalAutoscaleDefinition(funcName, ns, metric, min, max, value, labels)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if hpa.Spec.Metrics[0].Type != v2beta1.PodsMetricSourceType ||
+		hpa.Spec.Metrics[0].Pods.MetricName != "function_inflight_requests" ||
+		hpa.Spec.Metrics[0].Pods.TargetAverageValue.String() != "10" {
+		t.Error("Unexpected metric")
+	}
 }
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/artifacts"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// uploadTokenExpiration is the lifetime in seconds of the tokens used to upload functions (the minimum allowed)
+const uploadTokenExpiration = 600
+
+// functionContent returns the raw content of a function
+func functionContent(f *kubelessApi.Function) ([]byte, error) {
+	contentType := f.Spec.FunctionContentType
+	switch {
+	case strings.Contains(contentType, "url"):
+		return nil, fmt.Errorf("Functions downloaded from a URL can't be uploaded to the artifact store")
+	case strings.Contains(contentType, "base64"):
+		return base64.StdEncoding.DecodeString(f.Spec.Function)
+	case contentType == "text" || contentType == "":
+		return []byte(f.Spec.Function), nil
+	default:
+		return nil, fmt.Errorf("Unable to upload function of type %s", contentType)
+	}
+}
+
+// uploadToken returns a short-lived token of the default service account of a namespace. The
+// artifact server only accepts the tokens of the service accounts of the namespace of an artifact
+func uploadToken(cli kubernetes.Interface, namespace string) (string, error) {
+	expiration := int64(uploadTokenExpiration)
+	tr, err := cli.CoreV1().ServiceAccounts(namespace).CreateToken("default", &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expiration},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to get a token of the default service account of the namespace %s to upload the function: %v", namespace, err)
+	}
+	return tr.Status.Token, nil
+}
+
+// uploadFunction stores the content of a function in the artifact server and replaces it
+// with the key of the artifact. The server is reached through the API server proxy
+func uploadFunction(cli kubernetes.Interface, serverURL string, f *kubelessApi.Function) error {
+	if strings.Contains(f.Spec.FunctionContentType, "artifact") {
+		// Already uploaded
+		return nil
+	}
+	if serverURL == "" {
+		return fmt.Errorf("The artifact store is not enabled: artifact-server-url is not set in the Kubeless configuration")
+	}
+	svcNamespace, svcName, port, err := artifacts.ServiceFromURL(serverURL)
+	if err != nil {
+		return err
+	}
+	content, err := functionContent(f)
+	if err != nil {
+		return err
+	}
+	key, err := artifacts.Key(f.ObjectMeta.Namespace, f.ObjectMeta.Name, f.Spec.Checksum)
+	if err != nil {
+		return err
+	}
+	token, err := uploadToken(cli, f.ObjectMeta.Namespace)
+	if err != nil {
+		return err
+	}
+	err = cli.CoreV1().RESTClient().Put().
+		AbsPath(fmt.Sprintf("/api/v1/namespaces/%s/services/%s:%s/proxy%s%s", svcNamespace, svcName, port, artifacts.PathPrefix, key)).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetHeader(artifacts.TokenHeader, token).
+		Body(content).
+		Do().Error()
+	if err != nil {
+		return fmt.Errorf("Unable to upload the function to the artifact server: %v", err)
+	}
+	contentType := "artifact"
+	if strings.Contains(f.Spec.FunctionContentType, "zip") {
+		contentType += "+zip"
+	}
+	f.Spec.Function = key
+	f.Spec.FunctionContentType = contentType
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestUploadFunction(t *testing.T) {
+	content := []byte("PK zip content")
+	h := sha256.Sum256(content)
+	checksum := hex.EncodeToString(h[:])
+
+	var uploadedPath, uploadToken string
+	var uploaded []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/myns/serviceaccounts/default/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"kind": "TokenRequest", "apiVersion": "authentication.k8s.io/v1", "status": {"token": "sa-token"}}`))
+			return
+		}
+		if r.Method != "PUT" {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		uploadedPath = r.URL.Path
+		uploadToken = r.Header.Get("X-Kubeless-Token")
+		uploaded, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "myns"},
+		Spec: kubelessApi.FunctionSpec{
+			Function:            base64.StdEncoding.EncodeToString(content),
+			FunctionContentType: "base64+zip",
+			Checksum:            "sha256:" + checksum,
+		},
+	}
+	if err := uploadFunction(cli, "", f); err == nil {
+		t.Error("Expecting an error if the artifact server is not configured")
+	}
+	err = uploadFunction(cli, "http://kubeless-artifact-server.kubeless.svc.cluster.local:8080", f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedPath := "/api/v1/namespaces/kubeless/services/kubeless-artifact-server:8080/proxy/artifacts/myns/hello/" + checksum
+	if uploadedPath != expectedPath {
+		t.Errorf("Expecting upload to %s, received %s", expectedPath, uploadedPath)
+	}
+	if uploadToken != "sa-token" {
+		t.Errorf("The upload should include the token of the service account, received %q", uploadToken)
+	}
+	if string(uploaded) != string(content) {
+		t.Errorf("Unexpected content %q", uploaded)
+	}
+	if f.Spec.Function != "myns/hello/"+checksum || f.Spec.FunctionContentType != "artifact+zip" {
+		t.Errorf("Unexpected function spec %v", f.Spec)
+	}
+
+	// Functions from URLs can't be uploaded
+	f.Spec.Function = "https://example.com/hello.py"
+	f.Spec.FunctionContentType = "url"
+	if err := uploadFunction(cli, "http://kubeless-artifact-server.kubeless.svc.cluster.local:8080", f); err == nil {
+		t.Error("Expecting an error for a function from a URL")
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	layerbuilder "github.com/kubeless/kubeless/pkg/function-image-builder/layer-builder"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const defaultBuilderImage = "kubeless/function-image-builder:latest"
+
+var buildCmd = &cobra.Command{
+	Use:   "build <function_name> FLAG",
+	Short: "build the image of a function without deploying it",
+	Long: `Build the image of a function and push it to a registry. The function is prepared, its
+dependencies installed and compiled with the same steps that the controller uses when the build
+step is enabled. The steps are executed in a Job of the cluster or, with --local, in the local
+host using docker. The resulting image is printed so it can be deployed with --runtime-image.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - function name")
+		}
+		funcName := args[0]
+
+		runtime, err := cmd.Flags().GetString("runtime")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		handler, err := cmd.Flags().GetString("handler")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		file, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fromDir, err := cmd.Flags().GetString("from-dir")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if file != "" && fromDir != "" {
+			logrus.Fatal("The flags `--from-file` and `--from-dir` can't be used together")
+		}
+		if (file == "" && fromDir == "") || handler == "" || runtime == "" {
+			logrus.Fatal("The flags `--runtime`, `--handler` and `--from-file` or `--from-dir` are required")
+		}
+		deps, err := cmd.Flags().GetString("dependencies")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		image, err := cmd.Flags().GetString("image")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if image == "" {
+			logrus.Fatal("Need specify the image to push using the flag --image")
+		}
+		local, err := cmd.Flags().GetBool("local")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+		registrySecret, err := cmd.Flags().GetString("registry-secret")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		configFile, err := cmd.Flags().GetString("config-file")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		docker, err := cmd.Flags().GetString("docker")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		dockerConfig, err := cmd.Flags().GetString("docker-config")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		config, err := getLocalRunConfig(configFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		lr := langruntime.New(config)
+		lr.ReadConfigMap()
+		if !lr.IsValidRuntime(runtime) {
+			logrus.Fatalf("Invalid runtime: %s. Supported runtimes are: %s",
+				runtime, strings.Join(lr.GetRuntimes(), ", "))
+		}
+
+		funcDeps := ""
+		if deps != "" {
+			contentType, err := getContentType(deps)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			funcDeps, _, err = parseContent(deps, contentType)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		f, err := getFunctionDescription(funcName, ns, handler, file, funcDeps, runtime, "", "", "", "", string(v1.PullIfNotPresent), 8080, false, nil, nil, nil, kubelessApi.Function{})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if fromDir != "" {
+			if err := setFunctionFromDir(f, fromDir, lr, deps == ""); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+
+		registryHost, imageName, tag, err := splitBuildImage(image, f)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		builderImage := config.Data["builder-image"]
+		if builderImage == "" {
+			builderImage = defaultBuilderImage
+		}
+		provisionImage := config.Data["provision-image"]
+		if provisionImage == "" {
+			provisionImage = defaultProvisionImage
+		}
+		tlsVerify := config.Data["function-registry-tls-verify"] != "false"
+		if cmd.Flags().Changed("insecure") {
+			insecure, err := cmd.Flags().GetBool("insecure")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			tlsVerify = !insecure
+		}
+
+		if local {
+			pod, err := utils.GetLocalBuildPod(f, lr, imageName, tag, builderImage, registryHost, provisionImage, tlsVerify)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			runner := &localRunner{
+				docker: docker,
+				stdout: os.Stdout,
+				stderr: os.Stderr,
+			}
+			ctx, cancel := interruptContext()
+			defer cancel()
+			if err := runner.build(ctx, pod, dockerConfig); err != nil {
+				logrus.Fatal(err)
+			}
+		} else {
+			k8sClient := utils.GetClientOutOfCluster()
+			imagePullSecrets := utils.GetSecretsAsLocalObjectReference(config.Data["provision-image-secret"], config.Data["builder-image-secret"])
+			job, err := utils.CreateBuildJob(k8sClient, f, lr, imageName, tag, builderImage, registryHost, registrySecret, provisionImage, config.Data["artifact-server-url"], tlsVerify, imagePullSecrets)
+			if err != nil {
+				logrus.Fatalf("Unable to create the build job: %v", err)
+			}
+			if err := waitForBuildJob(k8sClient, job, time.Second); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		fmt.Printf("%s/%s:%s\n", registryHost, imageName, tag)
+	},
+}
+
+// splitBuildImage returns the registry, the repository and the tag of the image to build. If the
+// image doesn't have a tag, the checksum of the function and its dependencies is used
+func splitBuildImage(image string, f *kubelessApi.Function) (string, string, string, error) {
+	ref, err := layerbuilder.ParseReference(image)
+	if err != nil {
+		return "", "", "", err
+	}
+	if ref.Digest != "" {
+		return "", "", "", fmt.Errorf("The image %s can't include a digest", image)
+	}
+	tag := ref.Tag
+	if !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		tag, err = getSha256([]byte(fmt.Sprintf("%v%v", f.Spec.Function, f.Spec.Deps)))
+		if err != nil {
+			return "", "", "", err
+		}
+		tag = strings.TrimPrefix(tag, "sha256:")
+	}
+	return ref.Registry, ref.Repository, tag, nil
+}
+
+// waitForBuildJob streams the logs of a build job until it finishes
+func waitForBuildJob(client kubernetes.Interface, job *batchv1.Job, interval time.Duration) error {
+	var pods []v1.Pod
+	err := wait.PollImmediateInfinite(interval, func() (bool, error) {
+		var err error
+		pods, err = utils.GetBuildJobPods(client, job.ObjectMeta.Namespace, job.ObjectMeta.Name)
+		return len(pods) > 0, err
+	})
+	if err != nil {
+		return fmt.Errorf("Can't find the build pod: %v", err)
+	}
+	if err := printBuildLogs(client, *utils.LatestBuildPod(pods), true, os.Stderr); err != nil {
+		return fmt.Errorf("%v. Check the job %s for more details", err, job.ObjectMeta.Name)
+	}
+	return wait.PollImmediateInfinite(interval, func() (bool, error) {
+		j, err := client.BatchV1().Jobs(job.ObjectMeta.Namespace).Get(job.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, cond := range j.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == v1.ConditionTrue {
+				return false, fmt.Errorf("The build job %s failed: %s", job.ObjectMeta.Name, cond.Message)
+			}
+		}
+		return j.Status.Succeeded > 0, nil
+	})
+}
+
+// build executes the build steps of a function and pushes its image. dockerConfig is
+// the directory with the docker configuration that contains the registry credentials
+func (r *localRunner) build(ctx context.Context, pod *utils.LocalFunctionPod, dockerConfig string) error {
+	dir, err := ioutil.TempDir("", "kubeless-build-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Warnf("Unable to remove %s: %v", dir, err)
+		}
+	}()
+	defer r.cleanup()
+	buildDir := filepath.Join(dir, "build")
+	if err := os.Mkdir(buildDir, 0777); err != nil {
+		return err
+	}
+	// The registry may be only reachable from the host
+	runArgs := []string{"--network", "host"}
+	volumes, err := r.prepare(ctx, pod, dir, map[string]string{
+		pod.BuildVolume:    buildDir,
+		pod.RegistryVolume: dockerConfig,
+	}, runArgs...)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Running %s step using %s", pod.Container.Name, pod.Container.Image)
+	cmd := r.command(dockerRunArgs(pod.Container, volumes, r.containerArgs(pod.Container.Name, runArgs...)...)...)
+	cmd.Stdout = r.stderr
+	if err := r.exec(ctx, cmd); err != nil {
+		return fmt.Errorf("Step %s failed: %v", pod.Container.Name, err)
+	}
+	return nil
+}
+
+func init() {
+	buildCmd.Flags().StringP("runtime", "r", "", "Specify runtime")
+	buildCmd.Flags().StringP("handler", "", "", "Specify handler")
+	buildCmd.Flags().StringP("from-file", "f", "", "Specify code file or a URL to the code file")
+	buildCmd.Flags().String("from-dir", "", "Specify a directory with the code of the function. It is compressed honouring the patterns of its .kubelessignore file")
+	buildCmd.Flags().StringP("dependencies", "", "", "Specify a file containing list of dependencies for the function")
+	buildCmd.Flags().String("image", "", "Image to push. F.e. registry.example.com/user/function:tag. The checksum of the function is used as tag if it is not specified")
+	buildCmd.Flags().Bool("local", false, "Build the image in the local host using docker instead of in a Job of the cluster")
+	buildCmd.Flags().StringP("namespace", "n", "", "Specify namespace of the build job")
+	buildCmd.Flags().String("registry-secret", "kubeless-registry-credentials", "Secret of the build job namespace with the credentials to push the image")
+	buildCmd.Flags().Bool("insecure", false, "Don't verify the TLS certificate of the registry. Defaults to the function-registry-tls-verify setting of the Kubeless configuration")
+	buildCmd.Flags().String("config-file", "", "Read the Kubeless configuration from a kubeless-config ConfigMap manifest instead of the cluster")
+	buildCmd.Flags().String("docker", "docker", "Path to the docker binary, used with --local")
+	buildCmd.Flags().String("docker-config", filepath.Join(os.Getenv("HOME"), ".docker"), "Directory with the docker config.json that contains the registry credentials, used with --local")
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"k8s.io/api/core/v1"
+)
+
+func TestSplitBuildImage(t *testing.T) {
+	f := &kubelessApi.Function{}
+	f.Spec.Function = "function"
+	f.Spec.Deps = "deps"
+	checksum, _ := getSha256([]byte("functiondeps"))
+	checksum = strings.TrimPrefix(checksum, "sha256:")
+	tests := []struct {
+		image    string
+		registry string
+		repo     string
+		tag      string
+		err      bool
+	}{
+		{image: "registry.local/user/func:v1", registry: "registry.local", repo: "user/func", tag: "v1"},
+		{image: "localhost:5000/func", registry: "localhost:5000", repo: "func", tag: checksum},
+		{image: "user/func", registry: "docker.io", repo: "user/func", tag: checksum},
+		{image: "user/func@sha256:123", err: true},
+		{image: "dir://func", err: true},
+	}
+	for _, test := range tests {
+		registry, repo, tag, err := splitBuildImage(test.image, f)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expecting an error for %s", test.image)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.image, err)
+			continue
+		}
+		if registry != test.registry || repo != test.repo || tag != test.tag {
+			t.Errorf("Unexpected result for %s: %s %s %s", test.image, registry, repo, tag)
+		}
+	}
+}
+
+func TestLocalBuild(t *testing.T) {
+	calls := [][]string{}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		return exec.Command("true")
+	}
+	defer func() { execCommand = exec.Command }()
+
+	pod := &utils.LocalFunctionPod{
+		Files:          map[string]string{"handler.py": "code"},
+		SourceVolume:   "src",
+		RuntimeVolume:  "runtime",
+		BuildVolume:    "build",
+		RegistryVolume: "creds",
+		InitContainers: []v1.Container{
+			{Name: "prepare", Image: "unzip", VolumeMounts: []v1.VolumeMount{{Name: "src", MountPath: "/src"}}},
+			{Name: "bundle", Image: "builder", VolumeMounts: []v1.VolumeMount{{Name: "runtime", MountPath: "/kubeless"}, {Name: "build", MountPath: "/build"}}},
+		},
+		Container: v1.Container{
+			Name:         "build",
+			Image:        "builder",
+			Args:         []string{"/imbuilder", "add-layer"},
+			VolumeMounts: []v1.VolumeMount{{Name: "build", MountPath: "/build"}, {Name: "creds", MountPath: "/docker"}},
+		},
+	}
+	runner := &localRunner{docker: "docker", stdout: ioutil.Discard, stderr: ioutil.Discard}
+	if err := runner.build(context.Background(), pod, "/home/user/.docker"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(calls) != 6 {
+		t.Fatalf("Expecting 6 docker calls, received %v", calls)
+	}
+	for i, c := range calls[3:] {
+		if !reflect.DeepEqual(c, []string{"rm", "-f", calls[i][5]}) {
+			t.Errorf("The container of the step should be removed: %v", c)
+		}
+	}
+	for _, c := range calls[:3] {
+		if strings.Join(c[:4], " ") != "run --rm --network host" {
+			t.Errorf("The steps should use the host network: %v", c)
+		}
+	}
+	if !strings.HasSuffix(calls[1][9], "/build:/build") {
+		t.Errorf("Unexpected bundle call %v", calls[1])
+	}
+	build := strings.Join(calls[2], " ")
+	if !strings.Contains(build, "/home/user/.docker:/docker") || !strings.HasSuffix(build, "builder /imbuilder add-layer") {
+		t.Errorf("Unexpected build call %v", calls[2])
+	}
+
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("false")
+	}
+	if err := runner.build(context.Background(), pod, "/home/user/.docker"); err == nil || !strings.Contains(err.Error(), "prepare failed") {
+		t.Errorf("Expecting the prepare step to fail, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+var buildLogsCmd = &cobra.Command{
+	Use:   "build-logs <function_name> FLAG",
+	Short: "get logs from the build of a function",
+	Long:  `get the logs of the containers of the last job that built the image of a function, in the order they are executed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - function name")
+		}
+		funcName := args[0]
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+
+		k8sClient := utils.GetClientOutOfCluster()
+		job, err := utils.GetFunctionBuildJob(k8sClient, ns, funcName)
+		if err != nil {
+			logrus.Fatalf("Can't find the build of the function: %v", err)
+		}
+		pods, err := utils.GetBuildJobPods(k8sClient, ns, job.ObjectMeta.Name)
+		if err != nil {
+			logrus.Fatalf("Can't find the build pod: %v", err)
+		}
+		pod := utils.LatestBuildPod(pods)
+		if pod == nil {
+			logrus.Fatalf("The build job %s has not started any pod yet", job.ObjectMeta.Name)
+		}
+		if err := printBuildLogs(k8sClient, *pod, follow, os.Stdout); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+// containerStarted returns true if the container of a pod with the given name is running or has been executed
+func containerStarted(pod *v1.Pod, name string) bool {
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		if s.Name == name {
+			return s.State.Running != nil || s.State.Terminated != nil || s.LastTerminationState.Terminated != nil
+		}
+	}
+	return false
+}
+
+// waitForContainer waits until a container of a pod is started. It returns the updated pod
+// and false if the pod finishes without executing the container
+func waitForContainer(client kubernetes.Interface, pod *v1.Pod, name string, interval time.Duration) (*v1.Pod, bool, error) {
+	started := false
+	err := wait.PollImmediateInfinite(interval, func() (bool, error) {
+		p, err := client.CoreV1().Pods(pod.ObjectMeta.Namespace).Get(pod.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		pod = p
+		started = containerStarted(pod, name)
+		return started || pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded || utils.GetBuildFailure(pod) != nil, nil
+	})
+	return pod, started, err
+}
+
+// printBuildLogs writes the logs of the containers of a build pod in the order they are executed.
+// When following the logs it waits for every container to start and streams its log until it finishes
+func printBuildLogs(client kubernetes.Interface, pod v1.Pod, follow bool, w io.Writer) error {
+	current := &pod
+	for _, name := range utils.BuildContainers(&pod) {
+		if follow {
+			var started bool
+			var err error
+			current, started, err = waitForContainer(client, current, name, time.Second)
+			if err != nil {
+				return err
+			}
+			if !started {
+				break
+			}
+		} else if !containerStarted(current, name) {
+			break
+		}
+		fmt.Fprintf(w, "==> %s <==\n", name)
+		logs, err := client.CoreV1().Pods(pod.ObjectMeta.Namespace).GetLogs(pod.ObjectMeta.Name, &v1.PodLogOptions{
+			Container: name,
+			Follow:    follow,
+		}).Stream()
+		if err != nil {
+			return fmt.Errorf("Getting log of container %s failed: %v", name, err)
+		}
+		io.Copy(w, logs)
+		logs.Close()
+	}
+	if follow {
+		// Refresh the status of the pod once its containers have finished
+		if p, err := client.CoreV1().Pods(pod.ObjectMeta.Namespace).Get(pod.ObjectMeta.Name, metav1.GetOptions{}); err == nil {
+			current = p
+		}
+	}
+	if failure := utils.GetBuildFailure(current); failure != nil {
+		return fmt.Errorf("The build pod %s failed: %s", pod.ObjectMeta.Name, failure)
+	}
+	return nil
+}
+
+func init() {
+	buildLogsCmd.Flags().BoolP("follow", "f", false, "Specify if the logs should be streamed.")
+	buildLogsCmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func buildPod(phase v1.PodPhase, statuses ...v1.ContainerStatus) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "build-foo-0123456789-abcde"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "prepare"}, {Name: "install"}},
+			Containers:     []v1.Container{{Name: "build"}},
+		},
+		Status: v1.PodStatus{Phase: phase, InitContainerStatuses: statuses},
+	}
+}
+
+func TestContainerStarted(t *testing.T) {
+	pod := buildPod(v1.PodPending,
+		v1.ContainerStatus{Name: "prepare", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}},
+		v1.ContainerStatus{Name: "install", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+	)
+	if !containerStarted(pod, "prepare") {
+		t.Error("The container prepare should be started")
+	}
+	for _, name := range []string{"install", "build"} {
+		if containerStarted(pod, name) {
+			t.Errorf("The container %s should not be started", name)
+		}
+	}
+	pod.Status.InitContainerStatuses[1].LastTerminationState.Terminated = &v1.ContainerStateTerminated{ExitCode: 1}
+	if !containerStarted(pod, "install") {
+		t.Error("A container that has been restarted should be started")
+	}
+}
+
+func TestWaitForContainer(t *testing.T) {
+	running := buildPod(v1.PodPending, v1.ContainerStatus{Name: "prepare", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}})
+	pod, started, err := waitForContainer(fake.NewSimpleClientset(running), buildPod(v1.PodPending), "prepare", time.Millisecond)
+	if err != nil || !started || !containerStarted(pod, "prepare") {
+		t.Errorf("Expecting the container to be started (%v)", err)
+	}
+
+	// The build container is not executed if an init container fails
+	failed := buildPod(v1.PodFailed,
+		v1.ContainerStatus{Name: "prepare", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}}},
+	)
+	_, started, err = waitForContainer(fake.NewSimpleClientset(failed), failed, "build", time.Millisecond)
+	if err != nil || started {
+		t.Errorf("The container should not be started (%v)", err)
+	}
+}
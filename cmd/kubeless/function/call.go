@@ -18,11 +18,15 @@ package function
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/kubeless/kubeless/pkg/tracing"
 	"github.com/kubeless/kubeless/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -62,6 +66,18 @@ var callCmd = &cobra.Command{
 		if ns == "" {
 			ns = utils.GetDefaultNamespace()
 		}
+		async, err := cmd.Flags().GetBool("async")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		trace, err := cmd.Flags().GetBool("trace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		collector, err := cmd.Flags().GetString("trace-collector")
+		if err != nil {
+			logrus.Fatal(err)
+		}
 
 		clientset := utils.GetClientOutOfCluster()
 		svc, err := clientset.CoreV1().Services(ns).Get(funcName, metav1.GetOptions{})
@@ -99,7 +115,37 @@ var callCmd = &cobra.Command{
 		req.SetHeader("event-id", eventID)
 		req.SetHeader("event-time", timestamp.Format(time.RFC3339))
 		req.SetHeader("event-namespace", "cli.kubeless.io")
+		if async {
+			req.SetHeader("X-Kubeless-Invocation", "async")
+		}
+		var span *tracing.Span
+		var tracer *tracing.Tracer
+		if trace {
+			// Start a root span so the invocation can be followed through the function
+			var exporter tracing.Exporter
+			if collector != "" {
+				exporter = tracing.NewZipkinExporter(collector)
+			}
+			tracer = tracing.NewTracer("kubeless-cli", exporter)
+			span = tracer.StartSpan("call "+funcName, tracing.KindClient, tracing.SpanContext{})
+			span.SetTag("kubeless.function", funcName)
+			span.SetTag("kubeless.namespace", ns)
+			span.SetTag("kubeless.event_id", eventID)
+			header := http.Header{}
+			tracing.Inject(span.Context(), header)
+			for k := range header {
+				req.SetHeader(k, header.Get(k))
+			}
+		}
 		res, err := req.Do().Raw()
+		if span != nil {
+			if err != nil {
+				span.SetTag("error", err.Error())
+			}
+			span.End()
+			tracer.Shutdown()
+			logrus.Infof("Trace ID: %s", span.Context().TraceID)
+		}
 		if err != nil {
 			// Properly interpret line breaks
 			logrus.Error(string(res))
@@ -110,6 +156,17 @@ var callCmd = &cobra.Command{
 				logrus.Fatal(strings.Replace(err.Error(), `\n`, "\n", -1))
 			}
 		}
+		if async {
+			invocation := struct {
+				ID string `json:"id"`
+			}{}
+			if err := json.Unmarshal(res, &invocation); err != nil || invocation.ID == "" {
+				logrus.Fatalf("Unexpected response for an asynchronous invocation: %s", string(res))
+			}
+			fmt.Println(invocation.ID)
+			logrus.Infof("Check the result executing 'kubeless invocation get %s -n %s'", invocation.ID, ns)
+			return
+		}
 		fmt.Println(string(res))
 	},
 }
@@ -117,5 +174,8 @@ var callCmd = &cobra.Command{
 func init() {
 	callCmd.Flags().StringP("data", "d", "", "Specify data for function")
 	callCmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+	callCmd.Flags().Bool("async", false, "Queue the request and return an invocation ID instead of waiting for the result")
+	callCmd.Flags().Bool("trace", false, "Start a trace for the request and print its ID")
+	callCmd.Flags().String("trace-collector", os.Getenv(tracing.CollectorEnv), "URL of the Zipkin compatible collector in which the root span of the trace is exported")
 
 }
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 	cronjobApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
@@ -93,6 +94,13 @@ var deployCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		fromDir, err := cmd.Flags().GetString("from-dir")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if file != "" && fromDir != "" {
+			logrus.Fatal("The flags `--from-file` and `--from-dir` can't be used together")
+		}
 
 		ns, err := cmd.Flags().GetString("namespace")
 		if err != nil {
@@ -157,6 +165,21 @@ var deployCmd = &cobra.Command{
 			logrus.Fatal(err)
 		}
 
+		upload, err := cmd.Flags().GetBool("upload")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		idleTimeout, err := cmd.Flags().GetString("idle-timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if idleTimeout != "" {
+			if _, err := time.ParseDuration(idleTimeout); err != nil {
+				logrus.Fatalf("Invalid idle timeout %s: %v", idleTimeout, err)
+			}
+		}
+
 		port, err := cmd.Flags().GetInt32("port")
 		if err != nil {
 			logrus.Fatal(err)
@@ -195,6 +218,35 @@ var deployCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		if fromDir != "" {
+			if err := setFunctionFromDir(f, fromDir, lr, deps == ""); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		if upload && dryrun {
+			logrus.Warn("The function is not uploaded to the artifact server in dry-run mode")
+		} else if upload {
+			if err := uploadFunction(cli, config.Data["artifact-server-url"], f); err != nil {
+				logrus.Fatal(err)
+			}
+		} else if err := checkFunctionSize(f); err != nil {
+			logrus.Fatal(err)
+		}
+		if idleTimeout != "" {
+			f.Spec.IdleTimeout = idleTimeout
+		}
+		if err := setFailurePolicy(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setProtocol(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setContainerConcurrency(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setPayload(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
 
 		if dryrun == true {
 			if output == "json" {
@@ -262,6 +314,8 @@ func init() {
 	deployCmd.Flags().StringP("runtime", "r", "", "Specify runtime")
 	deployCmd.Flags().StringP("handler", "", "", "Specify handler")
 	deployCmd.Flags().StringP("from-file", "f", "", "Specify code file or a URL to the code file")
+	deployCmd.Flags().String("from-dir", "", "Specify a directory with the code of the function. It is compressed honouring the patterns of its .kubelessignore file")
+	deployCmd.Flags().Bool("upload", false, "Store the code of the function in the artifact server instead of embedding it in the function")
 	deployCmd.Flags().StringSliceP("label", "l", []string{}, "Specify labels of the function. Both separator ':' and '=' are allowed. For example: --label foo1=bar1,foo2:bar2")
 	deployCmd.Flags().StringSliceP("secrets", "", []string{}, "Specify Secrets to be mounted to the functions container. For example: --secrets mySecret")
 	deployCmd.Flags().StringSliceP("env", "e", []string{}, "Specify environment variable of the function. Both separator ':' and '=' are allowed. For example: --env foo1=bar1,foo2:bar2")
@@ -277,4 +331,13 @@ func init() {
 	deployCmd.Flags().Bool("headless", false, "Deploy http-based function without a single service IP and load balancing support from Kubernetes. See: https://kubernetes.io/docs/concepts/services-networking/service/#headless-services")
 	deployCmd.Flags().Bool("dryrun", false, "Output JSON manifest of the function without creating it")
 	deployCmd.Flags().Int32("port", 8080, "Deploy http-based function with a custom port")
+	deployCmd.Flags().String("idle-timeout", "", "Scale the function to zero after the given time without requests (e.g. 15m). A value of 0 disables it")
+	deployCmd.Flags().Int32("retry-max-attempts", 1, "Maximum number of times the function is invoked to process an event that fails")
+	deployCmd.Flags().String("retry-backoff", "", "Time to wait before retrying a failed event (e.g. 1s). It is doubled after every attempt")
+	deployCmd.Flags().String("protocol", "http", "Protocol served by the function: http, websocket or grpc")
+	deployCmd.Flags().Int32("container-concurrency", 0, "Maximum number of requests processed at the same time by each replica of the function. The rest wait in a queue. 0 means unlimited")
+	deployCmd.Flags().String("max-request-size", "", "Maximum size of the requests of the function, e.g. 1Mi. Bigger requests are rejected with a 413 error")
+	deployCmd.Flags().String("max-response-size", "", "Maximum size of the responses of the function, e.g. 10Mi")
+	deployCmd.Flags().String("request-schema", "", "File with the JSON Schema, in JSON or YAML, that the JSON requests of the function should match. Invalid requests are rejected with a 400 error")
+	deployCmd.Flags().String("on-failure", "", "Send the events that fail after all the attempts to a destination: function:<name>, nats:<topic>[@<url>], kafka:<topic>@<rest-proxy-url> or an http(s) URL")
 }
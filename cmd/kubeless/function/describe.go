@@ -97,6 +97,19 @@ func print(f kubelessApi.Function, name, output string) error {
 		table.AddRow("Envvar:", env)
 		table.AddRow("Memory:", memory)
 		table.AddRow("Dependencies:", f.Spec.Deps)
+		if f.Status.Phase != "" {
+			table.AddRow("Status:", string(f.Status.Phase))
+			table.AddRow("Image:", f.Status.Image)
+			if f.Status.Revision != 0 {
+				table.AddRow("Revision:", f.Status.Revision)
+			}
+			if f.Status.LastError != "" {
+				table.AddRow("Last error:", f.Status.LastError)
+			}
+			for _, c := range f.Status.Conditions {
+				table.AddRow(fmt.Sprintf("Condition %s:", c.Type), fmt.Sprintf("%s %s %s", c.Status, c.Reason, c.Message))
+			}
+		}
 		fmt.Println(table)
 	case "json":
 		b, err := json.MarshalIndent(f, "", "  ")
@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+)
+
+const (
+	// ignoreFileName is the file that lists the paths of a directory that are not deployed
+	ignoreFileName = ".kubelessignore"
+	// maxConfigMapSize is the maximum size of the data of a ConfigMap
+	maxConfigMapSize = 1024 * 1024
+)
+
+// alwaysIgnored are the paths that are never included in a function bundle
+var alwaysIgnored = []string{".git/", ignoreFileName}
+
+// ignoreRule is a pattern of a .kubelessignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseIgnoreRules parses a list of patterns with a syntax similar to .gitignore: "#" starts a
+// comment, "!" includes again a path, a trailing "/" only matches directories, a leading "/"
+// only matches paths relative to the root of the directory and "**" matches any number of directories
+func parseIgnoreRules(r io.Reader) ([]ignoreRule, error) {
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		} else if strings.Contains(line, "/") {
+			// Like in .gitignore, patterns with a slash are relative to the root
+			rule.anchored = true
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %v", line, err)
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored returns true if the given path, relative to the root and separated by slashes, should be skipped
+func ignored(rules []ignoreRule, relPath string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		name := relPath
+		if !rule.anchored {
+			name = relPath[strings.LastIndex(relPath, "/")+1:]
+		}
+		if matchPattern(strings.Split(rule.pattern, "/"), strings.Split(name, "/")) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
+
+// matchPattern returns true if the elements of a path match the ones of a pattern. Besides the
+// wildcards of path.Match, a "**" element matches zero or more directories. A trailing "**" matches
+// everything inside a directory but not the directory itself
+func matchPattern(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(elems) > 0
+			}
+			for i := range elems {
+				if matchPattern(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if match, _ := path.Match(pattern[0], elems[0]); !match {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// readIgnoreRules returns the default rules followed by the content of the .kubelessignore file of a directory
+func readIgnoreRules(dir string) ([]ignoreRule, error) {
+	rules, err := parseIgnoreRules(strings.NewReader(strings.Join(alwaysIgnored, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	userRules, err := parseIgnoreRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", ignoreFileName, err)
+	}
+	return append(rules, userRules...), nil
+}
+
+// zipDir returns a zip file with the content of a directory that is not ignored. The
+// modification times are not preserved so the same content always produces the same checksum
+func zipDir(dir string, rules []ignoreRule) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(rules, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			// Skip symlinks, sockets...
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		header.Modified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = w.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setFunctionFromDir sets the content of a function to a zip file with the given directory. If
+// setDeps is true the dependencies are read from the dependencies file of the runtime found in the directory
+func setFunctionFromDir(f *kubelessApi.Function, dir string, lr *langruntime.Langruntimes, setDeps bool) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	rules, err := readIgnoreRules(dir)
+	if err != nil {
+		return err
+	}
+	bundle, err := zipDir(dir, rules)
+	if err != nil {
+		return fmt.Errorf("Unable to compress %s: %v", dir, err)
+	}
+	checksum, err := getSha256(bundle)
+	if err != nil {
+		return err
+	}
+	f.Spec.Function = base64.StdEncoding.EncodeToString(bundle)
+	f.Spec.FunctionContentType = "base64+zip"
+	f.Spec.Checksum = checksum
+
+	if setDeps {
+		runtimeInf, err := lr.GetRuntimeInfo(f.Spec.Runtime)
+		if err == nil && runtimeInf.DepName != "" {
+			deps, err := ioutil.ReadFile(filepath.Join(dir, runtimeInf.DepName))
+			if err == nil {
+				f.Spec.Deps = string(deps)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFunctionSize returns an error if the function is too big to be stored in a ConfigMap
+func checkFunctionSize(f *kubelessApi.Function) error {
+	size := len(f.Spec.Function) + len(f.Spec.Deps) + len(f.Spec.Handler)
+	if size > maxConfigMapSize {
+		return fmt.Errorf("The function is %d bytes once encoded, bigger than the %d bytes that a ConfigMap can store. "+
+			"Exclude the files that are not needed adding them to %s or use --upload to store it in the artifact server", size, maxConfigMapSize, ignoreFileName)
+	}
+	return nil
+}
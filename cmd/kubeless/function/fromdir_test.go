@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIgnored(t *testing.T) {
+	rules, err := parseIgnoreRules(strings.NewReader(`
+# comment
+*.pyc
+build/
+/local.txt
+docs/*.md
+!docs/README.md
+**/fixtures/
+src/**/*_test.py
+assets/**
+!assets/logo.png
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"handler.py", false, false},
+		{"handler.pyc", false, true},
+		{"lib/module.pyc", false, true},
+		{"build", true, true},
+		{"lib/build", true, true},
+		{"build", false, false},
+		{"local.txt", false, true},
+		{"lib/local.txt", false, false},
+		{"docs/guide.md", false, true},
+		{"docs/README.md", false, false},
+		{"fixtures", true, true},
+		{"lib/tests/fixtures", true, true},
+		{"lib/fixtures", false, false},
+		{"src/handler_test.py", false, true},
+		{"src/lib/a/b_test.py", false, true},
+		{"lib/src/handler_test.py", false, false},
+		{"src/handler.py", false, false},
+		{"assets", true, false},
+		{"assets/img/icon.png", false, true},
+		{"assets/logo.png", false, false},
+	}
+	for _, tt := range tests {
+		if res := ignored(rules, tt.path, tt.isDir); res != tt.ignored {
+			t.Errorf("%s: expecting ignored=%v, received %v", tt.path, tt.ignored, res)
+		}
+	}
+
+	if _, err := parseIgnoreRules(strings.NewReader("[")); err == nil {
+		t.Error("Expecting an error for an invalid pattern")
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSetFunctionFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeless-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"handler.py":       "def hello(event, context): return helper.hello()",
+		"helper/hello.py":  "def hello(): return 'hello'",
+		"requirements.txt": "requests",
+		"tests/test.py":    "test",
+		".git/HEAD":        "ref",
+		ignoreFileName:     "tests/",
+	})
+
+	clientset := fake.NewSimpleClientset()
+	langruntime.AddFakeConfig(clientset)
+	lr := langruntime.SetupLangRuntime(clientset)
+	lr.ReadConfigMap()
+
+	f := &kubelessApi.Function{Spec: kubelessApi.FunctionSpec{Runtime: "python2.7", Handler: "handler.hello"}}
+	if err := setFunctionFromDir(f, dir, lr, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Spec.FunctionContentType != "base64+zip" || f.Spec.Deps != "requests" {
+		t.Errorf("Unexpected function spec %v", f.Spec)
+	}
+	bundle, err := base64.StdEncoding.DecodeString(f.Spec.Function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, _ := getSha256(bundle)
+	if f.Spec.Checksum != checksum {
+		t.Errorf("Expecting checksum %s, received %s", checksum, f.Spec.Checksum)
+	}
+	r, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, zf := range r.File {
+		files = append(files, zf.Name)
+	}
+	sort.Strings(files)
+	expected := []string{"handler.py", "helper/", "helper/hello.py", "requirements.txt"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expecting files %v, received %v", expected, files)
+	}
+
+	// The same content produces the same checksum
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "handler.py"), past, past)
+	f2 := &kubelessApi.Function{Spec: kubelessApi.FunctionSpec{Runtime: "python2.7", Deps: "custom"}}
+	if err := setFunctionFromDir(f2, dir, lr, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f2.Spec.Checksum != f.Spec.Checksum || f2.Spec.Deps != "custom" {
+		t.Errorf("Unexpected function spec %v", f2.Spec)
+	}
+
+	// Bundles bigger than a ConfigMap are rejected
+	big := make([]byte, maxConfigMapSize)
+	rand.Read(big)
+	writeFiles(t, dir, map[string]string{"big.bin": string(big)})
+	if err := setFunctionFromDir(f, dir, lr, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := checkFunctionSize(f); err == nil || !strings.Contains(err.Error(), "--upload") {
+		t.Errorf("Expecting an error about the size of the bundle, received %v", err)
+	}
+	if err := checkFunctionSize(f2); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
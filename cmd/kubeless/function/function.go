@@ -28,19 +28,24 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 	"unicode/utf8"
 
+	"github.com/ghodss/yaml"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	"github.com/kubeless/kubeless/pkg/utils"
 	"github.com/spf13/cobra"
 	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 )
 
-//FunctionCmd contains first-class command for function
+// FunctionCmd contains first-class command for function
 var FunctionCmd = &cobra.Command{
 	Use:   "function SUBCOMMAND",
 	Short: "function specific operations",
@@ -56,9 +61,15 @@ func init() {
 	FunctionCmd.AddCommand(listCmd)
 	FunctionCmd.AddCommand(callCmd)
 	FunctionCmd.AddCommand(logsCmd)
+	FunctionCmd.AddCommand(buildCmd)
+	FunctionCmd.AddCommand(buildLogsCmd)
 	FunctionCmd.AddCommand(describeCmd)
 	FunctionCmd.AddCommand(updateCmd)
 	FunctionCmd.AddCommand(topCmd)
+	FunctionCmd.AddCommand(rolloutCmd)
+	FunctionCmd.AddCommand(historyCmd)
+	FunctionCmd.AddCommand(rollbackCmd)
+	FunctionCmd.AddCommand(runCmd)
 }
 
 func getKV(input string) (string, string) {
@@ -109,6 +120,164 @@ func parseResource(in string) (resource.Quantity, error) {
 	return quantity, nil
 }
 
+// parseDestination parses the destination of the failed events of a function. Supported formats:
+// function:<name>, nats:<topic>[@<url>], kafka:<topic>@<rest-proxy-url> and http(s)://<url>
+func parseDestination(in string) (*kubelessApi.FunctionDestination, error) {
+	if strings.HasPrefix(in, "http://") || strings.HasPrefix(in, "https://") {
+		return &kubelessApi.FunctionDestination{Type: kubelessApi.DestinationHTTP, URL: in}, nil
+	}
+	parts := strings.SplitN(in, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid destination %q. Expecting <type>:<name>", in)
+	}
+	d := &kubelessApi.FunctionDestination{Type: kubelessApi.DestinationType(parts[0]), Name: parts[1]}
+	if d.Type == kubelessApi.DestinationNATS || d.Type == kubelessApi.DestinationKafka {
+		if i := strings.Index(d.Name, "@"); i >= 0 {
+			d.Name, d.URL = d.Name[:i], d.Name[i+1:]
+		}
+	}
+	if err := utils.ValidateFunctionDestination(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// setFailurePolicy applies the retry and failure destination flags that have been set to a function
+func setFailurePolicy(cmd *cobra.Command, f *kubelessApi.Function) error {
+	if cmd.Flags().Changed("retry-max-attempts") || cmd.Flags().Changed("retry-backoff") {
+		if f.Spec.RetryPolicy == nil {
+			f.Spec.RetryPolicy = &kubelessApi.FunctionRetryPolicy{}
+		}
+		attempts, err := cmd.Flags().GetInt32("retry-max-attempts")
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("retry-max-attempts") {
+			if attempts < 1 {
+				return fmt.Errorf("Invalid number of attempts %d", attempts)
+			}
+			f.Spec.RetryPolicy.MaxAttempts = attempts
+		}
+		backoff, err := cmd.Flags().GetString("retry-backoff")
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("retry-backoff") {
+			if _, err := time.ParseDuration(backoff); err != nil {
+				return fmt.Errorf("Invalid retry backoff %s: %v", backoff, err)
+			}
+			f.Spec.RetryPolicy.Backoff = backoff
+		}
+	}
+	if cmd.Flags().Changed("on-failure") {
+		onFailure, err := cmd.Flags().GetString("on-failure")
+		if err != nil {
+			return err
+		}
+		d, err := parseDestination(onFailure)
+		if err != nil {
+			return err
+		}
+		f.Spec.OnFailure = d
+	}
+	return nil
+}
+
+// setProtocol sets the protocol of the function from the --protocol flag and
+// names its service port after it
+func setProtocol(cmd *cobra.Command, f *kubelessApi.Function) error {
+	if cmd.Flags().Changed("protocol") {
+		protocol, err := cmd.Flags().GetString("protocol")
+		if err != nil {
+			return err
+		}
+		if err := utils.ValidateFunctionProtocol(kubelessApi.FunctionProtocol(protocol)); err != nil {
+			return err
+		}
+		f.Spec.Protocol = kubelessApi.FunctionProtocol(protocol)
+	}
+	if ports := f.Spec.ServiceSpec.Ports; len(ports) > 0 && utils.IsDefaultFunctionPortName(ports[0].Name) {
+		ports[0].Name = utils.FunctionPortName(f.Spec.Protocol)
+	}
+	return nil
+}
+
+// setContainerConcurrency sets the number of requests that each replica of the function
+// processes at the same time from the --container-concurrency flag
+func setContainerConcurrency(cmd *cobra.Command, f *kubelessApi.Function) error {
+	if !cmd.Flags().Changed("container-concurrency") {
+		return nil
+	}
+	concurrency, err := cmd.Flags().GetInt32("container-concurrency")
+	if err != nil {
+		return err
+	}
+	if concurrency < 0 {
+		return fmt.Errorf("Invalid container concurrency %d", concurrency)
+	}
+	f.Spec.ContainerConcurrency = concurrency
+	return nil
+}
+
+// setPayload sets the limits of the payloads of the function and the JSON Schema of its requests
+// from the --max-request-size, --max-response-size and --request-schema flags. The schema can
+// be written in JSON or YAML. Empty values remove the limits and the schema
+func setPayload(cmd *cobra.Command, f *kubelessApi.Function) error {
+	p := &kubelessApi.FunctionPayload{}
+	if f.Spec.Payload != nil {
+		p = f.Spec.Payload.DeepCopy()
+	}
+	for _, flag := range []struct {
+		name  string
+		value **resource.Quantity
+	}{
+		{"max-request-size", &p.MaxRequestSize},
+		{"max-response-size", &p.MaxResponseSize},
+	} {
+		if !cmd.Flags().Changed(flag.name) {
+			continue
+		}
+		value, err := cmd.Flags().GetString(flag.name)
+		if err != nil {
+			return err
+		}
+		size, err := parseResource(value)
+		if err != nil {
+			return fmt.Errorf("Wrong format of the %s value: %v", flag.name, err)
+		}
+		*flag.value = nil
+		if !size.IsZero() {
+			*flag.value = &size
+		}
+	}
+	if cmd.Flags().Changed("request-schema") {
+		file, err := cmd.Flags().GetString("request-schema")
+		if err != nil {
+			return err
+		}
+		p.RequestSchema = nil
+		if file != "" {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("Unable to read the request schema: %v", err)
+			}
+			schema, err := yaml.YAMLToJSON(content)
+			if err != nil {
+				return fmt.Errorf("Unable to parse the request schema %s: %v", file, err)
+			}
+			p.RequestSchema = &runtime.RawExtension{Raw: schema}
+		}
+	}
+	if err := utils.ValidateFunctionPayload(p); err != nil {
+		return err
+	}
+	f.Spec.Payload = p
+	if p.MaxRequestSize == nil && p.MaxResponseSize == nil && p.RequestSchema == nil {
+		f.Spec.Payload = nil
+	}
+	return nil
+}
+
 func getFileSha256(file string) (string, error) {
 	h := sha256.New()
 	ff, err := os.Open(file)
@@ -356,7 +525,7 @@ func getFunctionDescription(funcName, ns, handler, file, deps, runtime, runtimeI
 }
 
 func getDeploymentStatus(cli kubernetes.Interface, funcName, ns string) (string, error) {
-	dpm, err := cli.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := cli.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		return "", err
 	}
@@ -369,6 +538,26 @@ func getDeploymentStatus(cli kubernetes.Interface, funcName, ns string) (string,
 	return status, nil
 }
 
+// getFunctionStatus returns a human readable status of a function based on the phase
+// reported by the controller. Functions without phase fall back to the Deployment status
+func getFunctionStatus(cli kubernetes.Interface, f *kubelessApi.Function) (string, error) {
+	switch f.Status.Phase {
+	case kubelessApi.FunctionPhaseFailed:
+		return fmt.Sprintf("FAILED: %s", f.Status.LastError), nil
+	case kubelessApi.FunctionPhasePending:
+		return "PENDING", nil
+	case kubelessApi.FunctionPhaseBuilding:
+		return "BUILDING", nil
+	case kubelessApi.FunctionPhaseIdle:
+		return "IDLE", nil
+	}
+	status, err := getDeploymentStatus(cli, f.ObjectMeta.Name, f.ObjectMeta.Namespace)
+	if err != nil && k8sErrors.IsNotFound(err) {
+		return "MISSING: Check controller logs", nil
+	}
+	return status, err
+}
+
 func getFunctions(kubelessClient versioned.Interface, namespace, functionName string) ([]*kubelessApi.Function, error) {
 	if functionName == "" {
 		f, err := kubelessClient.KubelessV1beta1().Functions(namespace).List(metav1.ListOptions{})
@@ -28,6 +28,7 @@ import (
 	"testing"
 
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/spf13/cobra"
 	"k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
@@ -89,6 +90,112 @@ func TestParseEnv(t *testing.T) {
 	}
 }
 
+func TestParseDestination(t *testing.T) {
+	expected := map[string]kubelessApi.FunctionDestination{
+		"function:errors":                     {Type: kubelessApi.DestinationFunction, Name: "errors"},
+		"nats:errors":                         {Type: kubelessApi.DestinationNATS, Name: "errors"},
+		"nats:errors@nats://nats:4222":        {Type: kubelessApi.DestinationNATS, Name: "errors", URL: "nats://nats:4222"},
+		"kafka:errors@http://kafka-rest:8082": {Type: kubelessApi.DestinationKafka, Name: "errors", URL: "http://kafka-rest:8082"},
+		"https://example.com/errors":          {Type: kubelessApi.DestinationHTTP, URL: "https://example.com/errors"},
+	}
+	for in, e := range expected {
+		actual, err := parseDestination(in)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(e, *actual) {
+			t.Errorf("Expect %v got %v", e, *actual)
+		}
+	}
+	for _, in := range []string{"errors", "function:", "kafka:errors", "sqs:errors"} {
+		if _, err := parseDestination(in); err == nil {
+			t.Errorf("Expecting an error parsing %s", in)
+		}
+	}
+}
+
+func TestSetProtocol(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("protocol", "http", "")
+	f := &kubelessApi.Function{}
+	f.Spec.ServiceSpec.Ports = []v1.ServicePort{{Name: "http-function-port", Port: 8080}}
+	if err := cmd.Flags().Set("protocol", "grpc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProtocol(cmd, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Spec.Protocol != kubelessApi.ProtocolGRPC || f.Spec.ServiceSpec.Ports[0].Name != "grpc-function-port" {
+		t.Errorf("Unexpected function spec %v", f.Spec)
+	}
+	if err := cmd.Flags().Set("protocol", "ftp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProtocol(cmd, f); err == nil {
+		t.Error("Expecting an error for an unknown protocol")
+	}
+}
+
+func TestSetContainerConcurrency(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().Int32("container-concurrency", 0, "")
+	f := &kubelessApi.Function{}
+	f.Spec.ContainerConcurrency = 3
+	if err := setContainerConcurrency(cmd, f); err != nil || f.Spec.ContainerConcurrency != 3 {
+		t.Errorf("The concurrency should be kept if the flag is not set, got %d (%v)", f.Spec.ContainerConcurrency, err)
+	}
+	cmd.Flags().Set("container-concurrency", "1")
+	if err := setContainerConcurrency(cmd, f); err != nil || f.Spec.ContainerConcurrency != 1 {
+		t.Errorf("Unexpected concurrency %d (%v)", f.Spec.ContainerConcurrency, err)
+	}
+	cmd.Flags().Set("container-concurrency", "-1")
+	if err := setContainerConcurrency(cmd, f); err == nil {
+		t.Error("Expecting an error for a negative concurrency")
+	}
+}
+
+func TestSetPayload(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("max-request-size", "", "")
+	cmd.Flags().String("max-response-size", "", "")
+	cmd.Flags().String("request-schema", "", "")
+	f := &kubelessApi.Function{}
+	if err := setPayload(cmd, f); err != nil || f.Spec.Payload != nil {
+		t.Errorf("Unexpected payload %v (%v)", f.Spec.Payload, err)
+	}
+
+	schema, err := ioutil.TempFile("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(schema.Name())
+	schema.WriteString("type: object\nrequired: [name]\n")
+	schema.Close()
+	cmd.Flags().Set("max-request-size", "1Mi")
+	cmd.Flags().Set("request-schema", schema.Name())
+	if err := setPayload(cmd, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Spec.Payload.MaxRequestSize.Value() != 1048576 || f.Spec.Payload.MaxResponseSize != nil {
+		t.Errorf("Unexpected payload limits %v", f.Spec.Payload)
+	}
+	if string(f.Spec.Payload.RequestSchema.Raw) != `{"required":["name"],"type":"object"}` {
+		t.Errorf("Unexpected request schema %s", f.Spec.Payload.RequestSchema.Raw)
+	}
+
+	cmd.Flags().Set("max-request-size", "")
+	cmd.Flags().Set("request-schema", "")
+	if err := setPayload(cmd, f); err != nil || f.Spec.Payload != nil {
+		t.Errorf("The payload should be removed, got %v (%v)", f.Spec.Payload, err)
+	}
+
+	cmd.Flags().Set("max-response-size", "-1")
+	if err := setPayload(cmd, f); err == nil {
+		t.Error("Expecting an error for a negative size")
+	}
+}
+
 func TestGetFunctionDescription(t *testing.T) {
 	// It should parse the given values
 	file, err := ioutil.TempFile("", "test")
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gosuri/uitable"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <function_name>",
+	Short: "list the revisions of a function",
+	Long:  `list the revisions of a function. Any revision can be restored using 'kubeless function rollback'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - function name")
+		}
+		funcName := args[0]
+
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+
+		f, err := utils.GetFunction(funcName, ns)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		revisions, err := utils.ListFuncRevisions(utils.GetClientOutOfCluster(), ns, funcName)
+		if err != nil {
+			logrus.Fatalf("Unable to list the revisions of %s: %v", funcName, err)
+		}
+		err = printFunctionHistory(cmd.OutOrStdout(), revisions, f.Status.Revision)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	historyCmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+}
+
+// printFunctionHistory formats the list of revisions of a function
+func printFunctionHistory(w io.Writer, revisions []appsv1.ControllerRevision, current int64) error {
+	if len(revisions) == 0 {
+		fmt.Fprintln(w, "No revisions found")
+		return nil
+	}
+	table := uitable.New()
+	table.MaxColWidth = 50
+	table.AddRow("REVISION", "HANDLER", "RUNTIME", "CHECKSUM", "CREATED")
+	for i := range revisions {
+		spec, err := utils.GetFuncRevisionSpec(&revisions[i])
+		if err != nil {
+			return err
+		}
+		revision := strconv.FormatInt(revisions[i].Revision, 10)
+		if revisions[i].Revision == current {
+			revision += " (current)"
+		}
+		table.AddRow(revision, spec.Handler, spec.Runtime, spec.Checksum, revisions[i].CreationTimestamp.String())
+	}
+	fmt.Fprintln(w, table)
+	return nil
+}
+
+// findRevision returns the revision with the given number. If the number is 0
+// it returns the revision previous to the current one
+func findRevision(revisions []appsv1.ControllerRevision, number, current int64) (*appsv1.ControllerRevision, error) {
+	if number == 0 {
+		if current == 0 && len(revisions) > 0 {
+			// The function status is not available, assume the latest revision is deployed
+			current = revisions[len(revisions)-1].Revision
+		}
+		var previous *appsv1.ControllerRevision
+		for i := range revisions {
+			if revisions[i].Revision < current {
+				previous = &revisions[i]
+			}
+		}
+		if previous == nil {
+			return nil, fmt.Errorf("There is no revision previous to %d", current)
+		}
+		return previous, nil
+	}
+	for i := range revisions {
+		if revisions[i].Revision == number {
+			return &revisions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Revision %d not found", number)
+}
+
+// rollbackFunction replaces the spec of a function with the one stored in the given revision
+func rollbackFunction(f *kubelessApi.Function, revision *appsv1.ControllerRevision) error {
+	spec, err := utils.GetFuncRevisionSpec(revision)
+	if err != nil {
+		return err
+	}
+	f.Spec = *spec
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+)
+
+func fakeRevisions(t *testing.T, functions ...string) []appsv1.ControllerRevision {
+	revisions := []appsv1.ControllerRevision{}
+	for i, function := range functions {
+		data, err := json.Marshal(kubelessApi.FunctionSpec{
+			Handler:  "foo.bar",
+			Runtime:  "python2.7",
+			Function: function,
+			Checksum: "sha256:" + function,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisions = append(revisions, appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo-" + function, Namespace: "myns"},
+			Data:       runtime.RawExtension{Raw: data},
+			Revision:   int64(i + 1),
+		})
+	}
+	return revisions
+}
+
+func TestPrintFunctionHistory(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printFunctionHistory(&buf, fakeRevisions(t, "v1", "v2"), 2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := buf.String()
+	t.Log("output is", output)
+	for _, expected := range []string{`1\s+foo.bar\s+python2.7\s+sha256:v1`, `2 \(current\)\s+foo.bar\s+python2.7\s+sha256:v2`} {
+		m, err := regexp.MatchString(expected, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !m {
+			t.Errorf("Expecting %q in the output", expected)
+		}
+	}
+}
+
+func TestFindRevision(t *testing.T) {
+	revisions := fakeRevisions(t, "v1", "v2", "v3")
+	tests := []struct {
+		number, current, expected int64
+	}{
+		{number: 2, current: 3, expected: 2},
+		{number: 0, current: 3, expected: 2},
+		{number: 0, current: 2, expected: 1},
+		// Without status the latest revision is considered the current one
+		{number: 0, current: 0, expected: 2},
+	}
+	for _, test := range tests {
+		rev, err := findRevision(revisions, test.number, test.current)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rev.Revision != test.expected {
+			t.Errorf("Expecting revision %d, received %d", test.expected, rev.Revision)
+		}
+	}
+	if _, err := findRevision(revisions, 4, 3); err == nil {
+		t.Error("Expecting an error for an unknown revision")
+	}
+	if _, err := findRevision(revisions, 0, 1); err == nil {
+		t.Error("Expecting an error when there is no previous revision")
+	}
+}
+
+func TestRollbackFunction(t *testing.T) {
+	f := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Spec: kubelessApi.FunctionSpec{
+			Handler:  "foo.bar",
+			Function: "v2",
+			Canary:   &kubelessApi.FunctionCanary{Weight: 10},
+		},
+	}
+	revisions := fakeRevisions(t, "v1", "v2")
+	if err := rollbackFunction(f, &revisions[0]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Spec.Function != "v1" || f.Spec.Checksum != "sha256:v1" {
+		t.Errorf("Expecting the spec of the first revision, received %v", f.Spec)
+	}
+	if f.Spec.Canary != nil {
+		t.Error("Expecting the canary to be discarded")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -140,22 +141,22 @@ func TestList(t *testing.T) {
 
 	client := fFake.NewSimpleClientset(listObj.Items[0], listObj.Items[1], listObj.Items[2])
 
-	deploymentFoo := v1beta1.Deployment{
+	deploymentFoo := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "foo",
 			Namespace: "myns",
 		},
-		Status: v1beta1.DeploymentStatus{
+		Status: appsv1.DeploymentStatus{
 			Replicas:      int32(1),
 			ReadyReplicas: int32(1),
 		},
 	}
-	deploymentBar := v1beta1.Deployment{
+	deploymentBar := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "bar",
 			Namespace: "myns",
 		},
-		Status: v1beta1.DeploymentStatus{
+		Status: appsv1.DeploymentStatus{
 			Replicas:      int32(2),
 			ReadyReplicas: int32(0),
 		},
@@ -231,3 +232,58 @@ func TestList(t *testing.T) {
 		t.Errorf("table output didn't mention proper env of function")
 	}
 }
+
+func TestListWithStatus(t *testing.T) {
+	listObj := kubelessApi.FunctionList{
+		Items: []*kubelessApi.Function{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo",
+					Namespace: "myns",
+				},
+				Spec: kubelessApi.FunctionSpec{
+					Handler: "foo,bar",
+					Runtime: "python2.7",
+				},
+				Status: kubelessApi.FunctionStatus{
+					Phase:     kubelessApi.FunctionPhaseFailed,
+					LastError: "incorrect handler format",
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "bar",
+					Namespace: "myns",
+				},
+				Spec: kubelessApi.FunctionSpec{
+					Handler: "bar.foo",
+					Runtime: "python2.7",
+				},
+				Status: kubelessApi.FunctionStatus{
+					Phase: kubelessApi.FunctionPhaseBuilding,
+				},
+			},
+		},
+	}
+
+	client := fFake.NewSimpleClientset(listObj.Items[0], listObj.Items[1])
+	apiV1Client := fake.NewSimpleClientset()
+
+	output := listOutput(t, client, apiV1Client, "myns", "", []string{})
+	t.Log("output is", output)
+
+	m, err := regexp.MatchString("foo.*FAILED: incorrect handler format", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m {
+		t.Errorf("table output didn't mention the function error")
+	}
+	m, err = regexp.MatchString("bar.*BUILDING", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m {
+		t.Errorf("table output didn't mention the function phase")
+	}
+}
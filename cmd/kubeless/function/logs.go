@@ -17,13 +17,19 @@ limitations under the License.
 package function
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/kubeless/kubeless/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 var logsCmd = &cobra.Command{
@@ -39,6 +45,10 @@ var logsCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		eventID, err := cmd.Flags().GetString("event-id")
+		if err != nil {
+			logrus.Fatal(err)
+		}
 		ns, err := cmd.Flags().GetString("namespace")
 		if err != nil {
 			logrus.Fatal(err)
@@ -55,12 +65,20 @@ var logsCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatalf("Can't find the function pod: %v", err)
 		}
+		if eventID != "" {
+			// The invocation may have been served by any replica, including the canary ones
+			if err := printEventLogs(k8sClient, pods.Items, eventID, follow, os.Stdout); err != nil {
+				logrus.Fatalf("Reading log failed: %v", err)
+			}
+			return
+		}
 		readyPod, err := utils.GetReadyPod(pods)
 		if err != nil {
 			logrus.Fatalf("No function pod is running: %v", err)
 		}
 		podLog := &v1.PodLogOptions{
-			Container: funcName,
+			// The canary revision of a function runs in a container with a different name
+			Container: readyPod.Spec.Containers[0].Name,
 			Follow:    follow,
 		}
 		req := k8sClient.Core().Pods(ns).GetLogs(readyPod.Name, podLog)
@@ -74,7 +92,86 @@ var logsCmd = &cobra.Command{
 	},
 }
 
+// lockedWriter serializes the writes of the goroutines that read the logs of different pods
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(p)
+}
+
+// printEventLogs reads the logs of every started pod at the same time and writes the lines
+// that belong to the invocation with the given ID
+func printEventLogs(client kubernetes.Interface, pods []v1.Pod, eventID string, follow bool, w io.Writer) error {
+	out := &lockedWriter{w: w}
+	errs := make([]error, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		pod := pods[i]
+		if pod.Status.Phase == v1.PodPending || len(pod.Spec.Containers) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logs, err := client.CoreV1().Pods(pod.ObjectMeta.Namespace).GetLogs(pod.ObjectMeta.Name, &v1.PodLogOptions{
+				// The canary revision of a function runs in a container with a different name
+				Container: pod.Spec.Containers[0].Name,
+				Follow:    follow,
+			}).Stream()
+			if err != nil {
+				errs[i] = fmt.Errorf("pod %s: %v", pod.ObjectMeta.Name, err)
+				return
+			}
+			defer logs.Close()
+			if err := filterEventLogs(logs, out, eventID); err != nil {
+				errs[i] = fmt.Errorf("pod %s: %v", pod.ObjectMeta.Name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	messages := []string{}
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, ", "))
+	}
+	return nil
+}
+
+// filterEventLogs copies the log lines that belong to the invocation with the given ID.
+// Records written by the function proxy are matched by their event-id field, any
+// other line is matched if it contains the ID
+func filterEventLogs(r io.Reader, w io.Writer, eventID string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err == nil {
+			if id, ok := record["event-id"]; ok {
+				if id == eventID {
+					io.WriteString(w, line+"\n")
+				}
+				continue
+			}
+		}
+		if strings.Contains(line, eventID) {
+			io.WriteString(w, line+"\n")
+		}
+	}
+	return scanner.Err()
+}
+
 func init() {
 	logsCmd.Flags().BoolP("follow", "f", false, "Specify if the logs should be streamed.")
 	logsCmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+	logsCmd.Flags().StringP("event-id", "", "", "Show only the logs of the invocation with the given event ID")
 }
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestFilterEventLogs(t *testing.T) {
+	logs := strings.Join([]string{
+		`{"time":"2018-01-01T00:00:00Z","event-id":"abc","method":"GET","status":200}`,
+		`{"time":"2018-01-01T00:00:01Z","event-id":"xyz","method":"GET","status":200}`,
+		`processing abc`,
+		`processing xyz`,
+		`{"event-id":"abcd","status":500}`,
+		`{"level":"info","msg":"abc"}`,
+	}, "\n")
+	output := &bytes.Buffer{}
+	if err := filterEventLogs(strings.NewReader(logs), output, "abc"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{"time":"2018-01-01T00:00:00Z","event-id":"abc","method":"GET","status":200}
+processing abc
+{"level":"info","msg":"abc"}
+`
+	if output.String() != expected {
+		t.Errorf("Expecting:\n%s\nReceived:\n%s", expected, output.String())
+	}
+}
+
+func TestPrintEventLogs(t *testing.T) {
+	logs := map[string]string{
+		"/api/v1/namespaces/myns/pods/foo-1/log": "processing xyz\n",
+		"/api/v1/namespaces/myns/pods/foo-2/log": "processing xyz\nprocessing abc\n",
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log, ok := logs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("container") != "foo" {
+			t.Errorf("Unexpected container %s", r.URL.Query().Get("container"))
+		}
+		w.Write([]byte(log))
+	}))
+	defer s.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := func(name string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: name},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "foo"}}},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+	pods := []v1.Pod{pod("foo-1", v1.PodRunning), pod("foo-2", v1.PodRunning), pod("foo-3", v1.PodPending)}
+	output := &bytes.Buffer{}
+	if err := printEventLogs(client, pods, "abc", false, output); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output.String() != "processing abc\n" {
+		t.Errorf("Expecting the log of the second pod, received %q", output.String())
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <function_name> FLAG",
+	Short: "restore a previous revision of a function",
+	Long:  `restore a previous revision of a function. Use 'kubeless function history' to list the available revisions`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - function name")
+		}
+		funcName := args[0]
+
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+
+		toRevision, err := cmd.Flags().GetInt64("to-revision")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if toRevision < 0 {
+			logrus.Fatalf("Invalid revision %d", toRevision)
+		}
+
+		f, err := utils.GetFunction(funcName, ns)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		revisions, err := utils.ListFuncRevisions(utils.GetClientOutOfCluster(), ns, funcName)
+		if err != nil {
+			logrus.Fatalf("Unable to list the revisions of %s: %v", funcName, err)
+		}
+		revision, err := findRevision(revisions, toRevision, f.Status.Revision)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if f.Spec.Canary != nil {
+			logrus.Warnf("Function %s has a rollout in progress. Its canary will be discarded", funcName)
+		}
+		err = rollbackFunction(&f, revision)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = utils.UpdateFunctionCustomResource(kubelessClient, &f)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("Function %s rolled back to revision %d", funcName, revision.Revision)
+		logrus.Infof("Check the deployment status executing 'kubeless function ls %s'", funcName)
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+	rollbackCmd.Flags().Int64("to-revision", 0, "The revision to restore. By default, the revision previous to the current one")
+}
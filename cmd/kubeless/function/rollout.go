@@ -0,0 +1,249 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gosuri/uitable"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout SUBCOMMAND",
+	Short: "manage canary releases of a function",
+	Long: `rollout command allows user to manage canary releases of a function.
+
+A canary release is started executing 'kubeless function update <function_name> --canary-weight <weight>'.
+The new revision of the function runs next to the current one and receives the given percentage of the traffic
+until it is promoted or rolled back.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var rolloutStatusCmd = &cobra.Command{
+	Use:   "status <function_name>",
+	Short: "show the status of the canary release of a function",
+	Long:  `show the status of the canary release of a function`,
+	Run: func(cmd *cobra.Command, args []string) {
+		funcName, ns := rolloutArgs(cmd, args, 1)
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		f, err := kubelessClient.KubelessV1beta1().Functions(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = printRolloutStatus(cmd.OutOrStdout(), utils.GetClientOutOfCluster(), f)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var rolloutSetWeightCmd = &cobra.Command{
+	Use:   "set-weight <function_name> <weight>",
+	Short: "change the percentage of the traffic sent to the canary revision of a function",
+	Long:  `change the percentage of the traffic sent to the canary revision of a function`,
+	Run: func(cmd *cobra.Command, args []string) {
+		funcName, ns := rolloutArgs(cmd, args, 2)
+		weight, err := strconv.Atoi(args[1])
+		if err != nil {
+			logrus.Fatalf("Invalid weight %s: %v", args[1], err)
+		}
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = updateRollout(kubelessClient, ns, funcName, func(f *kubelessApi.Function) error {
+			return setCanaryWeight(f, int32(weight))
+		})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("Canary of function %s now receives %d%% of the traffic", funcName, weight)
+	},
+}
+
+var rolloutPromoteCmd = &cobra.Command{
+	Use:   "promote <function_name>",
+	Short: "replace the current revision of a function with its canary",
+	Long:  `replace the current revision of a function with its canary`,
+	Run: func(cmd *cobra.Command, args []string) {
+		funcName, ns := rolloutArgs(cmd, args, 1)
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = updateRollout(kubelessClient, ns, funcName, promoteCanary)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("Canary of function %s promoted", funcName)
+		logrus.Infof("Check the deployment status executing 'kubeless function ls %s'", funcName)
+	},
+}
+
+var rolloutRollbackCmd = &cobra.Command{
+	Use:   "rollback <function_name>",
+	Short: "discard the canary revision of a function",
+	Long:  `discard the canary revision of a function sending all the traffic back to the current revision`,
+	Run: func(cmd *cobra.Command, args []string) {
+		funcName, ns := rolloutArgs(cmd, args, 1)
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = updateRollout(kubelessClient, ns, funcName, rollbackCanary)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("Canary of function %s rolled back", funcName)
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{rolloutStatusCmd, rolloutSetWeightCmd, rolloutPromoteCmd, rolloutRollbackCmd} {
+		cmd.Flags().StringP("namespace", "n", "", "Specify namespace for the function")
+		rolloutCmd.AddCommand(cmd)
+	}
+}
+
+// rolloutArgs validates the arguments of a rollout subcommand and returns the function name and namespace
+func rolloutArgs(cmd *cobra.Command, args []string, expected int) (string, string) {
+	if len(args) != expected {
+		logrus.Fatalf("Need exactly %d argument(s). Usage: %s", expected, cmd.Use)
+	}
+	ns, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if ns == "" {
+		ns = utils.GetDefaultNamespace()
+	}
+	return args[0], ns
+}
+
+// canaryRollout returns a copy of the candidate function that keeps the spec of the current function
+// as the stable revision and the spec of the candidate as a canary that receives the given weight
+func canaryRollout(current, candidate *kubelessApi.Function, weight int32) *kubelessApi.Function {
+	f := candidate.DeepCopy()
+	f.Spec = *current.Spec.DeepCopy()
+	canarySpec := *candidate.Spec.DeepCopy()
+	canarySpec.Canary = nil
+	f.Spec.Canary = &kubelessApi.FunctionCanary{
+		Weight: weight,
+		Spec:   canarySpec,
+	}
+	return f
+}
+
+func setCanaryWeight(f *kubelessApi.Function, weight int32) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("Invalid weight %d. It should be a percentage between 0 and 100", weight)
+	}
+	f.Spec.Canary.Weight = weight
+	return utils.ValidateCanary(&f.Spec)
+}
+
+func promoteCanary(f *kubelessApi.Function) error {
+	f.Spec = *f.Spec.Canary.Spec.DeepCopy()
+	f.Spec.Canary = nil
+	return nil
+}
+
+func rollbackCanary(f *kubelessApi.Function) error {
+	f.Spec.Canary = nil
+	return nil
+}
+
+// updateRollout applies the given modification to a function with a rollout in progress
+func updateRollout(kubelessClient versioned.Interface, ns, funcName string, modify func(*kubelessApi.Function) error) error {
+	f, err := kubelessClient.KubelessV1beta1().Functions(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if f.Spec.Canary == nil {
+		return fmt.Errorf("Function %s has no rollout in progress", funcName)
+	}
+	err = modify(f)
+	if err != nil {
+		return err
+	}
+	return utils.UpdateFunctionCustomResource(kubelessClient, f)
+}
+
+// deploymentReplicas returns the ready and desired replicas of a deployment (zero if it doesn't exist)
+func deploymentReplicas(cli kubernetes.Interface, ns, name string) (int32, int32, error) {
+	dpm, err := cli.AppsV1().Deployments(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	return dpm.Status.ReadyReplicas, dpm.Status.Replicas, nil
+}
+
+// printRolloutStatus prints the weight configured for each revision of a function and
+// the share of the traffic they actually receive (based on their ready pods)
+func printRolloutStatus(w io.Writer, cli kubernetes.Interface, f *kubelessApi.Function) error {
+	if f.Spec.Canary == nil {
+		fmt.Fprintf(w, "Function %s has no rollout in progress\n", f.ObjectMeta.Name)
+		return nil
+	}
+	ns := f.ObjectMeta.Namespace
+	canaryName := utils.CanaryName(f.ObjectMeta.Name)
+	stableReady, stableReplicas, err := deploymentReplicas(cli, ns, f.ObjectMeta.Name)
+	if err != nil {
+		return err
+	}
+	canaryReady, canaryReplicas, err := deploymentReplicas(cli, ns, canaryName)
+	if err != nil {
+		return err
+	}
+	traffic := func(ready int32) string {
+		if stableReady+canaryReady == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d%%", ready*100/(stableReady+canaryReady))
+	}
+
+	table := uitable.New()
+	table.MaxColWidth = 50
+	table.AddRow("NAME", "TRACK", "WEIGHT", "READY", "TRAFFIC")
+	table.AddRow(f.ObjectMeta.Name, "stable", fmt.Sprintf("%d%%", 100-f.Spec.Canary.Weight), fmt.Sprintf("%d/%d", stableReady, stableReplicas), traffic(stableReady))
+	table.AddRow(canaryName, "canary", fmt.Sprintf("%d%%", f.Spec.Canary.Weight), fmt.Sprintf("%d/%d", canaryReady, canaryReplicas), traffic(canaryReady))
+	fmt.Fprintln(w, table)
+	return nil
+}
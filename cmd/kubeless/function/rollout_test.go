@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	fFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
+)
+
+func rolloutFunction() *kubelessApi.Function {
+	replicas := int32(10)
+	return &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "myns",
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Handler:  "foo.bar",
+			Function: "stable",
+			Deployment: v1beta1.Deployment{
+				Spec: v1beta1.DeploymentSpec{Replicas: &replicas},
+			},
+			Canary: &kubelessApi.FunctionCanary{
+				Weight: 10,
+				Spec: kubelessApi.FunctionSpec{
+					Handler:  "foo.bar",
+					Function: "canary",
+				},
+			},
+		},
+	}
+}
+
+func TestCanaryRollout(t *testing.T) {
+	current := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Spec:       kubelessApi.FunctionSpec{Function: "stable"},
+	}
+	candidate := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Spec:       kubelessApi.FunctionSpec{Function: "canary"},
+	}
+	f := canaryRollout(current, candidate, 20)
+	if f.Spec.Function != "stable" {
+		t.Errorf("Expecting the stable spec to be kept, received %s", f.Spec.Function)
+	}
+	if f.Spec.Canary == nil || f.Spec.Canary.Weight != 20 || f.Spec.Canary.Spec.Function != "canary" {
+		t.Errorf("Unexpected canary %v", f.Spec.Canary)
+	}
+	if candidate.Spec.Canary != nil || current.Spec.Canary != nil {
+		t.Error("The given functions should not be modified")
+	}
+}
+
+func TestUpdateRollout(t *testing.T) {
+	client := fFake.NewSimpleClientset(rolloutFunction())
+	err := updateRollout(client, "myns", "foo", func(f *kubelessApi.Function) error {
+		return setCanaryWeight(f, 50)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f, err := client.KubelessV1beta1().Functions("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Spec.Canary.Weight != 50 {
+		t.Errorf("Expecting weight 50, received %d", f.Spec.Canary.Weight)
+	}
+
+	err = updateRollout(client, "myns", "foo", func(f *kubelessApi.Function) error {
+		return setCanaryWeight(f, 101)
+	})
+	if err == nil {
+		t.Error("Expecting an error for an invalid weight")
+	}
+
+	err = updateRollout(client, "myns", "foo", func(f *kubelessApi.Function) error {
+		return setCanaryWeight(f, 3)
+	})
+	if err == nil {
+		t.Error("Expecting an error for a weight that the replicas of the function can't honor")
+	}
+
+	err = updateRollout(client, "myns", "foo", promoteCanary)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f, err = client.KubelessV1beta1().Functions("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Spec.Function != "canary" || f.Spec.Canary != nil {
+		t.Errorf("Expecting the canary to be promoted, received %v", f.Spec)
+	}
+
+	// Without a rollout in progress there is nothing to promote or roll back
+	err = updateRollout(client, "myns", "foo", rollbackCanary)
+	if err == nil {
+		t.Error("Expecting an error for a function without rollout")
+	}
+
+	client = fFake.NewSimpleClientset(rolloutFunction())
+	err = updateRollout(client, "myns", "foo", rollbackCanary)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f, err = client.KubelessV1beta1().Functions("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Spec.Function != "stable" || f.Spec.Canary != nil {
+		t.Errorf("Expecting the canary to be discarded, received %v", f.Spec)
+	}
+}
+
+func TestRolloutStatus(t *testing.T) {
+	stable := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Status:     appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 3},
+	}
+	canary := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-canary", Namespace: "myns"},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+	}
+	cli := fake.NewSimpleClientset(&stable, &canary)
+
+	var buf bytes.Buffer
+	if err := printRolloutStatus(&buf, cli, rolloutFunction()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := buf.String()
+	t.Log("output is", output)
+	for _, expected := range []string{`foo\s+stable\s+90%\s+3/3\s+75%`, `foo-canary\s+canary\s+10%\s+1/1\s+25%`} {
+		m, err := regexp.MatchString(expected, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !m {
+			t.Errorf("Expecting %q in the output", expected)
+		}
+	}
+
+	f := rolloutFunction()
+	f.Spec.Canary = nil
+	buf.Reset()
+	if err := printRolloutStatus(&buf, cli, f); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.String() != "Function foo has no rollout in progress\n" {
+		t.Errorf("Unexpected output %s", buf.String())
+	}
+}
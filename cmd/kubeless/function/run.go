@@ -0,0 +1,516 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ghodss/yaml"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	clientsetAPIExtensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultProvisionImage = "kubeless/unzip:latest"
+	localRunReadyTimeout  = 2 * time.Minute
+)
+
+// execCommand is replaced in the tests to avoid calling docker
+var execCommand = exec.Command
+
+// defaultRuntimeImages are the runtimes used when the configuration of the cluster is not available.
+// The released binaries embed the runtimes of kubeless.yaml (see hack/embed-runtimes.go)
+var defaultRuntimeImages = ""
+
+var runCmd = &cobra.Command{
+	Use:   "run [<function_name>] FLAG",
+	Short: "run a function locally",
+	Long: `Run a function in the local host using docker. The function is prepared, its dependencies
+installed and compiled using the same images that the controller uses in a cluster. Then the
+function is served in localhost and called with the given data.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			logrus.Fatal("Accepting at most one argument - function name")
+		}
+
+		runtime, err := cmd.Flags().GetString("runtime")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		handler, err := cmd.Flags().GetString("handler")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		file, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fromDir, err := cmd.Flags().GetString("from-dir")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if file != "" && fromDir != "" {
+			logrus.Fatal("The flags `--from-file` and `--from-dir` can't be used together")
+		}
+		deps, err := cmd.Flags().GetString("dependencies")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		envs, err := cmd.Flags().GetStringSlice("env")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		runtimeImage, err := cmd.Flags().GetString("runtime-image")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		timeout, err := cmd.Flags().GetString("timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		port, err := cmd.Flags().GetInt32("port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if port <= 0 || port > 65535 {
+			logrus.Fatalf("Invalid port number %d specified", port)
+		}
+		data, err := cmd.Flags().GetString("data")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		serve, err := cmd.Flags().GetBool("serve")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		configFile, err := cmd.Flags().GetString("config-file")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		docker, err := cmd.Flags().GetString("docker")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if (file == "" && fromDir == "") || handler == "" {
+			logrus.Fatal("The flags `--handler` and `--from-file` or `--from-dir` are required")
+		}
+		if runtime == "" && runtimeImage == "" {
+			logrus.Fatal("Either `--runtime` or `--runtime-image` flag must be specified.")
+		}
+		funcName := strings.Split(handler, ".")[0]
+		if len(args) == 1 {
+			funcName = args[0]
+		}
+
+		config, err := getLocalRunConfig(configFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		lr := langruntime.New(config)
+		lr.ReadConfigMap()
+		if runtime != "" && !lr.IsValidRuntime(runtime) {
+			logrus.Fatalf("Invalid runtime: %s. Supported runtimes are: %s",
+				runtime, strings.Join(lr.GetRuntimes(), ", "))
+		}
+
+		funcDeps := ""
+		if deps != "" {
+			contentType, err := getContentType(deps)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			funcDeps, _, err = parseContent(deps, contentType)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		}
+
+		f, err := getFunctionDescription(funcName, "default", handler, file, funcDeps, runtime, runtimeImage, "", "", timeout, string(v1.PullIfNotPresent), 8080, false, envs, nil, nil, kubelessApi.Function{})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if fromDir != "" {
+			if err := setFunctionFromDir(f, fromDir, lr, deps == ""); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		provisionImage := config.Data["provision-image"]
+		if provisionImage == "" {
+			provisionImage = defaultProvisionImage
+		}
+		pod, err := utils.GetLocalFunctionPod(f, lr, provisionImage)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		runner := &localRunner{
+			docker: docker,
+			stdout: os.Stdout,
+			stderr: os.Stderr,
+		}
+		ctx, cancel := interruptContext()
+		defer cancel()
+		if err := runner.run(ctx, pod, port, data, serve); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+// getLocalRunConfig returns the Kubeless configuration from a file or, if no file is given, from the cluster.
+// If the cluster is not available, the runtimes embedded in the CLI are used
+func getLocalRunConfig(file string) (*v1.ConfigMap, error) {
+	if file == "" {
+		config, err := getClusterConfig()
+		if err == nil {
+			return config, nil
+		}
+		if defaultRuntimeImages == "" {
+			return nil, fmt.Errorf("Unable to read the configuration of the cluster: %v. Use --config-file to specify it", err)
+		}
+		logrus.Warnf("Unable to read the configuration of the cluster (%v), using the default runtimes", err)
+		return &v1.ConfigMap{Data: map[string]string{"runtime-images": defaultRuntimeImages}}, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &v1.ConfigMap{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", file, err)
+	}
+	if config.Data["runtime-images"] == "" {
+		return nil, fmt.Errorf("The configuration in %s doesn't contain runtime-images", file)
+	}
+	return config, nil
+}
+
+// getClusterConfig returns the Kubeless configuration of the current cluster
+func getClusterConfig() (*v1.ConfigMap, error) {
+	restConfig, err := utils.BuildOutOfClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Don't wait too long if the cluster is not reachable
+	restConfig.Timeout = 10 * time.Second
+	cli, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	apiExtensionsClientset, err := clientsetAPIExtensions.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetKubelessConfig(cli, apiExtensionsClientset)
+}
+
+// interruptContext returns a context that is cancelled when the process receives SIGINT or SIGTERM
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// dockerRunArgs returns the arguments of a "docker run" that executes the given container.
+// volumes maps the name of the volumes of the pod to a directory of the host
+func dockerRunArgs(c v1.Container, volumes map[string]string, extraArgs ...string) []string {
+	args := []string{"run", "--rm"}
+	args = append(args, extraArgs...)
+	for _, m := range c.VolumeMounts {
+		dir, ok := volumes[m.Name]
+		if !ok {
+			logrus.Warnf("Volume %s is not available locally, skipping", m.Name)
+			continue
+		}
+		args = append(args, "-v", fmt.Sprintf("%s:%s", dir, m.MountPath))
+	}
+	for _, e := range c.Env {
+		if e.ValueFrom != nil {
+			logrus.Warnf("Environment variable %s references a cluster resource, skipping", e.Name)
+			continue
+		}
+		args = append(args, "-e", fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	if c.WorkingDir != "" {
+		args = append(args, "-w", c.WorkingDir)
+	}
+	command := c.Command
+	if len(command) > 0 {
+		args = append(args, "--entrypoint", command[0])
+		command = command[1:]
+	}
+	args = append(args, c.Image)
+	args = append(args, command...)
+	return append(args, c.Args...)
+}
+
+// localRunner executes the containers of a function using docker
+type localRunner struct {
+	docker string
+	stdout io.Writer
+	stderr io.Writer
+	// containers started by the runner, removed in cleanup
+	containers []string
+}
+
+func (r *localRunner) command(args ...string) *exec.Cmd {
+	logrus.Debugf("Executing %s %s", r.docker, strings.Join(args, " "))
+	c := execCommand(r.docker, args...)
+	c.Stderr = r.stderr
+	return c
+}
+
+// exec runs a command until it finishes or the context is cancelled
+func (r *localRunner) exec(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// containerArgs returns the "docker run" arguments that name a new container so it can be removed in cleanup
+func (r *localRunner) containerArgs(step string, runArgs ...string) []string {
+	name := fmt.Sprintf("kubeless-%d-%d-%s", os.Getpid(), len(r.containers), step)
+	r.containers = append(r.containers, name)
+	return append(append([]string{}, runArgs...), "--name", name)
+}
+
+// cleanup removes the containers started by the runner that are still running
+func (r *localRunner) cleanup() {
+	for _, c := range r.containers {
+		cmd := r.command("rm", "-f", c)
+		cmd.Stderr = nil
+		if err := cmd.Run(); err != nil {
+			// Containers of finished steps have been already removed by docker
+			logrus.Debugf("Unable to remove container %s: %v", c, err)
+		}
+	}
+	r.containers = nil
+}
+
+// prepare writes the function files and executes the init containers of the function.
+// extraVolumes maps additional volumes of the pod to existing directories, runArgs are
+// added to every "docker run". It returns the volumes of the pod
+func (r *localRunner) prepare(ctx context.Context, pod *utils.LocalFunctionPod, dir string, extraVolumes map[string]string, runArgs ...string) (map[string]string, error) {
+	volumes := map[string]string{
+		pod.SourceVolume:  filepath.Join(dir, "src"),
+		pod.RuntimeVolume: filepath.Join(dir, "kubeless"),
+	}
+	for _, d := range volumes {
+		if err := os.Mkdir(d, 0777); err != nil {
+			return nil, err
+		}
+		// The runtime containers may not be executed as root
+		if err := os.Chmod(d, 0777); err != nil {
+			return nil, err
+		}
+	}
+	for name, d := range extraVolumes {
+		volumes[name] = d
+	}
+	for name, content := range pod.Files {
+		if err := ioutil.WriteFile(filepath.Join(volumes[pod.SourceVolume], name), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range pod.InitContainers {
+		logrus.Infof("Running %s step using %s", c.Name, c.Image)
+		cmd := r.command(dockerRunArgs(c, volumes, r.containerArgs(c.Name, runArgs...)...)...)
+		cmd.Stdout = r.stderr
+		if err := r.exec(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("Step %s failed: %v", c.Name, err)
+		}
+	}
+	return volumes, nil
+}
+
+// run prepares the function, serves it in the given port and calls it with the given data.
+// If serve is true it keeps serving the function until the context is cancelled
+func (r *localRunner) run(ctx context.Context, pod *utils.LocalFunctionPod, port int32, data string, serve bool) error {
+	dir, err := ioutil.TempDir("", "kubeless-run-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Warnf("Unable to remove %s: %v", dir, err)
+		}
+	}()
+	defer r.cleanup()
+	volumes, err := r.prepare(ctx, pod, dir, nil)
+	if err != nil {
+		return err
+	}
+
+	funcPort := "8080"
+	for _, e := range pod.Container.Env {
+		if e.Name == "FUNC_PORT" {
+			funcPort = e.Value
+		}
+	}
+	logrus.Infof("Starting function using %s", pod.Container.Image)
+	runArgs := r.containerArgs("function", "-d", "-p", fmt.Sprintf("127.0.0.1:%d:%s", port, funcPort))
+	container := runArgs[len(runArgs)-1]
+	cmd := r.command(dockerRunArgs(pod.Container, volumes, runArgs...)...)
+	cmd.Stdout = ioutil.Discard
+	if err := r.exec(ctx, cmd); err != nil {
+		return fmt.Errorf("Unable to start the function: %v", err)
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	if err := waitForLocalFunction(ctx, url, localRunReadyTimeout); err != nil {
+		r.command("logs", container).Run()
+		return err
+	}
+	if data != "" || !serve {
+		res, err := callLocalFunction(ctx, url, data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.stdout, res)
+	}
+	if !serve {
+		return nil
+	}
+
+	logrus.Infof("Serving function at %s. Press Ctrl+C to stop it", url)
+	logs := r.command("logs", "-f", container)
+	logs.Stdout = r.stderr
+	if err := r.exec(ctx, logs); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("The function stopped: %v", err)
+	}
+	return nil
+}
+
+// waitForLocalFunction waits until the health endpoint of the function responds
+func waitForLocalFunction(ctx context.Context, url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		req, err := http.NewRequest("GET", url+"/healthz", nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("The function is not ready after %v", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// callLocalFunction sends the data to the function the same way "kubeless function call" does
+func callLocalFunction(ctx context.Context, url, data string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if data != "" {
+		req, err = http.NewRequest("POST", url, strings.NewReader(data))
+		if err == nil {
+			contentType := "application/x-www-form-urlencoded"
+			if utils.IsJSON(data) {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set("event-type", contentType)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	eventID, err := utils.GetRandString(11)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate ID %v", err)
+	}
+	req.Header.Set("event-id", eventID)
+	req.Header.Set("event-time", time.Now().UTC().Format(time.RFC3339))
+	req.Header.Set("event-namespace", "cli.kubeless.io")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("The function returned %s: %s", res.Status, string(body))
+	}
+	return string(body), nil
+}
+
+func init() {
+	runCmd.Flags().StringP("runtime", "r", "", "Specify runtime")
+	runCmd.Flags().StringP("handler", "", "", "Specify handler")
+	runCmd.Flags().StringP("from-file", "f", "", "Specify code file or a URL to the code file")
+	runCmd.Flags().String("from-dir", "", "Specify a directory with the code of the function. It is compressed honouring the patterns of its .kubelessignore file")
+	runCmd.Flags().StringP("dependencies", "", "", "Specify a file containing list of dependencies for the function")
+	runCmd.Flags().StringSliceP("env", "e", []string{}, "Specify environment variable of the function. Both separator ':' and '=' are allowed. For example: --env foo1=bar1,foo2:bar2")
+	runCmd.Flags().StringP("runtime-image", "", "", "Custom runtime image")
+	runCmd.Flags().StringP("timeout", "", "180", "Maximum timeout (in seconds) for the function to complete its execution")
+	runCmd.Flags().Int32("port", 8080, "Port of localhost in which the function is served")
+	runCmd.Flags().StringP("data", "d", "", "Specify data for function")
+	runCmd.Flags().Bool("serve", false, "Keep serving the function after calling it until Ctrl+C is pressed")
+	runCmd.Flags().String("config-file", "", "Read the runtimes from a kubeless-config ConfigMap manifest instead of the cluster")
+	runCmd.Flags().String("docker", "docker", "Path to the docker binary")
+}
@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+	"k8s.io/api/core/v1"
+)
+
+func TestDockerRunArgs(t *testing.T) {
+	c := v1.Container{
+		Image:        "python:3.6",
+		Command:      []string{"sh", "-c"},
+		Args:         []string{"pip install -r requirements.txt"},
+		WorkingDir:   "/kubeless",
+		VolumeMounts: []v1.VolumeMount{{Name: "runtime", MountPath: "/kubeless"}, {Name: "secret", MountPath: "/secret"}},
+		Env: []v1.EnvVar{
+			{Name: "FOO", Value: "bar"},
+			{Name: "SECRET", ValueFrom: &v1.EnvVarSource{}},
+		},
+	}
+	args := dockerRunArgs(c, map[string]string{"runtime": "/tmp/runtime"}, "-d")
+	expected := []string{
+		"run", "--rm", "-d",
+		"-v", "/tmp/runtime:/kubeless",
+		"-e", "FOO=bar",
+		"-w", "/kubeless",
+		"--entrypoint", "sh",
+		"python:3.6", "-c", "pip install -r requirements.txt",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expecting %v, received %v", expected, args)
+	}
+}
+
+func TestLocalRunner(t *testing.T) {
+	// The test server plays the role of the runtime container
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = r.Header.Get("Content-Type") + " " + string(body)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+	_, p, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	port, _ := strconv.Atoi(p)
+
+	calls := [][]string{}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		if args[0] == "run" && args[2] == "-d" {
+			return exec.Command("echo", "container-id")
+		}
+		return exec.Command("true")
+	}
+	defer func() { execCommand = exec.Command }()
+
+	pod := &utils.LocalFunctionPod{
+		Files:         map[string]string{"handler.py": "code"},
+		SourceVolume:  "src",
+		RuntimeVolume: "runtime",
+		InitContainers: []v1.Container{
+			{Name: "prepare", Image: "unzip", VolumeMounts: []v1.VolumeMount{{Name: "src", MountPath: "/src"}}},
+		},
+		Container: v1.Container{
+			Image:        "runtime",
+			Env:          []v1.EnvVar{{Name: "FUNC_PORT", Value: "9090"}},
+			VolumeMounts: []v1.VolumeMount{{Name: "runtime", MountPath: "/kubeless"}},
+		},
+	}
+	stdout := &bytes.Buffer{}
+	runner := &localRunner{docker: "docker", stdout: stdout, stderr: ioutil.Discard}
+	if err := runner.run(context.Background(), pod, int32(port), `{"hello": "world"}`, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("Unexpected output %q", stdout.String())
+	}
+	if received != `application/json {"hello": "world"}` {
+		t.Errorf("Unexpected request %q", received)
+	}
+	if len(calls) != 4 {
+		t.Fatalf("Expecting 4 docker calls, received %v", calls)
+	}
+	if calls[0][len(calls[0])-1] != "unzip" || !strings.HasSuffix(calls[0][5], "/src:/src") {
+		t.Errorf("Unexpected prepare call %v", calls[0])
+	}
+	if calls[1][4] != "127.0.0.1:"+p+":9090" {
+		t.Errorf("Unexpected port mapping in %v", calls[1])
+	}
+	prepare, function := calls[0][3], calls[1][6]
+	if !reflect.DeepEqual(calls[2], []string{"rm", "-f", prepare}) || !reflect.DeepEqual(calls[3], []string{"rm", "-f", function}) {
+		t.Errorf("The containers should be removed, received %v", calls[2:])
+	}
+}
+
+func TestLocalRunnerInterrupted(t *testing.T) {
+	// The function never gets ready
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	_, p, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	port, _ := strconv.Atoi(p)
+
+	calls := [][]string{}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		return exec.Command("true")
+	}
+	defer func() { execCommand = exec.Command }()
+
+	pod := &utils.LocalFunctionPod{
+		SourceVolume:  "src",
+		RuntimeVolume: "runtime",
+		Container:     v1.Container{Image: "runtime"},
+	}
+	runner := &localRunner{docker: "docker", stdout: ioutil.Discard, stderr: ioutil.Discard}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := runner.run(ctx, pod, int32(port), "", true); err != context.Canceled {
+		t.Fatalf("Expecting the run to be cancelled, received %v", err)
+	}
+	last := calls[len(calls)-1]
+	if calls[0][0] != "run" || !reflect.DeepEqual(last, []string{"rm", "-f", calls[0][6]}) {
+		t.Errorf("The function container should be removed, received %v", calls)
+	}
+}
+
+func TestGetLocalRunConfigWithoutCluster(t *testing.T) {
+	prevKubeconfig, prevRuntimes := os.Getenv("KUBECONFIG"), defaultRuntimeImages
+	defer func() {
+		os.Setenv("KUBECONFIG", prevKubeconfig)
+		defaultRuntimeImages = prevRuntimes
+	}()
+	os.Setenv("KUBECONFIG", "/non-existent")
+
+	defaultRuntimeImages = ""
+	if _, err := getLocalRunConfig(""); err == nil || !strings.Contains(err.Error(), "--config-file") {
+		t.Errorf("Expecting an error, received %v", err)
+	}
+
+	defaultRuntimeImages = `[{"ID": "python"}]`
+	config, err := getLocalRunConfig("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Data["runtime-images"] != defaultRuntimeImages {
+		t.Errorf("Expecting the default runtimes, received %v", config.Data)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -317,22 +318,22 @@ func TestTop(t *testing.T) {
 
 	client := fFake.NewSimpleClientset(listObj.Items[0], listObj.Items[1])
 
-	deploymentPy := v1beta1.Deployment{
+	deploymentPy := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      function1Name,
 			Namespace: namespace,
 		},
-		Status: v1beta1.DeploymentStatus{
+		Status: appsv1.DeploymentStatus{
 			Replicas:      int32(1),
 			ReadyReplicas: int32(1),
 		},
 	}
-	deploymentGo := v1beta1.Deployment{
+	deploymentGo := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      function2Name,
 			Namespace: namespace,
 		},
-		Status: v1beta1.DeploymentStatus{
+		Status: appsv1.DeploymentStatus{
 			Replicas:      int32(1),
 			ReadyReplicas: int32(1),
 		},
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/kubeless/kubeless/pkg/langruntime"
@@ -65,6 +66,13 @@ var updateCmd = &cobra.Command{
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		fromDir, err := cmd.Flags().GetString("from-dir")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if file != "" && fromDir != "" {
+			logrus.Fatal("The flags `--from-file` and `--from-dir` can't be used together")
+		}
 
 		secrets, err := cmd.Flags().GetStringSlice("secrets")
 		if err != nil {
@@ -157,15 +165,81 @@ var updateCmd = &cobra.Command{
 			logrus.Fatal(err)
 		}
 
+		upload, err := cmd.Flags().GetBool("upload")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		idleTimeout, err := cmd.Flags().GetString("idle-timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if idleTimeout != "" {
+			if _, err := time.ParseDuration(idleTimeout); err != nil {
+				logrus.Fatalf("Invalid idle timeout %s: %v", idleTimeout, err)
+			}
+		}
+
+		canaryWeight, err := cmd.Flags().GetInt32("canary-weight")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if cmd.Flags().Changed("canary-weight") && (canaryWeight < 1 || canaryWeight > 100) {
+			logrus.Fatalf("Invalid canary weight %d. It should be a percentage between 1 and 100", canaryWeight)
+		}
+
 		previousFunction, err := utils.GetFunction(funcName, ns)
 		if err != nil {
 			logrus.Fatal(err)
 		}
 
-		f, err := getFunctionDescription(funcName, ns, handler, file, funcDeps, runtime, runtimeImage, mem, cpu, timeout, imagePullPolicy, port, headless, envs, labels, secrets, previousFunction)
+		defaultFunction := previousFunction
+		if canaryWeight > 0 && previousFunction.Spec.Canary != nil {
+			// Modify the revision that is already being rolled out
+			defaultFunction.Spec = *previousFunction.Spec.Canary.Spec.DeepCopy()
+		} else if canaryWeight == 0 && previousFunction.Spec.Canary != nil {
+			logrus.Warnf("Function %s has a rollout in progress. The changes will be applied to the stable revision", funcName)
+		}
+
+		f, err := getFunctionDescription(funcName, ns, handler, file, funcDeps, runtime, runtimeImage, mem, cpu, timeout, imagePullPolicy, port, headless, envs, labels, secrets, defaultFunction)
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		if fromDir != "" {
+			if err := setFunctionFromDir(f, fromDir, lr, deps == ""); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		if upload && dryrun {
+			logrus.Warn("The function is not uploaded to the artifact server in dry-run mode")
+		} else if upload {
+			if err := uploadFunction(cli, config.Data["artifact-server-url"], f); err != nil {
+				logrus.Fatal(err)
+			}
+		} else if err := checkFunctionSize(f); err != nil {
+			logrus.Fatal(err)
+		}
+		if cmd.Flags().Changed("idle-timeout") {
+			f.Spec.IdleTimeout = idleTimeout
+		}
+		if err := setFailurePolicy(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setProtocol(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setContainerConcurrency(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := setPayload(cmd, f); err != nil {
+			logrus.Fatal(err)
+		}
+		if canaryWeight > 0 {
+			f = canaryRollout(&previousFunction, f, canaryWeight)
+			if err := utils.ValidateCanary(&f.Spec); err != nil {
+				logrus.Fatal(err)
+			}
+		}
 
 		if dryrun == true {
 			if output == "json" {
@@ -198,7 +272,11 @@ var updateCmd = &cobra.Command{
 			logrus.Fatal(err)
 		}
 		logrus.Infof("Function %s submitted for deployment", funcName)
-		logrus.Infof("Check the deployment status executing 'kubeless function ls %s'", funcName)
+		if canaryWeight > 0 {
+			logrus.Infof("Check the rollout status executing 'kubeless function rollout status %s'", funcName)
+		} else {
+			logrus.Infof("Check the deployment status executing 'kubeless function ls %s'", funcName)
+		}
 	},
 }
 
@@ -206,6 +284,8 @@ func init() {
 	updateCmd.Flags().StringP("runtime", "r", "", "Specify runtime")
 	updateCmd.Flags().StringP("handler", "", "", "Specify handler")
 	updateCmd.Flags().StringP("from-file", "f", "", "Specify code file or a URL to the code file")
+	updateCmd.Flags().String("from-dir", "", "Specify a directory with the code of the function. It is compressed honouring the patterns of its .kubelessignore file")
+	updateCmd.Flags().Bool("upload", false, "Store the code of the function in the artifact server instead of embedding it in the function")
 	updateCmd.Flags().StringP("memory", "", "", "Request amount of memory for the function")
 	updateCmd.Flags().StringP("cpu", "", "", "Request amount of cpu for the function.")
 	updateCmd.Flags().StringSliceP("label", "l", []string{}, "Specify labels of the function")
@@ -218,7 +298,17 @@ func init() {
 	updateCmd.Flags().StringP("timeout", "", "180", "Maximum timeout (in seconds) for the function to complete its execution")
 	updateCmd.Flags().Bool("headless", false, "Deploy http-based function without a single service IP and load balancing support from Kubernetes. See: https://kubernetes.io/docs/concepts/services-networking/service/#headless-services")
 	updateCmd.Flags().Int32("port", 8080, "Deploy http-based function with a custom port")
+	updateCmd.Flags().String("idle-timeout", "", "Scale the function to zero after the given time without requests (e.g. 15m). A value of 0 disables it")
+	updateCmd.Flags().Int32("retry-max-attempts", 1, "Maximum number of times the function is invoked to process an event that fails")
+	updateCmd.Flags().String("retry-backoff", "", "Time to wait before retrying a failed event (e.g. 1s). It is doubled after every attempt")
+	updateCmd.Flags().String("protocol", "http", "Protocol served by the function: http, websocket or grpc")
+	updateCmd.Flags().Int32("container-concurrency", 0, "Maximum number of requests processed at the same time by each replica of the function. The rest wait in a queue. 0 means unlimited")
+	updateCmd.Flags().String("max-request-size", "", "Maximum size of the requests of the function, e.g. 1Mi. Bigger requests are rejected with a 413 error")
+	updateCmd.Flags().String("max-response-size", "", "Maximum size of the responses of the function, e.g. 10Mi")
+	updateCmd.Flags().String("request-schema", "", "File with the JSON Schema, in JSON or YAML, that the JSON requests of the function should match. Invalid requests are rejected with a 400 error")
+	updateCmd.Flags().String("on-failure", "", "Send the events that fail after all the attempts to a destination: function:<name>, nats:<topic>[@<url>], kafka:<topic>@<rest-proxy-url> or an http(s) URL")
 	updateCmd.Flags().Bool("dryrun", false, "Output JSON manifest of the function without creating it")
 	updateCmd.Flags().StringP("output", "o", "yaml", "Output format")
+	updateCmd.Flags().Int32("canary-weight", 0, "Deploy the changes as a canary revision that receives the given percentage of the traffic. See 'kubeless function rollout'")
 
 }
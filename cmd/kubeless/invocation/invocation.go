@@ -0,0 +1,42 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package invocation
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// InvocationCmd contains first-class command for asynchronous invocations
+var InvocationCmd = &cobra.Command{
+	Use:   "invocation SUBCOMMAND",
+	Short: "manage asynchronous invocations of functions",
+	Long: `invocation command allows user to retrieve the result of asynchronous invocations.
+
+Functions are invoked asynchronously executing 'kubeless function call <function_name> --async'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	cmds := []*cobra.Command{invocationGetCmd}
+
+	for _, cmd := range cmds {
+		InvocationCmd.AddCommand(cmd)
+		cmd.Flags().StringP("namespace", "n", "", "Specify namespace of the function")
+	}
+}
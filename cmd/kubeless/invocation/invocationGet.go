@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package invocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/gosuri/uitable"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+// invocation is the status of an asynchronous invocation as returned by the function
+type invocation struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"statusCode,omitempty"`
+	Result      string     `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+var invocationGetCmd = &cobra.Command{
+	Use:   "get <invocation_id> FLAG",
+	Short: "get the status and result of an asynchronous invocation",
+	Long:  `get the status and result of an asynchronous invocation`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - invocation ID")
+		}
+		id := args[0]
+
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		inv, err := getInvocation(utils.GetClientOutOfCluster(), ns, id)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		err = printInvocation(cmd.OutOrStdout(), inv, output)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	invocationGetCmd.Flags().StringP("output", "o", "", "Output format. One of: json|yaml")
+}
+
+// invocationFunction returns the name of the function that received an invocation
+func invocationFunction(id string) (string, error) {
+	i := strings.LastIndex(id, ".")
+	if i <= 0 {
+		return "", fmt.Errorf("Invalid invocation ID %s", id)
+	}
+	return id[:i], nil
+}
+
+// requestInvocation requests an invocation to the function proxy of a pod
+var requestInvocation = func(cli kubernetes.Interface, pod *v1.Pod, id string) ([]byte, error) {
+	port := "8080"
+	if len(pod.Spec.Containers) > 0 && len(pod.Spec.Containers[0].Ports) > 0 {
+		port = strconv.Itoa(int(pod.Spec.Containers[0].Ports[0].ContainerPort))
+	}
+	return cli.CoreV1().RESTClient().Get().Namespace(pod.Namespace).Resource("pods").SubResource("proxy").Name(pod.Name+":"+port).Suffix("invocations", id).Do().Raw()
+}
+
+// getInvocation requests the status of an invocation to the pods of its function. Invocations
+// stored in a volume are available in every pod while the ones stored in memory are only
+// available in the pod that received them
+func getInvocation(cli kubernetes.Interface, ns, id string) (*invocation, error) {
+	funcName, err := invocationFunction(id)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := cli.CoreV1().Pods(ns).List(metav1.ListOptions{LabelSelector: "function=" + funcName})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the pods of function %s: %v", funcName, err)
+	}
+	var lastErr error
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		res, err := requestInvocation(cli, pod, id)
+		if err != nil {
+			if !k8sErrors.IsNotFound(err) {
+				lastErr = err
+			}
+			continue
+		}
+		inv := &invocation{}
+		err = json.Unmarshal(res, inv)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the invocation: %v", err)
+		}
+		return inv, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("Unable to get invocation %s: %v", id, lastErr)
+	}
+	return nil, fmt.Errorf("Invocation %s not found in the pods of function %s", id, funcName)
+}
+
+func printInvocation(w io.Writer, inv *invocation, output string) error {
+	switch output {
+	case "json":
+		b, err := json.MarshalIndent(inv, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	case "yaml":
+		b, err := yaml.Marshal(inv)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, string(b))
+	case "":
+		table := uitable.New()
+		table.MaxColWidth = 80
+		table.Wrap = true
+		table.AddRow("ID:", inv.ID)
+		table.AddRow("Status:", inv.Status)
+		table.AddRow("Attempts:", inv.Attempts)
+		if inv.StatusCode != 0 {
+			table.AddRow("Status code:", inv.StatusCode)
+		}
+		table.AddRow("Created:", inv.CreatedAt.String())
+		if inv.CompletedAt != nil {
+			table.AddRow("Completed:", inv.CompletedAt.String())
+		}
+		if inv.Error != "" {
+			table.AddRow("Error:", inv.Error)
+		}
+		fmt.Fprintln(w, table)
+		if inv.Result != "" {
+			fmt.Fprintln(w, "Result:")
+			fmt.Fprintln(w, inv.Result)
+		}
+	default:
+		return fmt.Errorf("Wrong output format %s. Use json or yaml", output)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package invocation
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInvocationFunction(t *testing.T) {
+	name, err := invocationFunction("foo.0123456789abcdef")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if name != "foo" {
+		t.Errorf("Unexpected function %s", name)
+	}
+	if _, err := invocationFunction("0123456789abcdef"); err == nil {
+		t.Error("Expecting an error for an ID without function")
+	}
+}
+
+func TestGetInvocation(t *testing.T) {
+	pod := func(name, phase string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "myns", Labels: map[string]string{"function": "foo"}},
+			Status:     v1.PodStatus{Phase: v1.PodPhase(phase)},
+		}
+	}
+	cli := fake.NewSimpleClientset(pod("foo-1", "Running"), pod("foo-2", "Pending"), pod("foo-3", "Running"))
+	requested := []string{}
+	prevRequest := requestInvocation
+	defer func() { requestInvocation = prevRequest }()
+	requestInvocation = func(cli kubernetes.Interface, pod *v1.Pod, id string) ([]byte, error) {
+		requested = append(requested, pod.Name)
+		if pod.Name != "foo-3" {
+			return nil, k8sErrors.NewNotFound(schema.GroupResource{Resource: "pods"}, pod.Name)
+		}
+		return []byte(`{"id": "foo.1234", "status": "Succeeded"}`), nil
+	}
+
+	inv, err := getInvocation(cli, "myns", "foo.1234")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.Status != "Succeeded" {
+		t.Errorf("Unexpected invocation %v", inv)
+	}
+	if strings.Join(requested, ",") != "foo-1,foo-3" {
+		t.Errorf("Expecting the invocation to be requested to the running pods, requested %v", requested)
+	}
+
+	if _, err := getInvocation(cli, "myns", "bar.1234"); err == nil {
+		t.Error("Expecting an error for an invocation without pods")
+	}
+}
+
+func TestPrintInvocation(t *testing.T) {
+	inv := &invocation{
+		ID:         "foo-1234.abcd",
+		Status:     "Succeeded",
+		Attempts:   2,
+		StatusCode: 200,
+		Result:     "hello world",
+	}
+	var buf bytes.Buffer
+	if err := printInvocation(&buf, inv, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := buf.String()
+	for _, expected := range []string{`ID:\s+foo-1234.abcd`, `Status:\s+Succeeded`, `Attempts:\s+2`, "Result:\nhello world"} {
+		if m, _ := regexp.MatchString(expected, output); !m {
+			t.Errorf("Expecting %q in the output: %s", expected, output)
+		}
+	}
+
+	buf.Reset()
+	if err := printInvocation(&buf, inv, "json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m, _ := regexp.MatchString(`"status": "Succeeded"`, buf.String()); !m {
+		t.Errorf("Unexpected output %s", buf.String())
+	}
+
+	if err := printInvocation(&buf, inv, "xml"); err == nil {
+		t.Error("Expecting an error for an unknown format")
+	}
+}
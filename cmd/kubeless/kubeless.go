@@ -24,6 +24,8 @@ import (
 	"github.com/kubeless/kubeless/cmd/kubeless/completion"
 	"github.com/kubeless/kubeless/cmd/kubeless/function"
 	"github.com/kubeless/kubeless/cmd/kubeless/getserverconfig"
+	"github.com/kubeless/kubeless/cmd/kubeless/invocation"
+	"github.com/kubeless/kubeless/cmd/kubeless/sequence"
 	"github.com/kubeless/kubeless/cmd/kubeless/topic"
 	"github.com/kubeless/kubeless/cmd/kubeless/trigger"
 	"github.com/kubeless/kubeless/cmd/kubeless/version"
@@ -39,7 +41,7 @@ func newRootCmd() *cobra.Command {
 		Long:  globalUsage,
 	}
 
-	cmd.AddCommand(function.FunctionCmd, topic.TopicCmd, version.VersionCmd, autoscale.AutoscaleCmd, getserverconfig.GetServerConfigCmd, trigger.TriggerCmd, completion.CompletionCmd)
+	cmd.AddCommand(function.FunctionCmd, topic.TopicCmd, version.VersionCmd, autoscale.AutoscaleCmd, getserverconfig.GetServerConfigCmd, trigger.TriggerCmd, completion.CompletionCmd, invocation.InvocationCmd, sequence.SequenceCmd)
 	return cmd
 }
 
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SequenceCmd contains first-class command for sequences of functions
+var SequenceCmd = &cobra.Command{
+	Use:   "sequence SUBCOMMAND",
+	Short: "manage sequences of functions",
+	Long: `sequence command allows user to create, list, describe and call sequences of functions.
+
+A sequence invokes its functions in order passing the output of each step to the next one.
+Steps can also be conditional branches or parallel branches whose outputs are combined.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	cmds := []*cobra.Command{sequenceCreateCmd, sequenceListCmd, sequenceDescribeCmd, sequenceCallCmd}
+
+	for _, cmd := range cmds {
+		SequenceCmd.AddCommand(cmd)
+		cmd.Flags().StringP("namespace", "n", "", "Specify namespace of the sequence")
+	}
+}
+
+// getSequenceDescription returns a sequence with the steps defined in a file or
+// a list of functions invoked one after the other
+func getSequenceDescription(name, ns, file string, functions []string, timeout string) (*kubelessApi.Sequence, error) {
+	seq := &kubelessApi.Sequence{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Sequence",
+			APIVersion: "kubeless.io/v1beta1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    map[string]string{"created-by": "kubeless"},
+		},
+	}
+	switch {
+	case file != "" && len(functions) > 0:
+		return nil, fmt.Errorf("Only one of --from-file or --steps can be specified")
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// The file may contain a Sequence object or just its spec
+		obj := kubelessApi.Sequence{}
+		if err := yaml.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		seq.Spec = obj.Spec
+		if len(seq.Spec.Steps) == 0 {
+			if err := yaml.Unmarshal(data, &seq.Spec); err != nil {
+				return nil, err
+			}
+		}
+	case len(functions) > 0:
+		for _, f := range functions {
+			seq.Spec.Steps = append(seq.Spec.Steps, kubelessApi.SequenceStep{Name: f, Function: f})
+		}
+	default:
+		return nil, fmt.Errorf("The steps of the sequence should be specified with --from-file or --steps")
+	}
+	if timeout != "" {
+		seq.Spec.Timeout = timeout
+	}
+	return seq, nil
+}
+
+// printSteps writes a tree with the steps of a sequence
+func printSteps(w io.Writer, steps []kubelessApi.SequenceStep, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	for i, step := range steps {
+		switch {
+		case step.Function != "":
+			fmt.Fprintf(w, "%s%d. %s: function %s\n", prefix, i+1, step.Name, step.Function)
+		case len(step.Branches) > 0:
+			fmt.Fprintf(w, "%s%d. %s: first matching branch of\n", prefix, i+1, step.Name)
+			printBranches(w, step.Branches, indent+1)
+		default:
+			fmt.Fprintf(w, "%s%d. %s: in parallel\n", prefix, i+1, step.Name)
+			printBranches(w, step.Parallel, indent+1)
+		}
+	}
+}
+
+func printBranches(w io.Writer, branches []kubelessApi.SequenceBranch, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	for _, b := range branches {
+		fmt.Fprintf(w, "%s- %s%s\n", prefix, b.Name, condition(b.When))
+		printSteps(w, b.Steps, indent+1)
+	}
+}
+
+// condition returns a human readable description of a branch condition
+func condition(c *kubelessApi.SequenceCondition) string {
+	if c == nil {
+		return ""
+	}
+	field := "input"
+	if c.Path != "" {
+		field = c.Path
+	}
+	conditions := []string{}
+	if c.Exists != nil {
+		if *c.Exists {
+			conditions = append(conditions, field+" exists")
+		} else {
+			conditions = append(conditions, field+" doesn't exist")
+		}
+	}
+	if c.Equals != "" {
+		conditions = append(conditions, fmt.Sprintf("%s == %q", field, c.Equals))
+	}
+	if c.Matches != "" {
+		conditions = append(conditions, fmt.Sprintf("%s =~ /%s/", field, c.Matches))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " (when " + strings.Join(conditions, " and ") + ")"
+}
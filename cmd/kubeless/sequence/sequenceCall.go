@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var sequenceCallCmd = &cobra.Command{
+	Use:   "call <sequence_name> FLAG",
+	Short: "call a sequence of functions",
+	Long:  `call a sequence of functions and print the output of its last step`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - sequence name")
+		}
+		name := args[0]
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+		data, err := cmd.Flags().GetString("data")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		clientset := utils.GetClientOutOfCluster()
+		svc, err := clientset.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+		if err != nil {
+			logrus.Fatalf("Unable to find the service for %s", name)
+		}
+
+		req := clientset.CoreV1().RESTClient().Post().Body(bytes.NewBufferString(data))
+		if utils.IsJSON(data) {
+			req.SetHeader("Content-Type", "application/json")
+		} else {
+			req.SetHeader("Content-Type", "application/x-www-form-urlencoded")
+		}
+		eventID, err := utils.GetRandString(11)
+		if err != nil {
+			logrus.Fatalf("Unable to generate ID %v", err)
+		}
+		req.SetHeader("event-id", eventID)
+		req.SetHeader("event-time", time.Now().UTC().Format(time.RFC3339))
+		req.SetHeader("event-namespace", "cli.kubeless.io")
+		// The REST package removes the trailing slash so the URL is built manually
+		req = req.AbsPath(fmt.Sprintf("%s:%d/proxy/", svc.ObjectMeta.SelfLink, utils.SequencePort))
+		res, err := req.Do().Raw()
+		if err != nil {
+			logrus.Error(string(res))
+			logrus.Fatal(strings.Replace(err.Error(), `\n`, "\n", -1))
+		}
+		fmt.Println(string(res))
+	},
+}
+
+func init() {
+	sequenceCallCmd.Flags().StringP("data", "d", "", "Specify the input of the sequence")
+}
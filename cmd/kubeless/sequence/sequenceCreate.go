@@ -0,0 +1,112 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubeless/kubeless/pkg/sequence"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var sequenceCreateCmd = &cobra.Command{
+	Use:   "create <sequence_name> FLAG",
+	Short: "create a sequence of functions",
+	Long: `create a sequence of functions. The steps are read from a YAML file with --from-file
+or, for sequences that simply invoke functions one after the other, from --steps`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - sequence name")
+		}
+		name := args[0]
+
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+		file, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		steps, err := cmd.Flags().GetStringSlice("steps")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		timeout, err := cmd.Flags().GetString("timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		dryrun, err := cmd.Flags().GetBool("dryrun")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		seq, err := getSequenceDescription(name, ns, file, steps, timeout)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := sequence.Validate(&seq.Spec); err != nil {
+			logrus.Fatal(err)
+		}
+
+		if dryrun {
+			var b []byte
+			switch output {
+			case "json":
+				b, err = json.MarshalIndent(seq, "", "    ")
+			case "yaml":
+				b, err = yaml.Marshal(seq)
+			default:
+				logrus.Fatal("Output format needs to be yaml or json")
+			}
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			fmt.Println(string(b))
+			return
+		}
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := utils.CreateSequenceCustomResource(kubelessClient, seq); err != nil {
+			logrus.Fatalf("Failed to create sequence %s. Received:\n%s", name, err)
+		}
+		logrus.Infof("Sequence %s submitted for deployment", name)
+		logrus.Infof("Check the deployment status executing 'kubeless sequence ls %s'", name)
+	},
+}
+
+func init() {
+	sequenceCreateCmd.Flags().StringP("from-file", "f", "", "Specify a YAML file with the steps of the sequence")
+	sequenceCreateCmd.Flags().StringSlice("steps", []string{}, "Specify the functions to invoke in order. For example: --steps validate,enrich,store")
+	sequenceCreateCmd.Flags().String("timeout", "", "Maximum timeout (in seconds) for the sequence to complete its execution")
+	sequenceCreateCmd.Flags().Bool("dryrun", false, "Output the manifest of the sequence without creating it")
+	sequenceCreateCmd.Flags().StringP("output", "o", "yaml", "Output format of --dryrun. One of: json|yaml")
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/gosuri/uitable"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var sequenceDescribeCmd = &cobra.Command{
+	Use:   "describe <sequence_name> FLAG",
+	Short: "describe a sequence of functions",
+	Long:  `describe a sequence of functions`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logrus.Fatal("Need exactly one argument - sequence name")
+		}
+		name := args[0]
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+		output, err := cmd.Flags().GetString("out")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		seq, err := kubelessClient.KubelessV1beta1().Sequences(ns).Get(name, metav1.GetOptions{})
+		if err != nil {
+			logrus.Fatalf("Can not describe sequence: %v", err)
+		}
+		if err := printSequence(cmd.OutOrStdout(), seq, output); err != nil {
+			logrus.Fatalf("Can not describe sequence: %v", err)
+		}
+	},
+}
+
+func init() {
+	sequenceDescribeCmd.Flags().StringP("out", "o", "", "Output format. One of: json|yaml")
+}
+
+func printSequence(w io.Writer, seq *kubelessApi.Sequence, output string) error {
+	switch output {
+	case "":
+		table := uitable.New()
+		table.MaxColWidth = 80
+		table.Wrap = true
+		table.AddRow("Name:", seq.ObjectMeta.Name)
+		table.AddRow("Namespace:", seq.ObjectMeta.Namespace)
+		if seq.Spec.Timeout != "" {
+			table.AddRow("Timeout:", seq.Spec.Timeout)
+		}
+		if seq.Status.Phase != "" {
+			table.AddRow("Status:", string(seq.Status.Phase))
+			table.AddRow("Functions:", strings.Join(seq.Status.Functions, ", "))
+			if seq.Status.LastError != "" {
+				table.AddRow("Last error:", seq.Status.LastError)
+			}
+		}
+		fmt.Fprintln(w, table)
+		fmt.Fprintln(w, "Steps:")
+		printSteps(w, seq.Spec.Steps, 1)
+	case "json":
+		b, err := json.MarshalIndent(seq, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	case "yaml":
+		b, err := yaml.Marshal(seq)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	default:
+		return fmt.Errorf("Wrong output format. Only accept json|yaml")
+	}
+	return nil
+}
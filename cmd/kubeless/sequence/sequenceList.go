@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/gosuri/uitable"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	"github.com/kubeless/kubeless/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var sequenceListCmd = &cobra.Command{
+	Use:     "list [<sequence_name>] FLAG",
+	Aliases: []string{"ls"},
+	Short:   "list sequences of functions",
+	Long:    `list sequences of functions`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, err := cmd.Flags().GetString("out")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		ns, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ns == "" {
+			ns = utils.GetDefaultNamespace()
+		}
+
+		kubelessClient, err := utils.GetKubelessClientOutCluster()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := doSequenceList(cmd.OutOrStdout(), kubelessClient, ns, output, args); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	sequenceListCmd.Flags().StringP("out", "o", "", "Output format. One of: json|yaml")
+}
+
+func doSequenceList(w io.Writer, kubelessClient versioned.Interface, ns, output string, names []string) error {
+	sequences := []*kubelessApi.Sequence{}
+	if len(names) == 0 {
+		list, err := kubelessClient.KubelessV1beta1().Sequences(ns).List(metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		sequences = list.Items
+	} else {
+		for _, name := range names {
+			seq, err := kubelessClient.KubelessV1beta1().Sequences(ns).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			sequences = append(sequences, seq)
+		}
+	}
+
+	switch output {
+	case "":
+		table := uitable.New()
+		table.MaxColWidth = 50
+		table.Wrap = true
+		table.AddRow("NAME", "NAMESPACE", "STEPS", "FUNCTIONS", "STATUS")
+		for _, seq := range sequences {
+			status := string(seq.Status.Phase)
+			if status == "" {
+				status = string(kubelessApi.SequencePhasePending)
+			}
+			table.AddRow(seq.ObjectMeta.Name, seq.ObjectMeta.Namespace, len(seq.Spec.Steps), strings.Join(seq.Status.Functions, ", "), status)
+		}
+		fmt.Fprintln(w, table)
+	case "json":
+		for _, seq := range sequences {
+			b, err := json.MarshalIndent(seq, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, string(b))
+		}
+	case "yaml":
+		for _, seq := range sequences {
+			b, err := yaml.Marshal(seq)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, "---")
+			fmt.Fprintln(w, string(b))
+		}
+	default:
+		return fmt.Errorf("Wrong output format. Only accept json|yaml")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	kFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSequenceDescription(t *testing.T) {
+	seq, err := getSequenceDescription("pipeline", "myns", "", []string{"foo", "bar"}, "60")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := kubelessApi.SequenceSpec{
+		Steps: []kubelessApi.SequenceStep{
+			{Name: "foo", Function: "foo"},
+			{Name: "bar", Function: "bar"},
+		},
+		Timeout: "60",
+	}
+	if !reflect.DeepEqual(seq.Spec, expected) || seq.ObjectMeta.Namespace != "myns" {
+		t.Errorf("Expecting %v, received %v", expected, seq.Spec)
+	}
+
+	file, err := ioutil.TempFile("", "sequence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	spec := `
+steps:
+- name: order
+  function: order
+- name: route
+  branches:
+  - name: paid
+    when:
+      path: status
+      equals: paid
+    steps:
+    - name: ship
+      function: ship
+`
+	for _, content := range []string{spec, "kind: Sequence\nspec:" + strings.Replace(spec, "\n", "\n  ", -1)} {
+		if err := ioutil.WriteFile(file.Name(), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		seq, err = getSequenceDescription("pipeline", "myns", file.Name(), nil, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(seq.Spec.Steps) != 2 || seq.Spec.Steps[1].Branches[0].When.Equals != "paid" {
+			t.Errorf("Unexpected sequence %v", seq.Spec)
+		}
+	}
+
+	if _, err := getSequenceDescription("pipeline", "myns", file.Name(), []string{"foo"}, ""); err == nil {
+		t.Error("Expecting an error if both a file and the steps are specified")
+	}
+}
+
+func TestSequenceListAndDescribe(t *testing.T) {
+	exists := true
+	seq := &kubelessApi.Sequence{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "myns"},
+		Spec: kubelessApi.SequenceSpec{Steps: []kubelessApi.SequenceStep{
+			{Name: "order", Function: "order"},
+			{Name: "notify", Parallel: []kubelessApi.SequenceBranch{
+				{Name: "email", Steps: []kubelessApi.SequenceStep{{Name: "email", Function: "email"}}},
+				{
+					Name:  "sms",
+					When:  &kubelessApi.SequenceCondition{Path: "phone", Exists: &exists},
+					Steps: []kubelessApi.SequenceStep{{Name: "sms", Function: "sms"}},
+				},
+			}},
+		}},
+		Status: kubelessApi.SequenceStatus{
+			Phase:     kubelessApi.SequencePhaseReady,
+			Functions: []string{"email", "order", "sms"},
+		},
+	}
+	client := kFake.NewSimpleClientset(seq)
+
+	var buf bytes.Buffer
+	if err := doSequenceList(&buf, client, "myns", "", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) < 2 || !strings.Contains(lines[1], "pipeline") || !strings.Contains(lines[1], "email, order, sms") || !strings.Contains(lines[1], "Ready") {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := printSequence(&buf, seq, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"1. order: function order",
+		"2. notify: in parallel",
+		"    - sms (when phone exists)",
+		"      1. sms: function sms",
+	} {
+		if !strings.Contains(buf.String(), expected+"\n") {
+			t.Errorf("Expecting %q in the output:\n%s", expected, buf.String())
+		}
+	}
+}
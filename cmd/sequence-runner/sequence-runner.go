@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Kubeless sequence runner binary.
+//
+// See github.com/kubeless/kubeless/pkg/sequence
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/sequence"
+	"github.com/kubeless/kubeless/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	globalUsage = `Executes the steps of a sequence of functions. The sequence is read from the
+environment variables SEQUENCE_SPEC and SEQUENCE_FUNCTIONS set by the controller.`
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "kubeless-sequence-runner",
+	Short: "Kubeless sequence runner",
+	Long:  globalUsage,
+	Run: func(cmd *cobra.Command, args []string) {
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		spec := kubelessApi.SequenceSpec{}
+		if err := json.Unmarshal([]byte(os.Getenv("SEQUENCE_SPEC")), &spec); err != nil {
+			logrus.Fatalf("Unable to parse SEQUENCE_SPEC: %v", err)
+		}
+		functions := map[string]string{}
+		if err := json.Unmarshal([]byte(os.Getenv("SEQUENCE_FUNCTIONS")), &functions); err != nil {
+			logrus.Fatalf("Unable to parse SEQUENCE_FUNCTIONS: %v", err)
+		}
+		timeout := time.Duration(0)
+		if spec.Timeout != "" {
+			t, err := strconv.Atoi(spec.Timeout)
+			if err != nil {
+				logrus.Fatalf("Invalid timeout %s: %v", spec.Timeout, err)
+			}
+			timeout = time.Duration(t) * time.Second
+		}
+
+		executor, err := sequence.NewExecutor(spec, functions, timeout)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		http.Handle("/", executor)
+		logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	},
+}
+
+func init() {
+	rootCmd.Flags().Int("port", 8080, "Port in which the sequence is served")
+}
+
+func main() {
+	logrus.Infof("Running Kubeless sequence runner version: %v", version.Version)
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
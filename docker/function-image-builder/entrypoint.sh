@@ -1,27 +0,0 @@
-#!/bin/bash
-
-# Copyright (c) 2016-2017 Bitnami
-#
-# Licensed under the Apache License, Version 2.0 (the "License");
-# you may not use this file except in compliance with the License.
-# You may obtain a copy of the License at
-#
-#     http://www.apache.org/licenses/LICENSE-2.0
-#
-# Unless required by applicable law or agreed to in writing, software
-# distributed under the License is distributed on an "AS IS" BASIS,
-# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-# See the License for the specific language governing permissions and
-# limitations under the License.
-
-set -e
-
-# Kubernetes ImagePullSecrets uses .dockerconfigjson as the file name
-# for storing credentials but skopeo requires it to be named config.json
-if [ -f $DOCKER_CONFIG_FOLDER/.dockerconfigjson ]; then
-    echo "Creating $HOME/.docker/config.json"
-    mkdir -p $HOME/.docker
-    ln -s $DOCKER_CONFIG_FOLDER/.dockerconfigjson $HOME/.docker/config.json
-fi
-
-"${@}"
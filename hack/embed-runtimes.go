@@ -0,0 +1,65 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// embed-runtimes writes a Go file that embeds the runtimes of a Kubeless manifest in the CLI,
+// so "kubeless function run" and "kubeless function build --local" work without a cluster.
+// Usage: go run hack/embed-runtimes.go kubeless.yaml > cmd/kubeless/function/zz_generated.runtimes.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: embed-runtimes <manifest>")
+		os.Exit(1)
+	}
+	content, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, doc := range regexp.MustCompile(`(?m)^---\s*$`).Split(string(content), -1) {
+		config := v1.ConfigMap{}
+		if err := yaml.Unmarshal([]byte(doc), &config); err != nil || config.Kind != "ConfigMap" || config.Name != "kubeless-config" {
+			continue
+		}
+		if config.Data["runtime-images"] == "" {
+			break
+		}
+		fmt.Printf(`// Code generated by hack/embed-runtimes.go. DO NOT EDIT.
+
+package function
+
+func init() {
+	defaultRuntimeImages = %q
+}
+`, config.Data["runtime-images"])
+		return
+	}
+	fmt.Fprintf(os.Stderr, "The manifest %s doesn't contain the runtime-images of the kubeless-config ConfigMap\n", os.Args[1])
+	os.Exit(1)
+}
@@ -32,4 +32,4 @@ cp ${SCRIPT_ROOT}/hack/boilerplate.go.txt ${GOPATH}/src/k8s.io/kubernetes/hack/b
 
 ${CODEGEN_PKG}/generate-groups.sh "deepcopy,client,informer,lister" \
   github.com/kubeless/kubeless/pkg/client github.com/kubeless/kubeless/pkg/apis \
-  kubeless:v1beta1 
+  kubeless:v1beta1,v1
@@ -0,0 +1,249 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package activator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+const (
+	// podCheckPeriod is the time between checks of the pods of a function being activated
+	podCheckPeriod = 500 * time.Millisecond
+	// shutdownTimeout is the time given to the requests in progress when a listener is closed
+	shutdownTimeout = 30 * time.Second
+)
+
+// Activator receives the requests sent to functions that have been scaled to zero.
+// It listens in the port assigned to each idle function, scales the function up when
+// a request arrives and forwards the held requests once a function pod is ready
+type Activator struct {
+	clientset         kubernetes.Interface
+	logger            *logrus.Entry
+	activationTimeout time.Duration
+	syncPeriod        time.Duration
+	mutex             sync.Mutex
+	servers           map[int32]*functionServer
+	activations       map[string]*activation
+}
+
+// functionServer is the listener of the activator for an idle function
+type functionServer struct {
+	namespace string
+	name      string
+	server    *http.Server
+}
+
+// activation is shared by all the requests that arrive while a function is being scaled up
+type activation struct {
+	done   chan struct{}
+	target string
+	err    error
+}
+
+// NewActivator initializes an activator. Requests fail if the function is not ready
+// after the activation timeout. Idle functions are discovered every sync period
+func NewActivator(clientset kubernetes.Interface, activationTimeout, syncPeriod time.Duration) *Activator {
+	return &Activator{
+		clientset:         clientset,
+		logger:            logrus.WithField("pkg", "activator"),
+		activationTimeout: activationTimeout,
+		syncPeriod:        syncPeriod,
+		servers:           map[int32]*functionServer{},
+		activations:       map[string]*activation{},
+	}
+}
+
+// Run keeps a listener open for each idle function until the stop channel is closed
+func (a *Activator) Run(stopCh <-chan struct{}) {
+	a.logger.Info("Starting function activator")
+	wait.Until(func() {
+		if err := a.sync(); err != nil {
+			a.logger.Errorf("Unable to sync idle functions: %v", err)
+		}
+	}, a.syncPeriod, stopCh)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for port := range a.servers {
+		a.closeServer(port)
+	}
+	a.logger.Info("Shutting down function activator")
+}
+
+// sync opens the listeners of the functions that have been scaled to zero and
+// closes the listeners of the functions that are no longer idle
+func (a *Activator) sync() error {
+	svcs, err := utils.ListIdleFuncServices(a.clientset)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	desired := map[int32]bool{}
+	for i := range svcs {
+		svc := &svcs[i]
+		port, err := utils.GetActivatorPort(svc)
+		if err != nil {
+			a.logger.Error(err)
+			continue
+		}
+		desired[port] = true
+		if s, ok := a.servers[port]; ok {
+			if s.namespace == svc.ObjectMeta.Namespace && s.name == svc.ObjectMeta.Name {
+				continue
+			}
+			// The port has been assigned to a different function
+			a.closeServer(port)
+		}
+		err = a.openServer(port, svc.ObjectMeta.Namespace, svc.ObjectMeta.Name)
+		if err != nil {
+			a.logger.Errorf("Unable to listen for function %s/%s: %v", svc.ObjectMeta.Namespace, svc.ObjectMeta.Name, err)
+		}
+	}
+	for port := range a.servers {
+		if !desired[port] {
+			a.closeServer(port)
+		}
+	}
+	return nil
+}
+
+func (a *Activator) openServer(port int32, ns, name string) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: a.handler(ns, name)}
+	a.servers[port] = &functionServer{namespace: ns, name: name, server: server}
+	go server.Serve(l)
+	a.logger.Infof("Listening in port %d for function %s/%s", port, ns, name)
+	return nil
+}
+
+func (a *Activator) closeServer(port int32) {
+	s := a.servers[port]
+	delete(a.servers, port)
+	a.logger.Infof("Closing port %d of function %s/%s", port, s.namespace, s.name)
+	// Let the requests held during the activation finish
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		s.server.Shutdown(ctx)
+	}()
+}
+
+// handler activates a function and forwards the request to one of its pods
+func (a *Activator) handler(ns, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := a.activate(ns, name)
+		if err != nil {
+			a.logger.Errorf("Unable to activate function %s/%s: %v", ns, name, err)
+			http.Error(w, fmt.Sprintf("Unable to activate function %s: %v", name, err), http.StatusServiceUnavailable)
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: target})
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// activate scales up a function (only once for concurrent requests) and returns the address of a ready pod
+func (a *Activator) activate(ns, name string) (string, error) {
+	key := ns + "/" + name
+	a.mutex.Lock()
+	act, ok := a.activations[key]
+	if !ok {
+		act = &activation{done: make(chan struct{})}
+		a.activations[key] = act
+		go func() {
+			act.target, act.err = a.wakeUp(ns, name)
+			a.mutex.Lock()
+			delete(a.activations, key)
+			a.mutex.Unlock()
+			close(act.done)
+		}()
+	}
+	a.mutex.Unlock()
+	<-act.done
+	return act.target, act.err
+}
+
+func (a *Activator) wakeUp(ns, name string) (string, error) {
+	a.logger.Infof("Activating function %s/%s", ns, name)
+	svc, err := a.clientset.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	err = utils.ScaleFuncFromZero(a.clientset, ns, name)
+	if err != nil {
+		return "", err
+	}
+
+	target := ""
+	err = wait.PollImmediate(podCheckPeriod, a.activationTimeout, func() (bool, error) {
+		pods, err := a.clientset.CoreV1().Pods(ns).List(metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("function=%s,track!=canary", name),
+		})
+		if err != nil {
+			return false, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.PodIP != "" && utils.IsPodReady(pod) {
+				target = fmt.Sprintf("%s:%d", pod.Status.PodIP, targetPort(svc))
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("the function is not ready: %v", err)
+	}
+
+	err = utils.RestoreFuncService(a.clientset, ns, name)
+	if err != nil {
+		return "", err
+	}
+	a.logger.Infof("Function %s/%s activated", ns, name)
+	return target, nil
+}
+
+// targetPort returns the port of the function pods that receives the requests of the service
+func targetPort(svc *v1.Service) int {
+	if len(svc.Spec.Ports) == 0 {
+		return 8080
+	}
+	if port := svc.Spec.Ports[0].TargetPort.IntValue(); port > 0 {
+		return port
+	}
+	return int(svc.Spec.Ports[0].Port)
+}
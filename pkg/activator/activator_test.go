@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package activator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+// idleService returns the service of a function scaled to zero whose pods listen in the given URL
+// and the IP of those pods
+func idleService(t *testing.T, backend string, activatorPort int32) (*v1.Service, string) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "myns",
+			Labels:    map[string]string{utils.IdleLabel: "true"},
+			Annotations: map[string]string{
+				utils.ActivatorPortAnnotation: strconv.Itoa(int(activatorPort)),
+				utils.IdleReplicasAnnotation:  "2",
+				utils.IdleSelectorAnnotation:  `{"function":"foo"}`,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Name: "http-function-port", Port: 8080, TargetPort: intstr.FromInt(p)}},
+		},
+	}, host
+}
+
+func newTestActivator(t *testing.T, backend string, activatorPort int32, podReady bool) (*Activator, *fake.Clientset) {
+	svc, podIP := idleService(t, backend, activatorPort)
+	zero := int32(0)
+	dpm := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &zero},
+	}
+	ready := v1.ConditionFalse
+	if podReady {
+		ready = v1.ConditionTrue
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-1234", Namespace: "myns", Labels: map[string]string{"function": "foo"}},
+		Status: v1.PodStatus{
+			PodIP:      podIP,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: ready}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc, dpm, pod)
+	return NewActivator(client, 200*time.Millisecond, 100*time.Millisecond), client
+}
+
+func TestActivateFunction(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer backend.Close()
+	a, client := newTestActivator(t, backend.URL, 10000, true)
+
+	w := httptest.NewRecorder()
+	a.handler("myns", "foo").ServeHTTP(w, httptest.NewRequest("GET", "/world", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello /world" {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	dpm, err := client.AppsV1().Deployments("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dpm.Spec.Replicas != 2 {
+		t.Errorf("Expecting the function to be scaled to 2 replicas, received %d", *dpm.Spec.Replicas)
+	}
+	svc, err := client.CoreV1().Services("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.IsFuncServiceIdle(svc) || svc.Spec.Selector["function"] != "foo" {
+		t.Errorf("Expecting the service to be restored, received %v", svc)
+	}
+}
+
+func TestActivationTimeout(t *testing.T) {
+	a, _ := newTestActivator(t, "http://127.0.0.1:8080", 10000, false)
+
+	w := httptest.NewRecorder()
+	a.handler("myns", "foo").ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting a 503 response, received %d", w.Code)
+	}
+}
+
+func TestSyncListeners(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+	// Find a free port for the activator
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	a, _ := newTestActivator(t, backend.URL, int32(port), true)
+
+	err = a.sync()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := a.servers[int32(port)]; !ok {
+		t.Fatalf("Expecting a listener in port %d", port)
+	}
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("Unexpected response %s", body)
+	}
+
+	// The function is no longer idle so the listener is closed
+	err = a.sync()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(a.servers) != 0 {
+		t.Errorf("Expecting the listener to be closed, found %v", a.servers)
+	}
+}
@@ -0,0 +1,462 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelessv1beta1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+)
+
+// ConversionAnnotation stores the fields of a v1beta1 function that can't be represented in v1
+// so they are restored when the function is converted back to v1beta1
+const ConversionAnnotation = "kubeless.io/v1beta1-fields"
+
+// scaleTargetAPIVersion is the API version used by the autoscalers to reference the function deployment
+const scaleTargetAPIVersion = "apps/v1"
+
+// qpsMetricName is the metric exposed by the runtimes with the number of calls to a function
+const qpsMetricName = "function_calls"
+
+var sourceTypes = map[string]SourceType{
+	"text":     SourceInline,
+	"base64":   SourceBase64,
+	"url":      SourceURL,
+	"artifact": SourceArtifact,
+}
+
+// v1beta1Fields are the fields of a v1beta1 function spec without an equivalent in v1
+type v1beta1Fields struct {
+	FunctionContentType     string                           `json:"function-content-type,omitempty"`
+	Timeout                 string                           `json:"timeout,omitempty"`
+	Deployment              *extensionsv1beta1.Deployment    `json:"deployment,omitempty"`
+	HorizontalPodAutoscaler *v2beta1.HorizontalPodAutoscaler `json:"horizontalPodAutoscaler,omitempty"`
+	Canary                  *v1beta1Fields                   `json:"canary,omitempty"`
+}
+
+func (f *v1beta1Fields) empty() bool {
+	return *f == v1beta1Fields{}
+}
+
+// ConvertFromV1beta1 returns the v1 representation of a v1beta1 function
+func ConvertFromV1beta1(in *kubelessv1beta1.Function) (*Function, error) {
+	out := &Function{
+		TypeMeta:   metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: "Function"},
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+	}
+	lost := &v1beta1Fields{}
+	out.Spec = specFromV1beta1(in.Spec.DeepCopy(), &in.ObjectMeta, lost)
+	if !lost.empty() {
+		raw, err := json.Marshal(lost)
+		if err != nil {
+			return nil, err
+		}
+		if out.ObjectMeta.Annotations == nil {
+			out.ObjectMeta.Annotations = map[string]string{}
+		}
+		out.ObjectMeta.Annotations[ConversionAnnotation] = string(raw)
+	}
+	out.Status = statusFromV1beta1(&in.Status)
+	return out, nil
+}
+
+// ConvertToV1beta1 returns the v1beta1 representation of a v1 function
+func ConvertToV1beta1(in *Function) (*kubelessv1beta1.Function, error) {
+	out := &kubelessv1beta1.Function{
+		TypeMeta:   metav1.TypeMeta{APIVersion: kubelessv1beta1.SchemeGroupVersion.String(), Kind: "Function"},
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+	}
+	lost := &v1beta1Fields{}
+	if raw, ok := out.ObjectMeta.Annotations[ConversionAnnotation]; ok {
+		if err := json.Unmarshal([]byte(raw), lost); err != nil {
+			return nil, fmt.Errorf("Unable to parse the annotation %s: %v", ConversionAnnotation, err)
+		}
+		delete(out.ObjectMeta.Annotations, ConversionAnnotation)
+		if len(out.ObjectMeta.Annotations) == 0 {
+			out.ObjectMeta.Annotations = nil
+		}
+	}
+	out.Spec = specToV1beta1(in.Spec.DeepCopy(), &out.ObjectMeta, lost)
+	out.Status = statusToV1beta1(&in.Status)
+	return out, nil
+}
+
+func specFromV1beta1(in *kubelessv1beta1.FunctionSpec, meta *metav1.ObjectMeta, lost *v1beta1Fields) FunctionSpec {
+	out := FunctionSpec{
+		Runtime: in.Runtime,
+		Handler: in.Handler,
+		Deps:    in.Deps,
+		Source: FunctionSource{
+			Content:  in.Function,
+			Checksum: in.Checksum,
+		},
+	}
+
+	if t, ok := sourceTypes[strings.TrimSuffix(in.FunctionContentType, "+zip")]; ok {
+		out.Source.Type = t
+		out.Source.Zip = strings.HasSuffix(in.FunctionContentType, "+zip")
+	} else {
+		lost.FunctionContentType = in.FunctionContentType
+	}
+
+	if in.Timeout != "" {
+		t, err := strconv.Atoi(in.Timeout)
+		if err == nil && t >= 0 && t <= math.MaxInt32 && strconv.Itoa(t) == in.Timeout {
+			timeout := int32(t)
+			out.TimeoutSeconds = &timeout
+		} else {
+			lost.Timeout = in.Timeout
+		}
+	}
+
+	// The fields of the main container are moved to the spec
+	template := stripTemplate(&in.Deployment.Spec.Template)
+	if len(in.Deployment.Spec.Template.Spec.Containers) > 0 {
+		c := in.Deployment.Spec.Template.Spec.Containers[0]
+		out.Env = c.Env
+		out.Resources = c.Resources
+		out.Image = c.Image
+		if len(template.Spec.Containers) == 1 && apiequality.Semantic.DeepEqual(template.Spec.Containers[0], corev1.Container{}) {
+			template.Spec.Containers = nil
+		}
+	}
+	if !apiequality.Semantic.DeepEqual(*template, corev1.PodTemplateSpec{}) {
+		out.PodTemplate = template
+	}
+
+	scaling := &FunctionScaling{
+		Replicas:    in.Deployment.Spec.Replicas,
+		MinReplicas: in.HorizontalPodAutoscaler.Spec.MinReplicas,
+		MaxReplicas: in.HorizontalPodAutoscaler.Spec.MaxReplicas,
+		IdleTimeout: in.IdleTimeout,
+	}
+	for _, m := range in.HorizontalPodAutoscaler.Spec.Metrics {
+		if m.Type == v2beta1.ResourceMetricSourceType && m.Resource != nil && m.Resource.Name == corev1.ResourceCPU {
+			scaling.TargetCPUUtilizationPercentage = m.Resource.TargetAverageUtilization
+		} else if m.Type == v2beta1.ObjectMetricSourceType && m.Object != nil && m.Object.MetricName == qpsMetricName {
+			qps := m.Object.TargetValue
+			scaling.TargetQPS = &qps
+		}
+	}
+	if !apiequality.Semantic.DeepEqual(*scaling, FunctionScaling{}) {
+		out.Scaling = scaling
+	}
+
+	if !apiequality.Semantic.DeepEqual(in.ServiceSpec, corev1.ServiceSpec{}) {
+		out.Service = &in.ServiceSpec
+	}
+
+	if in.RetryPolicy != nil {
+		out.RetryPolicy = &FunctionRetryPolicy{
+			MaxAttempts: in.RetryPolicy.MaxAttempts,
+			Backoff:     in.RetryPolicy.Backoff,
+			MaxBackoff:  in.RetryPolicy.MaxBackoff,
+		}
+	}
+	if in.OnFailure != nil {
+		out.OnFailure = &FunctionDestination{
+			Type: DestinationType(in.OnFailure.Type),
+			Name: in.OnFailure.Name,
+			URL:  in.OnFailure.URL,
+		}
+	}
+
+	out.Protocol = FunctionProtocol(in.Protocol)
+	out.ContainerConcurrency = in.ContainerConcurrency
+	if in.Payload != nil {
+		p := in.Payload.DeepCopy()
+		out.Payload = &FunctionPayload{
+			MaxRequestSize:  p.MaxRequestSize,
+			MaxResponseSize: p.MaxResponseSize,
+			RequestSchema:   p.RequestSchema,
+		}
+	}
+	if in.AsyncQueue != nil {
+		q := in.AsyncQueue.DeepCopy()
+		out.AsyncQueue = &FunctionQueue{
+			Storage:          QueueStorage(q.Storage),
+			StorageClassName: q.StorageClassName,
+			Size:             q.Size,
+		}
+	}
+
+	// Keep the deployment and the autoscaler if they have fields that are not part of the v1 spec
+	noLost := &v1beta1Fields{}
+	if !apiequality.Semantic.DeepEqual(deploymentToV1beta1(&out, noLost), in.Deployment) {
+		lost.Deployment = &in.Deployment
+	}
+	if !apiequality.Semantic.DeepEqual(autoscalerToV1beta1(&out, meta, noLost), in.HorizontalPodAutoscaler) {
+		lost.HorizontalPodAutoscaler = &in.HorizontalPodAutoscaler
+	}
+
+	if in.Canary != nil {
+		canaryLost := &v1beta1Fields{}
+		out.Canary = &FunctionCanary{
+			Weight: in.Canary.Weight,
+			Spec:   specFromV1beta1(&in.Canary.Spec, meta, canaryLost),
+		}
+		if !canaryLost.empty() {
+			lost.Canary = canaryLost
+		}
+	}
+	return out
+}
+
+func specToV1beta1(in *FunctionSpec, meta *metav1.ObjectMeta, lost *v1beta1Fields) kubelessv1beta1.FunctionSpec {
+	out := kubelessv1beta1.FunctionSpec{
+		Runtime:  in.Runtime,
+		Handler:  in.Handler,
+		Deps:     in.Deps,
+		Function: in.Source.Content,
+		Checksum: in.Source.Checksum,
+	}
+
+	if in.Source.Type == "" {
+		out.FunctionContentType = lost.FunctionContentType
+	} else {
+		for contentType, t := range sourceTypes {
+			if t == in.Source.Type {
+				out.FunctionContentType = contentType
+			}
+		}
+		if in.Source.Zip {
+			out.FunctionContentType += "+zip"
+		}
+	}
+
+	if in.TimeoutSeconds != nil {
+		out.Timeout = strconv.Itoa(int(*in.TimeoutSeconds))
+	} else {
+		out.Timeout = lost.Timeout
+	}
+
+	out.Deployment = deploymentToV1beta1(in, lost)
+	out.HorizontalPodAutoscaler = autoscalerToV1beta1(in, meta, lost)
+	if in.Scaling != nil {
+		out.IdleTimeout = in.Scaling.IdleTimeout
+	}
+	if in.Service != nil {
+		out.ServiceSpec = *in.Service
+	}
+
+	if in.RetryPolicy != nil {
+		out.RetryPolicy = &kubelessv1beta1.FunctionRetryPolicy{
+			MaxAttempts: in.RetryPolicy.MaxAttempts,
+			Backoff:     in.RetryPolicy.Backoff,
+			MaxBackoff:  in.RetryPolicy.MaxBackoff,
+		}
+	}
+	if in.OnFailure != nil {
+		out.OnFailure = &kubelessv1beta1.FunctionDestination{
+			Type: kubelessv1beta1.DestinationType(in.OnFailure.Type),
+			Name: in.OnFailure.Name,
+			URL:  in.OnFailure.URL,
+		}
+	}
+	out.Protocol = kubelessv1beta1.FunctionProtocol(in.Protocol)
+	out.ContainerConcurrency = in.ContainerConcurrency
+	if in.Payload != nil {
+		p := in.Payload.DeepCopy()
+		out.Payload = &kubelessv1beta1.FunctionPayload{
+			MaxRequestSize:  p.MaxRequestSize,
+			MaxResponseSize: p.MaxResponseSize,
+			RequestSchema:   p.RequestSchema,
+		}
+	}
+	if in.AsyncQueue != nil {
+		q := in.AsyncQueue.DeepCopy()
+		out.AsyncQueue = &kubelessv1beta1.FunctionQueue{
+			Storage:          kubelessv1beta1.QueueStorage(q.Storage),
+			StorageClassName: q.StorageClassName,
+			Size:             q.Size,
+		}
+	}
+
+	if in.Canary != nil {
+		canaryLost := lost.Canary
+		if canaryLost == nil {
+			canaryLost = &v1beta1Fields{}
+		}
+		out.Canary = &kubelessv1beta1.FunctionCanary{
+			Weight: in.Canary.Weight,
+			Spec:   specToV1beta1(&in.Canary.Spec, meta, canaryLost),
+		}
+	}
+	return out
+}
+
+// stripTemplate returns a copy of a pod template without the fields of the main container
+// that are part of the v1 spec
+func stripTemplate(in *corev1.PodTemplateSpec) *corev1.PodTemplateSpec {
+	out := in.DeepCopy()
+	if len(out.Spec.Containers) > 0 {
+		c := &out.Spec.Containers[0]
+		c.Env = nil
+		c.Resources = corev1.ResourceRequirements{}
+		c.Image = ""
+	}
+	return out
+}
+
+func deploymentToV1beta1(in *FunctionSpec, lost *v1beta1Fields) extensionsv1beta1.Deployment {
+	out := extensionsv1beta1.Deployment{}
+	if lost.Deployment != nil {
+		out = *lost.Deployment.DeepCopy()
+	}
+	out.Spec.Replicas = nil
+	if in.Scaling != nil {
+		out.Spec.Replicas = in.Scaling.Replicas
+	}
+
+	template := corev1.PodTemplateSpec{}
+	if in.PodTemplate != nil {
+		template = *in.PodTemplate.DeepCopy()
+	} else if lost.Deployment != nil {
+		template = *stripTemplate(&lost.Deployment.Spec.Template)
+	}
+	if len(template.Spec.Containers) == 0 && (in.Env != nil || in.Image != "" || !apiequality.Semantic.DeepEqual(in.Resources, corev1.ResourceRequirements{})) {
+		template.Spec.Containers = []corev1.Container{{}}
+	}
+	if len(template.Spec.Containers) > 0 {
+		c := &template.Spec.Containers[0]
+		c.Env = in.Env
+		c.Resources = in.Resources
+		c.Image = in.Image
+	}
+	out.Spec.Template = template
+	return out
+}
+
+func autoscalerToV1beta1(in *FunctionSpec, meta *metav1.ObjectMeta, lost *v1beta1Fields) v2beta1.HorizontalPodAutoscaler {
+	scaling := in.Scaling
+	if scaling == nil {
+		scaling = &FunctionScaling{}
+	}
+	if lost.HorizontalPodAutoscaler != nil {
+		out := *lost.HorizontalPodAutoscaler.DeepCopy()
+		out.Spec.MinReplicas = scaling.MinReplicas
+		out.Spec.MaxReplicas = scaling.MaxReplicas
+		return out
+	}
+	if scaling.MaxReplicas == 0 {
+		return v2beta1.HorizontalPodAutoscaler{}
+	}
+
+	// Same autoscaler created by "kubeless autoscale create"
+	var labels map[string]string
+	if meta.Labels != nil {
+		labels = map[string]string{}
+		for k, v := range meta.Labels {
+			labels[k] = v
+		}
+	}
+	var metrics []v2beta1.MetricSpec
+	if scaling.TargetCPUUtilizationPercentage != nil {
+		cpu := *scaling.TargetCPUUtilizationPercentage
+		metrics = append(metrics, v2beta1.MetricSpec{
+			Type: v2beta1.ResourceMetricSourceType,
+			Resource: &v2beta1.ResourceMetricSource{
+				Name:                     corev1.ResourceCPU,
+				TargetAverageUtilization: &cpu,
+			},
+		})
+	}
+	if scaling.TargetQPS != nil {
+		metrics = append(metrics, v2beta1.MetricSpec{
+			Type: v2beta1.ObjectMetricSourceType,
+			Object: &v2beta1.ObjectMetricSource{
+				MetricName:  qpsMetricName,
+				TargetValue: scaling.TargetQPS.DeepCopy(),
+				Target: v2beta1.CrossVersionObjectReference{
+					Kind: "Service",
+					Name: meta.Name,
+				},
+			},
+		})
+	}
+	return v2beta1.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "autoscaling/v2beta1",
+			Kind:       "HorizontalPodAutoscaler",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meta.Name,
+			Namespace: meta.Namespace,
+			Labels:    labels,
+		},
+		Spec: v2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: v2beta1.CrossVersionObjectReference{
+				APIVersion: scaleTargetAPIVersion,
+				Kind:       "Deployment",
+				Name:       meta.Name,
+			},
+			MinReplicas: scaling.MinReplicas,
+			MaxReplicas: scaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+func statusFromV1beta1(in *kubelessv1beta1.FunctionStatus) FunctionStatus {
+	out := FunctionStatus{
+		Phase:              FunctionPhase(in.Phase),
+		ObservedGeneration: in.ObservedGeneration,
+		Image:              in.Image,
+		LastError:          in.LastError,
+		Revision:           in.Revision,
+	}
+	for _, c := range in.Conditions {
+		out.Conditions = append(out.Conditions, FunctionCondition{
+			Type:               FunctionConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+	return out
+}
+
+func statusToV1beta1(in *FunctionStatus) kubelessv1beta1.FunctionStatus {
+	out := kubelessv1beta1.FunctionStatus{
+		Phase:              kubelessv1beta1.FunctionPhase(in.Phase),
+		ObservedGeneration: in.ObservedGeneration,
+		Image:              in.Image,
+		LastError:          in.LastError,
+		Revision:           in.Revision,
+	}
+	for _, c := range in.Conditions {
+		out.Conditions = append(out.Conditions, kubelessv1beta1.FunctionCondition{
+			Type:               kubelessv1beta1.FunctionConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+	return out
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	kubelessv1beta1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+)
+
+func int32p(i int32) *int32 {
+	return &i
+}
+
+// v1beta1Function returns a function like the ones created by "kubeless function deploy"
+func v1beta1Function() *kubelessv1beta1.Function {
+	labels := map[string]string{"created-by": "kubeless", "function": "foo"}
+	maxRequestSize := resource.MustParse("1Mi")
+	storageClass := "nfs"
+	return &kubelessv1beta1.Function{
+		TypeMeta: metav1.TypeMeta{APIVersion: "kubeless.io/v1beta1", Kind: "Function"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+			Labels:    labels,
+		},
+		Spec: kubelessv1beta1.FunctionSpec{
+			Handler:             "foo.bar",
+			Function:            "UEsDBA==",
+			FunctionContentType: "base64+zip",
+			Checksum:            "sha256:d8f7c3e7ee1e4f9b2b5de22e5af4a4b0b0b4e8a3e4d2bb8e1c7e1c3f2f3e1b5a",
+			Runtime:             "python2.7",
+			Timeout:             "180",
+			Deps:                "requests",
+			Deployment: extensionsv1beta1.Deployment{
+				Spec: extensionsv1beta1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{
+								Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+								Resources: corev1.ResourceRequirements{
+									Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+								},
+							}},
+						},
+					},
+				},
+			},
+			ServiceSpec: corev1.ServiceSpec{
+				Ports:    []corev1.ServicePort{{Name: "http-function-port", Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: corev1.ProtocolTCP}},
+				Selector: labels,
+				Type:     corev1.ServiceTypeClusterIP,
+			},
+			HorizontalPodAutoscaler: v2beta1.HorizontalPodAutoscaler{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "autoscaling/v2beta1", Kind: "HorizontalPodAutoscaler"},
+				ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", Labels: labels},
+				Spec: v2beta1.HorizontalPodAutoscalerSpec{
+					ScaleTargetRef: v2beta1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "foo"},
+					MinReplicas:    int32p(1),
+					MaxReplicas:    5,
+					Metrics: []v2beta1.MetricSpec{{
+						Type:     v2beta1.ResourceMetricSourceType,
+						Resource: &v2beta1.ResourceMetricSource{Name: corev1.ResourceCPU, TargetAverageUtilization: int32p(70)},
+					}},
+				},
+			},
+			IdleTimeout:          "15m",
+			RetryPolicy:          &kubelessv1beta1.FunctionRetryPolicy{MaxAttempts: 3, Backoff: "1s"},
+			OnFailure:            &kubelessv1beta1.FunctionDestination{Type: kubelessv1beta1.DestinationHTTP, URL: "http://dlq"},
+			Protocol:             kubelessv1beta1.ProtocolWebSocket,
+			ContainerConcurrency: 4,
+			Payload: &kubelessv1beta1.FunctionPayload{
+				MaxRequestSize: &maxRequestSize,
+				RequestSchema:  &runtime.RawExtension{Raw: []byte(`{"type":"object"}`)},
+			},
+			AsyncQueue: &kubelessv1beta1.FunctionQueue{
+				Storage:          kubelessv1beta1.QueueStorageVolume,
+				StorageClassName: &storageClass,
+			},
+		},
+		Status: kubelessv1beta1.FunctionStatus{
+			Phase:      kubelessv1beta1.FunctionPhaseReady,
+			Revision:   2,
+			Conditions: []kubelessv1beta1.FunctionCondition{{Type: kubelessv1beta1.FunctionReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func roundTrip(t *testing.T, f *kubelessv1beta1.Function) *Function {
+	converted, err := ConvertFromV1beta1(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Serialize the object as the API server does
+	raw, err := json.Marshal(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Function := &Function{}
+	if err := json.Unmarshal(raw, v1Function); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ConvertToV1beta1(v1Function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !apiequality.Semantic.DeepEqual(f, back) {
+		expected, _ := json.Marshal(f)
+		got, _ := json.Marshal(back)
+		t.Errorf("Round trip failed.\nExpecting: %s\nGot:       %s", expected, got)
+	}
+	return v1Function
+}
+
+func TestConvertFromV1beta1(t *testing.T) {
+	f := roundTrip(t, v1beta1Function())
+
+	if _, ok := f.ObjectMeta.Annotations[ConversionAnnotation]; ok {
+		t.Errorf("Unexpected annotation %s", f.ObjectMeta.Annotations[ConversionAnnotation])
+	}
+	if f.APIVersion != "kubeless.io/v1" {
+		t.Errorf("Unexpected API version %s", f.APIVersion)
+	}
+	expected := FunctionSource{Type: SourceBase64, Content: "UEsDBA==", Zip: true, Checksum: "sha256:d8f7c3e7ee1e4f9b2b5de22e5af4a4b0b0b4e8a3e4d2bb8e1c7e1c3f2f3e1b5a"}
+	if f.Spec.Source != expected {
+		t.Errorf("Unexpected source %v", f.Spec.Source)
+	}
+	if f.Spec.TimeoutSeconds == nil || *f.Spec.TimeoutSeconds != 180 {
+		t.Errorf("Unexpected timeout %v", f.Spec.TimeoutSeconds)
+	}
+	if len(f.Spec.Env) != 1 || f.Spec.Env[0].Name != "FOO" || f.Spec.Resources.Limits.Memory().String() != "128Mi" {
+		t.Errorf("Unexpected container settings %v %v", f.Spec.Env, f.Spec.Resources)
+	}
+	if f.Spec.PodTemplate != nil {
+		t.Errorf("Unexpected pod template %v", f.Spec.PodTemplate)
+	}
+	s := f.Spec.Scaling
+	if s == nil || s.MaxReplicas != 5 || *s.MinReplicas != 1 || *s.TargetCPUUtilizationPercentage != 70 || s.TargetQPS != nil || s.IdleTimeout != "15m" {
+		t.Errorf("Unexpected scaling %v", s)
+	}
+	if f.Spec.Protocol != ProtocolWebSocket {
+		t.Errorf("Unexpected protocol %s", f.Spec.Protocol)
+	}
+	if f.Spec.ContainerConcurrency != 4 {
+		t.Errorf("Unexpected container concurrency %d", f.Spec.ContainerConcurrency)
+	}
+	if p := f.Spec.Payload; p == nil || p.MaxRequestSize.String() != "1Mi" || string(p.RequestSchema.Raw) != `{"type":"object"}` {
+		t.Errorf("Unexpected payload %v", f.Spec.Payload)
+	}
+	if q := f.Spec.AsyncQueue; q == nil || q.Storage != QueueStorageVolume || *q.StorageClassName != "nfs" {
+		t.Errorf("Unexpected queue %v", f.Spec.AsyncQueue)
+	}
+	if f.Status.Phase != FunctionPhaseReady || f.Status.Conditions[0].Type != FunctionReady {
+		t.Errorf("Unexpected status %v", f.Status)
+	}
+}
+
+func TestConvertFromV1beta1Canary(t *testing.T) {
+	f := v1beta1Function()
+	canary := v1beta1Function().Spec
+	canary.Function = "new code"
+	canary.FunctionContentType = "text"
+	canary.HorizontalPodAutoscaler = v2beta1.HorizontalPodAutoscaler{}
+	f.Spec.Canary = &kubelessv1beta1.FunctionCanary{Weight: 10, Spec: canary}
+	converted := roundTrip(t, f)
+	if converted.Spec.Canary.Weight != 10 || converted.Spec.Canary.Spec.Source.Type != SourceInline || converted.Spec.Canary.Spec.Scaling.MaxReplicas != 0 {
+		t.Errorf("Unexpected canary %v", converted.Spec.Canary)
+	}
+}
+
+func TestConvertFromV1beta1Lossy(t *testing.T) {
+	f := v1beta1Function()
+	f.Spec.Timeout = "1m"
+	f.Spec.FunctionContentType = "tar"
+	f.Spec.Deployment.ObjectMeta.Annotations = map[string]string{"foo": "bar"}
+	f.Spec.Deployment.Spec.Strategy = extensionsv1beta1.DeploymentStrategy{Type: extensionsv1beta1.RecreateDeploymentStrategyType}
+	f.Spec.Deployment.Spec.Template.Spec.Containers[0].Name = "custom"
+	f.Spec.Deployment.Spec.Template.Spec.ServiceAccountName = "sa"
+	f.Spec.HorizontalPodAutoscaler.Spec.Metrics[0].Resource.Name = corev1.ResourceMemory
+	f.Spec.Canary = &kubelessv1beta1.FunctionCanary{Weight: 10, Spec: *f.Spec.DeepCopy()}
+
+	converted := roundTrip(t, f)
+	if _, ok := converted.ObjectMeta.Annotations[ConversionAnnotation]; !ok {
+		t.Error("Expecting the fields without a v1 equivalent to be stored in an annotation")
+	}
+	if converted.Spec.TimeoutSeconds != nil || converted.Spec.Source.Type != "" {
+		t.Errorf("Unexpected v1 fields %v", converted.Spec)
+	}
+	if converted.Spec.PodTemplate == nil || converted.Spec.PodTemplate.Spec.Containers[0].Name != "custom" || converted.Spec.PodTemplate.Spec.ServiceAccountName != "sa" {
+		t.Errorf("Unexpected pod template %v", converted.Spec.PodTemplate)
+	}
+
+	// Changes in the v1 fields are kept
+	converted.Spec.Env = []corev1.EnvVar{{Name: "BAR", Value: "foo"}}
+	converted.Spec.Scaling.MaxReplicas = 10
+	back, err := ConvertToV1beta1(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := back.Spec.Deployment.Spec.Template.Spec.Containers[0]
+	if c.Name != "custom" || c.Env[0].Name != "BAR" || back.Spec.Deployment.ObjectMeta.Annotations["foo"] != "bar" {
+		t.Errorf("Unexpected container %v", c)
+	}
+	if back.Spec.HorizontalPodAutoscaler.Spec.MaxReplicas != 10 || back.Spec.HorizontalPodAutoscaler.Spec.Metrics[0].Resource.Name != corev1.ResourceMemory {
+		t.Errorf("Unexpected autoscaler %v", back.Spec.HorizontalPodAutoscaler)
+	}
+	if _, ok := back.ObjectMeta.Annotations[ConversionAnnotation]; ok {
+		t.Error("The annotation should be removed")
+	}
+}
+
+func TestConvertToV1beta1(t *testing.T) {
+	f := &Function{
+		TypeMeta: metav1.TypeMeta{APIVersion: "kubeless.io/v1", Kind: "Function"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+		},
+		Spec: FunctionSpec{
+			Runtime:        "nodejs8",
+			Handler:        "foo.bar",
+			Source:         FunctionSource{Type: SourceURL, Content: "https://example.com/foo.zip", Zip: true},
+			TimeoutSeconds: int32p(30),
+			Image:          "my-runtime",
+			Env:            []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+			Scaling: &FunctionScaling{
+				MinReplicas: int32p(2),
+				MaxReplicas: 4,
+				TargetQPS:   resource.NewQuantity(100, resource.DecimalSI),
+			},
+			PodTemplate: &corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"foo": "bar"}},
+			},
+		},
+	}
+	back, err := ConvertToV1beta1(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Spec.FunctionContentType != "url+zip" || back.Spec.Timeout != "30" {
+		t.Errorf("Unexpected spec %v", back.Spec)
+	}
+	c := back.Spec.Deployment.Spec.Template.Spec.Containers[0]
+	if c.Image != "my-runtime" || c.Env[0].Name != "FOO" || back.Spec.Deployment.Spec.Template.ObjectMeta.Annotations["foo"] != "bar" {
+		t.Errorf("Unexpected deployment %v", back.Spec.Deployment)
+	}
+	hpa := back.Spec.HorizontalPodAutoscaler
+	if hpa.Name != "foo" || hpa.Spec.ScaleTargetRef.Name != "foo" || hpa.Spec.MaxReplicas != 4 || hpa.Spec.Metrics[0].Object.MetricName != "function_calls" {
+		t.Errorf("Unexpected autoscaler %v", hpa)
+	}
+
+	again, err := ConvertFromV1beta1(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !apiequality.Semantic.DeepEqual(f, again) {
+		expected, _ := json.Marshal(f)
+		got, _ := json.Marshal(again)
+		t.Errorf("Round trip failed.\nExpecting: %s\nGot:       %s", expected, got)
+	}
+}
@@ -20,7 +20,9 @@ import (
 	"k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // +genclient
@@ -30,7 +32,8 @@ import (
 type Function struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
-	Spec              FunctionSpec `json:"spec"`
+	Spec              FunctionSpec   `json:"spec"`
+	Status            FunctionStatus `json:"status,omitempty"`
 }
 
 // FunctionSpec contains func specification
@@ -45,6 +48,155 @@ type FunctionSpec struct {
 	Deployment              v1beta1.Deployment              `json:"deployment" protobuf:"bytes,3,opt,name=template"`
 	ServiceSpec             v1.ServiceSpec                  `json:"service"`
 	HorizontalPodAutoscaler v2beta1.HorizontalPodAutoscaler `json:"horizontalPodAutoscaler" protobuf:"bytes,3,opt,name=horizontalPodAutoscaler"`
+	Canary                  *FunctionCanary                 `json:"canary,omitempty"`               // New revision of the function receiving a part of the traffic
+	IdleTimeout             string                          `json:"idleTimeout,omitempty"`          // Time without requests after which the function is scaled to zero (e.g. 15m)
+	RetryPolicy             *FunctionRetryPolicy            `json:"retryPolicy,omitempty"`          // How failed invocations are retried
+	OnFailure               *FunctionDestination            `json:"onFailure,omitempty"`            // Destination of the events that fail after all the retries
+	Protocol                FunctionProtocol                `json:"protocol,omitempty"`             // Protocol served by the function: http (default), websocket or grpc
+	ContainerConcurrency    int32                           `json:"containerConcurrency,omitempty"` // Maximum number of requests processed at the same time by each replica (0 is unlimited)
+	Payload                 *FunctionPayload                `json:"payload,omitempty"`              // Limits and validation of the requests and responses
+	AsyncQueue              *FunctionQueue                  `json:"asyncQueue,omitempty"`           // Storage of the asynchronous invocations
+}
+
+// FunctionCanary describes a new revision of a function that runs next to the current one
+// until it is promoted or rolled back
+type FunctionCanary struct {
+	// Weight is the percentage (0-100) of the function traffic that the canary should receive
+	Weight int32 `json:"weight"`
+	// Spec of the new revision. Its own Canary field is ignored
+	Spec FunctionSpec `json:"spec"`
+}
+
+// FunctionRetryPolicy defines how the failed invocations of a function are retried
+type FunctionRetryPolicy struct {
+	// MaxAttempts is the total number of times an event is processed, including the first one
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+	// Backoff is the time to wait after the first failure (e.g. 1s). It is doubled after each attempt
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff is the maximum time to wait between attempts
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+}
+
+// FunctionPayload limits the size of the requests and responses of a function and
+// validates the body of its requests
+type FunctionPayload struct {
+	// MaxRequestSize is the maximum size of the body of a request (e.g. 1Mi)
+	MaxRequestSize *resource.Quantity `json:"maxRequestSize,omitempty"`
+	// MaxResponseSize is the maximum size of the body of a response
+	MaxResponseSize *resource.Quantity `json:"maxResponseSize,omitempty"`
+	// RequestSchema is a JSON Schema that the JSON body of the requests should match
+	RequestSchema *runtime.RawExtension `json:"requestSchema,omitempty"`
+}
+
+// FunctionQueue configures where the asynchronous invocations of a function are stored
+type FunctionQueue struct {
+	// Storage is "memory" (default) or "volume". The memory storage loses the invocations when the
+	// replica that received them stops. The volume storage keeps them in a volume shared by all the
+	// replicas of the function
+	Storage QueueStorage `json:"storage,omitempty"`
+	// StorageClassName of the volume. It should support the ReadWriteMany access mode
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// Size of the volume (1Gi by default)
+	Size *resource.Quantity `json:"size,omitempty"`
+}
+
+// QueueStorage is where the asynchronous invocations of a function are stored
+type QueueStorage string
+
+// Supported storages of the asynchronous invocations
+const (
+	// QueueStorageMemory keeps the invocations in the memory of the replica that received them
+	QueueStorageMemory QueueStorage = "memory"
+	// QueueStorageVolume keeps the invocations in a PersistentVolumeClaim shared by all the replicas
+	QueueStorageVolume QueueStorage = "volume"
+)
+
+// DestinationType is the kind of destination of the failed events of a function
+type DestinationType string
+
+// Supported destinations of the failed events of a function
+const (
+	// DestinationFunction sends the failed events to another function of the same namespace
+	DestinationFunction DestinationType = "function"
+	// DestinationNATS publishes the failed events in a NATS topic
+	DestinationNATS DestinationType = "nats"
+	// DestinationKafka publishes the failed events in a Kafka topic through a Kafka REST proxy
+	DestinationKafka DestinationType = "kafka"
+	// DestinationHTTP sends the failed events to an HTTP endpoint
+	DestinationHTTP DestinationType = "http"
+)
+
+// FunctionProtocol is the protocol used by the clients of a function
+type FunctionProtocol string
+
+// Supported protocols of a function
+const (
+	// ProtocolHTTP serves a request/response pair per invocation
+	ProtocolHTTP FunctionProtocol = "http"
+	// ProtocolWebSocket upgrades the HTTP connections to WebSockets
+	ProtocolWebSocket FunctionProtocol = "websocket"
+	// ProtocolGRPC serves gRPC streams over HTTP/2 without TLS
+	ProtocolGRPC FunctionProtocol = "grpc"
+)
+
+// FunctionDestination is the destination of the events that a function fails to process
+type FunctionDestination struct {
+	Type DestinationType `json:"type"`
+	// Name of the function or topic that receives the events
+	Name string `json:"name,omitempty"`
+	// URL of the HTTP endpoint, the NATS server or the Kafka REST proxy
+	URL string `json:"url,omitempty"`
+}
+
+// FunctionPhase is a label for the lifecycle stage of a function
+type FunctionPhase string
+
+const (
+	// FunctionPhasePending means the function has been accepted but not processed yet
+	FunctionPhasePending FunctionPhase = "Pending"
+	// FunctionPhaseBuilding means the function image is being built
+	FunctionPhaseBuilding FunctionPhase = "Building"
+	// FunctionPhaseDeploying means the function resources exist but its pods are not ready yet
+	FunctionPhaseDeploying FunctionPhase = "Deploying"
+	// FunctionPhaseReady means the function Deployment has been rolled out and is available
+	FunctionPhaseReady FunctionPhase = "Ready"
+	// FunctionPhaseFailed means the controller was unable to deploy the function
+	FunctionPhaseFailed FunctionPhase = "Failed"
+	// FunctionPhaseIdle means the function has been scaled to zero and it will be activated by the next request
+	FunctionPhaseIdle FunctionPhase = "Idle"
+)
+
+// FunctionConditionType is a valid value for FunctionCondition.Type
+type FunctionConditionType string
+
+const (
+	// FunctionBuilt indicates whether the function image has been built
+	FunctionBuilt FunctionConditionType = "Built"
+	// FunctionDeployed indicates whether the function resources (ConfigMap, Service and Deployment) have been created
+	FunctionDeployed FunctionConditionType = "Deployed"
+	// FunctionReady indicates whether the function is available to serve requests
+	FunctionReady FunctionConditionType = "Ready"
+	// FunctionIdle indicates whether the function has been scaled to zero
+	FunctionIdle FunctionConditionType = "Idle"
+)
+
+// FunctionCondition describes the state of a function at a certain point
+type FunctionCondition struct {
+	Type               FunctionConditionType `json:"type"`
+	Status             v1.ConditionStatus    `json:"status"`
+	LastTransitionTime metav1.Time           `json:"lastTransitionTime,omitempty"`
+	Reason             string                `json:"reason,omitempty"`
+	Message            string                `json:"message,omitempty"`
+}
+
+// FunctionStatus contains the observed state of a function
+type FunctionStatus struct {
+	Phase              FunctionPhase       `json:"phase,omitempty"`              // Current lifecycle stage of the function
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"` // Generation of the spec processed by the controller
+	Image              string              `json:"image,omitempty"`              // Image used to run the function
+	LastError          string              `json:"lastError,omitempty"`          // Last error found deploying the function
+	Revision           int64               `json:"revision,omitempty"`           // Revision of the function currently deployed
+	Conditions         []FunctionCondition `json:"conditions,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Sequence is a pipeline of functions exposed as a single service
+type Sequence struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              SequenceSpec   `json:"spec"`
+	Status            SequenceStatus `json:"status,omitempty"`
+}
+
+// SequenceSpec contains the steps of a sequence
+type SequenceSpec struct {
+	Steps   []SequenceStep `json:"steps"`             // Steps executed in order. The output of a step is the input of the next one
+	Timeout string         `json:"timeout,omitempty"` // Maximum time (in seconds) to execute the whole sequence
+}
+
+// SequenceStep is a step of a sequence. Exactly one of Function, Branches or Parallel must be set
+type SequenceStep struct {
+	Name string `json:"name"`
+	// Function invoked with the input of the step. Its response is the output of the step
+	Function string `json:"function,omitempty"`
+	// Branches are evaluated in order and only the steps of the first branch whose condition matches are
+	// executed. If no branch matches the input of the step is its output
+	Branches []SequenceBranch `json:"branches,omitempty"`
+	// Parallel branches are executed at the same time with the input of the step (fan-out). The output
+	// of the step is a JSON object with the output of each branch executed under its name (fan-in)
+	Parallel []SequenceBranch `json:"parallel,omitempty"`
+}
+
+// SequenceBranch is a list of steps executed when its condition matches the input
+type SequenceBranch struct {
+	Name  string             `json:"name"`
+	When  *SequenceCondition `json:"when,omitempty"` // A branch without condition always matches
+	Steps []SequenceStep     `json:"steps"`
+}
+
+// SequenceCondition matches the input of a step. If the input is a JSON document, Path selects a field
+// of it using dots to separate the keys (e.g. order.status). In other case the whole input is used
+type SequenceCondition struct {
+	Path    string `json:"path,omitempty"`
+	Equals  string `json:"equals,omitempty"`  // The value is equal to this string
+	Matches string `json:"matches,omitempty"` // The value matches this regular expression
+	Exists  *bool  `json:"exists,omitempty"`  // The field is (or isn't) present in the input
+}
+
+// SequencePhase is a label for the lifecycle stage of a sequence
+type SequencePhase string
+
+const (
+	// SequencePhasePending means the sequence has been accepted but not processed yet
+	SequencePhasePending SequencePhase = "Pending"
+	// SequencePhaseDeploying means the sequence resources exist but they are not available yet
+	SequencePhaseDeploying SequencePhase = "Deploying"
+	// SequencePhaseReady means the sequence is available to serve requests
+	SequencePhaseReady SequencePhase = "Ready"
+	// SequencePhaseFailed means the controller was unable to deploy the sequence
+	SequencePhaseFailed SequencePhase = "Failed"
+)
+
+// SequenceStatus contains the observed state of a sequence
+type SequenceStatus struct {
+	Phase              SequencePhase `json:"phase,omitempty"`              // Current lifecycle stage of the sequence
+	ObservedGeneration int64         `json:"observedGeneration,omitempty"` // Generation of the spec processed by the controller
+	Functions          []string      `json:"functions,omitempty"`          // Functions invoked by the sequence
+	LastError          string        `json:"lastError,omitempty"`          // Last error found deploying the sequence
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SequenceList contains a list of sequences
+type SequenceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	// Items is a list of sequences
+	Items []*Sequence `json:"items"`
+}
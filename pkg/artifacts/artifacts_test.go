@@ -0,0 +1,329 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func checksumOf(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+func TestKey(t *testing.T) {
+	sum := checksumOf([]byte("foo"))
+	key, err := Key("default", "hello", "sha256:"+sum)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key != "default/hello/"+sum {
+		t.Errorf("Unexpected key %s", key)
+	}
+	if checksum, err := ParseKey(key); err != nil || checksum != sum {
+		t.Errorf("Unexpected checksum %s (%v)", checksum, err)
+	}
+	if _, err := Key("default", "hello", "md5:abc"); err == nil {
+		t.Error("Expecting an error for a checksum that is not sha256")
+	}
+	for _, key := range []string{"", "default/hello", "../hello/" + sum, "default/hello/abc", "default/hello/" + sum + "/x"} {
+		if _, err := ParseKey(key); err == nil {
+			t.Errorf("Expecting an error for key %q", key)
+		}
+	}
+}
+
+func TestServiceFromURL(t *testing.T) {
+	ns, name, port, err := ServiceFromURL("http://artifact-server.kubeless.svc.cluster.local:8080")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ns != "kubeless" || name != "artifact-server" || port != "8080" {
+		t.Errorf("Unexpected service %s/%s:%s", ns, name, port)
+	}
+	if _, _, _, err := ServiceFromURL("http://artifacts.example.com"); err == nil {
+		t.Error("Expecting an error for a URL that is not a service")
+	}
+}
+
+// fakeS3 is a minimal stand-in of an S3 compatible service like MinIO
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-west-2/s3/aws4_request") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != checksumOf(body) {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = body
+	case "GET":
+		if r.URL.Query().Get("list-type") == "2" {
+			prefix := r.URL.Path + "/"
+			fmt.Fprint(w, "<ListBucketResult>")
+			for k := range s.objects {
+				if strings.HasPrefix(k, prefix) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2018-05-21T10:32:11.000Z</LastModified></Contents>", strings.TrimPrefix(k, prefix))
+				}
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+			return
+		}
+		content, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case "DELETE":
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newTestS3Store(t *testing.T) (*S3Store, *fakeS3, func()) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	ts := httptest.NewServer(fake)
+	store, err := NewS3Store(ts.URL, "functions", "us-west-2", credentials.NewStaticCredentials("AKID", "SECRET", ""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return store, fake, ts.Close
+}
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3Store, fake, stop := newTestS3Store(t)
+	defer stop()
+
+	content := []byte("def hello(event, context): return 'hello'")
+	key := "default/hello/" + checksumOf(content)
+	for name, store := range map[string]Store{"file": fileStore, "s3": s3Store} {
+		if _, err := store.Get(key); err != ErrNotFound {
+			t.Errorf("%s: expecting ErrNotFound, received %v", name, err)
+		}
+		if err := store.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		r, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		received, _ := ioutil.ReadAll(r)
+		r.Close()
+		if !bytes.Equal(received, content) {
+			t.Errorf("%s: unexpected content %q", name, received)
+		}
+		list, err := store.List()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(list) != 1 || list[0].Key != key || list[0].Modified.IsZero() {
+			t.Errorf("%s: unexpected artifacts %v", name, list)
+		}
+	}
+	if _, ok := fake.objects["/functions/"+key]; !ok {
+		t.Errorf("The object is not stored in the bucket: %v", fake.objects)
+	}
+	for name, store := range map[string]Store{"file": fileStore, "s3": s3Store} {
+		if err := store.Delete(key); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if _, err := store.Get(key); err != ErrNotFound {
+			t.Errorf("%s: expecting ErrNotFound after deleting the artifact, received %v", name, err)
+		}
+	}
+
+	badCreds, err := NewS3Store(s3Store.endpoint.String(), "functions", "eu-west-1", credentials.NewStaticCredentials("AKID", "SECRET", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := badCreds.Get(key); err == nil || err == ErrNotFound {
+		t.Errorf("Expecting an access error, received %v", err)
+	}
+}
+
+// fakeAuthorizer accepts the tokens of a namespace
+type fakeAuthorizer map[string]string
+
+func (a fakeAuthorizer) Authorize(token, namespace string) (bool, error) {
+	return a[token] == namespace, nil
+}
+
+func TestServer(t *testing.T) {
+	store, _, stop := newTestS3Store(t)
+	defer stop()
+	ts := httptest.NewServer(NewServer(store, 64, fakeAuthorizer{"default-token": "default", "other-token": "other"}))
+	defer ts.Close()
+
+	content := []byte("function content")
+	url := ts.URL + PathPrefix + "default/hello/" + checksumOf(content)
+	tests := []struct {
+		method string
+		url    string
+		token  string
+		body   []byte
+		status int
+	}{
+		{"GET", url, "default-token", nil, http.StatusNotFound},
+		{"PUT", url, "default-token", []byte("other content"), http.StatusBadRequest},
+		{"PUT", url, "default-token", bytes.Repeat([]byte("a"), 65), http.StatusRequestEntityTooLarge},
+		{"PUT", ts.URL + PathPrefix + "default/hello", "default-token", content, http.StatusBadRequest},
+		{"DELETE", url, "default-token", nil, http.StatusMethodNotAllowed},
+		{"PUT", url, "", content, http.StatusUnauthorized},
+		{"PUT", url, "other-token", content, http.StatusForbidden},
+		{"PUT", url, "default-token", content, http.StatusCreated},
+		{"GET", url, "", nil, http.StatusUnauthorized},
+		{"GET", url, "other-token", nil, http.StatusForbidden},
+		{"GET", url, "default-token", nil, http.StatusOK},
+		{"GET", ts.URL + "/healthz", "", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set(TokenHeader, tt.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s %s: expecting status %d, received %d: %s", tt.method, tt.url, tt.status, res.StatusCode, body)
+		}
+		if tt.method == "GET" && tt.url == url && res.StatusCode == http.StatusOK && !bytes.Equal(body, content) {
+			t.Errorf("Unexpected content %q", body)
+		}
+	}
+}
+
+func TestTokenReviewAuthorizer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		review := action.(ktesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "sa-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "system:serviceaccount:default:builder"}}
+		case "user-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "alice"}}
+		}
+		return true, review, nil
+	})
+	a := &TokenReviewAuthorizer{Client: client}
+	tests := []struct {
+		token     string
+		namespace string
+		allowed   bool
+	}{
+		{"sa-token", "default", true},
+		{"sa-token", "kube-system", false},
+		{"user-token", "default", false},
+		{"invalid", "default", false},
+	}
+	for _, tt := range tests {
+		allowed, err := a.Authorize(tt.token, tt.namespace)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s in %s: expecting allowed=%v", tt.token, tt.namespace, tt.allowed)
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An old referenced artifact, an old unreferenced one and a recent unreferenced one
+	keys := []string{}
+	for i, age := range []time.Duration{2 * time.Hour, 2 * time.Hour, time.Minute} {
+		content := []byte(fmt.Sprintf("function %d", i))
+		key := "default/hello/" + checksumOf(content)
+		if err := store.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	removed, err := CollectGarbage(store, map[string]bool{keys[0]: true}, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expecting one artifact to be removed, %d were removed", removed)
+	}
+	for i, key := range keys {
+		_, err := store.Get(key)
+		if i == 1 && err != ErrNotFound {
+			t.Errorf("The unreferenced artifact %s should be removed", key)
+		} else if i != 1 && err != nil {
+			t.Errorf("The artifact %s should be kept: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// TokenHeader is the header with the service account token of a client. The API server
+	// proxy removes the Authorization header so it can't be used for uploads
+	TokenHeader = "X-Kubeless-Token"
+	// ServiceAccountTokenFile is the file from which the pods read the token of their service account
+	ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// Authorizer decides if the owner of a token can read and write the artifacts of a namespace
+type Authorizer interface {
+	Authorize(token, namespace string) (bool, error)
+}
+
+// TokenReviewAuthorizer validates the tokens with the TokenReview API of Kubernetes. Only the
+// service accounts of a namespace can access its artifacts
+type TokenReviewAuthorizer struct {
+	Client kubernetes.Interface
+}
+
+// Authorize returns true if the token belongs to a service account of the namespace
+func (a *TokenReviewAuthorizer) Authorize(token, namespace string) (bool, error) {
+	review, err := a.Client.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return false, err
+	}
+	if !review.Status.Authenticated {
+		return false, nil
+	}
+	// The user name of a service account is system:serviceaccount:<namespace>:<name>
+	parts := strings.Split(review.Status.User.Username, ":")
+	return len(parts) == 4 && parts[0] == "system" && parts[1] == "serviceaccount" && parts[2] == namespace, nil
+}
+
+// requestToken returns the token of a request from the TokenHeader or the Authorization header
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(TokenHeader); token != "" {
+		return token
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"time"
+)
+
+// CollectGarbage removes the artifacts of a store that are not referenced. Artifacts newer than
+// minAge are kept since they may belong to functions that are being created. It returns the
+// number of artifacts removed
+func CollectGarbage(store Store, referenced map[string]bool, minAge time.Duration) (int, error) {
+	list, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, a := range list {
+		if referenced[a.Key] || time.Since(a.Modified) < minAge {
+			continue
+		}
+		if err := store.Delete(a.Key); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
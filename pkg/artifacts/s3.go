@@ -0,0 +1,196 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// S3Store saves the artifacts in a bucket of an S3 compatible service (AWS S3, MinIO...).
+// Objects are addressed using path-style URLs: <endpoint>/<bucket>/<key>
+type S3Store struct {
+	endpoint *url.URL
+	bucket   string
+	region   string
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// NewS3Store returns a store that saves the artifacts in the given bucket
+func NewS3Store(endpoint, bucket, region string, creds *credentials.Credentials) (*S3Store, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q. It should be an http(s) URL", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("A bucket is required to store artifacts in S3")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		endpoint: u,
+		bucket:   bucket,
+		region:   region,
+		signer: v4.NewSigner(creds, func(s *v4.Signer) {
+			// S3 expects the path to be escaped only once
+			s.DisableURIPathEscaping = true
+		}),
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *S3Store) objectURL(key string) string {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	return u.String()
+}
+
+func (s *S3Store) do(req *http.Request, body io.ReadSeeker) (*http.Response, error) {
+	if _, err := s.signer.Sign(req, body, "s3", s.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("Unable to sign request: %v", err)
+	}
+	return s.client.Do(req)
+}
+
+// responseError returns an error with the body of a failed response
+func responseError(res *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("Request to %s failed with status %s: %s", res.Request.URL.Path, res.Status, string(msg))
+}
+
+// Put uploads an object to the bucket
+func (s *S3Store) Put(key string, content io.ReadSeeker, size int64) error {
+	req, err := http.NewRequest("PUT", s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := s.do(req, content)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return responseError(res)
+	}
+	return nil
+}
+
+// Get downloads an object of the bucket
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, responseError(res)
+	}
+	return res.Body, nil
+}
+
+// listBucketResult is the response of the ListObjectsV2 operation
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects of the bucket that are valid artifact keys
+func (s *S3Store) List() ([]Artifact, error) {
+	list := []Artifact{}
+	token := ""
+	for {
+		u := *s.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.do(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 300 {
+			defer res.Body.Close()
+			return nil, responseError(res)
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the objects of bucket %s: %v", s.bucket, err)
+		}
+		for _, o := range result.Contents {
+			if _, err := ParseKey(o.Key); err == nil {
+				list = append(list, Artifact{Key: o.Key, Modified: o.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return list, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete removes an object of the bucket
+func (s *S3Store) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		return responseError(res)
+	}
+	return nil
+}
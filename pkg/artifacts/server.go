@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PathPrefix is the path in which the artifact server serves the artifacts
+	PathPrefix = "/artifacts/"
+	// DefaultMaxSize is the default maximum size of an artifact
+	DefaultMaxSize = 100 * 1024 * 1024
+)
+
+// Server exposes a Store through HTTP. Artifacts are uploaded with a PUT request to
+// /artifacts/<key> and downloaded with a GET request to the same path. The content
+// of an upload should match the checksum of its key. Requests should include a token
+// that the Auth authorizer accepts for the namespace of the artifact
+type Server struct {
+	Store   Store
+	MaxSize int64
+	Auth    Authorizer
+	logger  *logrus.Entry
+}
+
+// NewServer returns a server for the given store
+func NewServer(store Store, maxSize int64, auth Authorizer) *Server {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Server{
+		Store:   store,
+		MaxSize: maxSize,
+		Auth:    auth,
+		logger:  logrus.WithField("pkg", "artifacts"),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, PathPrefix)
+	checksum, err := ParseKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(w, r, strings.Split(key, "/")[0]) {
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.get(w, key)
+	case "PUT":
+		s.put(w, r, key, checksum)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// authorize checks the token of a request and writes an error if it can't access the namespace
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, namespace string) bool {
+	token := requestToken(r)
+	if token == "" {
+		http.Error(w, fmt.Sprintf("A service account token is required in the %s header", TokenHeader), http.StatusUnauthorized)
+		return false
+	}
+	allowed, err := s.Auth.Authorize(token, namespace)
+	if err != nil {
+		s.logger.Errorf("Unable to validate token: %v", err)
+		http.Error(w, "Unable to validate the token", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("The token is not allowed to access the artifacts of the namespace %s", namespace), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *Server) get(w http.ResponseWriter, key string) {
+	content, err := s.Store.Get(key)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("Artifact %s not found", key), http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.logger.Errorf("Unable to read artifact %s: %v", key, err)
+		http.Error(w, "Unable to read the artifact", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, content); err != nil {
+		s.logger.Errorf("Unable to send artifact %s: %v", key, err)
+	}
+}
+
+// put stores the body of a request in a temporary file to verify its checksum before saving it
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key, checksum string) {
+	tmp, err := ioutil.TempFile("", "artifact-")
+	if err != nil {
+		s.logger.Errorf("Unable to create temporary file: %v", err)
+		http.Error(w, "Unable to receive the artifact", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r.Body, s.MaxSize+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the artifact: %v", err), http.StatusBadRequest)
+		return
+	}
+	if size > s.MaxSize {
+		http.Error(w, fmt.Sprintf("The artifact exceeds the maximum size of %d bytes", s.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if received := hex.EncodeToString(h.Sum(nil)); received != checksum {
+		http.Error(w, fmt.Sprintf("The checksum of the artifact (%s) doesn't match its key", received), http.StatusBadRequest)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.Store.Put(key, tmp, size); err != nil {
+		s.logger.Errorf("Unable to store artifact %s: %v", key, err)
+		http.Error(w, "Unable to store the artifact", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Infof("Stored artifact %s (%d bytes)", key, size)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package artifacts stores the content of the functions outside of the Function objects so
+// it is not limited by the size of a ConfigMap.
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an artifact doesn't exist in a store
+var ErrNotFound = errors.New("artifact not found")
+
+// Store saves and retrieves the artifacts of the functions
+type Store interface {
+	// Put stores the content of an artifact
+	Put(key string, content io.ReadSeeker, size int64) error
+	// Get returns the content of an artifact or ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	// List returns all the artifacts of the store
+	List() ([]Artifact, error)
+	// Delete removes an artifact
+	Delete(key string) error
+}
+
+// Artifact describes an artifact of a store
+type Artifact struct {
+	Key      string
+	Modified time.Time
+}
+
+var (
+	nameRegexp     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+	checksumRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// Key returns the key of the artifact of a function. The key includes the checksum of
+// the content so different revisions of a function are stored as different artifacts
+func Key(namespace, function, checksum string) (string, error) {
+	if !strings.HasPrefix(checksum, "sha256:") {
+		return "", fmt.Errorf("Unable to store artifact with checksum %q: only sha256 is supported", checksum)
+	}
+	key := fmt.Sprintf("%s/%s/%s", namespace, function, strings.TrimPrefix(checksum, "sha256:"))
+	if _, err := ParseKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// ParseKey validates the key of an artifact and returns the sha256 checksum of its content
+func ParseKey(key string) (string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || !nameRegexp.MatchString(parts[0]) || !nameRegexp.MatchString(parts[1]) || !checksumRegexp.MatchString(parts[2]) {
+		return "", fmt.Errorf("Invalid artifact key %q. It should be <namespace>/<function>/<sha256>", key)
+	}
+	return parts[2], nil
+}
+
+// ServiceFromURL returns the namespace, name and port of the Kubernetes service of
+// an artifact server URL like http://<name>.<namespace>.svc.cluster.local:<port>
+func ServiceFromURL(serverURL string) (string, string, string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 3 || parts[2] != "svc" {
+		return "", "", "", fmt.Errorf("Unable to find the service of the artifact server %s. Expecting a URL like http://<service>.<namespace>.svc.cluster.local:<port>", serverURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return parts[1], parts[0], port, nil
+}
@@ -17,6 +17,7 @@ package versioned
 
 import (
 	glog "github.com/golang/glog"
+	kubelessv1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1"
 	kubelessv1beta1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1beta1"
 	discovery "k8s.io/client-go/discovery"
 	rest "k8s.io/client-go/rest"
@@ -26,8 +27,9 @@ import (
 type Interface interface {
 	Discovery() discovery.DiscoveryInterface
 	KubelessV1beta1() kubelessv1beta1.KubelessV1beta1Interface
+	KubelessV1() kubelessv1.KubelessV1Interface
 	// Deprecated: please explicitly pick a version if possible.
-	Kubeless() kubelessv1beta1.KubelessV1beta1Interface
+	Kubeless() kubelessv1.KubelessV1Interface
 }
 
 // Clientset contains the clients for groups. Each group has exactly one
@@ -35,6 +37,7 @@ type Interface interface {
 type Clientset struct {
 	*discovery.DiscoveryClient
 	kubelessV1beta1 *kubelessv1beta1.KubelessV1beta1Client
+	kubelessV1      *kubelessv1.KubelessV1Client
 }
 
 // KubelessV1beta1 retrieves the KubelessV1beta1Client
@@ -42,10 +45,15 @@ func (c *Clientset) KubelessV1beta1() kubelessv1beta1.KubelessV1beta1Interface {
 	return c.kubelessV1beta1
 }
 
+// KubelessV1 retrieves the KubelessV1Client
+func (c *Clientset) KubelessV1() kubelessv1.KubelessV1Interface {
+	return c.kubelessV1
+}
+
 // Deprecated: Kubeless retrieves the default version of KubelessClient.
 // Please explicitly pick a version.
-func (c *Clientset) Kubeless() kubelessv1beta1.KubelessV1beta1Interface {
-	return c.kubelessV1beta1
+func (c *Clientset) Kubeless() kubelessv1.KubelessV1Interface {
+	return c.kubelessV1
 }
 
 // Discovery retrieves the DiscoveryClient
@@ -68,6 +76,10 @@ func NewForConfig(c *rest.Config) (*Clientset, error) {
 	if err != nil {
 		return nil, err
 	}
+	cs.kubelessV1, err = kubelessv1.NewForConfig(&configShallowCopy)
+	if err != nil {
+		return nil, err
+	}
 
 	cs.DiscoveryClient, err = discovery.NewDiscoveryClientForConfig(&configShallowCopy)
 	if err != nil {
@@ -82,6 +94,7 @@ func NewForConfig(c *rest.Config) (*Clientset, error) {
 func NewForConfigOrDie(c *rest.Config) *Clientset {
 	var cs Clientset
 	cs.kubelessV1beta1 = kubelessv1beta1.NewForConfigOrDie(c)
+	cs.kubelessV1 = kubelessv1.NewForConfigOrDie(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClientForConfigOrDie(c)
 	return &cs
@@ -91,6 +104,7 @@ func NewForConfigOrDie(c *rest.Config) *Clientset {
 func New(c rest.Interface) *Clientset {
 	var cs Clientset
 	cs.kubelessV1beta1 = kubelessv1beta1.New(c)
+	cs.kubelessV1 = kubelessv1.New(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClient(c)
 	return &cs
@@ -17,6 +17,8 @@ package fake
 
 import (
 	clientset "github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	kubelessv1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1"
+	fakekubelessv1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1/fake"
 	kubelessv1beta1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1beta1"
 	fakekubelessv1beta1 "github.com/kubeless/kubeless/pkg/client/clientset/versioned/typed/kubeless/v1beta1/fake"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -64,7 +66,12 @@ func (c *Clientset) KubelessV1beta1() kubelessv1beta1.KubelessV1beta1Interface {
 	return &fakekubelessv1beta1.FakeKubelessV1beta1{Fake: &c.Fake}
 }
 
-// Kubeless retrieves the KubelessV1beta1Client
-func (c *Clientset) Kubeless() kubelessv1beta1.KubelessV1beta1Interface {
-	return &fakekubelessv1beta1.FakeKubelessV1beta1{Fake: &c.Fake}
+// KubelessV1 retrieves the KubelessV1Client
+func (c *Clientset) KubelessV1() kubelessv1.KubelessV1Interface {
+	return &fakekubelessv1.FakeKubelessV1{Fake: &c.Fake}
+}
+
+// Kubeless retrieves the KubelessV1Client
+func (c *Clientset) Kubeless() kubelessv1.KubelessV1Interface {
+	return &fakekubelessv1.FakeKubelessV1{Fake: &c.Fake}
 }
@@ -34,6 +34,7 @@ type FunctionsGetter interface {
 type FunctionInterface interface {
 	Create(*v1beta1.Function) (*v1beta1.Function, error)
 	Update(*v1beta1.Function) (*v1beta1.Function, error)
+	UpdateStatus(*v1beta1.Function) (*v1beta1.Function, error)
 	Delete(name string, options *v1.DeleteOptions) error
 	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
 	Get(name string, options v1.GetOptions) (*v1beta1.Function, error)
@@ -117,6 +118,22 @@ func (c *functions) Update(function *v1beta1.Function) (result *v1beta1.Function
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *functions) UpdateStatus(function *v1beta1.Function) (result *v1beta1.Function, err error) {
+	result = &v1beta1.Function{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("functions").
+		Name(function.Name).
+		SubResource("status").
+		Body(function).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
 func (c *functions) Delete(name string, options *v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -25,6 +25,7 @@ import (
 type KubelessV1beta1Interface interface {
 	RESTClient() rest.Interface
 	FunctionsGetter
+	SequencesGetter
 }
 
 // KubelessV1beta1Client is used to interact with features provided by the kubeless.io group.
@@ -36,6 +37,10 @@ func (c *KubelessV1beta1Client) Functions(namespace string) FunctionInterface {
 	return newFunctions(c, namespace)
 }
 
+func (c *KubelessV1beta1Client) Sequences(namespace string) SequenceInterface {
+	return newSequences(c, namespace)
+}
+
 // NewForConfig creates a new KubelessV1beta1Client for the given config.
 func NewForConfig(c *rest.Config) (*KubelessV1beta1Client, error) {
 	config := *c
@@ -21,6 +21,7 @@ package externalversions
 import (
 	"fmt"
 
+	v1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1"
 	v1beta1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
@@ -52,9 +53,15 @@ func (f *genericInformer) Lister() cache.GenericLister {
 // TODO extend this to unknown resources with a client pool
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
-	// Group=kubeless.io, Version=v1beta1
+	// Group=kubeless.io, Version=v1
+	case v1.SchemeGroupVersion.WithResource("functions"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubeless().V1().Functions().Informer()}, nil
+
+		// Group=kubeless.io, Version=v1beta1
 	case v1beta1.SchemeGroupVersion.WithResource("functions"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubeless().V1beta1().Functions().Informer()}, nil
+	case v1beta1.SchemeGroupVersion.WithResource("sequences"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubeless().V1beta1().Sequences().Informer()}, nil
 
 	}
 
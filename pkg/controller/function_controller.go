@@ -18,13 +18,19 @@ package controller
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	monitoringv1alpha1 "github.com/coreos/prometheus-operator/pkg/client/monitoring/v1alpha1"
 	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/autoscaling/v2beta1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
@@ -42,6 +48,7 @@ import (
 	kv1beta1 "github.com/kubeless/kubeless/pkg/client/informers/externalversions/kubeless/v1beta1"
 	"github.com/kubeless/kubeless/pkg/langruntime"
 	"github.com/kubeless/kubeless/pkg/registry"
+	"github.com/kubeless/kubeless/pkg/tracing"
 	"github.com/kubeless/kubeless/pkg/utils"
 )
 
@@ -50,8 +57,24 @@ const (
 	funcKind          = "Function"
 	funcAPIVersion    = "kubeless.io/v1beta1"
 	functionFinalizer = "kubeless.io/function"
+	// statusCheckPeriod is the time to wait before checking again the status of a function that is not ready
+	statusCheckPeriod = 5 * time.Second
+	// reasonBuildSkipped is the reason of the Built condition of the functions deployed without building their image
+	reasonBuildSkipped = "BuildSkipped"
+	// defaultRevisionHistoryLimit is the number of revisions kept for each function unless configured otherwise
+	defaultRevisionHistoryLimit = 10
+	// idleCheckPeriod is the time between checks of the activity of the functions that can be scaled to zero
+	idleCheckPeriod = 10 * time.Second
+	// defaultActivatorService is the name of the service of the activator unless configured otherwise
+	defaultActivatorService = "kubeless-activator"
+	// buildLogTailLines is the number of lines of the log of a failed build container reported in the function status
+	buildLogTailLines = 10
 )
 
+// errWaitingForImage is returned while the image of a function is being built. The
+// Deployment of the function is not updated until the image exists
+var errWaitingForImage = errors.New("Waiting for the function image to be built")
+
 // FunctionController object
 type FunctionController struct {
 	logger           *logrus.Entry
@@ -61,9 +84,23 @@ type FunctionController struct {
 	Functions        map[string]*kubelessApi.Function
 	queue            workqueue.RateLimitingInterface
 	informer         cache.SharedIndexInformer
+	jobInformer      cache.SharedIndexInformer
+	deployInformer   cache.SharedIndexInformer
 	config           *corev1.ConfigMap
 	langRuntime      *langruntime.Langruntimes
 	imagePullSecrets []corev1.LocalObjectReference
+	// podCalls returns the number of requests served by a function pod
+	podCalls func(pod corev1.Pod) (float64, error)
+	// podLogs returns the last lines of the log of a container
+	podLogs  func(pod corev1.Pod, container string, previous bool) (string, error)
+	activity map[string]*functionActivity
+	mutex    sync.Mutex
+}
+
+// functionActivity contains the last number of requests observed for a function and when it changed
+type functionActivity struct {
+	calls      float64
+	lastChange time.Time
 }
 
 // Config contains k8s client of a controller
@@ -116,16 +153,62 @@ func NewFunctionController(cfg Config, smclient *monitoringv1alpha1.MonitoringV1
 	if config.Data["enable-build-step"] == "true" {
 		imagePullSecrets = append(imagePullSecrets, utils.GetSecretsAsLocalObjectReference("kubeless-registry-credentials")...)
 	}
+
+	// The rollout of a function continues when its build job finishes
+	jobListWatch := cache.NewFilteredListWatchFromClient(cfg.KubeCli.BatchV1().RESTClient(), "jobs", config.Data["functions-namespace"], func(options *metav1.ListOptions) {
+		options.LabelSelector = "created-by=kubeless,function"
+	})
+	jobInformer := cache.NewSharedIndexInformer(jobListWatch, &batchv1.Job{}, 0, cache.Indexers{})
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldJob := old.(*batchv1.Job)
+			newJob := new.(*batchv1.Job)
+			if !apiequality.Semantic.DeepEqual(oldJob.Status, newJob.Status) {
+				enqueueLabeledFunction(queue, newJob)
+			}
+		},
+	})
+
+	// Deployments deleted or modified by hand are restored
+	deployListWatch := cache.NewFilteredListWatchFromClient(cfg.KubeCli.AppsV1().RESTClient(), "deployments", config.Data["functions-namespace"], func(options *metav1.ListOptions) {
+		options.LabelSelector = "created-by=kubeless,function"
+	})
+	deployInformer := cache.NewSharedIndexInformer(deployListWatch, &appsv1.Deployment{}, 0, cache.Indexers{})
+	deployInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldDpm := old.(*appsv1.Deployment)
+			newDpm := new.(*appsv1.Deployment)
+			if oldDpm.ObjectMeta.Generation != newDpm.ObjectMeta.Generation {
+				enqueueLabeledFunction(queue, newDpm)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if dpm, ok := obj.(*appsv1.Deployment); ok {
+				enqueueLabeledFunction(queue, dpm)
+			}
+		},
+	})
+
 	return &FunctionController{
 		logger:           logrus.WithField("pkg", "function-controller"),
 		clientset:        cfg.KubeCli,
 		smclient:         smclient,
 		kubelessclient:   cfg.FunctionClient,
 		informer:         informer,
+		jobInformer:      jobInformer,
+		deployInformer:   deployInformer,
 		queue:            queue,
 		config:           config,
 		langRuntime:      lr,
 		imagePullSecrets: imagePullSecrets,
+		podCalls:         utils.GetPodCalls,
+		podLogs: func(pod corev1.Pod, container string, previous bool) (string, error) {
+			return utils.GetPodLogs(cfg.KubeCli, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, container, buildLogTailLines, previous)
+		},
+		activity: map[string]*functionActivity{},
 	}
 }
 
@@ -137,6 +220,8 @@ func (c *FunctionController) Run(stopCh <-chan struct{}) {
 	c.logger.Info("Starting Function controller")
 
 	go c.informer.Run(stopCh)
+	go c.jobInformer.Run(stopCh)
+	go c.deployInformer.Run(stopCh)
 
 	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
 		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
@@ -150,7 +235,15 @@ func (c *FunctionController) Run(stopCh <-chan struct{}) {
 
 // HasSynced is required for the cache.Controller interface.
 func (c *FunctionController) HasSynced() bool {
-	return c.informer.HasSynced()
+	return c.informer.HasSynced() && c.jobInformer.HasSynced() && c.deployInformer.HasSynced()
+}
+
+// enqueueLabeledFunction adds to the queue the function of a resource (like its build jobs
+// or its Deployments) based on the label "function" of the resource
+func enqueueLabeledFunction(queue workqueue.Interface, obj metav1.Object) {
+	if name := obj.GetLabels()["function"]; name != "" {
+		queue.Add(fmt.Sprintf("%s/%s", obj.GetNamespace(), name))
+	}
 }
 
 // LastSyncResourceVersion is required for the cache.Controller interface.
@@ -207,7 +300,8 @@ func (c *FunctionController) processItem(key string) error {
 		return nil
 	}
 
-	funcObj := obj.(*kubelessApi.Function)
+	funcObj := obj.(*kubelessApi.Function).DeepCopy()
+	prevStatus := funcObj.Status.DeepCopy()
 
 	// Function API object is marked for deletion (DeletionTimestamp != nil), so lets process the delete update
 	if funcObj.ObjectMeta.DeletionTimestamp != nil {
@@ -243,63 +337,295 @@ func (c *FunctionController) processItem(key string) error {
 		}
 	}
 
-	err = c.ensureK8sResources(funcObj)
+	// The resources are ensured every time (unless the function is idle) so the ones deleted or
+	// modified by hand are restored
+	building := false
+	idle, err := c.idleFunctionUpToDate(funcObj)
 	if err != nil {
-		c.logger.Errorf("Function can not be created/updated: %v", err)
 		return err
 	}
+	if !idle {
+		err = c.ensureK8sResources(funcObj)
+		if err == errWaitingForImage {
+			// The resources are ensured again once the build job finishes
+			building = true
+			setFunctionCondition(&funcObj.Status, kubelessApi.FunctionDeployed, corev1.ConditionUnknown, "WaitingForImage", "The rollout is held until the function image is built")
+		} else if err != nil {
+			c.logger.Errorf("Function can not be created/updated: %v", err)
+			funcObj.Status.LastError = err.Error()
+			setFunctionCondition(&funcObj.Status, kubelessApi.FunctionDeployed, corev1.ConditionFalse, "DeployFailed", err.Error())
+			funcObj.Status.Phase = functionPhase(&funcObj.Status)
+			if statusErr := c.saveFunctionStatus(funcObj, prevStatus); statusErr != nil {
+				c.logger.Errorf("Unable to update status of function %s: %v", key, statusErr)
+			}
+			return err
+		} else {
+			funcObj.Status.LastError = ""
+			funcObj.Status.ObservedGeneration = funcObj.ObjectMeta.Generation
+			setFunctionCondition(&funcObj.Status, kubelessApi.FunctionDeployed, corev1.ConditionTrue, "ResourcesCreated", "The function ConfigMap, Service and Deployment are up to date")
+		}
+	}
+
+	ready, err := c.refreshFunctionStatus(funcObj)
+	if err != nil {
+		return err
+	}
+	idleCheck, err := c.checkIdleFunction(key, funcObj, ready)
+	if err != nil {
+		c.logger.Errorf("Unable to scale function %s to zero: %v", key, err)
+	}
+	err = c.saveFunctionStatus(funcObj, prevStatus)
+	if err != nil {
+		return fmt.Errorf("Unable to update status of function %s: %v", key, err)
+	}
+	if !ready && !building {
+		// Check again later until the Deployment is available. Functions waiting for their
+		// image are processed again when the build job changes
+		c.queue.AddAfter(key, statusCheckPeriod)
+	} else if idleCheck > 0 {
+		// Check again later if the function is still receiving requests
+		c.queue.AddAfter(key, idleCheck)
+	}
 
 	c.logger.Infof("Processed change to function: %s", key)
 	return nil
 }
 
+// idleFunctionUpToDate returns true if a function is scaled to zero, its current generation has
+// been deployed and its Deployment and Service exist. The resources of an idle function are not
+// ensured since that would activate it
+func (c *FunctionController) idleFunctionUpToDate(funcObj *kubelessApi.Function) (bool, error) {
+	idle := getFunctionCondition(&funcObj.Status, kubelessApi.FunctionIdle)
+	deployed := getFunctionCondition(&funcObj.Status, kubelessApi.FunctionDeployed)
+	if idle == nil || idle.Status != corev1.ConditionTrue ||
+		deployed == nil || deployed.Status != corev1.ConditionTrue ||
+		funcObj.ObjectMeta.Generation == 0 || funcObj.Status.ObservedGeneration != funcObj.ObjectMeta.Generation {
+		return false, nil
+	}
+	ns, name := funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name
+	if _, err := c.clientset.AppsV1().Deployments(ns).Get(name, metav1.GetOptions{}); err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := c.clientset.CoreV1().Services(ns).Get(name, metav1.GetOptions{}); err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// deploymentReady returns true if the latest version of a Deployment has been rolled out and it is available
+func deploymentReady(dpm *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dpm.Spec.Replicas != nil {
+		replicas = *dpm.Spec.Replicas
+	}
+	return dpm.Status.ObservedGeneration >= dpm.ObjectMeta.Generation &&
+		dpm.Status.UpdatedReplicas >= replicas &&
+		dpm.Status.AvailableReplicas >= replicas &&
+		dpm.Status.ReadyReplicas > 0
+}
+
+// refreshFunctionStatus updates the image, the Ready condition and the phase of a function
+// based on the state of its Deployment. It returns true if the function is ready
+func (c *FunctionController) refreshFunctionStatus(funcObj *kubelessApi.Function) (bool, error) {
+	status := &funcObj.Status
+	ready := false
+	dpm, err := c.clientset.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Get(funcObj.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return false, err
+		}
+		setFunctionCondition(status, kubelessApi.FunctionReady, corev1.ConditionFalse, "DeploymentNotFound", "The function Deployment doesn't exist")
+	} else {
+		if len(dpm.Spec.Template.Spec.Containers) > 0 {
+			status.Image = dpm.Spec.Template.Spec.Containers[0].Image
+		}
+		if idle := getFunctionCondition(status, kubelessApi.FunctionIdle); idle != nil && idle.Status == corev1.ConditionTrue {
+			if dpm.Spec.Replicas != nil && *dpm.Spec.Replicas == 0 {
+				setFunctionCondition(status, kubelessApi.FunctionReady, corev1.ConditionFalse, "ScaledToZero", "The function will be activated by the next request")
+				status.Phase = functionPhase(status)
+				return true, nil
+			}
+			setFunctionCondition(status, kubelessApi.FunctionIdle, corev1.ConditionFalse, "Activated", "The function has been activated by a request")
+		}
+		replicas := fmt.Sprintf("%d/%d replicas ready", dpm.Status.ReadyReplicas, dpm.Status.Replicas)
+		ready = deploymentReady(dpm)
+		if funcObj.Spec.Canary != nil {
+			// During a canary release the function is ready once both revisions are
+			canary, err := c.clientset.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Get(utils.CanaryName(funcObj.ObjectMeta.Name), metav1.GetOptions{})
+			if err != nil {
+				if !k8sErrors.IsNotFound(err) {
+					return false, err
+				}
+				ready = false
+				replicas += ", the canary Deployment doesn't exist"
+			} else {
+				ready = ready && deploymentReady(canary)
+				replicas += fmt.Sprintf(", %d/%d canary replicas ready", canary.Status.ReadyReplicas, canary.Status.Replicas)
+			}
+		}
+		if ready {
+			setFunctionCondition(status, kubelessApi.FunctionReady, corev1.ConditionTrue, "MinimumReplicasAvailable", replicas)
+		} else {
+			setFunctionCondition(status, kubelessApi.FunctionReady, corev1.ConditionFalse, "RolloutInProgress", replicas)
+		}
+	}
+	status.Phase = functionPhase(status)
+	return ready, nil
+}
+
+// saveFunctionStatus stores the status of a function if it has changed
+func (c *FunctionController) saveFunctionStatus(funcObj *kubelessApi.Function, prevStatus *kubelessApi.FunctionStatus) error {
+	if apiequality.Semantic.DeepEqual(&funcObj.Status, prevStatus) {
+		return nil
+	}
+	return utils.UpdateFunctionStatus(c.kubelessclient, funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name, &funcObj.Status)
+}
+
+// functionPhase returns the phase of a function based on its conditions
+func functionPhase(status *kubelessApi.FunctionStatus) kubelessApi.FunctionPhase {
+	deployed := getFunctionCondition(status, kubelessApi.FunctionDeployed)
+	ready := getFunctionCondition(status, kubelessApi.FunctionReady)
+	built := getFunctionCondition(status, kubelessApi.FunctionBuilt)
+	idle := getFunctionCondition(status, kubelessApi.FunctionIdle)
+	switch {
+	case deployed != nil && deployed.Status == corev1.ConditionFalse, built != nil && built.Status == corev1.ConditionFalse:
+		return kubelessApi.FunctionPhaseFailed
+	case idle != nil && idle.Status == corev1.ConditionTrue:
+		return kubelessApi.FunctionPhaseIdle
+	case ready != nil && ready.Status == corev1.ConditionTrue:
+		return kubelessApi.FunctionPhaseReady
+	case built != nil && built.Status == corev1.ConditionUnknown && built.Reason != reasonBuildSkipped:
+		return kubelessApi.FunctionPhaseBuilding
+	case deployed != nil && deployed.Status == corev1.ConditionTrue:
+		return kubelessApi.FunctionPhaseDeploying
+	default:
+		return kubelessApi.FunctionPhasePending
+	}
+}
+
+// getFunctionCondition returns the condition of the given type or nil if it is not present
+func getFunctionCondition(status *kubelessApi.FunctionStatus, condType kubelessApi.FunctionConditionType) *kubelessApi.FunctionCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setFunctionCondition adds or updates a condition of a function. The transition time
+// is only modified if the status of the condition changes
+func setFunctionCondition(status *kubelessApi.FunctionStatus, condType kubelessApi.FunctionConditionType, condStatus corev1.ConditionStatus, reason, message string) {
+	cond := getFunctionCondition(status, condType)
+	if cond == nil {
+		status.Conditions = append(status.Conditions, kubelessApi.FunctionCondition{Type: condType})
+		cond = &status.Conditions[len(status.Conditions)-1]
+	}
+	if cond.Status != condStatus {
+		cond.LastTransitionTime = metav1.Now()
+	}
+	cond.Status = condStatus
+	cond.Reason = reason
+	cond.Message = message
+}
+
 // startImageBuildJob creates (if necessary) a job that will build an image for the given function
-// returns the name of the image, a boolean indicating if the build job has been created and an error
-func (c *FunctionController) startImageBuildJob(funcObj *kubelessApi.Function, or []metav1.OwnerReference) (string, bool, error) {
+// returns the name of the image, the name of the build job (empty if the image already exists) and an error
+func (c *FunctionController) startImageBuildJob(funcObj *kubelessApi.Function, or []metav1.OwnerReference) (string, string, error) {
 	imagePullSecret, err := c.clientset.CoreV1().Secrets(funcObj.ObjectMeta.Namespace).Get("kubeless-registry-credentials", metav1.GetOptions{})
 	if err != nil {
-		return "", false, fmt.Errorf("Unable to locate registry credentials to build function image: %v", err)
+		return "", "", fmt.Errorf("Unable to locate registry credentials to build function image: %v", err)
 	}
 	reg, err := registry.New(*imagePullSecret)
 	if err != nil {
-		return "", false, fmt.Errorf("Unable to retrieve registry information: %v", err)
+		return "", "", fmt.Errorf("Unable to retrieve registry information: %v", err)
 	}
+	tlsVerify := true
+	if c.config.Data["function-registry-tls-verify"] == "false" {
+		tlsVerify = false
+	}
+	reg.Insecure = !tlsVerify
 	// Use function content and deps as tag (digested)
 	tag := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%v%v", funcObj.Spec.Function, funcObj.Spec.Deps))))
-	imageName := fmt.Sprintf("%s/%s", reg.Creds.Username, funcObj.ObjectMeta.Name)
+	imageName, err := reg.ImageName(funcObj.ObjectMeta.Name)
+	if err != nil {
+		return "", "", err
+	}
 	// Check if image already exists
 	exists, err := reg.ImageExists(imageName, tag)
 	if err != nil {
-		return "", false, fmt.Errorf("Unable to check is target image exists: %v", err)
+		return "", "", fmt.Errorf("Unable to check is target image exists: %v", err)
 	}
 	regURL, err := url.Parse(reg.Endpoint)
 	if err != nil {
-		return "", false, fmt.Errorf("Unable to parse registry URL: %v", err)
+		return "", "", fmt.Errorf("Unable to parse registry URL: %v", err)
 	}
 	image := fmt.Sprintf("%s/%s:%s", regURL.Host, imageName, tag)
 	if !exists {
-		tlsVerify := true
-		if c.config.Data["function-registry-tls-verify"] == "false" {
-			tlsVerify = false
-		}
-		err = utils.EnsureFuncImage(c.clientset, funcObj, c.langRuntime, or, imageName, tag, c.config.Data["builder-image"], regURL.Host, imagePullSecret.Name, c.config.Data["provision-image"], tlsVerify, c.imagePullSecrets)
+		err = utils.EnsureFuncImage(c.clientset, funcObj, c.langRuntime, or, imageName, tag, c.config.Data["builder-image"], regURL.Host, imagePullSecret.Name, c.config.Data["provision-image"], c.config.Data["artifact-server-url"], tlsVerify, c.imagePullSecrets)
 		if err != nil {
-			return "", false, fmt.Errorf("Unable to create image build job: %v", err)
+			return "", "", fmt.Errorf("Unable to create image build job: %v", err)
 		}
 	} else {
 		// Image already exists
-		return image, false, nil
+		return image, "", nil
 	}
-	return image, true, nil
+	return image, utils.BuildJobName(funcObj.ObjectMeta.Name, tag), nil
 }
 
-// ensureK8sResources creates/updates k8s objects (deploy, svc, configmap) for the function
-func (c *FunctionController) ensureK8sResources(funcObj *kubelessApi.Function) error {
-	if len(funcObj.ObjectMeta.Labels) == 0 {
-		funcObj.ObjectMeta.Labels = make(map[string]string)
+// buildJobStatus returns the Built condition of a function based on the state of its build job.
+// If the job or one of its pods has failed the message includes the failed container and the
+// last lines of its log
+func (c *FunctionController) buildJobStatus(ns, jobName, image string) (corev1.ConditionStatus, string, string, error) {
+	job, err := c.clientset.BatchV1().Jobs(ns).Get(jobName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", "", fmt.Errorf("Unable to get the build job %s: %v", jobName, err)
 	}
-	funcObj.ObjectMeta.Labels["function"] = funcObj.ObjectMeta.Name
+	if job.Status.Succeeded > 0 {
+		return corev1.ConditionTrue, "BuildSucceeded", fmt.Sprintf("Image %s has been built", image), nil
+	}
+	jobFailure := ""
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			jobFailure = fmt.Sprintf("Build job %s failed: %s", jobName, cond.Message)
+		}
+	}
+	message := fmt.Sprintf("Building image %s with job %s", image, jobName)
+	pods, err := utils.GetBuildJobPods(c.clientset, ns, jobName)
+	if err != nil {
+		return "", "", "", fmt.Errorf("Unable to get the pods of the build job %s: %v", jobName, err)
+	}
+	for _, pod := range pods {
+		failure := utils.GetBuildFailure(&pod)
+		if failure == nil {
+			continue
+		}
+		message = fmt.Sprintf("Build pod %s: %s", pod.ObjectMeta.Name, failure)
+		if logs, err := c.podLogs(pod, failure.Container, failure.Previous); err != nil {
+			logrus.Warnf("Unable to get the logs of the build pod %s: %v", pod.ObjectMeta.Name, err)
+		} else if logs = strings.TrimSpace(logs); logs != "" {
+			message = fmt.Sprintf("%s\n%s", message, logs)
+		}
+		if jobFailure != "" {
+			return corev1.ConditionFalse, "BuildFailed", fmt.Sprintf("%s. %s", jobFailure, message), nil
+		}
+		// The job retries the failed pods until its backoff limit is reached
+		return corev1.ConditionUnknown, "BuildRetrying", message, nil
+	}
+	if jobFailure != "" {
+		return corev1.ConditionFalse, "BuildFailed", jobFailure, nil
+	}
+	return corev1.ConditionUnknown, "BuildInProgress", message, nil
+}
 
+// mergeDeploymentConfig merges the default Deployment of the controller configuration into the given one
+func (c *FunctionController) mergeDeploymentConfig(dpm *v1beta1.Deployment) error {
 	deployment := v1beta1.Deployment{}
 	if deploymentConfigData, ok := c.config.Data["deployment"]; ok {
 		err := yaml.Unmarshal([]byte(deploymentConfigData), &deployment)
@@ -307,18 +633,113 @@ func (c *FunctionController) ensureK8sResources(funcObj *kubelessApi.Function) e
 			logrus.Errorf("Error parsing Deployment data in ConfigMap kubeless-function-deployment-config: %v", err)
 			return err
 		}
-		err = utils.MergeDeployments(&funcObj.Spec.Deployment, &deployment)
+		err = utils.MergeDeployments(dpm, &deployment)
 		if err != nil {
 			logrus.Errorf(" Error while merging function.Spec.Deployment and Deployment from ConfigMap: %v", err)
 			return err
 		}
 	}
+	if collector := c.config.Data["tracing-collector"]; collector != "" {
+		addTracingCollector(dpm, collector)
+	}
+	return nil
+}
+
+// addTracingCollector sets the collector of the spans of the function unless it is already
+// defined in its environment
+func addTracingCollector(dpm *v1beta1.Deployment, collector string) {
+	if len(dpm.Spec.Template.Spec.Containers) == 0 {
+		dpm.Spec.Template.Spec.Containers = []corev1.Container{{}}
+	}
+	container := &dpm.Spec.Template.Spec.Containers[0]
+	for _, env := range container.Env {
+		if env.Name == tracing.CollectorEnv {
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: tracing.CollectorEnv, Value: collector})
+}
+
+// functionImage returns the prebuilt image of the function (if any). If the build step is enabled
+// it starts a build job for the function and returns the image that the job pushes. While the job
+// is running it returns errWaitingForImage and, if the job fails, an error with the reason
+func (c *FunctionController) functionImage(funcObj *kubelessApi.Function, or []metav1.OwnerReference) (string, error) {
+	prebuiltImage := ""
+	if len(funcObj.Spec.Deployment.Spec.Template.Spec.Containers) > 0 && funcObj.Spec.Deployment.Spec.Template.Spec.Containers[0].Image != "" {
+		prebuiltImage = funcObj.Spec.Deployment.Spec.Template.Spec.Containers[0].Image
+	}
+	// Skip image build step if using a custom runtime
+	if prebuiltImage != "" {
+		logrus.Infof("Skipping image-build step for %s", funcObj.ObjectMeta.Name)
+		return prebuiltImage, nil
+	}
+	if c.config.Data["enable-build-step"] != "true" {
+		return "", nil
+	}
+	image, jobName, err := c.startImageBuildJob(funcObj, or)
+	if err != nil {
+		// The function is deployed without building its image so the build is not reported as failed
+		logrus.Errorf("Unable to build function: %v", err)
+		setFunctionCondition(&funcObj.Status, kubelessApi.FunctionBuilt, corev1.ConditionUnknown, reasonBuildSkipped, fmt.Sprintf("The function is deployed without building its image: %v", err))
+		return "", nil
+	}
+	if jobName == "" {
+		logrus.Infof("Found existing image %s", image)
+		setFunctionCondition(&funcObj.Status, kubelessApi.FunctionBuilt, corev1.ConditionTrue, "ImageFound", fmt.Sprintf("Found existing image %s", image))
+		return image, nil
+	}
+	status, reason, message, err := c.buildJobStatus(funcObj.ObjectMeta.Namespace, jobName, image)
+	if err != nil {
+		return "", err
+	}
+	setFunctionCondition(&funcObj.Status, kubelessApi.FunctionBuilt, status, reason, message)
+	switch status {
+	case corev1.ConditionTrue:
+		logrus.Infof("Function %s image %s has been built", funcObj.ObjectMeta.Name, image)
+		return image, nil
+	case corev1.ConditionFalse:
+		// The failed job is replaced after a backoff, see utils.EnsureFuncImage
+		if job, err := c.clientset.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Get(jobName, metav1.GetOptions{}); err == nil {
+			if retryAt, failed := utils.BuildRetryTime(job); failed {
+				c.queue.AddAfter(fmt.Sprintf("%s/%s", funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name), time.Until(retryAt))
+			}
+		}
+		return "", fmt.Errorf("Unable to build image %s: %s", image, message)
+	default:
+		logrus.Infof("Waiting for build job %s of function %s", jobName, funcObj.ObjectMeta.Name)
+		return "", errWaitingForImage
+	}
+}
+
+// ensureK8sResources creates/updates k8s objects (deploy, svc, configmap) for the function
+func (c *FunctionController) ensureK8sResources(funcObj *kubelessApi.Function) error {
+	if len(funcObj.ObjectMeta.Labels) == 0 {
+		funcObj.ObjectMeta.Labels = make(map[string]string)
+	}
+	funcObj.ObjectMeta.Labels["function"] = funcObj.ObjectMeta.Name
+	// Keep the spec as defined by the user to record it as a revision
+	spec := funcObj.Spec.DeepCopy()
+
+	err := c.wakeUpFunction(funcObj)
+	if err != nil {
+		return err
+	}
+
+	err = c.mergeDeploymentConfig(&funcObj.Spec.Deployment)
+	if err != nil {
+		return err
+	}
 
 	or, err := utils.GetOwnerReference(funcKind, funcAPIVersion, funcObj.Name, funcObj.UID)
 	if err != nil {
 		return err
 	}
 
+	canaryReplicas, err := c.splitFunctionReplicas(funcObj)
+	if err != nil {
+		return err
+	}
+
 	err = utils.EnsureFuncConfigMap(c.clientset, funcObj, or, c.langRuntime)
 	if err != nil {
 		return err
@@ -329,30 +750,26 @@ func (c *FunctionController) ensureK8sResources(funcObj *kubelessApi.Function) e
 		return err
 	}
 
-	prebuiltImage := ""
-	if len(funcObj.Spec.Deployment.Spec.Template.Spec.Containers) > 0 && funcObj.Spec.Deployment.Spec.Template.Spec.Containers[0].Image != "" {
-		prebuiltImage = funcObj.Spec.Deployment.Spec.Template.Spec.Containers[0].Image
+	err = utils.EnsureFuncQueueClaim(c.clientset, funcObj, or)
+	if err != nil {
+		return err
 	}
-	// Skip image build step if using a custom runtime
-	if prebuiltImage == "" {
-		if c.config.Data["enable-build-step"] == "true" {
-			var isBuilding bool
-			prebuiltImage, isBuilding, err = c.startImageBuildJob(funcObj, or)
-			if err != nil {
-				logrus.Errorf("Unable to build function: %v", err)
-			} else {
-				if isBuilding {
-					logrus.Infof("Started build process for function %s", funcObj.ObjectMeta.Name)
-				} else {
-					logrus.Infof("Found existing image %s", prebuiltImage)
-				}
-			}
-		}
-	} else {
-		logrus.Infof("Skipping image-build step for %s", funcObj.ObjectMeta.Name)
+
+	prebuiltImage, err := c.functionImage(funcObj, or)
+	if err != nil {
+		return err
 	}
 
-	err = utils.EnsureFuncDeployment(c.clientset, funcObj, or, c.langRuntime, prebuiltImage, c.config.Data["provision-image"], c.imagePullSecrets)
+	err = utils.EnsureFuncDeployment(c.clientset, funcObj, or, c.langRuntime, prebuiltImage, c.config.Data["provision-image"], c.config.Data["artifact-server-url"], c.imagePullSecrets)
+	if err != nil {
+		return err
+	}
+
+	if funcObj.Spec.Canary != nil {
+		err = c.ensureCanary(funcObj, or, canaryReplicas)
+	} else {
+		err = c.deleteCanary(funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name)
+	}
 	if err != nil {
 		return err
 	}
@@ -377,6 +794,222 @@ func (c *FunctionController) ensureK8sResources(funcObj *kubelessApi.Function) e
 			return err
 		}
 	}
+
+	revision, err := utils.EnsureFuncRevision(c.clientset, funcObj, spec, or, c.revisionHistoryLimit())
+	if err != nil {
+		return fmt.Errorf("Unable to record function revision: %v", err)
+	}
+	funcObj.Status.Revision = revision
+	return nil
+}
+
+// revisionHistoryLimit returns the maximum number of revisions to keep for each function
+func (c *FunctionController) revisionHistoryLimit() int {
+	if limit, ok := c.config.Data["revision-history-limit"]; ok {
+		l, err := strconv.Atoi(limit)
+		if err == nil && l > 0 {
+			return l
+		}
+		logrus.Warnf("Invalid revision-history-limit %q, using %d", limit, defaultRevisionHistoryLimit)
+	}
+	return defaultRevisionHistoryLimit
+}
+
+// splitFunctionReplicas sets the replicas of the stable Deployment of a function that is being
+// rolled out and returns the replicas that the canary Deployment should have. Since both
+// Deployments are behind the same service the traffic is split in proportion to their pods.
+// Once the rollout finishes the stable Deployment recovers its original replicas
+func (c *FunctionController) splitFunctionReplicas(funcObj *kubelessApi.Function) (int32, error) {
+	total := utils.FunctionReplicas(&funcObj.Spec)
+	if funcObj.Spec.Canary != nil {
+		if err := utils.ValidateCanary(&funcObj.Spec); err != nil {
+			return 0, err
+		}
+		stable, canary, err := utils.SplitReplicas(total, funcObj.Spec.Canary.Weight)
+		if err != nil {
+			return 0, err
+		}
+		funcObj.Spec.Deployment.Spec.Replicas = &stable
+		return canary, nil
+	}
+	_, err := c.clientset.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Get(utils.CanaryName(funcObj.ObjectMeta.Name), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	funcObj.Spec.Deployment.Spec.Replicas = &total
+	return 0, nil
+}
+
+// ensureCanary creates/updates the ConfigMap and the Deployment of the canary revision of a function
+func (c *FunctionController) ensureCanary(funcObj *kubelessApi.Function, or []metav1.OwnerReference, replicas int32) error {
+	canary := utils.CanaryFunction(funcObj)
+	err := c.mergeDeploymentConfig(&canary.Spec.Deployment)
+	if err != nil {
+		return err
+	}
+	canary.Spec.Deployment.Spec.Replicas = &replicas
+
+	err = utils.EnsureFuncConfigMap(c.clientset, canary, or, c.langRuntime)
+	if err != nil {
+		return fmt.Errorf("Unable to deploy canary: %v", err)
+	}
+
+	prebuiltImage, err := c.functionImage(canary, or)
+	if err != nil {
+		if err == errWaitingForImage {
+			return err
+		}
+		return fmt.Errorf("Unable to deploy canary: %v", err)
+	}
+
+	err = utils.EnsureFuncDeployment(c.clientset, canary, or, c.langRuntime, prebuiltImage, c.config.Data["provision-image"], c.config.Data["artifact-server-url"], c.imagePullSecrets)
+	if err != nil {
+		return fmt.Errorf("Unable to deploy canary: %v", err)
+	}
+	return nil
+}
+
+// wakeUpFunction scales up a function that has been scaled to zero so its changes are deployed
+func (c *FunctionController) wakeUpFunction(funcObj *kubelessApi.Function) error {
+	svc, err := c.clientset.CoreV1().Services(funcObj.ObjectMeta.Namespace).Get(funcObj.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !utils.IsFuncServiceIdle(svc) {
+		return nil
+	}
+	err = utils.WakeUpFunc(c.clientset, funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name)
+	if err != nil {
+		return fmt.Errorf("Unable to activate function: %v", err)
+	}
+	setFunctionCondition(&funcObj.Status, kubelessApi.FunctionIdle, corev1.ConditionFalse, "Activated", "The function has been activated to deploy its changes")
+	return nil
+}
+
+// checkIdleFunction scales a function to zero if it has not received any request during its idle timeout.
+// It returns the time to wait before checking the function again (zero if it doesn't need to be checked)
+func (c *FunctionController) checkIdleFunction(key string, funcObj *kubelessApi.Function, ready bool) (time.Duration, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if funcObj.Spec.IdleTimeout == "" {
+		delete(c.activity, key)
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(funcObj.Spec.IdleTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid idle timeout %q", funcObj.Spec.IdleTimeout)
+	}
+	if timeout <= 0 {
+		// Scaling to zero is disabled
+		delete(c.activity, key)
+		return 0, nil
+	}
+	period := idleCheckPeriod
+	if timeout < period {
+		period = timeout
+	}
+	if idle := getFunctionCondition(&funcObj.Status, kubelessApi.FunctionIdle); idle != nil && idle.Status == corev1.ConditionTrue {
+		// Keep checking the function to notice when it is activated
+		return period, nil
+	}
+	if !ready || funcObj.Spec.Canary != nil {
+		delete(c.activity, key)
+		return period, nil
+	}
+
+	calls, err := c.functionCalls(funcObj)
+	if err != nil {
+		// Without metrics it is not possible to know if the function is idle
+		return period, err
+	}
+	now := time.Now()
+	activity, ok := c.activity[key]
+	if !ok || activity.calls != calls {
+		c.activity[key] = &functionActivity{calls: calls, lastChange: now}
+		return period, nil
+	}
+	if now.Sub(activity.lastChange) < timeout {
+		return period, nil
+	}
+
+	c.logger.Infof("Function %s has not received requests in %s, scaling it to zero", key, timeout)
+	err = c.scaleToZero(funcObj)
+	if err != nil {
+		return period, err
+	}
+	delete(c.activity, key)
+	setFunctionCondition(&funcObj.Status, kubelessApi.FunctionIdle, corev1.ConditionTrue, "IdleTimeout", fmt.Sprintf("No requests received in %s", timeout))
+	setFunctionCondition(&funcObj.Status, kubelessApi.FunctionReady, corev1.ConditionFalse, "ScaledToZero", "The function will be activated by the next request")
+	funcObj.Status.Phase = functionPhase(&funcObj.Status)
+	return period, nil
+}
+
+// functionCalls returns the number of requests served by the ready pods of a function
+func (c *FunctionController) functionCalls(funcObj *kubelessApi.Function) (float64, error) {
+	pods, err := c.clientset.CoreV1().Pods(funcObj.ObjectMeta.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("function=%s,track!=canary", funcObj.ObjectMeta.Name),
+	})
+	if err != nil {
+		return 0, err
+	}
+	total := float64(0)
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP == "" || !utils.IsPodReady(&pod) {
+			continue
+		}
+		calls, err := c.podCalls(pod)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to get the metrics of %s: %v", pod.ObjectMeta.Name, err)
+		}
+		total += calls
+	}
+	return total, nil
+}
+
+// scaleToZero routes the service of a function to the activator and removes its pods
+func (c *FunctionController) scaleToZero(funcObj *kubelessApi.Function) error {
+	activatorService := c.config.Data["activator-service"]
+	if activatorService == "" {
+		activatorService = defaultActivatorService
+	}
+	endpoints, err := c.clientset.CoreV1().Endpoints(c.config.ObjectMeta.Namespace).Get(activatorService, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Unable to find the activator: %v", err)
+	}
+	ips := []string{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("The activator is not available")
+	}
+	port, err := utils.AllocateActivatorPort(c.clientset)
+	if err != nil {
+		return err
+	}
+	return utils.ScaleFuncToZero(c.clientset, funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name, ips, port)
+}
+
+// deleteCanary removes the canary revision of a function (if any)
+func (c *FunctionController) deleteCanary(ns, name string) error {
+	canaryName := utils.CanaryName(name)
+	deletePolicy := metav1.DeletePropagationBackground
+	err := c.clientset.AppsV1().Deployments(ns).Delete(canaryName, &metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	err = c.clientset.CoreV1().ConfigMaps(ns).Delete(canaryName, &metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
 
@@ -401,7 +1034,7 @@ func (c *FunctionController) deleteK8sResources(ns, name string) error {
 
 	// delete deployment
 	deletePolicy := metav1.DeletePropagationBackground
-	err := c.clientset.Extensions().Deployments(ns).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	err := c.clientset.AppsV1().Deployments(ns).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
 	if err != nil && !k8sErrors.IsNotFound(err) {
 		return err
 	}
@@ -417,12 +1050,26 @@ func (c *FunctionController) deleteK8sResources(ns, name string) error {
 		return err
 	}
 
+	// delete canary revision
+	err = c.deleteCanary(ns, name)
+	if err != nil {
+		return err
+	}
+
 	// delete service monitor
 	err = c.deleteAutoscale(ns, name)
 	if err != nil && !k8sErrors.IsNotFound(err) {
 		return err
 	}
 
+	// delete revisions
+	err = c.clientset.AppsV1().ControllerRevisions(ns).DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("created-by=kubeless,function=%s", name),
+	})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
 	// delete build job
 	err = c.clientset.BatchV1().Jobs(ns).DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("created-by=kubeless,function=%s", name),
@@ -443,24 +1090,9 @@ func functionObjChanged(oldFunctionObj, newFunctionObj *kubelessApi.Function) bo
 	if oldFunctionObj.ResourceVersion == newFunctionObj.ResourceVersion {
 		return false
 	}
-	newSpec := &oldFunctionObj.Spec
-	oldSpec := &newFunctionObj.Spec
-
-	if newSpec.Function != oldSpec.Function ||
-		// compare checksum since the url content type uses Function field to pass the URL for the function
-		// comparing the checksum ensures that if the function code has changed but the URL remains the same, the function will get redeployed
-		newSpec.Checksum != oldSpec.Checksum ||
-		newSpec.Handler != oldSpec.Handler ||
-		newSpec.FunctionContentType != oldSpec.FunctionContentType ||
-		newSpec.Deps != oldSpec.Deps ||
-		newSpec.Timeout != oldSpec.Timeout {
-		return true
-	}
-
-	if !apiequality.Semantic.DeepEqual(newSpec.Deployment, oldSpec.Deployment) ||
-		!apiequality.Semantic.DeepEqual(newSpec.HorizontalPodAutoscaler, oldSpec.HorizontalPodAutoscaler) ||
-		!apiequality.Semantic.DeepEqual(newSpec.ServiceSpec, oldSpec.ServiceSpec) {
-		return true
-	}
-	return false
+	// The whole spec is compared so new fields don't need to be listed here. The generation can't
+	// be used instead since it also changes with the status when the API server doesn't serve the
+	// status subresource. The labels are copied to the Deployment, the Service and the pods.
+	return !apiequality.Semantic.DeepEqual(oldFunctionObj.Spec, newFunctionObj.Spec) ||
+		!apiequality.Semantic.DeepEqual(oldFunctionObj.Labels, newFunctionObj.Labels)
 }
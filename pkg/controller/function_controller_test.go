@@ -1,20 +1,30 @@
 package controller
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	kFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
+	kv1beta1 "github.com/kubeless/kubeless/pkg/client/informers/externalversions/kubeless/v1beta1"
 	"github.com/kubeless/kubeless/pkg/langruntime"
 	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/autoscaling/v2beta1"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
-	xv1beta1 "k8s.io/api/extensions/v1beta1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func findAction(fake *fake.Clientset, verb, resource string) ktesting.Action {
@@ -36,7 +46,7 @@ func TestDeleteK8sResources(t *testing.T) {
 		Name:      "foo",
 	}
 
-	deploy := xv1beta1.Deployment{
+	deploy := appsv1.Deployment{
 		ObjectMeta: myNsFoo,
 	}
 
@@ -233,7 +243,7 @@ func TestEnsureK8sResourcesWithDeploymentDefinitionFromConfigMap(t *testing.T) {
 	if err := controller.ensureK8sResources(&funcObj); err != nil {
 		t.Fatalf("Creating/Updating resources returned err: %v", err)
 	}
-	dpm, _ := clientset.ExtensionsV1beta1().Deployments(namespace).Get(funcName, metav1.GetOptions{})
+	dpm, _ := clientset.AppsV1().Deployments(namespace).Get(funcName, metav1.GetOptions{})
 	expectedAnnotations := map[string]string{
 		"bar":                "foo",
 		"foo-from-deploy-cm": "bar-from-deploy-cm",
@@ -260,6 +270,34 @@ func TestEnsureK8sResourcesWithDeploymentDefinitionFromConfigMap(t *testing.T) {
 	}
 }
 
+func TestMergeDeploymentConfigWithTracingCollector(t *testing.T) {
+	controller := FunctionController{
+		logger: logrus.WithField("pkg", "controller"),
+		config: &v1.ConfigMap{
+			Data: map[string]string{"tracing-collector": "http://zipkin:9411/api/v2/spans"},
+		},
+	}
+	dpm := v1beta1.Deployment{}
+	if err := controller.mergeDeploymentConfig(&dpm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env := dpm.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "TRACING_COLLECTOR" || env[0].Value != "http://zipkin:9411/api/v2/spans" {
+		t.Errorf("Unexpected environment %v", env)
+	}
+
+	// The collector of a function is not overwritten
+	dpm = v1beta1.Deployment{}
+	dpm.Spec.Template.Spec.Containers = []v1.Container{{Env: []v1.EnvVar{{Name: "TRACING_COLLECTOR", Value: "http://other"}}}}
+	if err := controller.mergeDeploymentConfig(&dpm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env = dpm.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Value != "http://other" {
+		t.Errorf("Unexpected environment %v", env)
+	}
+}
+
 func TestEnsureK8sResourcesWithLivenessProbeFromConfigMap(t *testing.T) {
 	namespace := "default"
 	funcName := "foo"
@@ -368,7 +406,7 @@ func TestEnsureK8sResourcesWithLivenessProbeFromConfigMap(t *testing.T) {
 	if err := controller.ensureK8sResources(&funcObj); err != nil {
 		t.Fatalf("Creating/Updating resources returned err: %v", err)
 	}
-	dpm, _ := clientset.ExtensionsV1beta1().Deployments(namespace).Get(funcName, metav1.GetOptions{})
+	dpm, _ := clientset.AppsV1().Deployments(namespace).Get(funcName, metav1.GetOptions{})
 	expectedLivenessProbe := &v1.Probe{
 		InitialDelaySeconds: int32(5),
 		PeriodSeconds:       int32(10),
@@ -384,3 +422,756 @@ func TestEnsureK8sResourcesWithLivenessProbeFromConfigMap(t *testing.T) {
 	}
 
 }
+
+func TestFunctionPhase(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []kubelessApi.FunctionCondition
+		expected   kubelessApi.FunctionPhase
+	}{
+		{"no conditions", nil, kubelessApi.FunctionPhasePending},
+		{"deploy failed", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionFalse},
+		}, kubelessApi.FunctionPhaseFailed},
+		{"building", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionBuilt, Status: v1.ConditionUnknown},
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+		}, kubelessApi.FunctionPhaseBuilding},
+		{"build failed", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionBuilt, Status: v1.ConditionFalse},
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionFalse},
+		}, kubelessApi.FunctionPhaseFailed},
+		{"waiting for the image", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionBuilt, Status: v1.ConditionUnknown},
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionUnknown},
+		}, kubelessApi.FunctionPhaseBuilding},
+		{"build skipped", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionBuilt, Status: v1.ConditionUnknown, Reason: reasonBuildSkipped},
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			{Type: kubelessApi.FunctionReady, Status: v1.ConditionFalse},
+		}, kubelessApi.FunctionPhaseDeploying},
+		{"ready without building", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionBuilt, Status: v1.ConditionUnknown, Reason: reasonBuildSkipped},
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			{Type: kubelessApi.FunctionReady, Status: v1.ConditionTrue},
+		}, kubelessApi.FunctionPhaseReady},
+		{"deploying", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			{Type: kubelessApi.FunctionReady, Status: v1.ConditionFalse},
+		}, kubelessApi.FunctionPhaseDeploying},
+		{"ready", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			{Type: kubelessApi.FunctionReady, Status: v1.ConditionTrue},
+		}, kubelessApi.FunctionPhaseReady},
+		{"idle", []kubelessApi.FunctionCondition{
+			{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			{Type: kubelessApi.FunctionReady, Status: v1.ConditionFalse},
+			{Type: kubelessApi.FunctionIdle, Status: v1.ConditionTrue},
+		}, kubelessApi.FunctionPhaseIdle},
+	}
+	for _, tt := range tests {
+		phase := functionPhase(&kubelessApi.FunctionStatus{Conditions: tt.conditions})
+		if phase != tt.expected {
+			t.Errorf("%s: expecting phase %s, received %s", tt.name, tt.expected, phase)
+		}
+	}
+}
+
+func TestEnqueueLabeledFunction(t *testing.T) {
+	queue := workqueue.New()
+	defer queue.ShutDown()
+	enqueueLabeledFunction(queue, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "other"}})
+	enqueueLabeledFunction(queue, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "myns",
+		Name:      "build-foo-0123456789",
+		Labels:    map[string]string{"function": "foo"},
+	}})
+	if queue.Len() != 1 {
+		t.Fatalf("Expecting only the function of the build job, received %d items", queue.Len())
+	}
+	if key, _ := queue.Get(); key != "myns/foo" {
+		t.Errorf("Expecting myns/foo, received %v", key)
+	}
+}
+
+func TestFunctionObjChanged(t *testing.T) {
+	oldFunc := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns", ResourceVersion: "1"},
+		Spec:       kubelessApi.FunctionSpec{Handler: "foo.bar", Runtime: "ruby2.4"},
+	}
+	tests := []struct {
+		name     string
+		update   func(f *kubelessApi.Function)
+		expected bool
+	}{
+		{
+			name:     "status",
+			update:   func(f *kubelessApi.Function) { f.Status.Phase = kubelessApi.FunctionPhaseReady },
+			expected: false,
+		},
+		{
+			name: "async queue",
+			update: func(f *kubelessApi.Function) {
+				f.Spec.AsyncQueue = &kubelessApi.FunctionQueue{Storage: kubelessApi.QueueStorageVolume}
+			},
+			expected: true,
+		},
+		{
+			name:     "protocol",
+			update:   func(f *kubelessApi.Function) { f.Spec.Protocol = kubelessApi.ProtocolGRPC },
+			expected: true,
+		},
+		{
+			name: "payload",
+			update: func(f *kubelessApi.Function) {
+				f.Spec.Payload = &kubelessApi.FunctionPayload{RequestSchema: &runtime.RawExtension{Raw: []byte(`{"type":"object"}`)}}
+			},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		newFunc := oldFunc.DeepCopy()
+		newFunc.ResourceVersion = "2"
+		tt.update(newFunc)
+		if changed := functionObjChanged(oldFunc, newFunc); changed != tt.expected {
+			t.Errorf("%s: expecting the change to be %v, received %v", tt.name, tt.expected, changed)
+		}
+	}
+}
+
+func TestSetFunctionCondition(t *testing.T) {
+	status := kubelessApi.FunctionStatus{}
+	setFunctionCondition(&status, kubelessApi.FunctionReady, v1.ConditionFalse, "RolloutInProgress", "0/1 replicas ready")
+	if len(status.Conditions) != 1 {
+		t.Fatalf("Expecting one condition, received %d", len(status.Conditions))
+	}
+	transitionTime := status.Conditions[0].LastTransitionTime
+	if transitionTime.IsZero() {
+		t.Errorf("Expecting the transition time to be set")
+	}
+	setFunctionCondition(&status, kubelessApi.FunctionReady, v1.ConditionFalse, "RolloutInProgress", "0/2 replicas ready")
+	if len(status.Conditions) != 1 {
+		t.Fatalf("Expecting the condition to be updated, received %d conditions", len(status.Conditions))
+	}
+	if status.Conditions[0].Message != "0/2 replicas ready" {
+		t.Errorf("Unexpected message %s", status.Conditions[0].Message)
+	}
+	if !status.Conditions[0].LastTransitionTime.Equal(&transitionTime) {
+		t.Errorf("The transition time should not change if the status doesn't change")
+	}
+}
+
+func TestRefreshFunctionStatus(t *testing.T) {
+	replicas := int32(1)
+	deploy := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "foo",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Image: "foo-image"}},
+				},
+			},
+		},
+	}
+	funcObj := kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "foo",
+		},
+		Status: kubelessApi.FunctionStatus{
+			Conditions: []kubelessApi.FunctionCondition{
+				{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+			},
+		},
+	}
+
+	controller := FunctionController{
+		clientset: fake.NewSimpleClientset(&deploy),
+	}
+	ready, err := controller.refreshFunctionStatus(&funcObj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ready {
+		t.Errorf("The function should not be ready")
+	}
+	if funcObj.Status.Phase != kubelessApi.FunctionPhaseDeploying {
+		t.Errorf("Expecting phase %s, received %s", kubelessApi.FunctionPhaseDeploying, funcObj.Status.Phase)
+	}
+	if funcObj.Status.Image != "foo-image" {
+		t.Errorf("Expecting image foo-image, received %s", funcObj.Status.Image)
+	}
+
+	deploy.Status = appsv1.DeploymentStatus{
+		Replicas:          1,
+		UpdatedReplicas:   1,
+		ReadyReplicas:     1,
+		AvailableReplicas: 1,
+	}
+	controller = FunctionController{
+		clientset: fake.NewSimpleClientset(&deploy),
+	}
+	ready, err = controller.refreshFunctionStatus(&funcObj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ready {
+		t.Errorf("The function should be ready")
+	}
+	if funcObj.Status.Phase != kubelessApi.FunctionPhaseReady {
+		t.Errorf("Expecting phase %s, received %s", kubelessApi.FunctionPhaseReady, funcObj.Status.Phase)
+	}
+
+	// During a canary release the canary Deployment should be ready too
+	funcObj.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 50}
+	canary := deploy.DeepCopy()
+	canary.ObjectMeta.Name = "foo-canary"
+	canary.Status = appsv1.DeploymentStatus{Replicas: 1}
+	controller = FunctionController{
+		clientset: fake.NewSimpleClientset(&deploy, canary),
+	}
+	ready, err = controller.refreshFunctionStatus(&funcObj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cond := getFunctionCondition(&funcObj.Status, kubelessApi.FunctionReady)
+	if ready || cond.Status != v1.ConditionFalse || cond.Message != "1/1 replicas ready, 0/1 canary replicas ready" {
+		t.Errorf("The function should wait for the canary, received %+v", cond)
+	}
+	canary.Status = deploy.Status
+	controller = FunctionController{
+		clientset: fake.NewSimpleClientset(&deploy, canary),
+	}
+	if ready, err = controller.refreshFunctionStatus(&funcObj); err != nil || !ready {
+		t.Errorf("The function should be ready: %v", err)
+	}
+}
+
+func TestBuildJobStatus(t *testing.T) {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "build-foo-0123456789"},
+	}
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "build-foo-0123456789-abcde",
+			Labels:    map[string]string{"job-name": job.ObjectMeta.Name},
+		},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "prepare"}, {Name: "install"}},
+			Containers:     []v1.Container{{Name: "build"}},
+		},
+	}
+	failedInstall := []v1.ContainerStatus{
+		{Name: "prepare", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}}},
+		{
+			Name:                 "install",
+			State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}},
+			LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}},
+		},
+	}
+	jobFailed := []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: v1.ConditionTrue, Message: "Job has reached the specified backoff limit"},
+	}
+	tests := []struct {
+		name       string
+		jobStatus  batchv1.JobStatus
+		podStatus  []v1.ContainerStatus
+		status     v1.ConditionStatus
+		reason     string
+		message    string
+		logsCalled bool
+	}{
+		{"succeeded", batchv1.JobStatus{Succeeded: 1}, nil, v1.ConditionTrue, "BuildSucceeded", "Image foo:1 has been built", false},
+		{"in progress", batchv1.JobStatus{Active: 1}, nil, v1.ConditionUnknown, "BuildInProgress", "Building image foo:1 with job build-foo-0123456789", false},
+		{
+			"retrying", batchv1.JobStatus{Active: 1}, failedInstall, v1.ConditionUnknown, "BuildRetrying",
+			"Build pod build-foo-0123456789-abcde: container install failed: exit code 1 (Error)\nCollecting foo\nERROR: No matching distribution found", true,
+		},
+		{
+			"failed", batchv1.JobStatus{Failed: 6, Conditions: jobFailed}, failedInstall, v1.ConditionFalse, "BuildFailed",
+			"Build job build-foo-0123456789 failed: Job has reached the specified backoff limit. Build pod build-foo-0123456789-abcde: container install failed: exit code 1 (Error)\nCollecting foo\nERROR: No matching distribution found", true,
+		},
+		{
+			"failed without pods", batchv1.JobStatus{Failed: 1, Conditions: jobFailed}, nil, v1.ConditionFalse, "BuildFailed",
+			"Build job build-foo-0123456789 failed: Job has reached the specified backoff limit", false,
+		},
+	}
+	for _, tt := range tests {
+		job.Status = tt.jobStatus
+		pod.Status.InitContainerStatuses = tt.podStatus
+		logsCalled := false
+		controller := FunctionController{
+			clientset: fake.NewSimpleClientset(&job, &pod),
+			podLogs: func(p v1.Pod, container string, previous bool) (string, error) {
+				logsCalled = true
+				if container != "install" || !previous {
+					t.Errorf("%s: unexpected logs request for container %s (previous: %v)", tt.name, container, previous)
+				}
+				return "Collecting foo\nERROR: No matching distribution found\n", nil
+			},
+		}
+		status, reason, message, err := controller.buildJobStatus("myns", job.ObjectMeta.Name, "foo:1")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if status != tt.status || reason != tt.reason {
+			t.Errorf("%s: expecting %s %s, received %s %s", tt.name, tt.status, tt.reason, status, reason)
+		}
+		if message != tt.message {
+			t.Errorf("%s: expecting message %q, received %q", tt.name, tt.message, message)
+		}
+		if logsCalled != tt.logsCalled {
+			t.Errorf("%s: expecting the logs to be requested: %v", tt.name, tt.logsCalled)
+		}
+	}
+}
+
+func TestSaveFunctionStatus(t *testing.T) {
+	funcObj := kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "foo",
+		},
+	}
+	client := kFake.NewSimpleClientset(&funcObj)
+	controller := FunctionController{
+		kubelessclient: client,
+	}
+	prevStatus := funcObj.Status.DeepCopy()
+	funcObj.Status.Phase = kubelessApi.FunctionPhaseReady
+	if err := controller.saveFunctionStatus(&funcObj, prevStatus); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f, err := client.KubelessV1beta1().Functions("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Status.Phase != kubelessApi.FunctionPhaseReady {
+		t.Errorf("Expecting phase %s, received %s", kubelessApi.FunctionPhaseReady, f.Status.Phase)
+	}
+
+	// An unchanged status should not be stored again
+	client.ClearActions()
+	if err := controller.saveFunctionStatus(&funcObj, funcObj.Status.DeepCopy()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("Unexpected actions: %v", client.Actions())
+	}
+}
+
+func TestEnsureK8sResourcesWithCanary(t *testing.T) {
+	namespace := "default"
+	funcName := "foo"
+	replicas := int32(4)
+	funcObj := kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      funcName,
+			Namespace: namespace,
+			UID:       "foo-uid",
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Function: "function",
+			Handler:  "foo.bar",
+			Runtime:  "ruby2.4",
+			Deployment: v1beta1.Deployment{
+				Spec: v1beta1.DeploymentSpec{
+					Replicas: &replicas,
+				},
+			},
+			Canary: &kubelessApi.FunctionCanary{
+				Weight: 25,
+				Spec: kubelessApi.FunctionSpec{
+					Function: "new function",
+					Handler:  "foo.bar",
+					Runtime:  "ruby2.4",
+					Deployment: v1beta1.Deployment{
+						Spec: v1beta1.DeploymentSpec{
+							Replicas: &replicas,
+						},
+					},
+				},
+			},
+		},
+	}
+	runtimeImages := []langruntime.RuntimeInfo{{
+		ID:             "ruby",
+		DepName:        "Gemfile",
+		FileNameSuffix: ".rb",
+		Versions: []langruntime.RuntimeVersion{
+			{
+				Name:    "ruby24",
+				Version: "2.4",
+				Images: []langruntime.Image{
+					{Phase: "runtime", Image: "bitnami/ruby:2.4"},
+				},
+				ImagePullSecrets: []langruntime.ImageSecret{},
+			},
+		},
+	}}
+	out, err := yaml.Marshal(runtimeImages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "kubeless-config",
+		},
+		Data: map[string]string{"runtime-images": string(out)},
+	}
+	lr := langruntime.New(config)
+	lr.ReadConfigMap()
+
+	clientset := fake.NewSimpleClientset()
+	controller := FunctionController{
+		logger:      logrus.WithField("pkg", "controller"),
+		clientset:   clientset,
+		langRuntime: lr,
+		config:      config,
+	}
+
+	if err := controller.ensureK8sResources(funcObj.DeepCopy()); err != nil {
+		t.Fatalf("Creating/Updating resources returned err: %v", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments(namespace).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dpm.Spec.Replicas != 3 {
+		t.Errorf("Expecting 3 stable replicas but received %d", *dpm.Spec.Replicas)
+	}
+	canary, err := clientset.AppsV1().Deployments(namespace).Get("foo-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expecting a canary deployment: %v", err)
+	}
+	if *canary.Spec.Replicas != 1 {
+		t.Errorf("Expecting 1 canary replica but received %d", *canary.Spec.Replicas)
+	}
+	if canary.Spec.Template.Labels["function"] != funcName || canary.Spec.Template.Labels["track"] != "canary" {
+		t.Errorf("Unexpected canary pod labels %v", canary.Spec.Template.Labels)
+	}
+	// The stable Deployment doesn't select the canary pods but the service selects both revisions
+	stableSelector, err := metav1.LabelSelectorAsSelector(dpm.Spec.Selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dpm.Spec.Template.Labels["track"] != "stable" || !stableSelector.Matches(labels.Set(dpm.Spec.Template.Labels)) {
+		t.Errorf("Unexpected stable pod labels %v", dpm.Spec.Template.Labels)
+	}
+	if stableSelector.Matches(labels.Set(canary.Spec.Template.Labels)) {
+		t.Errorf("The selector %v of the stable Deployment should not match the canary pods", dpm.Spec.Selector)
+	}
+	svc, err := clientset.CoreV1().Services(namespace).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svcSelector := labels.SelectorFromSet(svc.Spec.Selector)
+	if !svcSelector.Matches(labels.Set(dpm.Spec.Template.Labels)) || !svcSelector.Matches(labels.Set(canary.Spec.Template.Labels)) {
+		t.Errorf("The service selector %v should match both revisions", svc.Spec.Selector)
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get("foo-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expecting a canary configmap: %v", err)
+	}
+	if cm.Data["foo.rb"] != "new function" {
+		t.Errorf("Expecting the canary code in its configmap, received %v", cm.Data)
+	}
+	stableCM, err := clientset.CoreV1().ConfigMaps(namespace).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stableCM.Data["foo.rb"] != "function" {
+		t.Errorf("The stable configmap should not be modified, received %v", stableCM.Data)
+	}
+
+	// Once the canary is removed the stable deployment should recover its replicas
+	funcObj.Spec.Canary = nil
+	if err := controller.ensureK8sResources(funcObj.DeepCopy()); err != nil {
+		t.Fatalf("Creating/Updating resources returned err: %v", err)
+	}
+	_, err = clientset.AppsV1().Deployments(namespace).Get("foo-canary", metav1.GetOptions{})
+	if !k8sErrors.IsNotFound(err) {
+		t.Errorf("Expecting the canary deployment to be deleted")
+	}
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Get("foo-canary", metav1.GetOptions{})
+	if !k8sErrors.IsNotFound(err) {
+		t.Errorf("Expecting the canary configmap to be deleted")
+	}
+	// The fake clientset doesn't apply patches so check the one sent for the stable deployment
+	var patch ktesting.PatchAction
+	for _, a := range clientset.Actions() {
+		if a.Matches("patch", "deployments") {
+			patch = a.(ktesting.PatchAction)
+		}
+	}
+	if patch == nil || patch.GetName() != funcName {
+		t.Fatalf("Expecting the stable deployment to be patched")
+	}
+	patched := appsv1.Deployment{}
+	if err := json.Unmarshal(patch.GetPatch(), &patched); err != nil {
+		t.Fatal(err)
+	}
+	if *patched.Spec.Replicas != 4 {
+		t.Errorf("Expecting 4 stable replicas but received %d", *patched.Spec.Replicas)
+	}
+}
+
+// newProcessingController returns a controller that processes the given function with fake clients
+func newProcessingController(t *testing.T, funcObj *kubelessApi.Function) (*FunctionController, *fake.Clientset) {
+	runtimeImages := []langruntime.RuntimeInfo{{
+		ID:             "ruby",
+		DepName:        "Gemfile",
+		FileNameSuffix: ".rb",
+		Versions: []langruntime.RuntimeVersion{
+			{
+				Name:    "ruby24",
+				Version: "2.4",
+				Images: []langruntime.Image{
+					{Phase: "runtime", Image: "bitnami/ruby:2.4"},
+				},
+				ImagePullSecrets: []langruntime.ImageSecret{},
+			},
+		},
+	}}
+	out, err := yaml.Marshal(runtimeImages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "kubeless-config",
+		},
+		Data: map[string]string{"runtime-images": string(out)},
+	}
+	lr := langruntime.New(config)
+	lr.ReadConfigMap()
+
+	kubelessClient := kFake.NewSimpleClientset(funcObj)
+	informer := kv1beta1.NewFunctionInformer(kubelessClient, "", 0, cache.Indexers{})
+	if err := informer.GetIndexer().Add(funcObj); err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset()
+	return &FunctionController{
+		logger:         logrus.WithField("pkg", "controller"),
+		clientset:      clientset,
+		kubelessclient: kubelessClient,
+		informer:       informer,
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		langRuntime:    lr,
+		config:         config,
+	}, clientset
+}
+
+func TestProcessItemRestoresResources(t *testing.T) {
+	funcObj := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "foo",
+			Namespace:  "default",
+			UID:        "foo-uid",
+			Generation: 1,
+			Finalizers: []string{functionFinalizer},
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Function: "function",
+			Handler:  "foo.bar",
+			Runtime:  "ruby2.4",
+		},
+		Status: kubelessApi.FunctionStatus{
+			ObservedGeneration: 1,
+			Conditions: []kubelessApi.FunctionCondition{
+				{Type: kubelessApi.FunctionDeployed, Status: v1.ConditionTrue},
+				{Type: kubelessApi.FunctionReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	controller, clientset := newProcessingController(t, funcObj)
+	defer controller.queue.ShutDown()
+
+	// The Deployment of the function has been deleted
+	if err := controller.processItem("default/foo"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := clientset.AppsV1().Deployments("default").Get("foo", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expecting the Deployment to be restored: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("default").Get("foo", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expecting the Service to be restored: %v", err)
+	}
+
+	// The resources of an idle function are kept as they are
+	funcObj.Status.Conditions = append(funcObj.Status.Conditions, kubelessApi.FunctionCondition{Type: kubelessApi.FunctionIdle, Status: v1.ConditionTrue})
+	if err := controller.informer.GetIndexer().Update(funcObj); err != nil {
+		t.Fatal(err)
+	}
+	clientset.ClearActions()
+	if err := controller.processItem("default/foo"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, a := range clientset.Actions() {
+		if a.GetVerb() != "get" && a.GetVerb() != "list" {
+			t.Errorf("Unexpected action %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+}
+
+func TestProcessItemUpdatesContainerConcurrency(t *testing.T) {
+	funcObj := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo",
+			Namespace:       "default",
+			UID:             "foo-uid",
+			ResourceVersion: "1",
+			Generation:      1,
+			Finalizers:      []string{functionFinalizer},
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Function: "function",
+			Handler:  "foo.bar",
+			Runtime:  "ruby2.4",
+		},
+	}
+	controller, clientset := newProcessingController(t, funcObj)
+	defer controller.queue.ShutDown()
+	if err := controller.processItem("default/foo"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	updated := funcObj.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Generation = 2
+	updated.Spec.ContainerConcurrency = 4
+	if !functionObjChanged(funcObj, updated) {
+		t.Fatal("Expecting the function to be processed again")
+	}
+	if err := controller.informer.GetIndexer().Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	clientset.ClearActions()
+	if err := controller.processItem("default/foo"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The fake clientset doesn't apply patches so check the one sent for the deployment
+	var patch ktesting.PatchAction
+	for _, a := range clientset.Actions() {
+		if a.Matches("patch", "deployments") {
+			patch = a.(ktesting.PatchAction)
+		}
+	}
+	if patch == nil {
+		t.Fatal("Expecting the deployment to be patched")
+	}
+	patched := appsv1.Deployment{}
+	if err := json.Unmarshal(patch.GetPatch(), &patched); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, env := range patched.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "FUNC_CONTAINER_CONCURRENCY" && env.Value == "4" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expecting the container concurrency in the environment, received %v", patched.Spec.Template.Spec.Containers[0].Env)
+	}
+}
+
+func TestCheckIdleFunction(t *testing.T) {
+	replicas := int32(2)
+	deploy := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "foo"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "foo"},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"function": "foo"},
+			Ports:    []v1.ServicePort{{Name: "http-function-port", Port: 8080}},
+		},
+	}
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "foo-1234", Labels: map[string]string{"function": "foo"}},
+		Status: v1.PodStatus{
+			PodIP:      "10.0.0.2",
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+	activator := v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kubeless", Name: "kubeless-activator"},
+		Subsets:    []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "10.0.0.100"}}}},
+	}
+	funcObj := kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "foo"},
+		Spec:       kubelessApi.FunctionSpec{IdleTimeout: "1ms"},
+	}
+	calls := float64(3)
+	clientset := fake.NewSimpleClientset(&deploy, &svc, &pod, &activator)
+	controller := FunctionController{
+		logger:    logrus.WithField("pkg", "controller"),
+		clientset: clientset,
+		config:    &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kubeless"}},
+		podCalls: func(pod v1.Pod) (float64, error) {
+			return calls, nil
+		},
+		activity: map[string]*functionActivity{},
+	}
+
+	// The first check only records the activity of the function
+	period, err := controller.checkIdleFunction("myns/foo", &funcObj, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if period != time.Millisecond {
+		t.Errorf("Expecting the function to be checked again in 1ms, received %s", period)
+	}
+	if hasAction(clientset, "update", "deployments") {
+		t.Fatal("The function should not be scaled to zero yet")
+	}
+
+	// New requests reset the idle time
+	calls = 4
+	controller.checkIdleFunction("myns/foo", &funcObj, true)
+	if hasAction(clientset, "update", "deployments") {
+		t.Fatal("The function should not be scaled to zero after receiving requests")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	_, err = controller.checkIdleFunction("myns/foo", &funcObj, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dpm.Spec.Replicas != 0 {
+		t.Errorf("Expecting the function to be scaled to zero, received %d replicas", *dpm.Spec.Replicas)
+	}
+	endpoints, err := clientset.CoreV1().Endpoints("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoints.Subsets[0].Addresses[0].IP != "10.0.0.100" {
+		t.Errorf("Expecting the function to be routed to the activator, received %v", endpoints.Subsets)
+	}
+	if funcObj.Status.Phase != kubelessApi.FunctionPhaseIdle {
+		t.Errorf("Expecting phase %s, received %s", kubelessApi.FunctionPhaseIdle, funcObj.Status.Phase)
+	}
+
+	// Once activated the status is updated
+	clientset = fake.NewSimpleClientset(&deploy)
+	controller.clientset = clientset
+	_, err = controller.refreshFunctionStatus(&funcObj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	idle := getFunctionCondition(&funcObj.Status, kubelessApi.FunctionIdle)
+	if idle.Status != v1.ConditionFalse || idle.Reason != "Activated" {
+		t.Errorf("Expecting the function to be activated, received %v", idle)
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	kv1beta1 "github.com/kubeless/kubeless/pkg/client/informers/externalversions/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/sequence"
+	"github.com/kubeless/kubeless/pkg/tracing"
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+const (
+	sequenceKind = "Sequence"
+	// sequenceRetryPeriod is the time to wait before deploying again a sequence that failed,
+	// for example because one of its functions doesn't exist yet
+	sequenceRetryPeriod = 30 * time.Second
+	// defaultSequenceRunnerImage is the image that executes the sequences unless configured otherwise
+	defaultSequenceRunnerImage = "kubeless/sequence-runner:latest"
+)
+
+// SequenceController deploys the sequences of functions
+type SequenceController struct {
+	logger           *logrus.Entry
+	clientset        kubernetes.Interface
+	kubelessclient   versioned.Interface
+	queue            workqueue.RateLimitingInterface
+	informer         cache.SharedIndexInformer
+	serviceInformer  cache.SharedIndexInformer
+	config           *corev1.ConfigMap
+	imagePullSecrets []corev1.LocalObjectReference
+}
+
+// NewSequenceController returns a new *SequenceController
+func NewSequenceController(cfg Config) *SequenceController {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	apiExtensionsClientset := utils.GetAPIExtensionsClientInCluster()
+	config, err := utils.GetKubelessConfig(cfg.KubeCli, apiExtensionsClientset)
+	if err != nil {
+		logrus.Fatalf("Unable to read the configmap: %s", err)
+	}
+
+	informer := kv1beta1.NewSequenceInformer(cfg.FunctionClient, config.Data["functions-namespace"], 0, cache.Indexers{})
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			if err == nil {
+				queue.Add(key)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			key, err := cache.MetaNamespaceKeyFunc(new)
+			if err == nil {
+				oldSequence := old.(*kubelessApi.Sequence)
+				newSequence := new.(*kubelessApi.Sequence)
+				if !apiequality.Semantic.DeepEqual(oldSequence.Spec, newSequence.Spec) {
+					queue.Add(key)
+				}
+			}
+		},
+	})
+
+	// The URLs of the steps depend on the Services of the functions so the sequences
+	// are deployed again when one of them is created, changed or deleted
+	serviceListWatch := cache.NewFilteredListWatchFromClient(cfg.KubeCli.CoreV1().RESTClient(), "services", config.Data["functions-namespace"], func(options *metav1.ListOptions) {
+		options.LabelSelector = "created-by=kubeless"
+	})
+	serviceInformer := cache.NewSharedIndexInformer(serviceListWatch, &corev1.Service{}, 0, cache.Indexers{})
+
+	c := &SequenceController{
+		logger:           logrus.WithField("pkg", "sequence-controller"),
+		clientset:        cfg.KubeCli,
+		kubelessclient:   cfg.FunctionClient,
+		informer:         informer,
+		serviceInformer:  serviceInformer,
+		queue:            queue,
+		config:           config,
+		imagePullSecrets: utils.GetSecretsAsLocalObjectReference(config.Data["sequence-runner-image-secret"]),
+	}
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueSequencesOfService,
+		UpdateFunc: func(old, new interface{}) {
+			oldSvc := old.(*corev1.Service)
+			newSvc := new.(*corev1.Service)
+			if !apiequality.Semantic.DeepEqual(oldSvc.Spec.Ports, newSvc.Spec.Ports) {
+				c.enqueueSequencesOfService(new)
+			}
+		},
+		DeleteFunc: c.enqueueSequencesOfService,
+	})
+	return c
+}
+
+// enqueueSequencesOfService adds to the queue the sequences with a step that calls the function of a Service
+func (c *SequenceController) enqueueSequencesOfService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+	for _, o := range c.informer.GetStore().List() {
+		seq := o.(*kubelessApi.Sequence)
+		if seq.ObjectMeta.Namespace != svc.ObjectMeta.Namespace {
+			continue
+		}
+		for _, f := range sequence.Functions(&seq.Spec) {
+			if f == svc.ObjectMeta.Name {
+				key, err := cache.MetaNamespaceKeyFunc(seq)
+				if err == nil {
+					c.queue.Add(key)
+				}
+				break
+			}
+		}
+	}
+}
+
+// Run starts the sequence controller
+func (c *SequenceController) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	c.logger.Info("Starting Sequence controller")
+
+	go c.informer.Run(stopCh)
+	go c.serviceInformer.Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+		return
+	}
+
+	c.logger.Info("Sequence controller synced and ready")
+
+	wait.Until(c.runWorker, time.Second, stopCh)
+}
+
+// HasSynced is required for the cache.Controller interface.
+func (c *SequenceController) HasSynced() bool {
+	return c.informer.HasSynced() && c.serviceInformer.HasSynced()
+}
+
+func (c *SequenceController) runWorker() {
+	for c.processNextItem() {
+		// continue looping
+	}
+}
+
+func (c *SequenceController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.processItem(key.(string))
+	if err == nil {
+		// No error, reset the ratelimit counters
+		c.queue.Forget(key)
+	} else if c.queue.NumRequeues(key) < maxRetries {
+		c.logger.Errorf("Error processing %s (will retry): %v", key, err)
+		c.queue.AddRateLimited(key)
+	} else {
+		// err != nil and too many retries
+		c.logger.Errorf("Error processing %s (giving up): %v", key, err)
+		c.queue.Forget(key)
+		utilruntime.HandleError(err)
+	}
+
+	return true
+}
+
+func (c *SequenceController) processItem(key string) error {
+	c.logger.Infof("Processing change to Sequence %s", key)
+
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
+	}
+	if !exists {
+		// The Deployment and the Service are removed by the garbage collector
+		c.logger.Infof("Sequence %s has been deleted", key)
+		return nil
+	}
+
+	seq := obj.(*kubelessApi.Sequence).DeepCopy()
+	prevStatus := seq.Status.DeepCopy()
+	seq.Status.ObservedGeneration = seq.ObjectMeta.Generation
+	seq.Status.Functions = sequence.Functions(&seq.Spec)
+
+	err = c.ensureSequenceResources(seq)
+	if err != nil {
+		c.logger.Errorf("Sequence %s can not be deployed: %v", key, err)
+		seq.Status.Phase = kubelessApi.SequencePhaseFailed
+		seq.Status.LastError = err.Error()
+		if statusErr := c.saveSequenceStatus(seq, prevStatus); statusErr != nil {
+			return statusErr
+		}
+		// Try again later in case the sequence depends on functions that don't exist yet
+		c.queue.AddAfter(key, sequenceRetryPeriod)
+		return nil
+	}
+	seq.Status.LastError = ""
+
+	ready, err := c.refreshSequenceStatus(seq)
+	if err != nil {
+		return err
+	}
+	if err := c.saveSequenceStatus(seq, prevStatus); err != nil {
+		return fmt.Errorf("Unable to update status of sequence %s: %v", key, err)
+	}
+	if !ready {
+		c.queue.AddAfter(key, statusCheckPeriod)
+	}
+
+	c.logger.Infof("Processed change to sequence: %s", key)
+	return nil
+}
+
+// ensureSequenceResources creates/updates the Service and the Deployment of a sequence
+func (c *SequenceController) ensureSequenceResources(seq *kubelessApi.Sequence) error {
+	if err := sequence.Validate(&seq.Spec); err != nil {
+		return err
+	}
+	urls, err := utils.SequenceFunctionURLs(c.clientset, seq.ObjectMeta.Namespace, seq.Status.Functions)
+	if err != nil {
+		return err
+	}
+	or, err := utils.GetOwnerReference(sequenceKind, funcAPIVersion, seq.ObjectMeta.Name, seq.ObjectMeta.UID)
+	if err != nil {
+		return err
+	}
+	if err := utils.EnsureSequenceService(c.clientset, seq, or); err != nil {
+		return err
+	}
+	image := c.config.Data["sequence-runner-image"]
+	if image == "" {
+		image = defaultSequenceRunnerImage
+	}
+	env := []corev1.EnvVar{}
+	if collector := c.config.Data["tracing-collector"]; collector != "" {
+		env = append(env, corev1.EnvVar{Name: tracing.CollectorEnv, Value: collector})
+	}
+	return utils.EnsureSequenceDeployment(c.clientset, seq, or, image, urls, c.imagePullSecrets, env)
+}
+
+// refreshSequenceStatus sets the phase of a sequence based on its Deployment. It returns true if the sequence is ready
+func (c *SequenceController) refreshSequenceStatus(seq *kubelessApi.Sequence) (bool, error) {
+	dpm, err := c.clientset.AppsV1().Deployments(seq.ObjectMeta.Namespace).Get(seq.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			seq.Status.Phase = kubelessApi.SequencePhasePending
+			return false, nil
+		}
+		return false, err
+	}
+	if deploymentReady(dpm) {
+		seq.Status.Phase = kubelessApi.SequencePhaseReady
+		return true, nil
+	}
+	seq.Status.Phase = kubelessApi.SequencePhaseDeploying
+	return false, nil
+}
+
+// saveSequenceStatus stores the status of a sequence if it has changed
+func (c *SequenceController) saveSequenceStatus(seq *kubelessApi.Sequence, prevStatus *kubelessApi.SequenceStatus) error {
+	if apiequality.Semantic.DeepEqual(&seq.Status, prevStatus) {
+		return nil
+	}
+	return utils.UpdateSequenceStatus(c.kubelessclient, seq.ObjectMeta.Namespace, seq.ObjectMeta.Name, &seq.Status)
+}
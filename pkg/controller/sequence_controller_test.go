@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	kFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
+	kv1beta1 "github.com/kubeless/kubeless/pkg/client/informers/externalversions/kubeless/v1beta1"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestSequenceController(seq *kubelessApi.Sequence, objects ...runtime.Object) (*SequenceController, *fake.Clientset, *kFake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	kubelessClient := kFake.NewSimpleClientset(seq)
+	informer := kv1beta1.NewSequenceInformer(kubelessClient, "", 0, cache.Indexers{})
+	informer.GetIndexer().Add(seq)
+	return &SequenceController{
+		logger:         logrus.WithField("pkg", "sequence-controller"),
+		clientset:      clientset,
+		kubelessclient: kubelessClient,
+		informer:       informer,
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		config: &v1.ConfigMap{
+			Data: map[string]string{"sequence-runner-image": "sequence-runner:test"},
+		},
+	}, clientset, kubelessClient
+}
+
+// savedSequenceStatus returns the last status stored by the controller
+func savedSequenceStatus(t *testing.T, kubelessClient *kFake.Clientset) kubelessApi.SequenceStatus {
+	var status *kubelessApi.SequenceStatus
+	for _, a := range kubelessClient.Actions() {
+		if a.Matches("update", "sequences") {
+			status = &a.(ktesting.UpdateAction).GetObject().(*kubelessApi.Sequence).Status
+		}
+	}
+	if status == nil {
+		t.Fatal("The status of the sequence has not been updated")
+	}
+	return *status
+}
+
+func TestProcessSequence(t *testing.T) {
+	seq := &kubelessApi.Sequence{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "myns", UID: "1234", Generation: 2},
+		Spec: kubelessApi.SequenceSpec{Steps: []kubelessApi.SequenceStep{
+			{Name: "first", Function: "foo"},
+			{Name: "second", Parallel: []kubelessApi.SequenceBranch{
+				{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "bar", Function: "bar"}}},
+			}},
+		}},
+	}
+	fooSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 8080}}},
+	}
+
+	// A function of the sequence doesn't exist
+	controller, clientset, kubelessClient := newTestSequenceController(seq, fooSvc)
+	if err := controller.processItem("myns/pipeline"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status := savedSequenceStatus(t, kubelessClient)
+	if status.Phase != kubelessApi.SequencePhaseFailed || status.LastError != "Function bar not found" {
+		t.Errorf("Unexpected status %v", status)
+	}
+	if hasAction(clientset, "create", "deployments") {
+		t.Error("The sequence should not be deployed")
+	}
+
+	barSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "myns"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 9090}}},
+	}
+	controller, clientset, kubelessClient = newTestSequenceController(seq, fooSvc, barSvc)
+	if err := controller.processItem("myns/pipeline"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments("myns").Get("pipeline", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	container := dpm.Spec.Template.Spec.Containers[0]
+	if container.Image != "sequence-runner:test" {
+		t.Errorf("Unexpected image %s", container.Image)
+	}
+	env := map[string]string{}
+	for _, e := range container.Env {
+		env[e.Name] = e.Value
+	}
+	urls := map[string]string{}
+	if err := json.Unmarshal([]byte(env["SEQUENCE_FUNCTIONS"]), &urls); err != nil {
+		t.Fatal(err)
+	}
+	if urls["foo"] != "http://foo.myns.svc.cluster.local:8080" || urls["bar"] != "http://bar.myns.svc.cluster.local:9090" {
+		t.Errorf("Unexpected function URLs %v", urls)
+	}
+	spec := kubelessApi.SequenceSpec{}
+	if err := json.Unmarshal([]byte(env["SEQUENCE_SPEC"]), &spec); err != nil || len(spec.Steps) != 2 {
+		t.Errorf("Unexpected sequence spec %s", env["SEQUENCE_SPEC"])
+	}
+	svc, err := clientset.CoreV1().Services("myns").Get("pipeline", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if svc.Spec.Selector["sequence"] != "pipeline" || dpm.Spec.Template.ObjectMeta.Labels["sequence"] != "pipeline" {
+		t.Errorf("The service doesn't select the sequence pods: %v", svc.Spec.Selector)
+	}
+	if len(svc.ObjectMeta.OwnerReferences) != 1 || svc.ObjectMeta.OwnerReferences[0].Kind != "Sequence" {
+		t.Errorf("Unexpected owner references %v", svc.ObjectMeta.OwnerReferences)
+	}
+	status = savedSequenceStatus(t, kubelessClient)
+	if status.Phase != kubelessApi.SequencePhaseDeploying || status.ObservedGeneration != 2 || len(status.Functions) != 2 {
+		t.Errorf("Unexpected status %v", status)
+	}
+}
+
+func TestEnqueueSequencesOfService(t *testing.T) {
+	seq := &kubelessApi.Sequence{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "myns"},
+		Spec: kubelessApi.SequenceSpec{Steps: []kubelessApi.SequenceStep{
+			{Name: "first", Function: "foo"},
+			{Name: "second", Parallel: []kubelessApi.SequenceBranch{
+				{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "bar", Function: "bar"}}},
+			}},
+		}},
+	}
+	controller, _, _ := newTestSequenceController(seq)
+
+	for _, svc := range []*v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "myns"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "default"}},
+	} {
+		controller.enqueueSequencesOfService(svc)
+		if controller.queue.Len() != 0 {
+			t.Errorf("The sequence should not be queued for the service %s/%s", svc.ObjectMeta.Namespace, svc.ObjectMeta.Name)
+		}
+	}
+
+	bar := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "myns"}}
+	controller.enqueueSequencesOfService(cache.DeletedFinalStateUnknown{Key: "myns/bar", Obj: bar})
+	if controller.queue.Len() != 1 {
+		t.Fatalf("The sequence should be queued when a function of a parallel step changes")
+	}
+	key, _ := controller.queue.Get()
+	if key != "myns/pipeline" {
+		t.Errorf("Unexpected key %v", key)
+	}
+}
+
+func TestRefreshSequenceStatus(t *testing.T) {
+	seq := &kubelessApi.Sequence{ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "myns"}}
+	replicas := int32(1)
+	dpm := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "myns", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1, AvailableReplicas: 1, ReadyReplicas: 1},
+	}
+	controller, _, _ := newTestSequenceController(seq, dpm)
+	ready, err := controller.refreshSequenceStatus(seq)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ready || seq.Status.Phase != kubelessApi.SequencePhaseReady {
+		t.Errorf("Expecting the sequence to be ready, received %s", seq.Status.Phase)
+	}
+}
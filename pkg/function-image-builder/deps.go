@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"path"
+
+	lbuilder "github.com/kubeless/kubeless/pkg/function-image-builder/layer-builder"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// depsList contains the snapshot of the files that belong to the dependencies layer
+	depsList = "deps.list"
+	// previousList contains the snapshot of the files before installing the dependencies
+	previousList = "previous.list"
+	// depsTar is the dependencies layer when it is not cached yet
+	depsTar = "deps.tar"
+)
+
+func init() {
+	addRegistryFlags(restoreDepsCmd)
+	restoreDepsCmd.Flags().StringP("image", "", "", "Image with the dependencies layer. F.e. docker://user/kubeless-deps:tag")
+	for _, cmd := range []*cobra.Command{restoreDepsCmd, saveDepsCmd, bundleCmd} {
+		cmd.Flags().StringP("dir", "", "/kubeless", "Directory with the function and its dependencies")
+		cmd.Flags().StringP("state", "", "", "Directory to store the state of the build between steps")
+	}
+}
+
+// stringFlags returns the value of the given flags failing if any of them is empty
+func stringFlags(cmd *cobra.Command, names ...string) []string {
+	values := []string{}
+	for _, name := range names {
+		v, err := cmd.Flags().GetString(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if v == "" {
+			log.Fatalf("Need specify the flag --%s", name)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+var restoreDepsCmd = &cobra.Command{
+	Use:   "restore-deps FLAG",
+	Short: "Extract the dependencies layer of an image if it exists",
+	Long: `Extract the last layer of the dependencies image in the root filesystem. If the image
+doesn't exist the current state of the directory is stored so save-deps can find the
+files created when installing the dependencies.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := stringFlags(cmd, "image", "dir", "state")
+		image, dir, state := flags[0], flags[1], flags[2]
+
+		ref, err := lbuilder.ParseReference(image)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds, err := lbuilder.DockerConfigCredentials(lbuilder.DockerConfigFile())
+		if err != nil {
+			log.Fatal(err)
+		}
+		client, err := newClient(cmd, creds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exists, err := client.ImageExists(ref)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !exists {
+			snapshot, err := lbuilder.TakeSnapshot(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := snapshot.Save(path.Join(state, previousList)); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("The dependencies image ", image, " doesn't exist, the dependencies will be installed")
+			return
+		}
+		snapshot, err := lbuilder.ExtractLayer(client, ref, dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := snapshot.Save(path.Join(state, depsList)); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Restored ", len(snapshot), " files from ", image)
+	},
+}
+
+var saveDepsCmd = &cobra.Command{
+	Use:   "save-deps FLAG",
+	Short: "Store the files created when installing the dependencies as a layer",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := stringFlags(cmd, "dir", "state")
+		dir, state := flags[0], flags[1]
+
+		if fileExists(path.Join(state, depsList)) {
+			log.Println("The dependencies have been restored from the cache")
+			return
+		}
+		previous, err := lbuilder.LoadSnapshot(path.Join(state, previousList))
+		if err != nil {
+			log.Fatal(err)
+		}
+		current, err := lbuilder.TakeSnapshot(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files := current.Changed(previous)
+		if err := lbuilder.WriteTar(path.Join(state, depsTar), files, current.Deleted(previous)); err != nil {
+			log.Fatal(err)
+		}
+		deps := lbuilder.Snapshot{}
+		for _, f := range files {
+			deps[f] = current[f]
+		}
+		if err := deps.Save(path.Join(state, depsList)); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Stored ", len(files), " dependency files at ", path.Join(state, depsTar))
+	},
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle <tar> FLAG",
+	Short: "Store the function files that are not part of the dependencies layer in a tar",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("Need exactly one argument - bundle tar")
+		}
+		flags := stringFlags(cmd, "dir", "state")
+		dir, state := flags[0], flags[1]
+
+		deps := lbuilder.Snapshot{}
+		if fileExists(path.Join(state, depsList)) {
+			var err error
+			deps, err = lbuilder.LoadSnapshot(path.Join(state, depsList))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		current, err := lbuilder.TakeSnapshot(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files := current.Changed(deps)
+		if err := lbuilder.WriteTar(args[0], files, current.Deleted(deps)); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Stored ", len(files), " function files at ", args[0])
+	},
+}
@@ -17,65 +17,87 @@ limitations under the License.
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path"
 
 	lbuilder "github.com/kubeless/kubeless/pkg/function-image-builder/layer-builder"
 	"github.com/spf13/cobra"
 )
 
-var globalUsage = `` //TODO: add explanation
+var globalUsage = `Pulls the manifest of an image from a Docker registry, appends a tar file as
+a new layer and pushes the resulting image using the Docker Registry HTTP API V2.
+Both Docker schema2 and OCI manifests are supported.`
 
 func init() {
-	layerCmd.Flags().Bool("insecure", false, "Disable TLS verification.")
-	layerCmd.Flags().StringP("src", "", "", "Source image reference. F.e. dir://path/to/image")
+	addRegistryFlags(layerCmd)
+	layerCmd.Flags().StringP("src", "", "", "Source image reference. F.e. docker://user/image:tag")
 	layerCmd.Flags().StringP("src-creds", "", "", "Source image credentials in case it is a private registry. F.e. user:my_pass")
 	layerCmd.Flags().StringP("dst", "", "", "Destination image reference. F.e. docker://user/image")
 	layerCmd.Flags().StringP("dst-creds", "", "", "Destination credentials in case it is a docker registry. F.e. user:my_pass")
+	layerCmd.Flags().StringP("cache", "", "", "Image with the dependencies layer. F.e. docker://user/kubeless-deps:tag. If the tar of --deps exists the image is created from --src, otherwise it is used as base image")
+	layerCmd.Flags().StringP("deps", "", "", "Tar file with the dependencies layer stored in the --cache image")
 	layerCmd.Flags().StringP("cwd", "", "", "Working directory")
 }
 
-func runCommand(command string, args []string) error {
-	cmd := exec.Command(command, args...)
-
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	cmd.Start()
+// addRegistryFlags adds the flags required to connect to a registry
+func addRegistryFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("insecure", false, "Disable TLS verification and allow plain HTTP registries.")
+	cmd.Flags().StringP("ca-file", "", "", "PEM file with the certificates of the registries. Defaults to the ca.crt file of DOCKER_CONFIG_FOLDER if it exists")
+}
 
-	scannerStdout := bufio.NewScanner(stdout)
-	scannerStdout.Split(bufio.ScanLines)
-	for scannerStdout.Scan() {
-		m := scannerStdout.Text()
-		fmt.Fprintln(os.Stdout, m)
+// newClient returns a registry client configured with the flags of addRegistryFlags
+func newClient(cmd *cobra.Command, creds lbuilder.Credentials) (*lbuilder.Client, error) {
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		return nil, err
 	}
-	scannerStderr := bufio.NewScanner(stderr)
-	scannerStderr.Split(bufio.ScanLines)
-	for scannerStderr.Scan() {
-		m := scannerStderr.Text()
-		fmt.Fprintln(os.Stderr, m)
+	caFile, err := cmd.Flags().GetString("ca-file")
+	if err != nil {
+		return nil, err
 	}
-
-	return cmd.Wait()
+	if caFile == "" && os.Getenv("DOCKER_CONFIG_FOLDER") != "" {
+		// The registry secret may include the CA bundle of the registry
+		if f := path.Join(os.Getenv("DOCKER_CONFIG_FOLDER"), "ca.crt"); fileExists(f) {
+			caFile = f
+		}
+	}
+	caBundle := []byte{}
+	if caFile != "" {
+		caBundle, err = ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lbuilder.NewClient(insecure, caBundle, creds)
 }
 
-func skopeoCopy(src, dst, srcCreds, dstCreds string, insecure bool) error {
-	command := "skopeo"
-	args := []string{"copy"}
+// credentials returns the credentials of the docker configuration overridden by the ones given
+// for the source and destination registries
+func credentials(src, dst *lbuilder.Reference, srcCreds, dstCreds string) (lbuilder.Credentials, error) {
+	creds, err := lbuilder.DockerConfigCredentials(lbuilder.DockerConfigFile())
+	if err != nil {
+		return nil, err
+	}
 	if srcCreds != "" {
-		args = append(args, "--src-creds", srcCreds)
+		creds, err = lbuilder.StaticCredentials(src.Registry, srcCreds, creds)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if dstCreds != "" {
-		args = append(args, "--dest-creds", dstCreds)
-	}
-	if insecure {
-		args = append(args, "--src-tls-verify=false", "--dest-tls-verify=false")
+		creds, err = lbuilder.StaticCredentials(dst.Registry, dstCreds, creds)
+		if err != nil {
+			return nil, err
+		}
 	}
-	args = append(args, src, dst)
-	return runCommand(command, args)
+	return creds, nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
 }
 
 var layerCmd = &cobra.Command{
@@ -126,27 +148,50 @@ var layerCmd = &cobra.Command{
 			}
 		}
 
-		insecure, err := cmd.Flags().GetBool("insecure")
+		src, err := lbuilder.ParseReference(srcImage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dst, err := lbuilder.ParseReference(dstImage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds, err := credentials(src, dst, srcCreds, dstCreds)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// Store src image
-		err = skopeoCopy(srcImage, fmt.Sprintf("dir://%s", workDir), srcCreds, dstCreds, insecure)
+		client, err := newClient(cmd, creds)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Println("Succesfully stored base image ", srcImage, " at ", workDir)
 
-		// Add layer
-		err = lbuilder.AddTarToLayer(workDir, layerTar)
+		cacheImage, err := cmd.Flags().GetString("cache")
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Println("Added layer ", layerTar, " in ", workDir)
+		depsTar, err := cmd.Flags().GetString("deps")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cacheImage != "" {
+			cache, err := lbuilder.ParseReference(cacheImage)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if depsTar != "" && fileExists(depsTar) {
+				// Store the dependencies layer so other builds can reuse it
+				err = lbuilder.AddLayer(client, src, cache, depsTar, workDir)
+				if err != nil {
+					log.Fatal(err)
+				}
+				log.Println("Succesfully stored dependencies image at ", cacheImage)
+			}
+			src = cache
+		}
 
-		// Publish new image
-		err = skopeoCopy(fmt.Sprintf("dir://%s", workDir), dstImage, srcCreds, dstCreds, insecure)
+		// Add layer and publish the new image
+		err = lbuilder.AddLayer(client, src, dst, layerTar, workDir)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -161,7 +206,7 @@ func newRootCmd() *cobra.Command {
 		Long:  globalUsage,
 	}
 
-	cmd.AddCommand(layerCmd)
+	cmd.AddCommand(layerCmd, restoreDepsCmd, saveDepsCmd, bundleCmd)
 	return cmd
 }
 
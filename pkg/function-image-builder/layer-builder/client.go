@@ -0,0 +1,458 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials returns the username and password used to authenticate against a registry.
+// Empty values mean anonymous access
+type Credentials func(registry string) (string, string)
+
+// Client pulls and pushes images using the Docker Registry HTTP API V2
+type Client struct {
+	client      *http.Client
+	insecure    bool
+	credentials Credentials
+
+	mu sync.Mutex
+	// schemes contains the scheme (https or http) served by each registry
+	schemes map[string]string
+	// auth contains the Authorization header for each registry and scope
+	auth map[string]string
+}
+
+// NewClient returns a registry client. Insecure clients skip the TLS verification and fall back
+// to plain HTTP if the registry doesn't serve HTTPS. The certificates of caBundle (PEM encoded)
+// are trusted in addition to the system ones
+func NewClient(insecure bool, caBundle []byte, credentials Credentials) (*Client, error) {
+	if credentials == nil {
+		credentials = func(string) (string, string) { return "", "" }
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if len(caBundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("Unable to parse the CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &Client{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: 10,
+			},
+		},
+		insecure:    insecure,
+		credentials: credentials,
+		schemes:     map[string]string{},
+		auth:        map[string]string{},
+	}, nil
+}
+
+// registryErrors is the body of the error responses of the registry API
+type registryErrors struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// responseError returns an error with the status and the error messages of a registry response
+func responseError(res *http.Response, action string) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	errs := registryErrors{}
+	if json.Unmarshal(body, &errs) == nil && len(errs.Errors) > 0 {
+		msgs := []string{}
+		for _, e := range errs.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("Unable to %s: %s (%s)", action, strings.Join(msgs, ", "), res.Status)
+	}
+	return fmt.Errorf("Unable to %s: %s", action, res.Status)
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseChallenge returns the scheme and the parameters of a WWW-Authenticate header
+func parseChallenge(header string) (string, map[string]string) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	params := map[string]string{}
+	if len(parts) == 2 {
+		for _, m := range challengeParam.FindAllStringSubmatch(parts[1], -1) {
+			params[strings.ToLower(m[1])] = m[2]
+		}
+	}
+	return strings.ToLower(parts[0]), params
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// fetchToken requests a bearer token for the given scopes to the realm of the registry
+func (c *Client) fetchToken(registry string, params map[string]string, scopes []string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("Invalid authentication realm %q of %s", params["realm"], registry)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	for _, s := range scopes {
+		q.Add("scope", s)
+	}
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user, password := c.credentials(registry); user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Unable to obtain a token for %s: %v", registry, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", responseError(res, "obtain a token for "+registry)
+	}
+	token := tokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("Unable to parse the token of %s: %v", registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return token.Token, nil
+}
+
+// ping checks the version endpoint of a registry and returns its response
+func (c *Client) ping(registry string) (*http.Response, error) {
+	schemes := []string{"https"}
+	if c.insecure {
+		schemes = append(schemes, "http")
+	}
+	var err error
+	for _, scheme := range schemes {
+		var res *http.Response
+		res, err = c.client.Get(fmt.Sprintf("%s://%s/v2/", scheme, registry))
+		if err == nil {
+			c.schemes[registry] = scheme
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to connect to the registry %s: %v", registry, err)
+}
+
+// authorize returns the Authorization header required to access a registry with the given scopes
+func (c *Client) authorize(registry string, scopes []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := registry + " " + strings.Join(scopes, " ")
+	if header, ok := c.auth[key]; ok {
+		return header, nil
+	}
+	res, err := c.ping(registry)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	header := ""
+	switch res.StatusCode {
+	case http.StatusOK:
+		// The registry doesn't require authentication
+	case http.StatusUnauthorized:
+		scheme, params := parseChallenge(res.Header.Get("WWW-Authenticate"))
+		switch scheme {
+		case "basic":
+			user, password := c.credentials(registry)
+			if user == "" {
+				return "", fmt.Errorf("The registry %s requires credentials", registry)
+			}
+			req := http.Request{Header: http.Header{}}
+			req.SetBasicAuth(user, password)
+			header = req.Header.Get("Authorization")
+		case "bearer":
+			token, err := c.fetchToken(registry, params, scopes)
+			if err != nil {
+				return "", err
+			}
+			header = "Bearer " + token
+		default:
+			return "", fmt.Errorf("Unsupported authentication scheme %q of the registry %s", scheme, registry)
+		}
+	default:
+		return "", responseError(res, "connect to the registry "+registry)
+	}
+	c.auth[key] = header
+	return header, nil
+}
+
+// pullScope returns the scope required to pull from the repository of ref
+func pullScope(ref *Reference) []string {
+	return []string{fmt.Sprintf("repository:%s:pull", ref.Repository)}
+}
+
+// pushScope returns the scopes required to push to the repository of ref, mounting
+// blobs from the repositories in from
+func pushScope(ref *Reference, from ...string) []string {
+	scopes := []string{fmt.Sprintf("repository:%s:pull,push", ref.Repository)}
+	for _, repo := range from {
+		scopes = append(scopes, fmt.Sprintf("repository:%s:pull", repo))
+	}
+	return scopes
+}
+
+// do sends a request to the repository of ref. The path is relative to the repository
+// endpoint unless it is an absolute URL
+func (c *Client) do(method string, ref *Reference, path string, scopes []string, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	return c.send(method, ref, path, scopes, header, body, length, false)
+}
+
+func (c *Client) send(method string, ref *Reference, path string, scopes []string, header http.Header, body io.Reader, length int64, retried bool) (*http.Response, error) {
+	auth, err := c.authorize(ref.host(), scopes)
+	if err != nil {
+		return nil, err
+	}
+	u := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		c.mu.Lock()
+		scheme := c.schemes[ref.host()]
+		c.mu.Unlock()
+		u = fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.host(), ref.Repository, path)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	res, err := c.client.Do(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized && auth != "" && body == nil && !retried {
+		// The token may have expired, request a new one
+		res.Body.Close()
+		c.mu.Lock()
+		delete(c.auth, ref.host()+" "+strings.Join(scopes, " "))
+		c.mu.Unlock()
+		return c.send(method, ref, path, scopes, header, body, length, true)
+	}
+	return res, err
+}
+
+// manifestAccept lists the manifests accepted by the client, the manifest lists and
+// indexes are resolved to the manifest of the current platform
+var manifestAccept = []string{
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+}
+
+type manifestIndex struct {
+	Manifests []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Platform  struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// GetManifest returns the content and the media type of the manifest of an image. If the image
+// is a manifest list (or an OCI index) the manifest for the current platform is returned
+func (c *Client) GetManifest(ref *Reference) ([]byte, string, error) {
+	res, err := c.do("GET", ref, "manifests/"+ref.reference(), pullScope(ref), http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}, nil, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", responseError(res, "get the manifest of "+ref.String())
+	}
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := strings.TrimSpace(strings.Split(res.Header.Get("Content-Type"), ";")[0])
+	if mediaType == "" || mediaType == "application/json" {
+		m := struct {
+			MediaType string `json:"mediaType"`
+		}{}
+		json.Unmarshal(content, &m)
+		mediaType = m.MediaType
+	}
+	switch mediaType {
+	case MediaTypeOCIManifest, MediaTypeDockerManifest:
+		return content, mediaType, nil
+	case MediaTypeOCIIndex, MediaTypeDockerManifestList:
+		index := manifestIndex{}
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, "", fmt.Errorf("Unable to parse the manifest list of %s: %v", ref, err)
+		}
+		for _, m := range index.Manifests {
+			if m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+				platformRef := *ref
+				platformRef.Digest = m.Digest
+				return c.GetManifest(&platformRef)
+			}
+		}
+		return nil, "", fmt.Errorf("The image %s is not available for %s/%s", ref, runtime.GOOS, runtime.GOARCH)
+	default:
+		return nil, "", fmt.Errorf("Unsupported manifest type %q of %s", mediaType, ref)
+	}
+}
+
+// ImageExists returns true if the manifest of the image ref exists
+func (c *Client) ImageExists(ref *Reference) (bool, error) {
+	res, err := c.do("HEAD", ref, "manifests/"+ref.reference(), pullScope(ref), http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(res, "check the manifest of "+ref.String())
+	}
+}
+
+// GetBlob returns the content of a blob of the repository of ref
+func (c *Client) GetBlob(ref *Reference, digest string) (io.ReadCloser, error) {
+	res, err := c.do("GET", ref, "blobs/"+digest, pullScope(ref), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, responseError(res, fmt.Sprintf("get the blob %s of %s", digest, ref.Repository))
+	}
+	return res.Body, nil
+}
+
+// BlobExists returns true if the repository of ref contains the given blob
+func (c *Client) BlobExists(ref *Reference, digest string) (bool, error) {
+	res, err := c.do("HEAD", ref, "blobs/"+digest, pushScope(ref), nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(res, fmt.Sprintf("check the blob %s of %s", digest, ref.Repository))
+	}
+}
+
+// PushBlob uploads a blob to the repository of ref unless it already exists. If mountFrom is not
+// empty the blob is mounted from that repository of the same registry when possible. Otherwise
+// the content returned by open is uploaded
+func (c *Client) PushBlob(ref *Reference, digest string, size int64, mountFrom string, open func() (io.ReadCloser, error)) error {
+	exists, err := c.BlobExists(ref, digest)
+	if err != nil || exists {
+		return err
+	}
+	path := "blobs/uploads/"
+	scopes := pushScope(ref)
+	if mountFrom != "" && mountFrom != ref.Repository {
+		path += "?" + url.Values{"mount": {digest}, "from": {mountFrom}}.Encode()
+		scopes = pushScope(ref, mountFrom)
+	}
+	res, err := c.do("POST", ref, path, scopes, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+		// The blob has been mounted
+		return nil
+	case http.StatusAccepted:
+	default:
+		return responseError(res, fmt.Sprintf("upload the blob %s to %s", digest, ref.Repository))
+	}
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil || res.Header.Get("Location") == "" {
+		return fmt.Errorf("Invalid upload location %q for %s", res.Header.Get("Location"), ref.Repository)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+	content, err := open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	res, err = c.do("PUT", ref, location.String(), pushScope(ref), http.Header{"Content-Type": {"application/octet-stream"}}, content, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return responseError(res, fmt.Sprintf("upload the blob %s to %s", digest, ref.Repository))
+	}
+	return nil
+}
+
+// PutManifest stores the manifest of the image ref
+func (c *Client) PutManifest(ref *Reference, mediaType string, manifest []byte) error {
+	res, err := c.do("PUT", ref, "manifests/"+ref.reference(), pushScope(ref), http.Header{"Content-Type": {mediaType}}, strings.NewReader(string(manifest)), int64(len(manifest)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return responseError(res, "push the manifest of "+ref.String())
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+// normalizeRegistry returns the host of a registry address, the different hosts
+// of the Docker Hub are considered the same
+func normalizeRegistry(registry string) string {
+	host := registry
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", dockerHubAPI:
+		return dockerHub
+	}
+	return host
+}
+
+// DockerConfigFile returns the docker configuration file with the registry credentials. The
+// secret mounted at DOCKER_CONFIG_FOLDER is preferred over the configuration of the user
+func DockerConfigFile() string {
+	candidates := []string{}
+	if dir := os.Getenv("DOCKER_CONFIG_FOLDER"); dir != "" {
+		// Kubernetes ImagePullSecrets use .dockerconfigjson as the file name
+		candidates = append(candidates, path.Join(dir, ".dockerconfigjson"), path.Join(dir, "config.json"))
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		candidates = append(candidates, path.Join(home, ".docker", "config.json"))
+	}
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return ""
+}
+
+// DockerConfigCredentials returns the credentials stored in a docker configuration file.
+// An empty file name returns anonymous credentials
+func DockerConfigCredentials(file string) (Credentials, error) {
+	creds := map[string][2]string{}
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		config := dockerConfig{}
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %v", file, err)
+		}
+		for registry, auth := range config.Auths {
+			user, password := auth.Username, auth.Password
+			if auth.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("Unable to decode the credentials of %s: %v", registry, err)
+				}
+				parts := strings.SplitN(string(decoded), ":", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("Invalid credentials for %s", registry)
+				}
+				user, password = parts[0], parts[1]
+			}
+			creds[normalizeRegistry(registry)] = [2]string{user, password}
+		}
+	}
+	return func(registry string) (string, string) {
+		c := creds[normalizeRegistry(registry)]
+		return c[0], c[1]
+	}, nil
+}
+
+// StaticCredentials returns the credentials with the format user:password for the given
+// registry, falling back to the credentials of fallback for any other registry
+func StaticCredentials(registry, userPassword string, fallback Credentials) (Credentials, error) {
+	parts := strings.SplitN(userPassword, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Credentials should have the format user:password")
+	}
+	return func(r string) (string, string) {
+		if normalizeRegistry(r) == normalizeRegistry(registry) {
+			return parts[0], parts[1]
+		}
+		return fallback(r)
+	}, nil
+}
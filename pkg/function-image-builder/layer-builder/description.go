@@ -45,6 +45,10 @@ type Config struct {
 	Entrypoint   interface{}
 	OnBuild      interface{}
 	Labels       interface{}
+	ExposedPorts interface{} `json:",omitempty"`
+	Healthcheck  interface{} `json:",omitempty"`
+	StopSignal   string      `json:",omitempty"`
+	Shell        []string    `json:",omitempty"`
 }
 
 // HistoryEntry represents a layer creation info
@@ -71,6 +75,9 @@ type Description struct {
 	DockerVersion   string         `json:"docker_version"`
 	History         []HistoryEntry `json:"history"`
 	OS              string         `json:"os"`
+	OSVersion       string         `json:"os.version,omitempty"`
+	Variant         string         `json:"variant,omitempty"`
+	Author          string         `json:"author,omitempty"`
 	Rootfs          Rootfs         `json:"rootfs"`
 }
 
@@ -97,7 +104,11 @@ func (d *Description) AddLayer(newLayer *Layer) {
 		Created: time.Now().UTC().Format(time.RFC3339),
 		Comment: "Created by Kubeless",
 	})
-	d.Rootfs.DiffIds = append(d.Rootfs.DiffIds, fmt.Sprintf("sha256:%s", newLayer.Sha256))
+	diffID := newLayer.DiffID
+	if diffID == "" {
+		diffID = newLayer.Sha256
+	}
+	d.Rootfs.DiffIds = append(d.Rootfs.DiffIds, fmt.Sprintf("sha256:%s", diffID))
 }
 
 // Content returns the description content
@@ -17,8 +17,10 @@ limitations under the License.
 package layerbuilder
 
 import (
+	"compress/gzip"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 )
@@ -27,6 +29,8 @@ import (
 type Layer struct {
 	Size   int64
 	Sha256 string
+	// DiffID is the checksum of the uncompressed content of the layer
+	DiffID string
 }
 
 // New returns a Layer based on its file
@@ -46,3 +50,36 @@ func (f *Layer) New(layerFile *os.File) error {
 	f.Size = fstat.Size()
 	return nil
 }
+
+// compressLayer gzips a tar file into dir and returns the resulting Layer and its path
+func compressLayer(tarFile, dir string) (*Layer, string, error) {
+	src, err := os.Open(tarFile)
+	if err != nil {
+		return nil, "", err
+	}
+	defer src.Close()
+	dst, err := ioutil.TempFile(dir, "layer")
+	if err != nil {
+		return nil, "", err
+	}
+	defer dst.Close()
+
+	compressedSha := sha256.New()
+	uncompressedSha := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(dst, compressedSha))
+	if _, err := io.Copy(io.MultiWriter(gz, uncompressedSha), src); err != nil {
+		return nil, "", fmt.Errorf("Unable to compress %s: %v", tarFile, err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", fmt.Errorf("Unable to compress %s: %v", tarFile, err)
+	}
+	fstat, err := dst.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	return &Layer{
+		Size:   fstat.Size(),
+		Sha256: fmt.Sprintf("%x", compressedSha.Sum(nil)),
+		DiffID: fmt.Sprintf("%x", uncompressedSha.Sum(nil)),
+	}, dst.Name(), nil
+}
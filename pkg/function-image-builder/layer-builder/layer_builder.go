@@ -24,103 +24,80 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"strings"
 )
 
-func copyReader(src io.Reader, dst string) error {
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, src)
-	if err != nil {
-		return err
-	}
-	err = dstFile.Sync()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func copyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	return copyReader(srcFile, dst)
-}
-
-func getLayer(file string) (*Layer, error) {
-	layerFile, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer layerFile.Close()
-	layer := Layer{}
-	err = layer.New(layerFile)
-	if err != nil {
-		return nil, err
-	}
-	return &layer, nil
-}
-
-func saveNewDescription(content []byte, dir, contentChecksum string) error {
-	dLayerFile := path.Join(dir, contentChecksum)
-	return copyReader(bytes.NewReader(content), dLayerFile)
-}
-
-func updateDescription(descriptionDir string, descriptionFile *os.File, newLayer *Layer) (*Description, error) {
+func updateDescription(descriptionFile io.Reader, newLayer *Layer) (*Description, error) {
 	d := Description{}
 	err := d.New(descriptionFile)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to parse image description: %v", err)
 	}
 	d.AddLayer(newLayer)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to update image description: %v", err)
-	}
 	return &d, nil
 }
 
-// AddTarToLayer copies a tar file into a image directory and update its metadata
-func AddTarToLayer(imageDir, tarFile string) error {
-	tarLayer, err := getLayer(tarFile)
+// AddLayer pulls the image src, appends the content of a tar file as a new layer and pushes
+// the resulting image to dst. The compressed layer is stored in workDir while it is uploaded
+func AddLayer(c *Client, src, dst *Reference, tarFile, workDir string) error {
+	// Parse manifest
+	manifestContent, mediaType, err := c.GetManifest(src)
 	if err != nil {
 		return err
 	}
-	destFile := path.Join(imageDir, tarLayer.Sha256)
-	err = copyFile(tarFile, destFile)
+	m := Manifest{}
+	err = m.New(bytes.NewReader(manifestContent))
 	if err != nil {
-		return fmt.Errorf("Failed to copy tar file: %v", err)
+		return fmt.Errorf("Failed to parse image manifest: %v", err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = mediaType
 	}
-	log.Printf("Copied source %s to %s", tarFile, destFile)
+	log.Printf("Parsed manifest of %s", src)
 
-	// Parse manifest
-	manifestPath := path.Join(imageDir, "manifest.json")
-	manifestFile, err := os.Open(manifestPath)
+	// Compress the new layer
+	tarLayer, layerFile, err := compressLayer(tarFile, workDir)
 	if err != nil {
 		return err
 	}
-	m := Manifest{}
-	err = m.New(manifestFile)
+	defer os.Remove(layerFile)
+	log.Printf("Compressed %s as sha256:%s", tarFile, tarLayer.Sha256)
+
+	// Copy the base layers, mounting them when both images are stored in the same registry
+	mountFrom := ""
+	if src.host() == dst.host() {
+		mountFrom = src.Repository
+	}
+	for _, l := range m.Layers {
+		if l.foreign() {
+			// Foreign layers are downloaded from their URLs instead of the registry
+			continue
+		}
+		digest := l.Digest
+		err = c.PushBlob(dst, digest, l.Size, mountFrom, func() (io.ReadCloser, error) {
+			return c.GetBlob(src, digest)
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Copied layer %s", digest)
+	}
+
+	// Push the new layer
+	err = c.PushBlob(dst, "sha256:"+tarLayer.Sha256, tarLayer.Size, "", func() (io.ReadCloser, error) {
+		return os.Open(layerFile)
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to parse image manifest: %v", err)
+		return err
 	}
-	log.Printf("Parsed manifest")
+	log.Printf("Pushed layer sha256:%s", tarLayer.Sha256)
 
 	// Update description
-	descriptionPath := path.Join(imageDir, strings.Replace(m.Config.Digest, "sha256:", "", -1))
-	descriptionFile, err := os.Open(descriptionPath)
+	descriptionFile, err := c.GetBlob(src, m.Config.Digest)
 	if err != nil {
 		return err
 	}
-	description, err := updateDescription(imageDir, descriptionFile, tarLayer)
+	description, err := updateDescription(descriptionFile, tarLayer)
+	descriptionFile.Close()
 	if err != nil {
 		return err
 	}
@@ -132,7 +109,9 @@ func AddTarToLayer(imageDir, tarFile string) error {
 	if err != nil {
 		return err
 	}
-	err = saveNewDescription(descriptionContent, imageDir, descriptionLayer.Sha256)
+	err = c.PushBlob(dst, "sha256:"+descriptionLayer.Sha256, descriptionLayer.Size, "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(descriptionContent)), nil
+	})
 	if err != nil {
 		return err
 	}
@@ -145,11 +124,11 @@ func AddTarToLayer(imageDir, tarFile string) error {
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(manifestPath, mBytes, 0644)
+	err = c.PutManifest(dst, m.MediaType, mBytes)
 	if err != nil {
 		return err
 	}
-	log.Printf("Updated manifest")
+	log.Printf("Updated manifest of %s", dst)
 
 	return nil
 }
@@ -0,0 +1,321 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is an in-memory registry that implements the subset of the Docker Registry
+// HTTP API V2 used by the client
+type fakeRegistry struct {
+	mu        sync.Mutex
+	token     string
+	blobs     map[string]map[string][]byte
+	manifests map[string]map[string][]byte
+	types     map[string]string
+	uploads   int
+	mounts    int
+}
+
+var (
+	manifestPath = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	blobPath     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[0-9a-f]+)$`)
+	uploadPath   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/(\w*)$`)
+)
+
+func newFakeRegistry(token string) *fakeRegistry {
+	return &fakeRegistry{
+		token:     token,
+		blobs:     map[string]map[string][]byte{},
+		manifests: map[string]map[string][]byte{},
+		types:     map[string]string{},
+	}
+}
+
+func digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func (r *fakeRegistry) addBlob(repo string, content []byte) string {
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = map[string][]byte{}
+	}
+	d := digest(content)
+	r.blobs[repo][d] = content
+	return d
+}
+
+func (r *fakeRegistry) addManifest(repo, ref, mediaType string, content []byte) {
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = map[string][]byte{}
+	}
+	r.manifests[repo][ref] = content
+	r.manifests[repo][digest(content)] = content
+	r.types[digest(content)] = mediaType
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if user, password, ok := req.BasicAuth(); !ok || user != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+	if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch p := req.URL.Path; {
+	case p == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case manifestPath.MatchString(p):
+		m := manifestPath.FindStringSubmatch(p)
+		switch req.Method {
+		case "GET", "HEAD":
+			content, ok := r.manifests[m[1]][m[2]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+				return
+			}
+			w.Header().Set("Content-Type", r.types[digest(content)])
+			w.Write(content)
+		case "PUT":
+			content, _ := ioutil.ReadAll(req.Body)
+			r.addManifest(m[1], m[2], req.Header.Get("Content-Type"), content)
+			w.WriteHeader(http.StatusCreated)
+		}
+	case blobPath.MatchString(p):
+		m := blobPath.FindStringSubmatch(p)
+		content, ok := r.blobs[m[1]][m[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == "GET" {
+			w.Write(content)
+		}
+	case uploadPath.MatchString(p):
+		m := uploadPath.FindStringSubmatch(p)
+		switch req.Method {
+		case "POST":
+			if from := req.URL.Query().Get("from"); from != "" {
+				if content, ok := r.blobs[from][req.URL.Query().Get("mount")]; ok {
+					r.addBlob(m[1], content)
+					r.mounts++
+					w.WriteHeader(http.StatusCreated)
+					return
+				}
+			}
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/upload%d", m[1], r.uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case "PUT":
+			content, _ := ioutil.ReadAll(req.Body)
+			if d := req.URL.Query().Get("digest"); d != digest(content) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`)
+				return
+			}
+			r.addBlob(m[1], content)
+			r.uploads++
+			w.WriteHeader(http.StatusCreated)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// addBaseImage stores an image with a single layer and returns its manifest
+func (r *fakeRegistry) addBaseImage(repo, tag string, oci bool) Manifest {
+	layerContent := []byte("base layer")
+	d := Description{OS: "linux", Arch: "amd64", Rootfs: Rootfs{Type: "layers", DiffIds: []string{digest(layerContent)}}}
+	d.Config.Cmd = []string{"node", "kubeless.js"}
+	config, _ := d.Content()
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifest,
+		Config:        layer{MediaType: MediaTypeDockerConfig, Size: int64(len(config)), Digest: r.addBlob(repo, config)},
+		Layers: []layer{
+			{MediaType: MediaTypeDockerLayer, Size: int64(len(layerContent)), Digest: r.addBlob(repo, layerContent)},
+			{MediaType: MediaTypeDockerForeignLayer, Size: 10, Digest: digest([]byte("foreign")), URLs: []string{"http://example.com/layer"}},
+		},
+	}
+	if oci {
+		m.MediaType = MediaTypeOCIManifest
+		m.Config.MediaType = MediaTypeOCIConfig
+		m.Layers[0].MediaType = MediaTypeOCILayer
+		m.Layers[1].MediaType = MediaTypeOCIForeignLayer
+	}
+	content, _ := json.Marshal(m)
+	r.addManifest(repo, tag, m.MediaType, content)
+	return m
+}
+
+func writeTar(t *testing.T, dir string) string {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	content := []byte("module.exports = {}")
+	tw.WriteHeader(&tar.Header{Name: "kubeless/handler.js", Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	file := path.Join(dir, "bundle.tar")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestClient(t *testing.T, creds Credentials) *Client {
+	c, err := NewClient(true, nil, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAddLayer(t *testing.T) {
+	tests := []struct {
+		name      string
+		oci       bool
+		token     string
+		dstRepo   string
+		expMedia  string
+		expLayer  string
+		expMounts int
+	}{
+		{name: "docker", dstRepo: "user/func", expMedia: MediaTypeDockerManifest, expLayer: MediaTypeDockerLayer, expMounts: 1},
+		{name: "oci", oci: true, dstRepo: "user/func", expMedia: MediaTypeOCIManifest, expLayer: MediaTypeOCILayer, expMounts: 1},
+		{name: "authenticated", token: "secret", dstRepo: "user/func", expMedia: MediaTypeDockerManifest, expLayer: MediaTypeDockerLayer, expMounts: 1},
+		{name: "same repository", dstRepo: "kubeless/runtime", expMedia: MediaTypeDockerManifest, expLayer: MediaTypeDockerLayer, expMounts: 0},
+	}
+	for _, test := range tests {
+		workDir, err := ioutil.TempDir("", "build")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(workDir)
+		registry := newFakeRegistry(test.token)
+		base := registry.addBaseImage("kubeless/runtime", "1.0", test.oci)
+		server := httptest.NewServer(registry)
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		src, _ := ParseReference(fmt.Sprintf("docker://%s/kubeless/runtime:1.0", host))
+		dst, _ := ParseReference(fmt.Sprintf("docker://%s/%s:abc", host, test.dstRepo))
+		creds := func(string) (string, string) { return "user", "pass" }
+		err = AddLayer(newTestClient(t, creds), src, dst, writeTar(t, workDir), workDir)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+
+		content, ok := registry.manifests[test.dstRepo]["abc"]
+		if !ok {
+			t.Fatalf("%s: manifest not pushed", test.name)
+		}
+		m := Manifest{}
+		if err := json.Unmarshal(content, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.MediaType != test.expMedia || registry.types[digest(content)] != test.expMedia {
+			t.Errorf("%s: unexpected media type %s", test.name, m.MediaType)
+		}
+		if len(m.Layers) != 3 || m.Layers[0].Digest != base.Layers[0].Digest || len(m.Layers[1].URLs) != 1 {
+			t.Fatalf("%s: unexpected layers %v", test.name, m.Layers)
+		}
+		if m.Layers[2].MediaType != test.expLayer {
+			t.Errorf("%s: unexpected layer media type %s", test.name, m.Layers[2].MediaType)
+		}
+		if registry.mounts != test.expMounts {
+			t.Errorf("%s: expecting %d mounted blobs, got %d", test.name, test.expMounts, registry.mounts)
+		}
+		for _, l := range append([]layer{m.Config}, m.Layers[0], m.Layers[2]) {
+			if _, ok := registry.blobs[test.dstRepo][l.Digest]; !ok {
+				t.Errorf("%s: blob %s not pushed", test.name, l.Digest)
+			}
+		}
+		if _, ok := registry.blobs[test.dstRepo][m.Layers[1].Digest]; ok {
+			t.Errorf("%s: foreign layer should not be pushed", test.name)
+		}
+
+		// The new layer is gzipped and the configuration references its uncompressed content
+		gz, err := gzip.NewReader(bytes.NewReader(registry.blobs[test.dstRepo][m.Layers[2].Digest]))
+		if err != nil {
+			t.Fatalf("%s: layer is not compressed: %v", test.name, err)
+		}
+		uncompressed, _ := ioutil.ReadAll(gz)
+		d := Description{}
+		if err := json.Unmarshal(registry.blobs[test.dstRepo][m.Config.Digest], &d); err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Rootfs.DiffIds) != 2 || d.Rootfs.DiffIds[1] != digest(uncompressed) {
+			t.Errorf("%s: unexpected diff ids %v", test.name, d.Rootfs.DiffIds)
+		}
+		if len(d.Config.Cmd) != 2 {
+			t.Errorf("%s: the configuration of the base image should be kept, got %v", test.name, d.Config)
+		}
+	}
+}
+
+func TestAddLayerErrors(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	registry := newFakeRegistry("secret")
+	registry.addBaseImage("kubeless/runtime", "1.0", false)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	dst, _ := ParseReference(fmt.Sprintf("docker://%s/user/func:abc", host))
+
+	// Unknown base image
+	src, _ := ParseReference(fmt.Sprintf("docker://%s/kubeless/runtime:2.0", host))
+	creds := func(string) (string, string) { return "user", "pass" }
+	err = AddLayer(newTestClient(t, creds), src, dst, writeTar(t, workDir), workDir)
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("Expecting a manifest unknown error, got %v", err)
+	}
+
+	// Wrong credentials
+	src, _ = ParseReference(fmt.Sprintf("docker://%s/kubeless/runtime:1.0", host))
+	creds = func(string) (string, string) { return "user", "wrong" }
+	err = AddLayer(newTestClient(t, creds), src, dst, writeTar(t, workDir), workDir)
+	if err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("Expecting an authentication error, got %v", err)
+	}
+}
@@ -23,18 +23,40 @@ import (
 	"io/ioutil"
 )
 
+// Media types of the manifests and layers supported
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCIForeignLayer    = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+)
+
 type layer struct {
-	MediaType string `json:"mediaType"`
-	Size      int64  `json:"size"`
-	Digest    string `json:"digest"`
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// foreign returns true if the layer cannot be pushed to a registry
+func (l *layer) foreign() bool {
+	return l.MediaType == MediaTypeDockerForeignLayer || l.MediaType == MediaTypeOCIForeignLayer
 }
 
 // Manifest represent the manifest.json of an image
 type Manifest struct {
-	SchemaVersion int     `json:"schemaVersion"`
-	MediaType     string  `json:"mediaType"`
-	Config        layer   `json:"config"`
-	Layers        []layer `json:"layers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        layer             `json:"config"`
+	Layers        []layer           `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
 }
 
 // New parses an io.Reader into a Manifest
@@ -43,11 +65,12 @@ func (m *Manifest) New(manifestFile io.Reader) error {
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(manifestContent, m)
-	if err != nil {
-		return nil
-	}
-	return nil
+	return json.Unmarshal(manifestContent, m)
+}
+
+// OCI returns true if the manifest follows the OCI image specification
+func (m *Manifest) OCI() bool {
+	return m.MediaType == MediaTypeOCIManifest || (m.MediaType == "" && m.Config.MediaType == MediaTypeOCIConfig)
 }
 
 // UpdateConfig overrides the Config information of the manifest with a new Layer
@@ -58,8 +81,12 @@ func (m *Manifest) UpdateConfig(newConfig *Layer) {
 
 // AddLayer adds a new layer to the list in the Manifest
 func (m *Manifest) AddLayer(newLayer *Layer) {
+	mediaType := MediaTypeDockerLayer
+	if m.OCI() {
+		mediaType = MediaTypeOCILayer
+	}
 	m.Layers = append(m.Layers, layer{
-		MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+		MediaType: mediaType,
 		Size:      newLayer.Size,
 		Digest:    fmt.Sprintf("sha256:%s", newLayer.Sha256),
 	})
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// dockerHub is the registry used for the images without a registry host
+	dockerHub = "docker.io"
+	// dockerHubAPI is the host that serves the registry API of the Docker Hub
+	dockerHubAPI = "registry-1.docker.io"
+)
+
+// Reference identifies an image in a Docker registry
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference with the format docker://[registry/]repository[:tag|@digest]
+func ParseReference(ref string) (*Reference, error) {
+	name := ref
+	if i := strings.Index(name, "://"); i >= 0 {
+		if transport := name[:i]; transport != "docker" {
+			return nil, fmt.Errorf("Unsupported image transport %s in %s", transport, ref)
+		}
+		name = strings.TrimPrefix(name[i+3:], "//")
+	}
+	r := &Reference{}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !strings.HasPrefix(r.Digest, "sha256:") {
+			return nil, fmt.Errorf("Invalid digest in %s", ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Registry, name = parts[0], parts[1]
+	} else {
+		r.Registry = dockerHub
+		if len(parts) == 1 {
+			// Official images of the Docker Hub
+			name = "library/" + name
+		}
+	}
+	if name == "" || strings.ToLower(name) != name {
+		return nil, fmt.Errorf("Invalid repository name in %s", ref)
+	}
+	r.Repository = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// host returns the host that serves the registry API
+func (r *Reference) host() string {
+	if r.Registry == dockerHub {
+		return dockerHubAPI
+	}
+	return r.Registry
+}
+
+// reference returns the digest of the image if it is known or its tag
+func (r *Reference) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	res := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		res += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		res += "@" + r.Digest
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected Reference
+		err      bool
+	}{
+		{ref: "docker://kubeless/python:2.7", expected: Reference{Registry: "docker.io", Repository: "kubeless/python", Tag: "2.7"}},
+		{ref: "python", expected: Reference{Registry: "docker.io", Repository: "library/python", Tag: "latest"}},
+		{ref: "localhost:5000/user/func", expected: Reference{Registry: "localhost:5000", Repository: "user/func", Tag: "latest"}},
+		{ref: "docker://quay.io/user/func:abc@sha256:123", expected: Reference{Registry: "quay.io", Repository: "user/func", Tag: "abc", Digest: "sha256:123"}},
+		{ref: "registry.local/func@sha256:123", expected: Reference{Registry: "registry.local", Repository: "func", Digest: "sha256:123"}},
+		{ref: "dir://path/to/image", err: true},
+		{ref: "user/func@md5:123", err: true},
+		{ref: "User/Func", err: true},
+	}
+	for _, test := range tests {
+		res, err := ParseReference(test.ref)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expecting an error parsing %s", test.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", test.ref, err)
+			continue
+		}
+		if *res != test.expected {
+			t.Errorf("Unexpected reference for %s: %+v", test.ref, *res)
+		}
+	}
+}
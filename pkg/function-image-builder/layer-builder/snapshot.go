@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// whiteoutPrefix is the prefix of the entries that mark a file of a lower layer as deleted
+const whiteoutPrefix = ".wh."
+
+// Snapshot contains the state (modification time and size) of the files of a directory
+type Snapshot map[string]string
+
+func fileState(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+}
+
+// TakeSnapshot returns the state of the files and links under dir
+func TakeSnapshot(dir string) (Snapshot, error) {
+	s := Snapshot{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			s[file] = fileState(info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save stores the snapshot in a file, one line per file with the format "<mtime> <size> <path>"
+func (s Snapshot) Save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	files := []string{}
+	for k := range s {
+		files = append(files, k)
+	}
+	sort.Strings(files)
+	w := bufio.NewWriter(f)
+	for _, k := range files {
+		fmt.Fprintf(w, "%s %s\n", s[k], k)
+	}
+	return w.Flush()
+}
+
+// LoadSnapshot reads a snapshot stored with Save
+func LoadSnapshot(file string) (Snapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := Snapshot{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("Invalid line %q in %s", scanner.Text(), file)
+		}
+		s[parts[2]] = parts[0] + " " + parts[1]
+	}
+	return s, scanner.Err()
+}
+
+// Changed returns the files of the snapshot that don't exist or are different in base
+func (s Snapshot) Changed(base Snapshot) []string {
+	files := []string{}
+	for f, state := range s {
+		if base[f] != state {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Deleted returns the files of base that don't exist in the snapshot. If the directory of a file
+// has been removed, the topmost directory that doesn't exist anymore is returned instead
+func (s Snapshot) Deleted(base Snapshot) []string {
+	deleted := map[string]bool{}
+	for f := range base {
+		if _, ok := s[f]; ok {
+			continue
+		}
+		for {
+			parent := filepath.Dir(f)
+			if _, err := os.Lstat(parent); err == nil || parent == f {
+				break
+			}
+			f = parent
+		}
+		deleted[f] = true
+	}
+	files := []string{}
+	for f := range deleted {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// WriteTar stores the given files in a tar file. The entries are named after the absolute path
+// of the files without the leading slash so the tar can be used as an image layer. The deleted
+// files are stored as whiteouts so they are removed from the lower layers of the image
+func WriteTar(tarFile string, files, deleted []string) error {
+	f, err := os.Create(tarFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, file := range deleted {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:     strings.TrimPrefix(filepath.Join(filepath.Dir(abs), whiteoutPrefix+filepath.Base(abs)), "/"),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		info, err := os.Lstat(file)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(abs, "/")
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			content, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, content)
+			content.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// ExtractLayer extracts the last layer of an image in the root of the filesystem. Only the
+// entries under dir are extracted and the files marked by whiteouts are removed. It returns
+// the snapshot of the extracted files
+func ExtractLayer(c *Client, ref *Reference, dir string) (Snapshot, error) {
+	manifestContent, _, err := c.GetManifest(ref)
+	if err != nil {
+		return nil, err
+	}
+	m := Manifest{}
+	if err := m.New(strings.NewReader(string(manifestContent))); err != nil {
+		return nil, fmt.Errorf("Failed to parse image manifest: %v", err)
+	}
+	if len(m.Layers) == 0 {
+		return nil, fmt.Errorf("The image %s doesn't have any layer", ref)
+	}
+	blob, err := c.GetBlob(ref, m.Layers[len(m.Layers)-1].Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	gz, err := gzip.NewReader(blob)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decompress the layer of %s: %v", ref, err)
+	}
+	dir = filepath.Clean(dir)
+	s := Snapshot{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the layer of %s: %v", ref, err)
+		}
+		target := filepath.Join("/", hdr.Name)
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return nil, fmt.Errorf("The layer of %s contains the file %s outside of %s", ref, hdr.Name, dir)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if base := filepath.Base(target); strings.HasPrefix(base, whiteoutPrefix) {
+			deleted := filepath.Join(filepath.Dir(target), strings.TrimPrefix(base, whiteoutPrefix))
+			if err := os.RemoveAll(deleted); err != nil {
+				return nil, err
+			}
+			for f := range s {
+				if f == deleted || strings.HasPrefix(f, deleted+string(filepath.Separator)) {
+					delete(s, f)
+				}
+			}
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return nil, err
+			}
+			continue
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported entry %s of type %c in the layer of %s", hdr.Name, hdr.Typeflag, ref)
+		}
+		info, err := os.Lstat(target)
+		if err != nil {
+			return nil, err
+		}
+		s[target] = fileState(info)
+	}
+	return s, nil
+}
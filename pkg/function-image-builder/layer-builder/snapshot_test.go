@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package layerbuilder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "handler.py"), []byte("code"), 0644)
+	ioutil.WriteFile(path.Join(dir, "requirements.txt"), []byte("requests"), 0644)
+	before, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := path.Join(dir, "..", path.Base(dir)+".list")
+	defer os.Remove(list)
+	if err := before.Save(list); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, before) {
+		t.Errorf("Expecting %v, got %v", before, loaded)
+	}
+
+	os.MkdirAll(path.Join(dir, "lib", "requests"), 0755)
+	ioutil.WriteFile(path.Join(dir, "lib", "requests", "__init__.py"), []byte("lib"), 0644)
+	os.Symlink("requests", path.Join(dir, "lib", "link"))
+	ioutil.WriteFile(path.Join(dir, "handler.py"), []byte("new code"), 0644)
+	after, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{path.Join(dir, "handler.py"), path.Join(dir, "lib", "link"), path.Join(dir, "lib", "requests", "__init__.py")}
+	if changed := after.Changed(before); !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expecting %v, got %v", expected, changed)
+	}
+	if deleted := after.Deleted(before); len(deleted) != 0 {
+		t.Errorf("Unexpected deleted files %v", deleted)
+	}
+
+	// Removed directories are reported instead of their files
+	os.Remove(path.Join(dir, "requirements.txt"))
+	os.RemoveAll(path.Join(dir, "lib"))
+	current, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{path.Join(dir, "lib"), path.Join(dir, "requirements.txt")}
+	if deleted := current.Deleted(after); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expecting %v, got %v", expected, deleted)
+	}
+}
+
+func TestExtractLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workDir, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Push an image with the dependencies as last layer
+	os.MkdirAll(path.Join(dir, "node_modules", "lodash"), 0755)
+	ioutil.WriteFile(path.Join(dir, "node_modules", "lodash", "index.js"), []byte("lodash"), 0644)
+	os.Symlink("lodash/index.js", path.Join(dir, "node_modules", "index.js"))
+	deps, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depsTar := path.Join(workDir, "deps.tar")
+	ioutil.WriteFile(path.Join(dir, "stale.js"), []byte("stale"), 0644)
+	if err := WriteTar(depsTar, deps.Changed(Snapshot{}), []string{path.Join(dir, "stale.js")}); err != nil {
+		t.Fatal(err)
+	}
+	registry := newFakeRegistry("")
+	registry.addBaseImage("kubeless/runtime", "1.0", false)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	src, _ := ParseReference(fmt.Sprintf("%s/kubeless/runtime:1.0", host))
+	cache, _ := ParseReference(fmt.Sprintf("%s/user/kubeless-deps:nodejs8-abc", host))
+	c := newTestClient(t, nil)
+	if exists, err := c.ImageExists(cache); err != nil || exists {
+		t.Fatalf("The image should not exist yet: %v", err)
+	}
+	if err := AddLayer(c, src, cache, depsTar, workDir); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.ImageExists(cache); err != nil || !exists {
+		t.Fatalf("The image should exist: %v", err)
+	}
+
+	// Restore the layer in a clean directory
+	os.RemoveAll(path.Join(dir, "node_modules"))
+	restored, err := ExtractLayer(c, cache, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, "node_modules", "index.js"))
+	if err != nil || string(content) != "lodash" {
+		t.Errorf("Unexpected content %q: %v", content, err)
+	}
+	current, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := current.Changed(restored); len(changed) != 0 {
+		t.Errorf("The restored files should be part of the snapshot, changed: %v", changed)
+	}
+	// The whiteouts of the layer remove the deleted files
+	if _, err := os.Stat(path.Join(dir, "stale.js")); !os.IsNotExist(err) {
+		t.Errorf("Expecting stale.js to be removed: %v", err)
+	}
+
+	// Layers are only extracted inside the given directory
+	other, err := ioutil.TempDir("", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	if _, err := ExtractLayer(c, cache, other); err == nil || !strings.Contains(err.Error(), "outside of") {
+		t.Errorf("Expecting an error extracting files outside of %s, got %v", other, err)
+	}
+}
@@ -17,18 +17,24 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
 	"golang.org/x/net/context"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kubeless/kubeless/pkg/function-proxy/utils"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// functionAddr is the address where the function process listens
+const functionAddr = "localhost:8090"
+
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
 		for _, v := range vv {
@@ -37,53 +43,125 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
-func handle(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+func handle(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	client := &http.Client{}
-	req, err := http.NewRequest(r.Method, "http://localhost:8090", r.Body)
+	req, err := http.NewRequest(r.Method, "http://"+functionAddr, r.Body)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
+	// Cancel the request to the function process if the timeout is exceeded
+	req = req.WithContext(ctx)
 	copyHeaders(req.Header, r.Header)
 	req.ContentLength = r.ContentLength
 	response, err := client.Do(req)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	return ioutil.ReadAll(response.Body)
+	return utils.WriteResponse(w, response)
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	utils.Handler(w, r, handle)
+	utils.ProtocolHandler(w, r, functionAddr, handle)
 }
 
-func health(w http.ResponseWriter, r *http.Request) {
-	rr, err := http.Get("http://localhost:8090/healthz")
-	res, _ := ioutil.ReadAll(rr.Body)
-	log.Println(string(res))
+// checkFunction returns an error if the function process doesn't answer its health check
+func checkFunction() error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get("http://" + functionAddr + "/healthz")
 	if err != nil {
-		log.Fatalln("localhost:8090 not responding")
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func health(w http.ResponseWriter, r *http.Request) {
+	// The function process is not available while the watchdog restarts it
+	if utils.Restarting() {
+		w.Write([]byte("OK"))
+		return
+	}
+	if err := checkFunction(); err != nil {
+		log.Printf("%s not responding: %v", functionAddr, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Internal Server error"))
-	} else {
-		w.Write([]byte("OK"))
+		return
 	}
+	w.Write([]byte("OK"))
 }
 
-func startNativeDaemon() {
-	args := os.Getenv("FUNC_PROCESS")
-	cmd := exec.Command("/bin/sh", "-c", args)
+// ready fails while the function process is restarted or the pod is terminating so the
+// replica doesn't receive new requests
+func ready(w http.ResponseWriter, r *http.Request) {
+	if !utils.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Not ready"))
+		return
+	}
+	health(w, r)
+}
+
+// functionProcess runs the command of the function. The watchdog restarts it if it gets stuck
+type functionProcess struct {
+	mu     sync.Mutex
+	args   string
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+func (p *functionProcess) start() error {
+	cmd := exec.Command("/bin/sh", "-c", p.args)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		log.Fatalf("Unable to run %s. Received %v", args, err)
+	// Run the process in its own group so it can be killed along with its children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
 	}
+	exited := make(chan struct{})
+	p.mu.Lock()
+	p.cmd, p.exited = cmd, exited
+	p.mu.Unlock()
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		p.mu.Lock()
+		restarted := p.cmd != cmd
+		p.mu.Unlock()
+		if err != nil && !restarted {
+			log.Fatalf("Unable to run %s. Received %v", p.args, err)
+		}
+	}()
+	return nil
+}
+
+// restart kills the function process and starts it again
+func (p *functionProcess) restart() error {
+	p.mu.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.cmd = nil
+	p.mu.Unlock()
+	if cmd != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+	return p.start()
 }
 
 func main() {
-	go startNativeDaemon()
+	process := &functionProcess{args: os.Getenv("FUNC_PROCESS")}
+	if err := process.start(); err != nil {
+		log.Fatalf("Unable to run %s. Received %v", process.args, err)
+	}
+	utils.StartWatchdog(checkFunction, process.restart)
+	utils.StartAsyncWorkers(utils.BufferedHandle(handle))
 	http.HandleFunc("/", handler)
+	http.HandleFunc(utils.InvocationsPath, utils.InvocationsHandler)
 	http.HandleFunc("/healthz", health)
+	http.HandleFunc("/ready", ready)
 	http.Handle("/metrics", promhttp.Handler())
 	utils.ListenAndServe()
 }
@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// InvocationHeader selects the invocation mode of a request. Use "async" to queue the request
+	InvocationHeader = "X-Kubeless-Invocation"
+	// InvocationIDHeader contains the ID of an asynchronous invocation in the response
+	InvocationIDHeader = "X-Kubeless-Invocation-Id"
+	// InvocationsPath is the path in which the results of the asynchronous invocations are served
+	InvocationsPath = "/invocations/"
+)
+
+var (
+	queueBackend = os.Getenv("FUNC_QUEUE")
+	queueDir     = os.Getenv("FUNC_QUEUE_DIR")
+	asyncRetries = os.Getenv("FUNC_ASYNC_RETRIES")
+	asyncWorkers = os.Getenv("FUNC_ASYNC_WORKERS")
+	resultTTL    = os.Getenv("FUNC_ASYNC_RESULT_TTL")
+	// The canary of a function has its own name but shares the service of the function
+	invocationPrefix = os.Getenv("FUNC_SERVICE")
+
+	queue       Queue
+	queueOnce   sync.Once
+	workersOnce sync.Once
+)
+
+// getQueue returns the queue configured for the function (in memory by default)
+func getQueue() Queue {
+	queueOnce.Do(func() {
+		ttl := time.Hour
+		if resultTTL != "" {
+			d, err := time.ParseDuration(resultTTL)
+			if err != nil {
+				log.Fatalf("Invalid FUNC_ASYNC_RESULT_TTL %s: %v", resultTTL, err)
+			}
+			ttl = d
+		}
+		switch queueBackend {
+		case "", "memory":
+			queue = NewMemoryQueue(ttl)
+		case "file":
+			if queueDir == "" {
+				queueDir = "/var/lib/kubeless/invocations"
+			}
+			q, err := NewFileQueue(queueDir, ttl)
+			if err != nil {
+				log.Fatalf("Unable to open the invocation queue in %s: %v", queueDir, err)
+			}
+			queue = q
+		default:
+			log.Fatalf("Unknown queue backend %s", queueBackend)
+		}
+	})
+	return queue
+}
+
+// SetQueue replaces the queue used to store the asynchronous invocations. It should be called
+// before the function starts receiving requests
+func SetQueue(q Queue) {
+	queueOnce.Do(func() {})
+	queue = q
+}
+
+// StartAsyncWorkers starts processing the asynchronous invocations with the given handler.
+// Invocations stored in a persistent queue are processed even before receiving new requests
+func StartAsyncWorkers(h Handle) {
+	workersOnce.Do(func() {
+		loadFailurePolicy()
+		workers := 1
+		if asyncWorkers != "" {
+			w, err := strconv.Atoi(asyncWorkers)
+			if err != nil || w < 1 {
+				log.Fatalf("Invalid FUNC_ASYNC_WORKERS %s", asyncWorkers)
+			}
+			workers = w
+		}
+		q := getQueue()
+		for i := 0; i < workers; i++ {
+			go func() {
+				for {
+					inv, err := q.Pop(context.Background())
+					if err != nil {
+						log.Printf("Unable to read the invocation queue: %v", err)
+						time.Sleep(time.Second)
+						continue
+					}
+					processInvocation(q, inv, h)
+				}
+			}()
+		}
+	})
+}
+
+func newInvocationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// The ID starts with the name of the function so its result can be looked up in its pods
+	if invocationPrefix == "" {
+		return hex.EncodeToString(b), nil
+	}
+	return invocationPrefix + "." + hex.EncodeToString(b), nil
+}
+
+// enqueue stores a request to be processed asynchronously and returns its invocation ID
+func enqueue(w http.ResponseWriter, r *http.Request, h Handle) {
+	StartAsyncWorkers(h)
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	id, err := newInvocationID()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Error: %v", err)))
+		return
+	}
+	header := http.Header{}
+	copyHeaders(header, r.Header)
+	header.Del(InvocationHeader)
+	inv := &Invocation{
+		ID:        id,
+		Status:    InvocationQueued,
+		CreatedAt: time.Now().UTC(),
+		Request: &InvocationRequest{
+			Method: r.Method,
+			URL:    r.URL.String(),
+			Header: header,
+			Body:   body,
+		},
+	}
+	if err := getQueue().Push(inv); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Error: unable to queue the invocation: %v", err)))
+		return
+	}
+	w.Header().Set(InvocationIDHeader, id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "status": string(inv.Status)})
+}
+
+// processInvocation executes an invocation following the retry policy. Invocations
+// that keep failing are sent to the failure destination of the function
+func processInvocation(q Queue, inv *Invocation, h Handle) {
+	for {
+		inv.Status = InvocationRunning
+		inv.Attempts++
+		if err := q.Update(inv); err == ErrLeaseLost {
+			log.Printf("Invocation %s has been claimed by another replica", inv.ID)
+			return
+		} else if err != nil {
+			log.Printf("Unable to update invocation %s: %v", inv.ID, err)
+		}
+		code, res := executeInvocation(inv, h)
+		inv.StatusCode = code
+		if !failed(code) {
+			inv.Status = InvocationSucceeded
+			inv.Result = string(res)
+			inv.Error = ""
+			break
+		}
+		inv.Error = string(res)
+		log.Printf("Invocation %s failed (attempt %d): %s", inv.ID, inv.Attempts, inv.Error)
+		if inv.Attempts >= asyncRetryPolicy.MaxAttempts {
+			inv.Status = InvocationFailed
+			handleFailedEvent(newFailedEvent(inv.Request.Method, inv.Request.Header, inv.Request.Body, code, res, inv.Attempts))
+			break
+		}
+		time.Sleep(asyncRetryPolicy.Delay(inv.Attempts))
+	}
+	now := time.Now().UTC()
+	inv.CompletedAt = &now
+	if err := q.Update(inv); err != nil {
+		log.Printf("Unable to store the result of invocation %s: %v", inv.ID, err)
+	}
+}
+
+// executeInvocation runs the function with the request stored in an invocation
+func executeInvocation(inv *Invocation, h Handle) (int, []byte) {
+	r, err := http.NewRequest(inv.Request.Method, inv.Request.URL, bytes.NewReader(inv.Request.Body))
+	if err != nil {
+		return http.StatusInternalServerError, []byte(fmt.Sprintf("Error: %v", err))
+	}
+	copyHeaders(r.Header, inv.Request.Header)
+	r.ContentLength = int64(len(inv.Request.Body))
+	if r.Header.Get("event-id") == "" {
+		r.Header.Set("event-id", inv.ID)
+	}
+	var code int
+	var res []byte
+	waitConcurrency(func() {
+		code, _, res = executeRecorded(newResponseRecorder(), r, h)
+	})
+	return code, res
+}
+
+// InvocationsHandler returns the status and result of an asynchronous invocation
+func InvocationsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, InvocationsPath)
+	if r.Method != http.MethodGet || id == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	inv, err := getQueue().Get(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvocationNotFound {
+			code = http.StatusNotFound
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(fmt.Sprintf("Error: %v", err)))
+		return
+	}
+	// The request may contain sensitive information
+	inv.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
+
+// responseRecorder stores the response of a function executed asynchronously or with retries.
+// The body is limited to the maximum response size (or the default buffer size)
+type responseRecorder struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+	max        int64
+	exceeded   bool
+}
+
+func newResponseRecorder() *responseRecorder {
+	loadPayloadLimits()
+	return &responseRecorder{header: http.Header{}, max: bufferLimit(maxResponseSize)}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.exceeded || int64(r.body.Len()+len(b)) > r.max {
+		r.exceeded = true
+		return 0, errResponseTooLarge
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.statusCode == 0 {
+		r.statusCode = code
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue(time.Hour)
+	for _, id := range []string{"a", "b"} {
+		if err := q.Push(&Invocation{ID: id, Status: InvocationQueued}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := q.Push(&Invocation{ID: "a"}); err == nil {
+		t.Error("Expecting an error for a duplicated invocation")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, expected := range []string{"a", "b"} {
+		inv, err := q.Pop(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if inv.ID != expected {
+			t.Errorf("Expecting invocation %s, received %s", expected, inv.ID)
+		}
+	}
+
+	// Pop blocks until the context is done if there are no invocations
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); err == nil {
+		t.Error("Expecting an error for an empty queue")
+	}
+
+	if err := q.Update(&Invocation{ID: "a", Status: InvocationSucceeded, Result: "foo"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	inv, err := q.Get("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.Status != InvocationSucceeded || inv.Result != "foo" {
+		t.Errorf("Unexpected invocation %v", inv)
+	}
+	if _, err := q.Get("c"); err != ErrInvocationNotFound {
+		t.Errorf("Expecting a not found error, received %v", err)
+	}
+}
+
+func TestMemoryQueueExpiration(t *testing.T) {
+	q := NewMemoryQueue(time.Minute)
+	completed := time.Now().Add(-2 * time.Minute)
+	q.Push(&Invocation{ID: "old"})
+	q.Update(&Invocation{ID: "old", Status: InvocationSucceeded, CompletedAt: &completed})
+	q.Push(&Invocation{ID: "new"})
+	if _, err := q.Get("old"); err != ErrInvocationNotFound {
+		t.Errorf("Expecting the old invocation to be removed")
+	}
+	if _, err := q.Get("new"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := newFileQueue(dir, time.Hour, "pod-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now()
+	q.Push(&Invocation{ID: "b", Status: InvocationQueued, CreatedAt: now.Add(-time.Second)})
+	q.Push(&Invocation{ID: "a", Status: InvocationQueued, CreatedAt: now.Add(-2 * time.Second)})
+	q.Push(&Invocation{ID: "c", Status: InvocationQueued, CreatedAt: now})
+	if err := q.Push(&Invocation{ID: "a"}); err == nil {
+		t.Error("Expecting an error for a duplicated invocation")
+	}
+	if err := q.Push(&Invocation{ID: "../a"}); err == nil {
+		t.Error("Expecting an error for an invalid ID")
+	}
+
+	// Invocations are claimed in the order in which they were received
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, expected := range []string{"a", "b"} {
+		inv, err := q.Pop(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if inv.ID != expected {
+			t.Errorf("Expecting invocation %s, received %s", expected, inv.ID)
+		}
+	}
+	if err := q.Update(&Invocation{ID: "a", Status: InvocationSucceeded, Result: "done"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := q.Update(&Invocation{ID: "c", Status: InvocationRunning}); err != ErrLeaseLost {
+		t.Errorf("Expecting an error updating an invocation that is not claimed, received %v", err)
+	}
+
+	// Other replicas sharing the directory see the results and skip the claimed invocations
+	other, err := newFileQueue(dir, time.Hour, "pod-2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	inv, err := other.Get("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.Result != "done" {
+		t.Errorf("Expecting the result to be stored, received %v", inv)
+	}
+	if _, err := other.Get("../a"); err != ErrInvocationNotFound {
+		t.Errorf("Expecting a not found error, received %v", err)
+	}
+	inv, err = other.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.ID != "c" {
+		t.Errorf("Expecting invocation c, received %s", inv.ID)
+	}
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if inv, err := other.Pop(short); err == nil {
+		t.Errorf("Expecting no invocations to claim, received %v", inv)
+	}
+}
+
+// stopRenewing simulates a replica that stops without releasing its claims
+func stopRenewing(fq *fileQueue) {
+	fq.mutex.Lock()
+	defer fq.mutex.Unlock()
+	for id, c := range fq.claims {
+		close(c.stop)
+		fq.claims[id] = &queueClaim{generation: c.generation, stop: make(chan struct{})}
+	}
+}
+
+func TestFileQueueLeases(t *testing.T) {
+	prevLease := queueLeaseDuration
+	prevPoll := queuePollInterval
+	defer func() {
+		queueLeaseDuration = prevLease
+		queuePollInterval = prevPoll
+	}()
+	queueLeaseDuration = 60 * time.Millisecond
+	queuePollInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q1, err := newFileQueue(dir, time.Hour, "pod-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	q2, err := newFileQueue(dir, time.Hour, "pod-2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	q1.Push(&Invocation{ID: "a", Status: InvocationQueued, CreatedAt: time.Now()})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := q1.Pop(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The lease is renewed while the invocation is processed
+	short, cancelShort := context.WithTimeout(context.Background(), 3*queueLeaseDuration)
+	defer cancelShort()
+	if inv, err := q2.Pop(short); err == nil {
+		t.Fatalf("Expecting the invocation to be leased, received %v", inv)
+	}
+
+	// Once the replica stops renewing it, the invocation is claimed by another replica
+	stopRenewing(q1)
+	inv, err := q2.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.ID != "a" {
+		t.Errorf("Expecting invocation a, received %s", inv.ID)
+	}
+	if err := q1.Update(&Invocation{ID: "a", Status: InvocationSucceeded}); err != ErrLeaseLost {
+		t.Errorf("Expecting the lease to be lost, received %v", err)
+	}
+	if err := q2.Update(&Invocation{ID: "a", Status: InvocationSucceeded, Result: "done"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "a.json" {
+		names := []string{}
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("Expecting only the result of the invocation, found %v", names)
+	}
+
+	// A replica that is restarted claims its invocations again without waiting for the lease
+	queueLeaseDuration = time.Hour
+	q1.Push(&Invocation{ID: "b", Status: InvocationQueued, CreatedAt: time.Now()})
+	if _, err := q1.Pop(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stopRenewing(q1)
+	restarted, err := newFileQueue(dir, time.Hour, "pod-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	inv, err = restarted.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inv.ID != "b" {
+		t.Errorf("Expecting invocation b, received %s", inv.ID)
+	}
+}
+
+func waitForInvocation(t *testing.T, q Queue, id string) *Invocation {
+	for i := 0; i < 100; i++ {
+		inv, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if inv.Finished() {
+			return inv
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Invocation %s not finished", id)
+	return nil
+}
+
+func TestAsyncInvocation(t *testing.T) {
+	q := NewMemoryQueue(time.Hour)
+	SetQueue(q)
+	loadFailurePolicy()
+	prevPolicy := asyncRetryPolicy
+	defer func() {
+		asyncRetryPolicy = prevPolicy
+	}()
+	asyncRetryPolicy = RetryPolicy{MaxAttempts: 4, Backoff: time.Millisecond}
+	calls := 0
+	StartAsyncWorkers(func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "fail" {
+			return nil, fmt.Errorf("failed")
+		}
+		if calls < 2 {
+			// The first attempt fails
+			return nil, fmt.Errorf("temporary error")
+		}
+		return []byte("hello " + string(body)), nil
+	})
+
+	invoke := func(data string) string {
+		req := httptest.NewRequest("POST", "/", bytes.NewBufferString(data))
+		req.Header.Set(InvocationHeader, "async")
+		w := httptest.NewRecorder()
+		Handler(w, req, nil)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expecting status 202, received %d: %s", w.Code, w.Body.String())
+		}
+		res := map[string]string{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res["id"] == "" || w.Header().Get(InvocationIDHeader) != res["id"] {
+			t.Fatalf("Unexpected response %v", res)
+		}
+		return res["id"]
+	}
+
+	id := invoke("world")
+	inv := waitForInvocation(t, q, id)
+	if inv.Status != InvocationSucceeded || inv.Result != "hello world" || inv.Attempts != 2 {
+		t.Errorf("Unexpected invocation %v", inv)
+	}
+
+	id = invoke("fail")
+	inv = waitForInvocation(t, q, id)
+	if inv.Status != InvocationFailed || inv.Attempts != 4 || !strings.Contains(inv.Error, "failed") {
+		t.Errorf("Unexpected invocation %v", inv)
+	}
+
+	// The result is available in the invocations endpoint
+	w := httptest.NewRecorder()
+	InvocationsHandler(w, httptest.NewRequest("GET", InvocationsPath+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expecting status 200, received %d", w.Code)
+	}
+	res := Invocation{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != id || res.Status != InvocationFailed || res.Request != nil {
+		t.Errorf("Unexpected invocation %v", res)
+	}
+
+	w = httptest.NewRecorder()
+	InvocationsHandler(w, httptest.NewRequest("GET", InvocationsPath+"unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expecting status 404, received %d", w.Code)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	containerConcurrency = os.Getenv("FUNC_CONTAINER_CONCURRENCY")
+	requestQueueSize     = os.Getenv("FUNC_REQUEST_QUEUE_SIZE")
+	requestQueueTimeout  = os.Getenv("FUNC_REQUEST_QUEUE_TIMEOUT")
+
+	limiterOnce sync.Once
+	limiter     *concurrencyLimiter
+
+	funcInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "function_inflight_requests",
+		Help: "Number of requests being processed by the function",
+	})
+	funcQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "function_queued_requests",
+		Help: "Number of requests waiting for the function to be available",
+	})
+)
+
+// Errors returned when the function can't process more requests
+var (
+	errQueueFull    = errors.New("Too many requests, the request queue of the function is full")
+	errQueueTimeout = errors.New("Timeout exceeded waiting for the function to be available")
+)
+
+// retryAfter is the number of seconds that the clients of a busy function are asked to wait
+const retryAfter = "1"
+
+func init() {
+	prometheus.MustRegister(funcInFlight, funcQueued)
+}
+
+// concurrencyLimiter limits the number of requests processed at the same time. The requests
+// that exceed the limit wait in a bounded queue for a slot to be released
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+func newConcurrencyLimiter(concurrency, queueSize int, timeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:   make(chan struct{}, concurrency),
+		queue:   make(chan struct{}, queueSize),
+		timeout: timeout,
+	}
+}
+
+// acquire takes a slot to process a request. It returns errQueueFull if the request can't
+// wait, errQueueTimeout if it waits longer than the queue timeout or the error of the
+// context if the client goes away
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return errQueueFull
+	}
+	funcQueued.Inc()
+	defer func() {
+		<-l.queue
+		funcQueued.Dec()
+	}()
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait takes a slot without a limit of time nor queue size. It is used by the workers
+// that process the asynchronous invocations
+func (l *concurrencyLimiter) wait() {
+	l.slots <- struct{}{}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// getLimiter returns the concurrency limiter of the function or nil if the
+// number of concurrent requests is not limited
+func getLimiter() *concurrencyLimiter {
+	limiterOnce.Do(func() {
+		if containerConcurrency == "" || containerConcurrency == "0" {
+			return
+		}
+		concurrency, err := strconv.Atoi(containerConcurrency)
+		if err != nil || concurrency < 0 {
+			log.Fatalf("Invalid FUNC_CONTAINER_CONCURRENCY %s", containerConcurrency)
+		}
+		queueSize := 10 * concurrency
+		if requestQueueSize != "" {
+			queueSize, err = strconv.Atoi(requestQueueSize)
+			if err != nil || queueSize < 0 {
+				log.Fatalf("Invalid FUNC_REQUEST_QUEUE_SIZE %s", requestQueueSize)
+			}
+		}
+		timeout := time.Duration(intTimeout) * time.Second
+		if requestQueueTimeout != "" {
+			timeout, err = time.ParseDuration(requestQueueTimeout)
+			if err != nil {
+				log.Fatalf("Invalid FUNC_REQUEST_QUEUE_TIMEOUT %s: %v", requestQueueTimeout, err)
+			}
+		}
+		limiter = newConcurrencyLimiter(concurrency, queueSize, timeout)
+	})
+	return limiter
+}
+
+// limitConcurrency calls f once the function can process another request. If the queue of
+// requests is full the client receives a 429 response and, if the request waits longer
+// than the queue timeout, a 503 response. Both ask the client to retry later
+func limitConcurrency(w http.ResponseWriter, r *http.Request, f func()) {
+	if l := getLimiter(); l != nil {
+		if err := l.acquire(r.Context()); err != nil {
+			switch err {
+			case errQueueFull:
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+			case errQueueTimeout:
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			// Otherwise the client is gone
+			return
+		}
+		defer l.release()
+	}
+	funcInFlight.Inc()
+	defer funcInFlight.Dec()
+	f()
+}
+
+// waitConcurrency calls f once the function can process another request, as long as it takes
+func waitConcurrency(f func()) {
+	if l := getLimiter(); l != nil {
+		l.wait()
+		defer l.release()
+	}
+	funcInFlight.Inc()
+	defer funcInFlight.Dec()
+	f()
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setLimiter replaces the concurrency limiter of the function and returns a function that restores it
+func setLimiter(l *concurrencyLimiter) func() {
+	limiterOnce.Do(func() {})
+	prev := limiter
+	limiter = l
+	return func() {
+		limiter = prev
+	}
+}
+
+// serveLimited sends a request through limitConcurrency. The handler blocks until release is closed
+func serveLimited(release chan struct{}, started chan struct{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	limitConcurrency(w, httptest.NewRequest("GET", "/", nil), func() {
+		if started != nil {
+			close(started)
+		}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	return w
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1, 10*time.Second)
+	defer setLimiter(l)()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serveLimited(release, started) }()
+	<-started
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- serveLimited(release, nil) }()
+	for i := 0; len(l.queue) == 0; i++ {
+		if i > 100 {
+			t.Fatal("The second request should be waiting in the queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The queue is full
+	w := serveLimited(release, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != retryAfter {
+		t.Errorf("Expecting a 429 response with Retry-After, received %d %v", w.Code, w.Header())
+	}
+
+	close(release)
+	if w := <-first; w.Code != http.StatusOK {
+		t.Errorf("Unexpected status %d", w.Code)
+	}
+	if w := <-second; w.Code != http.StatusOK {
+		t.Errorf("The queued request should be processed, received status %d", w.Code)
+	}
+	if len(l.slots) != 0 || len(l.queue) != 0 {
+		t.Errorf("Expecting all the slots to be released")
+	}
+}
+
+func TestLimitConcurrencyQueueTimeout(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1, 20*time.Millisecond)
+	defer setLimiter(l)()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serveLimited(release, started) }()
+	<-started
+
+	w := serveLimited(release, nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != retryAfter {
+		t.Errorf("Expecting a 503 response with Retry-After, received %d %v", w.Code, w.Header())
+	}
+	close(release)
+	<-first
+}
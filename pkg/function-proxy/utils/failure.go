@@ -0,0 +1,250 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	retryMaxAttempts = os.Getenv("FUNC_RETRY_MAX_ATTEMPTS")
+	retryBackoff     = os.Getenv("FUNC_RETRY_BACKOFF")
+	retryMaxBackoff  = os.Getenv("FUNC_RETRY_MAX_BACKOFF")
+	onFailure        = os.Getenv("FUNC_ON_FAILURE")
+	funcName         = os.Getenv("FUNC_NAME")
+	funcNamespace    = os.Getenv("FUNC_NAMESPACE")
+
+	failurePolicyOnce  sync.Once
+	syncRetryPolicy    RetryPolicy
+	asyncRetryPolicy   RetryPolicy
+	failureDestination *Destination
+
+	deadLetterClient = &http.Client{Timeout: 10 * time.Second}
+	// Headers with credentials that are not sent to the failure destination
+	redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token"}
+	funcDeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "function_dead_letters_total",
+		Help: "Number of failed events sent to the failure destination",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(funcDeadLetters)
+}
+
+// RetryPolicy defines how many times a failed event is processed and how long to wait between attempts
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Delay returns the time to wait after the given failed attempt (starting at 1)
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Destination receives the events that a function fails to process after all the retries
+type Destination struct {
+	// Type is one of function, http, nats or kafka
+	Type string `json:"type"`
+	// Name of the function or topic
+	Name string `json:"name,omitempty"`
+	// URL of the function, HTTP endpoint, NATS server or Kafka REST proxy
+	URL string `json:"url"`
+}
+
+// FailedEvent is the message sent to the failure destination of a function
+type FailedEvent struct {
+	Function   string      `json:"function"`
+	Namespace  string      `json:"namespace,omitempty"`
+	EventID    string      `json:"eventId,omitempty"`
+	Error      string      `json:"error"`
+	StatusCode int         `json:"statusCode"`
+	Attempts   int         `json:"attempts"`
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	Header     http.Header `json:"header,omitempty"`
+	Data       string      `json:"data"`
+}
+
+// loadFailurePolicy reads the retry policy and the failure destination from the environment.
+// Synchronous requests are not retried unless a retry policy is configured
+func loadFailurePolicy() {
+	failurePolicyOnce.Do(func() {
+		syncRetryPolicy = RetryPolicy{MaxAttempts: 1, Backoff: time.Second, MaxBackoff: time.Minute}
+		asyncRetryPolicy = RetryPolicy{MaxAttempts: 4, Backoff: time.Second, MaxBackoff: time.Minute}
+		if asyncRetries != "" {
+			r, err := strconv.Atoi(asyncRetries)
+			if err != nil || r < 0 {
+				log.Fatalf("Invalid FUNC_ASYNC_RETRIES %s", asyncRetries)
+			}
+			asyncRetryPolicy.MaxAttempts = r + 1
+		}
+		if retryMaxAttempts != "" {
+			attempts, err := strconv.Atoi(retryMaxAttempts)
+			if err != nil || attempts < 1 {
+				log.Fatalf("Invalid FUNC_RETRY_MAX_ATTEMPTS %s", retryMaxAttempts)
+			}
+			syncRetryPolicy.MaxAttempts = attempts
+			asyncRetryPolicy.MaxAttempts = attempts
+		}
+		for env, dst := range map[string]*time.Duration{retryBackoff: &syncRetryPolicy.Backoff, retryMaxBackoff: &syncRetryPolicy.MaxBackoff} {
+			if env == "" {
+				continue
+			}
+			d, err := time.ParseDuration(env)
+			if err != nil {
+				log.Fatalf("Invalid retry backoff %s: %v", env, err)
+			}
+			*dst = d
+		}
+		asyncRetryPolicy.Backoff = syncRetryPolicy.Backoff
+		asyncRetryPolicy.MaxBackoff = syncRetryPolicy.MaxBackoff
+		if onFailure != "" {
+			d := &Destination{}
+			if err := json.Unmarshal([]byte(onFailure), d); err != nil {
+				log.Fatalf("Invalid FUNC_ON_FAILURE %s: %v", onFailure, err)
+			}
+			failureDestination = d
+		}
+	})
+}
+
+// failed returns true if the status code of a response means that the event should be retried
+func failed(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusRequestTimeout
+}
+
+// redactHeader returns a copy of the header replacing the value of the headers with credentials
+func redactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	for k, v := range header {
+		redacted[k] = v
+	}
+	for _, k := range redactedHeaders {
+		if _, ok := redacted[k]; ok {
+			redacted.Set(k, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+func newFailedEvent(method string, header http.Header, body []byte, code int, res []byte, attempts int) *FailedEvent {
+	return &FailedEvent{
+		Function:   funcName,
+		Namespace:  funcNamespace,
+		EventID:    header.Get("event-id"),
+		Error:      string(res),
+		StatusCode: code,
+		Attempts:   attempts,
+		Time:       time.Now().UTC(),
+		Method:     method,
+		Header:     redactHeader(header),
+		Data:       string(body),
+	}
+}
+
+// handleFailedEvent sends an event to the failure destination (if any)
+func handleFailedEvent(e *FailedEvent) {
+	loadFailurePolicy()
+	if failureDestination == nil {
+		return
+	}
+	err := sendFailedEvent(failureDestination, e)
+	if err != nil {
+		funcDeadLetters.With(prometheus.Labels{"result": "error"}).Inc()
+		log.Printf("Unable to send the failed event %s to %s %s: %v", e.EventID, failureDestination.Type, failureDestination.Name, err)
+		return
+	}
+	funcDeadLetters.With(prometheus.Labels{"result": "sent"}).Inc()
+}
+
+func sendFailedEvent(d *Destination, e *FailedEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	switch d.Type {
+	case "function", "http":
+		return postEvent(d.URL, "application/json", data, e.EventID)
+	case "kafka":
+		// Kafka REST proxy API (v2)
+		records, err := json.Marshal(map[string]interface{}{
+			"records": []map[string]json.RawMessage{{"value": data}},
+		})
+		if err != nil {
+			return err
+		}
+		return postEvent(strings.TrimSuffix(d.URL, "/")+"/topics/"+d.Name, "application/vnd.kafka.json.v2+json", records, e.EventID)
+	case "nats":
+		nc, err := nats.Connect(d.URL)
+		if err != nil {
+			return err
+		}
+		defer nc.Close()
+		if err := nc.Publish(d.Name, data); err != nil {
+			return err
+		}
+		return nc.Flush()
+	}
+	return fmt.Errorf("unknown destination type %s", d.Type)
+}
+
+func postEvent(url, contentType string, data []byte, eventID string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("event-type", "application/json")
+	req.Header.Set("event-namespace", "failures.kubeless.io")
+	if eventID != "" {
+		req.Header.Set("event-id", eventID)
+	}
+	res, err := deadLetterClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("received status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if d := p.Delay(attempt); d != expected {
+			t.Errorf("Expecting a delay of %s after attempt %d, received %s", expected, attempt, d)
+		}
+	}
+}
+
+// deadLetterSink returns a server that stores the requests received
+func deadLetterSink(received chan *http.Request, bodies chan []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+}
+
+func TestSyncRetriesAndFailureDestination(t *testing.T) {
+	loadFailurePolicy()
+	prevPolicy, prevDestination := syncRetryPolicy, failureDestination
+	defer func() {
+		syncRetryPolicy, failureDestination = prevPolicy, prevDestination
+	}()
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	sink := deadLetterSink(received, bodies)
+	defer sink.Close()
+	syncRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	failureDestination = &Destination{Type: "http", URL: sink.URL}
+
+	attempts := 0
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "data" {
+			return nil, fmt.Errorf("unexpected body %q", body)
+		}
+		if attempts < 3 && r.Header.Get("event-id") == "recover" {
+			return nil, fmt.Errorf("temporary error")
+		}
+		if r.Header.Get("event-id") == "fail" {
+			return nil, fmt.Errorf("boom")
+		}
+		return []byte("ok"), nil
+	}
+
+	// The request succeeds in the third attempt
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString("data"))
+	req.Header.Set("event-id", "recover")
+	w := httptest.NewRecorder()
+	Handler(w, req, h)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" || attempts != 3 {
+		t.Errorf("Unexpected response %d: %s after %d attempts", w.Code, w.Body.String(), attempts)
+	}
+	select {
+	case <-received:
+		t.Fatal("The event should not be sent to the failure destination")
+	default:
+	}
+
+	// The request fails after all the attempts and it is sent to the failure destination
+	attempts = 0
+	req = httptest.NewRequest("POST", "/", bytes.NewBufferString("data"))
+	req.Header.Set("event-id", "fail")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	w = httptest.NewRecorder()
+	Handler(w, req, h)
+	if w.Code != http.StatusInternalServerError || attempts != 3 {
+		t.Errorf("Unexpected response %d after %d attempts", w.Code, attempts)
+	}
+	r := <-received
+	if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("event-id") != "fail" {
+		t.Errorf("Unexpected headers %v", r.Header)
+	}
+	event := FailedEvent{}
+	if err := json.Unmarshal(<-bodies, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Data != "data" || event.Attempts != 3 || event.StatusCode != http.StatusInternalServerError || event.Error != "Error: boom" {
+		t.Errorf("Unexpected failed event %v", event)
+	}
+	if event.Header.Get("event-id") != "fail" || event.Header.Get("Authorization") != "REDACTED" || event.Header.Get("Cookie") != "REDACTED" {
+		t.Errorf("Unexpected header of the failed event %v", event.Header)
+	}
+}
+
+func TestSendFailedEventToKafka(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	sink := deadLetterSink(received, bodies)
+	defer sink.Close()
+
+	err := sendFailedEvent(&Destination{Type: "kafka", Name: "errors", URL: sink.URL + "/"}, &FailedEvent{Function: "foo", Data: "data"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	r := <-received
+	if r.URL.Path != "/topics/errors" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("Unexpected request %s %v", r.URL.Path, r.Header)
+	}
+	records := struct {
+		Records []struct {
+			Value FailedEvent `json:"value"`
+		} `json:"records"`
+	}{}
+	if err := json.Unmarshal(<-bodies, &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Records) != 1 || records.Records[0].Value.Function != "foo" {
+		t.Errorf("Unexpected records %v", records)
+	}
+
+	if err := sendFailedEvent(&Destination{Type: "unknown"}, &FailedEvent{}); err == nil {
+		t.Error("Expecting an error for an unknown destination")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"golang.org/x/net/context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	watchdogTimeouts = os.Getenv("FUNC_WATCHDOG_TIMEOUTS")
+	drainDelay       = os.Getenv("FUNC_DRAIN_DELAY")
+
+	watchdog = &Watchdog{}
+
+	drainMu  sync.RWMutex
+	draining bool
+
+	// restartPollInterval is the time between the health checks of a restarted function process
+	restartPollInterval = time.Second
+
+	funcRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "function_restarts_total",
+		Help: "Number of times the watchdog restarted the function process",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(funcRestarts)
+}
+
+// Watchdog restarts the function process when it is stuck. The process is considered stuck
+// if several consecutive requests exceed the function timeout and it doesn't answer its health check
+type Watchdog struct {
+	mu         sync.Mutex
+	threshold  int
+	timeouts   int
+	checking   bool
+	restarting bool
+	check      func() error
+	restart    func() error
+}
+
+// StartWatchdog enables the watchdog of the function process. check returns an error if the
+// process is not responding and restart starts it again. The number of consecutive timeouts
+// that trigger the check is read from FUNC_WATCHDOG_TIMEOUTS (3 by default, 0 disables it)
+func StartWatchdog(check, restart func() error) {
+	threshold := 3
+	if watchdogTimeouts != "" {
+		t, err := strconv.Atoi(watchdogTimeouts)
+		if err != nil || t < 0 {
+			log.Fatalf("Invalid FUNC_WATCHDOG_TIMEOUTS %s", watchdogTimeouts)
+		}
+		threshold = t
+	}
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	watchdog.threshold = threshold
+	watchdog.timeouts = 0
+	watchdog.check = check
+	watchdog.restart = restart
+}
+
+// timeout records a request that exceeded the function timeout
+func (wd *Watchdog) timeout() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.timeouts++
+	if wd.threshold == 0 || wd.check == nil || wd.checking || wd.timeouts < wd.threshold {
+		return
+	}
+	wd.checking = true
+	go wd.recover()
+}
+
+// success records a request completed within the function timeout
+func (wd *Watchdog) success() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.timeouts = 0
+}
+
+func (wd *Watchdog) setRestarting(restarting bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.restarting = restarting
+}
+
+func (wd *Watchdog) isRestarting() bool {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return wd.restarting
+}
+
+// recover restarts the function process if it doesn't answer its health check and waits
+// until it is healthy again
+func (wd *Watchdog) recover() {
+	defer func() {
+		wd.mu.Lock()
+		defer wd.mu.Unlock()
+		wd.checking = false
+		wd.timeouts = 0
+	}()
+	if err := wd.check(); err == nil {
+		// The function is slow but it is still responding
+		return
+	}
+	log.Printf("The function process is not responding after %d timeouts. Restarting it", wd.threshold)
+	wd.setRestarting(true)
+	defer wd.setRestarting(false)
+	funcRestarts.Inc()
+	if err := wd.restart(); err != nil {
+		log.Fatalf("Unable to restart the function process: %v", err)
+	}
+	deadline := time.Now().Add(time.Duration(intTimeout) * time.Second)
+	for wd.check() != nil {
+		if time.Now().After(deadline) {
+			log.Printf("The function process is not healthy after restarting it")
+			return
+		}
+		time.Sleep(restartPollInterval)
+	}
+}
+
+// Restarting returns true while the watchdog restarts the function process
+func Restarting() bool {
+	return watchdog.isRestarting()
+}
+
+func isDraining() bool {
+	drainMu.RLock()
+	defer drainMu.RUnlock()
+	return draining
+}
+
+// Ready returns false while the proxy drains its requests or the function process is restarted
+// so the replica doesn't receive new requests
+func Ready() bool {
+	return !isDraining() && !Restarting()
+}
+
+// drainOnSignal shuts down the server gracefully when the pod is terminated. The readiness
+// probe fails first and, after FUNC_DRAIN_DELAY (5s by default), the server stops accepting
+// connections and waits for the requests in flight up to the function timeout
+func drainOnSignal(server *http.Server, sigs <-chan os.Signal, done chan<- struct{}) {
+	defer close(done)
+	<-sigs
+	drainMu.Lock()
+	draining = true
+	drainMu.Unlock()
+	delay := 5 * time.Second
+	if drainDelay != "" {
+		d, err := time.ParseDuration(drainDelay)
+		if err != nil {
+			log.Printf("Invalid FUNC_DRAIN_DELAY %s: %v", drainDelay, err)
+		} else {
+			delay = d
+		}
+	}
+	log.Printf("Terminating. Draining the requests in flight")
+	// Give time to the endpoints of the function to be updated
+	time.Sleep(delay)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(intTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Unable to complete the requests in flight: %v", err)
+	}
+}
+
+// notifyTermination returns a channel that receives the signals that terminate the pod
+func notifyTermination() <-chan os.Signal {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	return sigs
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeProcess is a function process that gets stuck until it is restarted
+type fakeProcess struct {
+	mu       sync.Mutex
+	stuck    bool
+	restarts int
+	restart  chan struct{}
+}
+
+func (p *fakeProcess) check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stuck {
+		return errors.New("not responding")
+	}
+	return nil
+}
+
+func (p *fakeProcess) doRestart() error {
+	<-p.restart
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stuck = false
+	p.restarts++
+	return nil
+}
+
+func (p *fakeProcess) getRestarts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts
+}
+
+// setWatchdog replaces the watchdog of the function and returns a function that restores it
+func setWatchdog(check, restart func() error) func() {
+	prevWatchdog, prevInterval := watchdog, restartPollInterval
+	watchdog = &Watchdog{}
+	restartPollInterval = 10 * time.Millisecond
+	StartWatchdog(check, restart)
+	return func() {
+		watchdog, restartPollInterval = prevWatchdog, prevInterval
+	}
+}
+
+// waitFor polls cond until it is true or fails the test after a second
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		if i > 100 {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	p := &fakeProcess{restart: make(chan struct{})}
+	defer setWatchdog(p.check, p.doRestart)()
+
+	// A slow function that still answers its health check is not restarted
+	for i := 0; i < 3; i++ {
+		watchdog.timeout()
+	}
+	waitFor(t, "The watchdog should finish checking the function", func() bool {
+		watchdog.mu.Lock()
+		defer watchdog.mu.Unlock()
+		return !watchdog.checking
+	})
+
+	// Successful requests reset the count of timeouts
+	p.mu.Lock()
+	p.stuck = true
+	p.mu.Unlock()
+	watchdog.timeout()
+	watchdog.timeout()
+	watchdog.success()
+	watchdog.timeout()
+	if Restarting() || p.getRestarts() != 0 {
+		t.Fatal("The function should not be restarted")
+	}
+
+	watchdog.timeout()
+	watchdog.timeout()
+	waitFor(t, "The function should be restarted", Restarting)
+	if Ready() {
+		t.Error("The function should not be ready while it is restarted")
+	}
+	close(p.restart)
+	waitFor(t, "The function should be ready after the restart", Ready)
+	if p.getRestarts() != 1 {
+		t.Errorf("Expecting a restart, received %d", p.getRestarts())
+	}
+}
+
+func TestStreamCancelledByClient(t *testing.T) {
+	p := &fakeProcess{stuck: true, restart: make(chan struct{})}
+	defer setWatchdog(p.check, p.doRestart)()
+	watchdog.threshold = 1
+
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	StreamHandler(httptest.NewRecorder(), r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("The request to the function should be cancelled")
+	}
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	if watchdog.timeouts != 0 || watchdog.checking {
+		t.Error("A cancelled request should not be considered a timeout")
+	}
+}
+
+func TestDrainOnSignal(t *testing.T) {
+	prevDelay := drainDelay
+	defer func() {
+		drainDelay = prevDelay
+		drainMu.Lock()
+		draining = false
+		drainMu.Unlock()
+	}()
+	drainDelay = "50ms"
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		responses <- res
+	}()
+	<-started
+
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go drainOnSignal(server, sigs, done)
+	sigs <- syscall.SIGTERM
+	waitFor(t, "The proxy should not be ready while draining", func() bool { return !Ready() })
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server should be shut down once the requests in flight are completed")
+	}
+	if res := <-responses; res == nil || res.StatusCode != http.StatusOK {
+		t.Errorf("The request in flight should be completed, received %v", res)
+	}
+	if _, err := http.Get("http://" + l.Addr().String()); err == nil {
+		t.Error("The server should not accept new requests")
+	}
+}
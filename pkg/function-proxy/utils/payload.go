@@ -0,0 +1,240 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kubeless/kubeless/pkg/jsonschema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons of the rejected requests
+const (
+	rejectRequestTooLarge  = "request_too_large"
+	rejectInvalidPayload   = "invalid_payload"
+	rejectResponseTooLarge = "response_too_large"
+)
+
+var (
+	maxRequestSizeEnv  = os.Getenv("FUNC_MAX_REQUEST_SIZE")
+	maxResponseSizeEnv = os.Getenv("FUNC_MAX_RESPONSE_SIZE")
+	requestSchemaEnv   = os.Getenv("FUNC_REQUEST_SCHEMA")
+
+	payloadOnce     sync.Once
+	maxRequestSize  int64
+	maxResponseSize int64
+	requestSchema   *jsonschema.Schema
+
+	funcRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "function_rejected_requests_total",
+		Help: "Number of requests rejected before or after calling the function",
+	}, []string{"reason"})
+)
+
+// defaultBufferSize limits the requests and responses that are kept in memory (to validate,
+// retry or queue them) when the function doesn't set a maximum size
+const defaultBufferSize = 32 << 20
+
+// errResponseTooLarge is returned by the writes of a handler that exceeded the maximum response size
+var errResponseTooLarge = errors.New("Response too large")
+
+func init() {
+	prometheus.MustRegister(funcRejected)
+}
+
+func parseSize(name, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		log.Fatalf("Invalid %s %s", name, value)
+	}
+	return size
+}
+
+// loadPayloadLimits reads the limits of the requests and responses of the function
+func loadPayloadLimits() {
+	payloadOnce.Do(func() {
+		maxRequestSize = parseSize("FUNC_MAX_REQUEST_SIZE", maxRequestSizeEnv)
+		maxResponseSize = parseSize("FUNC_MAX_RESPONSE_SIZE", maxResponseSizeEnv)
+		if requestSchemaEnv != "" {
+			var err error
+			requestSchema, err = jsonschema.Compile([]byte(requestSchemaEnv))
+			if err != nil {
+				log.Fatalf("Invalid FUNC_REQUEST_SCHEMA: %v", err)
+			}
+		}
+	})
+}
+
+// bufferLimit returns the maximum size of a request or response kept in memory
+func bufferLimit(max int64) int64 {
+	if max > 0 {
+		return max
+	}
+	return defaultBufferSize
+}
+
+// readBody reads the body of a request that is kept in memory. Requests bigger than the maximum
+// request size (or the default buffer size) are rejected with a 413 response
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	loadPayloadLimits()
+	limit := bufferLimit(maxRequestSize)
+	tooLarge := fmt.Sprintf("Request too large, the maximum size is %d bytes", limit)
+	if r.ContentLength > limit {
+		reject(w, http.StatusRequestEntityTooLarge, rejectRequestTooLarge, tooLarge)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error: unable to read the request: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if int64(len(body)) > limit {
+		reject(w, http.StatusRequestEntityTooLarge, rejectRequestTooLarge, tooLarge)
+		return nil, false
+	}
+	return body, true
+}
+
+func reject(w http.ResponseWriter, code int, reason, msg string) {
+	funcRejected.With(prometheus.Labels{"reason": reason}).Inc()
+	http.Error(w, msg, code)
+}
+
+// hasPayload returns true for the requests whose body is validated with the request schema
+func hasPayload(r *http.Request) bool {
+	return r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// checkRequest rejects the requests bigger than the maximum request size with a 413 response
+// and the payloads that don't match the request schema with a 400 response. It returns false
+// if the request has been rejected. The body of the request is kept so it can be read again
+func checkRequest(w http.ResponseWriter, r *http.Request) bool {
+	loadPayloadLimits()
+	tooLarge := fmt.Sprintf("Request too large, the maximum size is %d bytes", maxRequestSize)
+	if maxRequestSize > 0 && r.ContentLength > maxRequestSize {
+		reject(w, http.StatusRequestEntityTooLarge, rejectRequestTooLarge, tooLarge)
+		return false
+	}
+	validate := requestSchema != nil && hasPayload(r)
+	if !validate && (maxRequestSize == 0 || r.ContentLength >= 0) {
+		// The server doesn't read beyond the Content-Length of the request
+		return true
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if validate {
+		if !isJSON(r.Header.Get("Content-Type")) {
+			reject(w, http.StatusBadRequest, rejectInvalidPayload, "Invalid payload: expecting a JSON request (Content-Type: application/json)")
+			return false
+		}
+		if err := requestSchema.ValidateJSON(body); err != nil {
+			reject(w, http.StatusBadRequest, rejectInvalidPayload, fmt.Sprintf("Invalid payload: %v", err))
+			return false
+		}
+	}
+	return true
+}
+
+// limitedResponseWriter forwards the response of the function while it doesn't exceed the
+// maximum response size. Responses with a bigger Content-Length are replaced with a 502
+// response. Responses without a Content-Length are truncated to the maximum size
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	max         int64
+	written     int64
+	wroteHeader bool
+	exceeded    bool
+}
+
+func newLimitedResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	loadPayloadLimits()
+	if maxResponseSize == 0 {
+		return w
+	}
+	return &limitedResponseWriter{ResponseWriter: w, max: maxResponseSize}
+}
+
+func (lw *limitedResponseWriter) exceed() {
+	lw.exceeded = true
+	funcRejected.With(prometheus.Labels{"reason": rejectResponseTooLarge}).Inc()
+	log.Printf("The response of the function exceeds the maximum size of %d bytes", lw.max)
+}
+
+func (lw *limitedResponseWriter) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if length, err := strconv.ParseInt(lw.Header().Get("Content-Length"), 10, 64); err == nil && length > lw.max {
+		lw.exceed()
+		lw.Header().Del("Content-Length")
+		http.Error(lw.ResponseWriter, fmt.Sprintf("Response too large, the maximum size is %d bytes", lw.max), http.StatusBadGateway)
+		return
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *limitedResponseWriter) Write(b []byte) (int, error) {
+	lw.WriteHeader(http.StatusOK)
+	if lw.exceeded {
+		return 0, errResponseTooLarge
+	}
+	if lw.written+int64(len(b)) > lw.max {
+		// Send the data that fits in the limit before truncating the response
+		n, _ := lw.ResponseWriter.Write(b[:lw.max-lw.written])
+		lw.written += int64(n)
+		lw.exceed()
+		return n, errResponseTooLarge
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.written += int64(n)
+	return n, err
+}
+
+// Flush sends the buffered data to the client so streamed responses are not delayed
+func (lw *limitedResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok && !lw.exceeded {
+		f.Flush()
+	}
+}
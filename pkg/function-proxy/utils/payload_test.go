@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeless/kubeless/pkg/jsonschema"
+)
+
+// setPayloadLimits replaces the payload limits of the function and returns a function that restores them
+func setPayloadLimits(maxRequest, maxResponse int64, schema string) func() {
+	payloadOnce.Do(func() {})
+	prevRequest, prevResponse, prevSchema := maxRequestSize, maxResponseSize, requestSchema
+	maxRequestSize, maxResponseSize, requestSchema = maxRequest, maxResponse, nil
+	if schema != "" {
+		s, err := jsonschema.Compile([]byte(schema))
+		if err != nil {
+			panic(err)
+		}
+		requestSchema = s
+	}
+	return func() {
+		maxRequestSize, maxResponseSize, requestSchema = prevRequest, prevResponse, prevSchema
+	}
+}
+
+// unknownLength hides the length of a request body
+type unknownLength struct {
+	io.Reader
+}
+
+func TestCheckRequest(t *testing.T) {
+	defer setPayloadLimits(12, 0, `{"type": "object", "required": ["name"]}`)()
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        io.Reader
+		code        int
+		message     string
+	}{
+		{"valid payload", "POST", "application/json", strings.NewReader(`{"name":"a"}`), http.StatusOK, ""},
+		{"too large", "POST", "application/json", strings.NewReader(`{"name":"foo"}`), http.StatusRequestEntityTooLarge, "Request too large, the maximum size is 12 bytes"},
+		{"too large without length", "POST", "application/json", unknownLength{strings.NewReader(`{"name":"foo"}`)}, http.StatusRequestEntityTooLarge, "Request too large"},
+		{"invalid payload", "POST", "application/json", strings.NewReader(`{"foo":1}`), http.StatusBadRequest, `Invalid payload: /: missing required property "name"`},
+		{"not JSON", "PUT", "text/plain", strings.NewReader(`{"name":1}`), http.StatusBadRequest, "Invalid payload: expecting a JSON request"},
+		{"JSON suffix", "PATCH", "application/merge-patch+json; charset=utf-8", strings.NewReader(`{"name":1}`), http.StatusOK, ""},
+		{"GET is not validated", "GET", "", nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", tt.body)
+		req.Header.Set("Content-Type", tt.contentType)
+		if _, ok := tt.body.(unknownLength); ok {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		if checkRequest(w, req) != (tt.code == http.StatusOK) {
+			t.Errorf("%s: unexpected result with response %d %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.message) {
+			t.Errorf("%s: expecting %d %q, received %d %q", tt.name, tt.code, tt.message, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCheckRequestKeepsBody(t *testing.T) {
+	defer setPayloadLimits(100, 0, `{"type": "object"}`)()
+	req := httptest.NewRequest("POST", "/", unknownLength{strings.NewReader(`{"name":"foo"}`)})
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	if !checkRequest(httptest.NewRecorder(), req) {
+		t.Fatal("Unexpected rejected request")
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != `{"name":"foo"}` || req.ContentLength != int64(len(body)) {
+		t.Errorf("Unexpected body %q with length %d", body, req.ContentLength)
+	}
+}
+
+func TestLimitResponseSize(t *testing.T) {
+	defer setPayloadLimits(0, 5, "")()
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "" {
+			// The length of the response is not known in advance
+			for i := 0; i < 3; i++ {
+				io.WriteString(w, "abc")
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		body := r.URL.Query().Get("body")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer function.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ProtocolHandler(w, r, "", proxyTo(function.URL+"/?"+r.URL.RawQuery))
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"body=hello", http.StatusOK, "hello"},
+		{"body=hello+world", http.StatusBadGateway, "Response too large, the maximum size is 5 bytes\n"},
+		{"stream=1", http.StatusOK, "abcab"},
+	}
+	for _, tt := range tests {
+		res, err := http.Get(proxy.URL + "/?" + tt.query)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.code || string(body) != tt.body {
+			t.Errorf("%s: expecting %d %q, received %d %q", tt.query, tt.code, tt.body, res.StatusCode, body)
+		}
+	}
+}
+
+func TestLimitBufferedResponseSize(t *testing.T) {
+	defer setPayloadLimits(0, 5, "")()
+	w := httptest.NewRecorder()
+	Handler(newLimitedResponseWriter(w), httptest.NewRequest("GET", "/", nil), func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		return []byte("hello world"), nil
+	})
+	if w.Code != http.StatusBadGateway || !strings.HasPrefix(w.Body.String(), "Response too large") {
+		t.Errorf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestLimitRecordedPayloads(t *testing.T) {
+	defer setPayloadLimits(8, 5, "")()
+	loadFailurePolicy()
+	prevPolicy := syncRetryPolicy
+	defer func() {
+		syncRetryPolicy = prevPolicy
+	}()
+	syncRetryPolicy = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+
+	// The recorded response is not buffered beyond the maximum size
+	written := 0
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/", nil), func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		for i := 0; i < 10; i++ {
+			n, err := io.WriteString(w, "abc")
+			written += n
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if w.Code != http.StatusBadGateway || !strings.HasPrefix(w.Body.String(), "Response too large") {
+		t.Errorf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if written > 2*3 {
+		t.Errorf("The response should have been cut after the maximum size, %d bytes written", written)
+	}
+
+	// The request kept for the retries is limited too, even if its length is unknown
+	r := httptest.NewRequest("POST", "/", strings.NewReader("too large request"))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	Handler(w, r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		t.Error("The function should not be called")
+		return nil, nil
+	})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+)
+
+// Protocols that the proxy can serve besides plain HTTP requests
+const (
+	protocolWebSocket = "websocket"
+	protocolGRPC      = "grpc"
+)
+
+var (
+	protocol = os.Getenv("FUNC_PROTOCOL")
+	// grpcTransport sends HTTP/2 requests in clear text (h2c) to the function process
+	grpcTransport = &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+)
+
+// ProtocolHandler receives an HTTP request and response and a streaming handler function.
+// If the function serves WebSockets or gRPC, connection upgrades and gRPC calls are relayed
+// to the function process listening in addr. The rest of the requests are handled as in
+// StreamHandler. The function timeout doesn't apply to WebSocket connections nor to gRPC streams.
+// Synchronous requests wait until the function can process them if its concurrency is limited.
+// The size and the payload of the rest of the requests, and the size of their responses, are
+// checked before calling the function
+func ProtocolHandler(w http.ResponseWriter, r *http.Request, addr string, h StreamHandle) {
+	if !(protocol == protocolWebSocket && isWebSocketUpgrade(r)) && !(protocol == protocolGRPC && isGRPCRequest(r)) {
+		if !checkRequest(w, r) {
+			return
+		}
+		w = newLimitedResponseWriter(w)
+	}
+	if strings.EqualFold(r.Header.Get(InvocationHeader), "async") {
+		// Asynchronous invocations are queued and wait for the function when they are processed
+		StreamHandler(w, r, h)
+		return
+	}
+	limitConcurrency(w, r, func() {
+		switch {
+		case protocol == protocolWebSocket && isWebSocketUpgrade(r):
+			proxyWebSocket(w, r, addr)
+		case protocol == protocolGRPC && isGRPCRequest(r):
+			proxyGRPC(w, r, addr)
+		default:
+			StreamHandler(w, r, h)
+		}
+	})
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func isGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// proxyWebSocket forwards the handshake of a WebSocket connection to the function process
+// and then copies the frames in both directions until one of the sides closes the connection
+func proxyWebSocket(w http.ResponseWriter, r *http.Request, addr string) {
+	labels := prometheus.Labels{"method": r.Method}
+	funcCalls.With(labels).Inc()
+	start := time.Now()
+	defer func() {
+		funcHistogram.With(labels).Observe(time.Since(start).Seconds())
+	}()
+
+	backend, err := net.Dial("tcp", addr)
+	if err != nil {
+		funcErrors.With(labels).Inc()
+		http.Error(w, "Unable to reach the function: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		funcErrors.With(labels).Inc()
+		http.Error(w, "WebSocket connections are not supported", http.StatusInternalServerError)
+		return
+	}
+	// The function process answers the handshake
+	if err := r.Write(backend); err != nil {
+		funcErrors.With(labels).Inc()
+		http.Error(w, "Unable to reach the function: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		funcErrors.With(labels).Inc()
+		log.Printf("Unable to hijack the WebSocket connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		// The client may have sent data that is already buffered by the server
+		_, err := io.Copy(backend, rw.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, backend)
+		errc <- err
+	}()
+	// Closing both connections stops the other copy
+	<-errc
+}
+
+// proxyGRPC sends a gRPC call to the function process and streams back the messages of the
+// response. The gRPC status is sent in the trailers of the response
+func proxyGRPC(w http.ResponseWriter, r *http.Request, addr string) {
+	labels := prometheus.Labels{"method": r.Method}
+	funcCalls.With(labels).Inc()
+	start := time.Now()
+	defer func() {
+		funcHistogram.With(labels).Observe(time.Since(start).Seconds())
+	}()
+
+	req, err := http.NewRequest(r.Method, "http://"+addr+r.URL.RequestURI(), r.Body)
+	if err != nil {
+		funcErrors.With(labels).Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req = req.WithContext(r.Context())
+	copyHeaders(req.Header, r.Header)
+	for _, h := range hopHeaders {
+		// gRPC requires "te: trailers"
+		if h != "Te" {
+			req.Header.Del(h)
+		}
+	}
+	req.ContentLength = r.ContentLength
+	res, err := grpcTransport.RoundTrip(req)
+	if err != nil {
+		funcErrors.With(labels).Inc()
+		http.Error(w, "Unable to reach the function: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := WriteResponse(w, res); err != nil {
+		funcErrors.With(labels).Inc()
+		log.Printf("Unable to complete the gRPC response: %v", err)
+		return
+	}
+	// The trailers are known once the body has been read
+	for k, vv := range res.Trailer {
+		w.Header()[http2.TrailerPrefix+k] = vv
+	}
+	if status := res.Trailer.Get("Grpc-Status"); res.StatusCode != http.StatusOK || (status != "" && status != "0") {
+		funcErrors.With(labels).Inc()
+	}
+}
+
+// h2cHandler serves HTTP/2 connections in clear text for clients with prior knowledge
+// of the protocol, like gRPC clients. HTTP/1 requests are passed to the handler
+type h2cHandler struct {
+	handler http.Handler
+	server  *http2.Server
+}
+
+// prefaceRemainder is what follows the "PRI * HTTP/2.0" request line in the HTTP/2 preface
+const prefaceRemainder = "SM\r\n\r\n"
+
+func (h *h2cHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PRI" || r.URL.Path != "*" || r.Proto != "HTTP/2.0" {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "HTTP/2 is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Unable to hijack the HTTP/2 connection: %v", err)
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, len(prefaceRemainder))
+	if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != prefaceRemainder {
+		log.Printf("Invalid HTTP/2 preface")
+		return
+	}
+	// The HTTP/2 server expects to read the complete preface
+	h.server.ServeConn(&prefacedConn{
+		Conn:   conn,
+		reader: io.MultiReader(strings.NewReader(http2.ClientPreface), rw.Reader),
+	}, &http2.ServeConnOpts{Handler: h.handler})
+}
+
+// prefacedConn is a connection that reads first the data already consumed by the HTTP/1 server
+type prefacedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *prefacedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// setProtocol changes the protocol served by the proxy and returns a function that restores it
+func setProtocol(p string) func() {
+	prev := protocol
+	protocol = p
+	return func() {
+		protocol = prev
+	}
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	defer setProtocol(protocolWebSocket)()
+	// The function answers the handshake and echoes the data it receives
+	function, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer function.Close()
+	go func() {
+		conn, err := function.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil || req.Header.Get("Sec-WebSocket-Key") != "foo" {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.Copy(conn, reader)
+	}()
+
+	proxy := httptest.NewServer(logReq(traceReq(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ProtocolHandler(w, r, function.Addr().String(), nil)
+	}))))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: foo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expecting status %d, received %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+	io.WriteString(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("Expecting the echoed message, received %q (%v)", line, err)
+	}
+}
+
+func TestProxyGRPC(t *testing.T) {
+	defer setProtocol(protocolGRPC)()
+	// The function streams back the messages it receives and sets the status in the trailers
+	function, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer function.Close()
+	go func() {
+		for {
+			conn, err := function.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Te") != "trailers" || r.URL.Path != "/echo.Echo/Say" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Type", "application/grpc")
+				w.WriteHeader(http.StatusOK)
+				io.Copy(w, r.Body)
+				w.Header().Set(http2.TrailerPrefix+"Grpc-Status", "0")
+			})})
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ProtocolHandler(w, r, function.Addr().String(), nil)
+	})
+	proxy := httptest.NewServer(&h2cHandler{handler: logReq(traceReq(mux)), server: &http2.Server{}})
+	defer proxy.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	req, err := http.NewRequest("POST", proxy.URL+"/echo.Echo/Say", strings.NewReader("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "message" {
+		t.Errorf("Unexpected response %d %q", res.StatusCode, body)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("Expecting an HTTP/2 response, received %s", res.Proto)
+	}
+	if status := res.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Expecting the gRPC status in the trailers, received %v", res.Trailer)
+	}
+}
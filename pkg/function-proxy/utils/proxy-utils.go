@@ -17,15 +17,26 @@ limitations under the License.
 package utils
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/kubeless/kubeless/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
 )
 
 var (
@@ -44,6 +55,7 @@ var (
 		Name: "function_failures_total",
 		Help: "Number of exceptions in user function",
 	}, []string{"method"})
+	tracer *tracing.Tracer
 )
 
 func init() {
@@ -59,16 +71,36 @@ func init() {
 		panic(err)
 	}
 	prometheus.MustRegister(funcHistogram, funcCalls, funcErrors)
+	tracer = tracing.NewTracerFromEnv(serviceName())
 }
 
+// serviceName returns the name of the function used in the exported spans
+func serviceName() string {
+	if funcName == "" {
+		return "function"
+	}
+	if funcNamespace == "" {
+		return funcName
+	}
+	return funcName + "." + funcNamespace
+}
+
+// EventIDHeader identifies an invocation of the function. It is generated by the proxy if the
+// request doesn't include it
+const EventIDHeader = "event-id"
+
+// logOutput is where the invocation records are written
+var logOutput io.Writer = os.Stdout
+
 // Logging Functions, required to expose statusCode property
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int
 }
 
 func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
-	return &loggingResponseWriter{w, http.StatusOK}
+	return &loggingResponseWriter{w, http.StatusOK, 0}
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
@@ -76,17 +108,108 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytes += n
+	return n, err
+}
+
+// Flush sends the buffered data to the client so streamed responses are not delayed
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection of a WebSocket or HTTP/2 upgrade. The protocol is switched
+// so the request is logged with the 101 status
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("The connection doesn't support hijacking")
+	}
+	lrw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// logRecord is the JSON record written for every request served by the proxy
+type logRecord struct {
+	Time      string  `json:"time"`
+	EventID   string  `json:"event-id"`
+	TraceID   string  `json:"trace-id,omitempty"`
+	Namespace string  `json:"namespace,omitempty"`
+	Function  string  `json:"function,omitempty"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Duration  float64 `json:"duration"`
+	Bytes     int     `json:"bytes"`
+	Remote    string  `json:"remote,omitempty"`
+	UserAgent string  `json:"user-agent,omitempty"`
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func logReq(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The event ID is sent to the function so its output can be correlated with the request
+		eventID := r.Header.Get(EventIDHeader)
+		if eventID == "" {
+			id, err := newEventID()
+			if err != nil {
+				log.Printf("Unable to generate an event ID: %v", err)
+			}
+			eventID = id
+			r.Header.Set(EventIDHeader, eventID)
+		}
+		lrw := newLoggingResponseWriter(w)
+		start := time.Now()
+		handler.ServeHTTP(lrw, r)
+		record, err := json.Marshal(logRecord{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			EventID:   eventID,
+			TraceID:   tracing.Extract(r.Header).TraceID,
+			Namespace: funcNamespace,
+			Function:  funcName,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    lrw.statusCode,
+			Duration:  time.Since(start).Seconds(),
+			Bytes:     lrw.bytes,
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		})
+		if err == nil {
+			fmt.Fprintln(logOutput, string(record))
+		}
+	})
+}
+
+// traceReq starts a server span for every request. The trace context of the request is
+// replaced with the one of the span so it is propagated to the function
+func traceReq(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		span := tracer.StartSpan(r.Method+" "+r.URL.Path, tracing.KindServer, tracing.Extract(r.Header))
+		defer span.End()
+		tracing.Inject(span.Context(), r.Header)
+		span.SetTag("http.method", r.Method)
+		span.SetTag("http.path", r.URL.Path)
+		span.SetTag("kubeless.event_id", r.Header.Get(EventIDHeader))
 		lrw := newLoggingResponseWriter(w)
 		handler.ServeHTTP(lrw, r)
-		log.Printf("%s \"%s %s %s\" %d %s", r.RemoteAddr, r.Method, r.RequestURI, r.Proto, lrw.statusCode, r.UserAgent())
-		if lrw.statusCode == 408 {
-			go func() {
-				// Give time to return timeout response
-				time.Sleep(time.Second)
-				log.Fatal("Request timeout. Forcing exit")
-			}()
+		span.SetTag("http.status_code", strconv.Itoa(lrw.statusCode))
+		if lrw.statusCode >= 500 || lrw.statusCode == http.StatusRequestTimeout {
+			span.SetTag("error", "true")
 		}
 	})
 }
@@ -103,9 +226,55 @@ func copyHeaders(dst, src http.Header) {
 type Handle func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error)
 
 // Handler receives an HTTP request and response and a handler function
-// It manages timeouts and prometheus metrics
+// It manages timeouts, retries and prometheus metrics. Requests with the header
+// X-Kubeless-Invocation: async are queued and processed in the background
 func Handler(w http.ResponseWriter, r *http.Request, h Handle) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(intTimeout)*time.Second)
+	if strings.EqualFold(r.Header.Get(InvocationHeader), "async") {
+		enqueue(w, r, h)
+		return
+	}
+	loadFailurePolicy()
+	var code int
+	var res []byte
+	if syncRetryPolicy.MaxAttempts <= 1 && failureDestination == nil {
+		code, res = execute(w, r, h)
+	} else {
+		// Keep the body to retry the request or to send it to the failure destination
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		// The response of each attempt is recorded so only the last one is sent. Every attempt
+		// gets its own request and recorder since a timed out handler may still be using them
+		var header http.Header
+		attempt := 1
+		for ; ; attempt++ {
+			req := r.WithContext(r.Context())
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			code, header, res = executeRecorded(newResponseRecorder(), req, h)
+			if !failed(code) || attempt >= syncRetryPolicy.MaxAttempts || r.Context().Err() != nil {
+				break
+			}
+			time.Sleep(syncRetryPolicy.Delay(attempt))
+		}
+		if failed(code) {
+			handleFailedEvent(newFailedEvent(r.Method, r.Header, body, code, res, attempt))
+		}
+		copyHeaders(w.Header(), header)
+	}
+	// The complete response is known, its size is checked before sending it
+	w.Header().Set("Content-Length", strconv.Itoa(len(res)))
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+	}
+	w.Write(res)
+}
+
+// execute runs the handler function within the function timeout and records its metrics.
+// It returns the status code and the body of the response
+// The handler is cancelled if the client goes away
+func execute(w http.ResponseWriter, r *http.Request, h Handle) (int, []byte) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(intTimeout)*time.Second)
 	defer cancel()
 	funcChannel := make(chan struct {
 		res string
@@ -124,24 +293,63 @@ func Handler(w http.ResponseWriter, r *http.Request, h Handle) {
 	}()
 	select {
 	case respPack := <-funcChannel:
+		watchdog.success()
 		if respPack.err != nil {
 			funcErrors.With(prometheus.Labels{"method": r.Method}).Inc()
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("Error: %v", respPack.err)))
-		} else {
-			w.Write([]byte(respPack.res))
+			return http.StatusInternalServerError, []byte(fmt.Sprintf("Error: %v", respPack.err))
 		}
+		return http.StatusOK, []byte(respPack.res)
 	// Send Timeout response
 	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			return http.StatusRequestTimeout, []byte("Request cancelled")
+		}
 		funcErrors.With(prometheus.Labels{"method": r.Method}).Inc()
-		w.WriteHeader(http.StatusRequestTimeout)
-		w.Write([]byte("Timeout exceeded"))
+		watchdog.timeout()
+		return http.StatusRequestTimeout, []byte("Timeout exceeded")
 	}
 }
 
-// ListenAndServe starts an HTTP server in FUNC_PORT using custom logging
+// executeRecorded runs the handler function storing the response in a recorder.
+// It returns the status code, the headers and the complete body of the response. If the
+// handler is still running after a timeout the recorder is dropped without reading it
+func executeRecorded(rec *responseRecorder, r *http.Request, h Handle) (int, http.Header, []byte) {
+	done := make(chan struct{})
+	code, res := execute(rec, r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		defer close(done)
+		return h(ctx, w, r)
+	})
+	select {
+	case <-done:
+	default:
+		return code, http.Header{}, res
+	}
+	if rec.exceeded || int64(rec.body.Len()+len(res)) > rec.max {
+		funcRejected.With(prometheus.Labels{"reason": rejectResponseTooLarge}).Inc()
+		log.Printf("The response of the function exceeds the maximum size of %d bytes", rec.max)
+		return http.StatusBadGateway, http.Header{}, []byte(fmt.Sprintf("Response too large, the maximum size is %d bytes", rec.max))
+	}
+	if code == http.StatusOK && rec.statusCode != 0 {
+		// The handler may have set the status of the response
+		code = rec.statusCode
+	}
+	// The handler may have written part of the response
+	return code, rec.header, append(rec.body.Bytes(), res...)
+}
+
+// ListenAndServe starts an HTTP server in FUNC_PORT using custom logging and tracing.
+// gRPC functions also accept HTTP/2 connections in clear text. When the pod is terminated
+// the requests in flight are completed before returning
 func ListenAndServe() {
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", funcPort), logReq(http.DefaultServeMux)); err != nil {
+	var handler http.Handler = logReq(traceReq(http.DefaultServeMux))
+	if protocol == protocolGRPC {
+		handler = &h2cHandler{handler: handler, server: &http2.Server{}}
+	}
+	server := &http.Server{Addr: fmt.Sprintf(":%s", funcPort), Handler: handler}
+	done := make(chan struct{})
+	go drainOnSignal(server, notifyTermination(), done)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		panic(err)
 	}
+	<-done
 }
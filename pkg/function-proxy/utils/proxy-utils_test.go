@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeless/kubeless/pkg/tracing"
+)
+
+func TestLogReq(t *testing.T) {
+	prevOutput, prevName, prevNamespace := logOutput, funcName, funcNamespace
+	defer func() {
+		logOutput, funcName, funcNamespace = prevOutput, prevName, prevNamespace
+	}()
+	output := &bytes.Buffer{}
+	logOutput = output
+	funcName, funcNamespace = "foo", "myns"
+
+	received := ""
+	h := logReq(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(EventIDHeader)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	for _, eventID := range []string{"abc123", ""} {
+		output.Reset()
+		req := httptest.NewRequest("POST", "/", nil)
+		if eventID != "" {
+			req.Header.Set(EventIDHeader, eventID)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		record := logRecord{}
+		if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+			t.Fatalf("Expecting a JSON record, received %q: %v", output.String(), err)
+		}
+		if received == "" || record.EventID != received {
+			t.Errorf("Expecting the function to receive the event ID %q, received %q", record.EventID, received)
+		}
+		if eventID != "" && record.EventID != eventID {
+			t.Errorf("Expecting event ID %s, received %s", eventID, record.EventID)
+		}
+		if record.Namespace != "myns" || record.Function != "foo" || record.Method != "POST" || record.Status != http.StatusCreated || record.Bytes != 5 {
+			t.Errorf("Unexpected record %+v", record)
+		}
+	}
+}
+
+func TestTraceReq(t *testing.T) {
+	prevTracer := tracer
+	defer func() {
+		tracer = prevTracer
+	}()
+	exporter := &tracing.InMemoryExporter{}
+	tracer = tracing.NewTracer("foo.myns", exporter)
+
+	// The wrapped process receives the headers forwarded by the proxy
+	received := make(chan http.Header, 1)
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer function.Close()
+	h := traceReq(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest(r.Method, function.URL, r.Body)
+		copyHeaders(req.Header, r.Header)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		res.Body.Close()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-B3-TraceId", "4bf92f3577b34da6a3ce929d0e0e4736")
+	req.Header.Set("X-B3-SpanId", "00f067aa0ba902b7")
+	req.Header.Set(EventIDHeader, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expecting a span, received %d", len(spans))
+	}
+	span := spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentID != "00f067aa0ba902b7" || span.Kind != tracing.KindServer {
+		t.Errorf("Unexpected span %+v", span)
+	}
+	if span.Name != "GET /foo" || span.Service != "foo.myns" || span.Tags["http.status_code"] != "502" || span.Tags["error"] != "true" || span.Tags["kubeless.event_id"] != "abc" {
+		t.Errorf("Unexpected span %+v", span)
+	}
+	forwarded := tracing.Extract(<-received)
+	if forwarded != span.SpanContext {
+		t.Errorf("Expecting the function to receive the context %+v, received %+v", span.SpanContext, forwarded)
+	}
+
+	// Health checks are not traced
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if len(exporter.Spans()) != 1 {
+		t.Error("Health checks should not be traced")
+	}
+}
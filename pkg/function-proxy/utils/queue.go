@@ -0,0 +1,541 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InvocationStatus is the state of an asynchronous invocation
+type InvocationStatus string
+
+// Possible states of an asynchronous invocation
+const (
+	InvocationQueued    InvocationStatus = "Queued"
+	InvocationRunning   InvocationStatus = "Running"
+	InvocationSucceeded InvocationStatus = "Succeeded"
+	InvocationFailed    InvocationStatus = "Failed"
+)
+
+// InvocationRequest contains the request that triggered an asynchronous invocation
+type InvocationRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Invocation is an event queued to be processed asynchronously and its result
+type Invocation struct {
+	ID          string             `json:"id"`
+	Status      InvocationStatus   `json:"status"`
+	Attempts    int                `json:"attempts"`
+	StatusCode  int                `json:"statusCode,omitempty"`
+	Result      string             `json:"result,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty"`
+	Request     *InvocationRequest `json:"request,omitempty"`
+}
+
+// Finished returns true if the invocation will not be executed again
+func (i *Invocation) Finished() bool {
+	return i.Status == InvocationSucceeded || i.Status == InvocationFailed
+}
+
+// ErrInvocationNotFound is returned when an invocation is not in the queue
+var ErrInvocationNotFound = fmt.Errorf("invocation not found")
+
+// Queue stores the asynchronous invocations of a function and their results
+type Queue interface {
+	// Push stores a new invocation pending to be executed
+	Push(inv *Invocation) error
+	// Pop blocks until there is an invocation pending to be executed or the context is done
+	Pop(ctx context.Context) (*Invocation, error)
+	// Update stores the new state of an invocation
+	Update(inv *Invocation) error
+	// Get returns the invocation with the given ID
+	Get(id string) (*Invocation, error)
+}
+
+// memoryQueue keeps the invocations in memory. Finished invocations are kept for the given TTL
+type memoryQueue struct {
+	mutex       sync.Mutex
+	invocations map[string]*Invocation
+	pending     []string
+	notify      chan struct{}
+	ttl         time.Duration
+}
+
+// NewMemoryQueue returns a queue that doesn't survive a restart of the function
+func NewMemoryQueue(ttl time.Duration) Queue {
+	return newMemoryQueue(ttl)
+}
+
+func newMemoryQueue(ttl time.Duration) *memoryQueue {
+	return &memoryQueue{
+		invocations: map[string]*Invocation{},
+		notify:      make(chan struct{}, 1),
+		ttl:         ttl,
+	}
+}
+
+func (q *memoryQueue) Push(inv *Invocation) error {
+	_, err := q.push(inv)
+	return err
+}
+
+// push stores a new invocation and returns the IDs of the invocations expired
+func (q *memoryQueue) push(inv *Invocation) ([]string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	expired := q.expire()
+	if _, ok := q.invocations[inv.ID]; ok {
+		return expired, fmt.Errorf("invocation %s already exists", inv.ID)
+	}
+	q.invocations[inv.ID] = copyInvocation(inv)
+	q.pending = append(q.pending, inv.ID)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return expired, nil
+}
+
+func (q *memoryQueue) Pop(ctx context.Context) (*Invocation, error) {
+	for {
+		q.mutex.Lock()
+		if len(q.pending) > 0 {
+			id := q.pending[0]
+			q.pending = q.pending[1:]
+			inv := copyInvocation(q.invocations[id])
+			if len(q.pending) > 0 {
+				// Wake up other workers waiting for invocations
+				select {
+				case q.notify <- struct{}{}:
+				default:
+				}
+			}
+			q.mutex.Unlock()
+			return inv, nil
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *memoryQueue) Update(inv *Invocation) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.invocations[inv.ID]; !ok {
+		return ErrInvocationNotFound
+	}
+	q.invocations[inv.ID] = copyInvocation(inv)
+	return nil
+}
+
+func (q *memoryQueue) Get(id string) (*Invocation, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	inv, ok := q.invocations[id]
+	if !ok {
+		return nil, ErrInvocationNotFound
+	}
+	return copyInvocation(inv), nil
+}
+
+// expire removes the finished invocations older than the TTL. The caller should hold the lock
+func (q *memoryQueue) expire() []string {
+	expired := []string{}
+	if q.ttl <= 0 {
+		return expired
+	}
+	for id, inv := range q.invocations {
+		if inv.Finished() && inv.CompletedAt != nil && time.Since(*inv.CompletedAt) > q.ttl {
+			delete(q.invocations, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+func copyInvocation(inv *Invocation) *Invocation {
+	c := *inv
+	if inv.Request != nil {
+		req := *inv.Request
+		c.Request = &req
+	}
+	return &c
+}
+
+// ErrLeaseLost is returned when an invocation is updated by a replica that no longer holds its lease
+var ErrLeaseLost = fmt.Errorf("the invocation has been claimed by another replica")
+
+var (
+	// queueLeaseDuration is the time after which an invocation claimed by a replica that stopped
+	// renewing its lease can be claimed by another replica
+	queueLeaseDuration = 30 * time.Second
+	// queuePollInterval is the time between scans of the queue directory for new invocations
+	queuePollInterval = time.Second
+
+	invocationIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+// fileQueue stores the invocations as JSON files in a directory that can be shared by all the
+// replicas of a function (a ReadWriteMany volume). For each invocation the directory contains:
+//   - <id>.json: The invocation and its result.
+//   - <id>.pending: Exists until the invocation is finished. Its modification time is the time in
+//     which the invocation was received.
+//   - <id>.lease-<n>: The n-th claim of the invocation. Files are created exclusively so a single
+//     replica gets each claim. The replica renews the lease updating its modification time and,
+//     if it stops doing it (e.g. because its pod has been deleted), any replica can claim the
+//     invocation again once the lease expires.
+type fileQueue struct {
+	dir    string
+	ttl    time.Duration
+	lease  time.Duration
+	owner  string
+	notify chan struct{}
+
+	mutex      sync.Mutex
+	claims     map[string]*queueClaim
+	lastExpire time.Time
+}
+
+// queueClaim is a lease held by this replica
+type queueClaim struct {
+	generation int
+	stop       chan struct{}
+}
+
+// NewFileQueue returns a queue stored in the given directory. Invocations that were not
+// finished when the replica that claimed them stopped are executed again
+func NewFileQueue(dir string, ttl time.Duration) (Queue, error) {
+	owner, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return newFileQueue(dir, ttl, owner)
+}
+
+// newFileQueue returns a queue for the replica identified by owner
+func newFileQueue(dir string, ttl time.Duration, owner string) (*fileQueue, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	q := &fileQueue{
+		dir:    dir,
+		ttl:    ttl,
+		lease:  queueLeaseDuration,
+		owner:  owner,
+		notify: make(chan struct{}, 1),
+		claims: map[string]*queueClaim{},
+	}
+	// Leases of a previous execution of this replica can be claimed right away
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, _, ok := parseLease(f.Name()); !ok {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		if data, err := ioutil.ReadFile(path); err == nil && string(data) == owner {
+			os.Chtimes(path, time.Unix(0, 0), time.Unix(0, 0))
+		}
+	}
+	return q, nil
+}
+
+func (q *fileQueue) path(id, suffix string) string {
+	return filepath.Join(q.dir, id+suffix)
+}
+
+func (q *fileQueue) leasePath(id string, generation int) string {
+	return q.path(id, fmt.Sprintf(".lease-%d", generation))
+}
+
+// parseLease returns the invocation ID and the generation of a lease file
+func parseLease(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".lease-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	generation, err := strconv.Atoi(name[i+len(".lease-"):])
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:i], generation, true
+}
+
+func validInvocationID(id string) error {
+	if !invocationIDRegexp.MatchString(id) {
+		return fmt.Errorf("invalid invocation ID %q", id)
+	}
+	return nil
+}
+
+func (q *fileQueue) save(inv *Invocation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	// Write the file atomically so a crash doesn't leave a corrupted invocation
+	tmp, err := ioutil.TempFile(q.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path(inv.ID, ".json"))
+}
+
+func (q *fileQueue) load(id string) (*Invocation, error) {
+	data, err := ioutil.ReadFile(q.path(id, ".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrInvocationNotFound
+		}
+		return nil, err
+	}
+	inv := &Invocation{}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("unable to load invocation %s: %v", id, err)
+	}
+	return inv, nil
+}
+
+func (q *fileQueue) Push(inv *Invocation) error {
+	if err := validInvocationID(inv.ID); err != nil {
+		return err
+	}
+	q.expire()
+	if _, err := os.Stat(q.path(inv.ID, ".json")); err == nil {
+		return fmt.Errorf("invocation %s already exists", inv.ID)
+	}
+	if err := q.save(inv); err != nil {
+		return err
+	}
+	pending := q.path(inv.ID, ".pending")
+	if err := ioutil.WriteFile(pending, nil, 0600); err != nil {
+		return err
+	}
+	// Invocations are claimed in the order in which they were received
+	if !inv.CreatedAt.IsZero() {
+		os.Chtimes(pending, inv.CreatedAt, inv.CreatedAt)
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *fileQueue) Pop(ctx context.Context) (*Invocation, error) {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		inv, err := q.claimNext()
+		if err != nil || inv != nil {
+			return inv, err
+		}
+		select {
+		case <-q.notify:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// claimNext claims the oldest pending invocation that is not leased by another replica
+func (q *fileQueue) claimNext() (*Invocation, error) {
+	q.expire()
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	pending := []os.FileInfo{}
+	leases := map[string]os.FileInfo{}
+	generations := map[string]int{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".pending") {
+			pending = append(pending, f)
+		} else if id, generation, ok := parseLease(f.Name()); ok && generation >= generations[id] {
+			generations[id] = generation
+			leases[id] = f
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ModTime().Before(pending[j].ModTime())
+	})
+	for _, f := range pending {
+		id := strings.TrimSuffix(f.Name(), ".pending")
+		q.mutex.Lock()
+		_, claimed := q.claims[id]
+		q.mutex.Unlock()
+		if claimed {
+			continue
+		}
+		if lease, ok := leases[id]; ok && time.Since(lease.ModTime()) < q.lease {
+			continue
+		}
+		generation := generations[id] + 1
+		lease, err := os.OpenFile(q.leasePath(id, generation), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			// Another replica got the claim first
+			continue
+		}
+		lease.Write([]byte(q.owner))
+		lease.Close()
+		inv, err := q.load(id)
+		if err != nil || inv.Finished() {
+			if err != nil {
+				log.Printf("Unable to claim invocation %s: %v", id, err)
+			}
+			os.Remove(q.leasePath(id, generation))
+			continue
+		}
+		c := &queueClaim{generation: generation, stop: make(chan struct{})}
+		q.mutex.Lock()
+		q.claims[id] = c
+		q.mutex.Unlock()
+		go q.renew(id, c)
+		return inv, nil
+	}
+	return nil, nil
+}
+
+// renew keeps the lease of an invocation until the claim is released
+func (q *fileQueue) renew(id string, c *queueClaim) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(q.leasePath(id, c.generation), now, now); os.IsNotExist(err) {
+				return
+			} else if err != nil {
+				log.Printf("Unable to renew the lease of invocation %s: %v", id, err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// release stops renewing the lease of an invocation. The caller should hold the lock
+func (q *fileQueue) release(id string) {
+	if c, ok := q.claims[id]; ok {
+		close(c.stop)
+		delete(q.claims, id)
+	}
+}
+
+// Update stores the new state of an invocation. It fails with ErrLeaseLost if the invocation
+// has been claimed by another replica since this replica claimed it
+func (q *fileQueue) Update(inv *Invocation) error {
+	if err := validInvocationID(inv.ID); err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	c, ok := q.claims[inv.ID]
+	if !ok {
+		return ErrLeaseLost
+	}
+	if _, err := os.Stat(q.leasePath(inv.ID, c.generation+1)); err == nil {
+		q.release(inv.ID)
+		return ErrLeaseLost
+	}
+	if _, err := os.Stat(q.path(inv.ID, ".json")); os.IsNotExist(err) {
+		return ErrInvocationNotFound
+	}
+	if err := q.save(inv); err != nil {
+		return err
+	}
+	if inv.Finished() {
+		q.release(inv.ID)
+		os.Remove(q.path(inv.ID, ".pending"))
+		for generation := c.generation; generation > 0; generation-- {
+			os.Remove(q.leasePath(inv.ID, generation))
+		}
+	}
+	return nil
+}
+
+func (q *fileQueue) Get(id string) (*Invocation, error) {
+	if err := validInvocationID(id); err != nil {
+		return nil, ErrInvocationNotFound
+	}
+	return q.load(id)
+}
+
+// expire removes the finished invocations older than the TTL. The directory is scanned at
+// most once a minute
+func (q *fileQueue) expire() {
+	q.mutex.Lock()
+	if q.ttl <= 0 || time.Since(q.lastExpire) < time.Minute {
+		q.mutex.Unlock()
+		return
+	}
+	q.lastExpire = time.Now()
+	q.mutex.Unlock()
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return
+	}
+	pending := map[string]bool{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".pending") {
+			pending[strings.TrimSuffix(f.Name(), ".pending")] = true
+		}
+	}
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+		if id == f.Name() || pending[id] || time.Since(f.ModTime()) < q.ttl {
+			continue
+		}
+		os.Remove(filepath.Join(q.dir, f.Name()))
+	}
+}
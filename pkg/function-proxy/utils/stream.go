@@ -0,0 +1,212 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrHandlerTimeout is returned by the writes of a handler that exceeded the function timeout
+var ErrHandlerTimeout = errors.New("Timeout exceeded")
+
+// hopHeaders are the headers that apply to a single connection and are not forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// StreamHandle receives the context elements of a HTTP request and writes the response
+// of the function as it is produced
+type StreamHandle func(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+
+// StreamHandler receives an HTTP request and response and a streaming handler function.
+// The response is sent to the client as the handler writes it. Asynchronous requests and
+// functions with a retry policy or a failure destination need the complete response so
+// in those cases it is buffered as in Handler
+func StreamHandler(w http.ResponseWriter, r *http.Request, h StreamHandle) {
+	loadFailurePolicy()
+	if strings.EqualFold(r.Header.Get(InvocationHeader), "async") || syncRetryPolicy.MaxAttempts > 1 || failureDestination != nil {
+		Handler(w, r, BufferedHandle(h))
+		return
+	}
+	stream(w, r, h)
+}
+
+// BufferedHandle adapts a streaming handler to be executed with a response recorder, for
+// example to process asynchronous invocations
+func BufferedHandle(h StreamHandle) Handle {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+		return nil, h(ctx, w, r)
+	}
+}
+
+// WriteResponse sends the status, headers and body of a response received from the
+// function process. The body is flushed to the client as it arrives
+func WriteResponse(w http.ResponseWriter, res *http.Response) error {
+	defer res.Body.Close()
+	header := w.Header()
+	copyHeaders(header, res.Header)
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+	w.WriteHeader(res.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// timeoutWriter forwards the writes of a handler until the function timeout is exceeded
+type timeoutWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.statusCode = code
+	dst := tw.w.Header()
+	for k, vv := range tw.header {
+		dst[k] = vv
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut {
+		tw.writeHeaderLocked(code)
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		f.Flush()
+	}
+}
+
+// stream runs a streaming handler within the function timeout and records its metrics
+func stream(w http.ResponseWriter, r *http.Request, h StreamHandle) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(intTimeout)*time.Second)
+	defer cancel()
+	labels := prometheus.Labels{"method": r.Method}
+	funcCalls.With(labels).Inc()
+	start := time.Now()
+	tw := &timeoutWriter{w: w, header: http.Header{}}
+	done := make(chan error, 1)
+	go func() {
+		done <- h(ctx, tw, r)
+	}()
+	select {
+	case err := <-done:
+		funcHistogram.With(labels).Observe(time.Since(start).Seconds())
+		watchdog.success()
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if err == errResponseTooLarge {
+			// The response has been rejected or truncated by the proxy
+			return
+		}
+		if err != nil {
+			funcErrors.With(labels).Inc()
+			if !tw.wroteHeader {
+				tw.writeHeaderLocked(http.StatusInternalServerError)
+				tw.w.Write([]byte(fmt.Sprintf("Error: %v", err)))
+				return
+			}
+			// The response has been partially sent, the client will receive it truncated
+			log.Printf("Unable to complete the response: %v", err)
+			return
+		}
+		if tw.statusCode >= http.StatusInternalServerError {
+			funcErrors.With(labels).Inc()
+		}
+		tw.writeHeaderLocked(http.StatusOK)
+	// Send Timeout response
+	case <-ctx.Done():
+		funcHistogram.With(labels).Observe(time.Since(start).Seconds())
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if ctx.Err() != context.DeadlineExceeded {
+			// The client is gone, the request to the function process has been cancelled
+			tw.timedOut = true
+			return
+		}
+		funcErrors.With(labels).Inc()
+		watchdog.timeout()
+		if !tw.wroteHeader {
+			tw.writeHeaderLocked(http.StatusRequestTimeout)
+			tw.w.Write([]byte("Timeout exceeded"))
+		}
+		tw.timedOut = true
+	}
+}
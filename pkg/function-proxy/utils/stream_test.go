@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyTo returns a streaming handler that forwards the requests to the given URL
+func proxyTo(url string) StreamHandle {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		req, err := http.NewRequest(r.Method, url, r.Body)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		copyHeaders(req.Header, r.Header)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return WriteResponse(w, res)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	release := make(chan struct{})
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Custom", "foo")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("data: first\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: second\n"))
+	}))
+	defer function.Close()
+	defer close(release)
+
+	h := proxyTo(function.URL)
+	proxy := httptest.NewServer(logReq(traceReq(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamHandler(w, r, h)
+	}))))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("Expecting status %d, received %d", http.StatusAccepted, res.StatusCode)
+	}
+	if res.Header.Get("Content-Type") != "text/event-stream" || res.Header.Get("X-Custom") != "foo" {
+		t.Errorf("Expecting the headers of the function, received %v", res.Header)
+	}
+	// The first event is received before the function finishes
+	reader := bufio.NewReader(res.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("Expecting the first event, received %q: %v", line, err)
+	}
+	release <- struct{}{}
+	rest, err := ioutil.ReadAll(reader)
+	if err != nil || string(rest) != "data: second\n" {
+		t.Errorf("Expecting the second event, received %q: %v", rest, err)
+	}
+}
+
+func TestStreamHandlerErrors(t *testing.T) {
+	prevTimeout := intTimeout
+	defer func() {
+		intTimeout = prevTimeout
+	}()
+	intTimeout = 1
+
+	tests := []struct {
+		name         string
+		handle       StreamHandle
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "error before writing",
+			handle: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return context.Canceled
+			},
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "Error: context canceled",
+		},
+		{
+			name: "timeout before writing",
+			handle: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			expectedCode: http.StatusRequestTimeout,
+			expectedBody: "Timeout exceeded",
+		},
+		{
+			name: "timeout while streaming",
+			handle: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				w.Write([]byte("partial"))
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				if _, err := w.Write([]byte("late")); err != ErrHandlerTimeout {
+					t.Errorf("Expecting the writes after the timeout to fail, received %v", err)
+				}
+				return nil
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "partial",
+		},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		StreamHandler(w, httptest.NewRequest("GET", "/", nil), tt.handle)
+		if w.Code != tt.expectedCode || w.Body.String() != tt.expectedBody {
+			t.Errorf("%s: expecting %d %q, received %d %q", tt.name, tt.expectedCode, tt.expectedBody, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestStreamHandlerWithRetries(t *testing.T) {
+	loadFailurePolicy()
+	prevPolicy := syncRetryPolicy
+	defer func() {
+		syncRetryPolicy = prevPolicy
+	}()
+	syncRetryPolicy = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+
+	attempts := 0
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("X-Attempt", "first")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("unavailable"))
+			return
+		}
+		w.Header().Set("X-Attempt", "second")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer function.Close()
+
+	w := httptest.NewRecorder()
+	StreamHandler(w, httptest.NewRequest("POST", "/", strings.NewReader("data")), proxyTo(function.URL))
+	if w.Code != http.StatusCreated || w.Body.String() != "created" {
+		t.Errorf("Expecting the response of the last attempt, received %d %q", w.Code, w.Body.String())
+	}
+	if attempt := w.Header()["X-Attempt"]; len(attempt) != 1 || attempt[0] != "second" {
+		t.Errorf("Expecting the headers of the last attempt, received %v", w.Header())
+	}
+}
+
+func TestStreamHandlerRetryAfterTimeout(t *testing.T) {
+	loadFailurePolicy()
+	prevPolicy, prevTimeout := syncRetryPolicy, intTimeout
+	defer func() {
+		syncRetryPolicy, intTimeout = prevPolicy, prevTimeout
+	}()
+	syncRetryPolicy = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	intTimeout = 1
+
+	// The first attempt ignores the timeout and keeps writing its response while
+	// the second one runs. It should be detected with -race
+	attempts := make(chan int, 2)
+	attempts <- 1
+	attempts <- 2
+	finished := make(chan struct{})
+	handle := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if <-attempts == 2 {
+			w.Header().Set("X-Attempt", "second")
+			w.Write([]byte("ok"))
+			return nil
+		}
+		defer close(finished)
+		ioutil.ReadAll(r.Body)
+		for i := 0; i < 150; i++ {
+			w.Header().Set("X-Attempt", "first")
+			w.Write([]byte("slow"))
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	StreamHandler(w, httptest.NewRequest("POST", "/", strings.NewReader("data")), handle)
+	<-finished
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("Expecting the response of the last attempt, received %d %q", w.Code, w.Body.String())
+	}
+	if attempt := w.Header()["X-Attempt"]; len(attempt) != 1 || attempt[0] != "second" {
+		t.Errorf("Expecting the headers of the last attempt, received %v", w.Header())
+	}
+}
@@ -0,0 +1,562 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonschema validates JSON documents with the most common keywords of JSON Schema
+// (draft 4 to draft 7): type, enum, const, properties, required, additionalProperties, items,
+// the length, size and range limits, pattern and the allOf, anyOf, oneOf and not combinators.
+// Annotations (like title or $schema) are ignored. Schemas with any other keyword (like $ref or
+// format) are rejected since the documents would not be fully validated.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	// always is set for the boolean schemas true and false
+	always *bool
+
+	types    []string
+	enum     []interface{}
+	constant []interface{}
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items    *Schema
+	minItems *int
+	maxItems *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+// ValidationError describes why a document doesn't match a schema
+type ValidationError struct {
+	// Path is the JSON pointer of the invalid value
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// Compile parses a JSON Schema
+func Compile(data []byte) (*Schema, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	return compile(v, "")
+}
+
+func compile(v interface{}, path string) (*Schema, error) {
+	switch s := v.(type) {
+	case bool:
+		return &Schema{always: &s}, nil
+	case map[string]interface{}:
+		return compileObject(s, path)
+	default:
+		return nil, fmt.Errorf("invalid JSON schema at %s: expecting an object or a boolean", pointer(path))
+	}
+}
+
+func compileObject(m map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+	var err error
+	invalid := func(keyword, msg string) error {
+		return fmt.Errorf("invalid JSON schema at %s: %s %s", pointer(path+"/"+keyword), keyword, msg)
+	}
+	sub := func(keyword string, v interface{}) (*Schema, error) {
+		return compile(v, path+"/"+keyword)
+	}
+	subs := func(keyword string, v interface{}) ([]*Schema, error) {
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, invalid(keyword, "should be a non empty array")
+		}
+		res := []*Schema{}
+		for i, item := range list {
+			c, err := compile(item, path+"/"+keyword+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, c)
+		}
+		return res, nil
+	}
+	integer := func(keyword string, v interface{}) (*int, error) {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, invalid(keyword, "should be a non negative integer")
+		}
+		i, err := strconv.Atoi(n.String())
+		if err != nil || i < 0 {
+			return nil, invalid(keyword, "should be a non negative integer")
+		}
+		return &i, nil
+	}
+	number := func(keyword string, v interface{}) (*float64, error) {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, invalid(keyword, "should be a number")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, invalid(keyword, "should be a number")
+		}
+		return &f, nil
+	}
+
+	for keyword, v := range m {
+		switch keyword {
+		case "type":
+			switch t := v.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					name, ok := item.(string)
+					if !ok {
+						return nil, invalid(keyword, "should be a string or an array of strings")
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, invalid(keyword, "should be a string or an array of strings")
+			}
+			for _, t := range s.types {
+				switch t {
+				case "null", "boolean", "object", "array", "number", "integer", "string":
+				default:
+					return nil, invalid(keyword, fmt.Sprintf("has an unknown type %q", t))
+				}
+			}
+		case "enum":
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, invalid(keyword, "should be an array")
+			}
+			s.enum = list
+		case "const":
+			s.constant = []interface{}{v}
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, invalid(keyword, "should be an object")
+			}
+			s.properties = map[string]*Schema{}
+			for name, p := range props {
+				if s.properties[name], err = compile(p, path+"/properties/"+escape(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, invalid(keyword, "should be an array of strings")
+			}
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok {
+					return nil, invalid(keyword, "should be an array of strings")
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if s.additionalProperties, err = sub(keyword, v); err != nil {
+				return nil, err
+			}
+		case "minProperties":
+			if s.minProperties, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "maxProperties":
+			if s.maxProperties, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = sub(keyword, v); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			if s.minItems, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "maxItems":
+			if s.maxItems, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "minLength":
+			if s.minLength, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "maxLength":
+			if s.maxLength, err = integer(keyword, v); err != nil {
+				return nil, err
+			}
+		case "pattern":
+			p, ok := v.(string)
+			if !ok {
+				return nil, invalid(keyword, "should be a string")
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, invalid(keyword, fmt.Sprintf("is not a valid regular expression: %v", err))
+			}
+		case "minimum":
+			if s.minimum, err = number(keyword, v); err != nil {
+				return nil, err
+			}
+		case "maximum":
+			if s.maximum, err = number(keyword, v); err != nil {
+				return nil, err
+			}
+		case "exclusiveMinimum", "exclusiveMaximum":
+			// Draft 4 uses booleans that modify minimum and maximum, later drafts use numbers
+			if _, ok := v.(bool); ok {
+				continue
+			}
+			n, err := number(keyword, v)
+			if err != nil {
+				return nil, err
+			}
+			if keyword == "exclusiveMinimum" {
+				s.exclusiveMinimum = n
+			} else {
+				s.exclusiveMaximum = n
+			}
+		case "multipleOf":
+			if s.multipleOf, err = number(keyword, v); err != nil {
+				return nil, err
+			}
+			if *s.multipleOf <= 0 {
+				return nil, invalid(keyword, "should be greater than 0")
+			}
+		case "allOf":
+			if s.allOf, err = subs(keyword, v); err != nil {
+				return nil, err
+			}
+		case "anyOf":
+			if s.anyOf, err = subs(keyword, v); err != nil {
+				return nil, err
+			}
+		case "oneOf":
+			if s.oneOf, err = subs(keyword, v); err != nil {
+				return nil, err
+			}
+		case "not":
+			if s.not, err = sub(keyword, v); err != nil {
+				return nil, err
+			}
+		case "$schema", "$id", "id", "$comment", "title", "description", "default", "examples", "readOnly", "writeOnly":
+			// Annotations don't affect the validation
+		default:
+			return nil, fmt.Errorf("invalid JSON schema at %s: unsupported keyword %s", pointer(path+"/"+escape(keyword)), keyword)
+		}
+	}
+	// Draft 4 exclusive limits
+	if b, ok := m["exclusiveMinimum"].(bool); ok && b && s.minimum != nil {
+		s.exclusiveMinimum, s.minimum = s.minimum, nil
+	}
+	if b, ok := m["exclusiveMaximum"].(bool); ok && b && s.maximum != nil {
+		s.exclusiveMaximum, s.maximum = s.maximum, nil
+	}
+	return s, nil
+}
+
+// ValidateJSON returns an error if data is not a JSON document that matches the schema
+func (s *Schema) ValidateJSON(data []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return &ValidationError{Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if d.More() {
+		return &ValidationError{Message: "invalid JSON: unexpected data after the document"}
+	}
+	return s.Validate(v)
+}
+
+// Validate returns a *ValidationError if the decoded JSON value doesn't match the schema.
+// Numbers can be float64 or json.Number values
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate(v, "")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if s.always != nil {
+		if !*s.always {
+			return fail("no value is allowed")
+		}
+		return nil
+	}
+
+	if len(s.types) > 0 {
+		t := typeOf(v)
+		valid := false
+		for _, expected := range s.types {
+			if expected == t || (expected == "number" && t == "integer") {
+				valid = true
+			}
+		}
+		if !valid {
+			return fail("expecting %s, found %s", strings.Join(s.types, " or "), t)
+		}
+	}
+	if s.enum != nil {
+		valid := false
+		for _, e := range s.enum {
+			if equal(v, e) {
+				valid = true
+			}
+		}
+		if !valid {
+			return fail("the value is not one of the allowed values")
+		}
+	}
+	if len(s.constant) > 0 && !equal(v, s.constant[0]) {
+		return fail("the value is not the expected constant")
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := value[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		if s.minProperties != nil && len(value) < *s.minProperties {
+			return fail("expecting at least %d properties", *s.minProperties)
+		}
+		if s.maxProperties != nil && len(value) > *s.maxProperties {
+			return fail("expecting at most %d properties", *s.maxProperties)
+		}
+		// Validate the properties in order so the errors are deterministic
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propertyPath := path + "/" + escape(name)
+			if p, ok := s.properties[name]; ok {
+				if err := p.validate(value[name], propertyPath); err != nil {
+					return err
+				}
+			} else if s.additionalProperties != nil {
+				if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+					return fail("unexpected property %q", name)
+				}
+				if err := s.additionalProperties.validate(value[name], propertyPath); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			return fail("expecting at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			return fail("expecting at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(value))
+		if s.minLength != nil && length < *s.minLength {
+			return fail("expecting at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fail("expecting at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return fail("the value doesn't match the pattern %s", s.pattern.String())
+		}
+	case json.Number, float64:
+		n, ok := toFloat(value)
+		if !ok {
+			return fail("invalid number %v", value)
+		}
+		if s.minimum != nil && n < *s.minimum {
+			return fail("expecting a value greater than or equal to %v", *s.minimum)
+		}
+		if s.maximum != nil && n > *s.maximum {
+			return fail("expecting a value less than or equal to %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+			return fail("expecting a value greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+			return fail("expecting a value less than %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := n / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				return fail("expecting a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		valid := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, path) == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fail("the value doesn't match any of the schemas of anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail("the value matches %d schemas of oneOf, expecting exactly one", matches)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fail("the value matches the schema of not")
+	}
+	return nil
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value
+func typeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		if n, ok := toFloat(value); ok && n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal compares two decoded JSON values. Numbers are compared by value
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for k, v := range va {
+			if !equal(v, vb[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !equal(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// escape encodes a property name as a token of a JSON pointer
+func escape(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`"string"`,
+		`{"type": "text"}`,
+		`{"type": 1}`,
+		`{"required": "name"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"multipleOf": 0}`,
+		`{"anyOf": []}`,
+		`{"properties": {"a": 1}}`,
+		`{"$ref": "#/definitions/user"}`,
+		`{"type": "string", "format": "email"}`,
+		`{"properties": {"a": {"patternProperties": {"^x-": {}}}}}`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("Expecting %s to be an invalid schema", schema)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		doc    string
+		err    string
+	}{
+		{"boolean true", `true`, `{"a": 1}`, ""},
+		{"annotations", `{"$schema": "http://json-schema.org/draft-07/schema#", "title": "A", "description": "B", "default": 1}`, `1`, ""},
+		{"boolean false", `false`, `1`, "/: no value is allowed"},
+		{"type", `{"type": "object"}`, `[]`, "/: expecting object, found array"},
+		{"type list", `{"type": ["string", "null"]}`, `null`, ""},
+		{"integer", `{"type": "integer"}`, `1.0`, ""},
+		{"not an integer", `{"type": "integer"}`, `1.5`, "/: expecting integer, found number"},
+		{"integer is a number", `{"type": "number"}`, `3`, ""},
+		{"enum", `{"enum": ["a", 1]}`, `1.0`, ""},
+		{"not in enum", `{"enum": ["a", 1]}`, `"b"`, "/: the value is not one of the allowed values"},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, ""},
+		{
+			"required",
+			`{"type": "object", "required": ["name"]}`,
+			`{"age": 1}`,
+			`/: missing required property "name"`,
+		},
+		{
+			"nested property",
+			`{"properties": {"user": {"properties": {"age": {"type": "integer", "minimum": 0}}}}}`,
+			`{"user": {"age": -1}}`,
+			"/user/age: expecting a value greater than or equal to 0",
+		},
+		{
+			"additional properties",
+			`{"properties": {"a": {}}, "additionalProperties": false}`,
+			`{"a": 1, "b": 2}`,
+			`/: unexpected property "b"`,
+		},
+		{
+			"additional properties schema",
+			`{"additionalProperties": {"type": "string"}}`,
+			`{"a/b": 2}`,
+			"/a~1b: expecting string, found integer",
+		},
+		{"items", `{"items": {"type": "string"}}`, `["a", 2]`, "/1: expecting string, found integer"},
+		{"max items", `{"maxItems": 1}`, `[1, 2]`, "/: expecting at most 1 items"},
+		{"min length", `{"minLength": 2}`, `"é"`, "/: expecting at least 2 characters"},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, ""},
+		{"pattern mismatch", `{"pattern": "^[a-z]+$"}`, `"ABC"`, "/: the value doesn't match the pattern ^[a-z]+$"},
+		{"exclusive maximum", `{"exclusiveMaximum": 10}`, `10`, "/: expecting a value less than 10"},
+		{"draft 4 exclusive minimum", `{"minimum": 0, "exclusiveMinimum": true}`, `0`, "/: expecting a value greater than 0"},
+		{"multiple of", `{"multipleOf": 0.5}`, `1.5`, ""},
+		{"any of", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, "/: the value doesn't match any of the schemas of anyOf"},
+		{"one of", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, "/: the value matches 2 schemas of oneOf, expecting exactly one"},
+		{"not", `{"not": {"type": "null"}}`, `null`, "/: the value matches the schema of not"},
+		{"invalid json", `{}`, `{"a":`, "/: invalid JSON: unexpected EOF"},
+		{"trailing data", `{}`, `{} {}`, "/: invalid JSON: unexpected data after the document"},
+	}
+	for _, test := range tests {
+		s, err := Compile([]byte(test.schema))
+		if err != nil {
+			t.Fatalf("%s: unexpected error compiling the schema: %v", test.name, err)
+		}
+		err = s.ValidateJSON([]byte(test.doc))
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expecting an error", test.name)
+		} else if err.Error() != test.err {
+			t.Errorf("%s: expecting error %q, received %q", test.name, test.err, err.Error())
+		}
+	}
+}
+
+func TestValidationErrorPath(t *testing.T) {
+	s, err := Compile([]byte(`{"properties": {"items": {"items": {"required": ["id"]}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ValidateJSON([]byte(`{"items": [{"id": 1}, {}]}`))
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expecting a ValidationError, received %v", err)
+	}
+	if verr.Path != "/items/1" || !strings.Contains(verr.Message, `"id"`) {
+		t.Errorf("Unexpected error %v", verr)
+	}
+}
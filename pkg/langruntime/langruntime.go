@@ -9,8 +9,8 @@ import (
 
 	yaml "github.com/ghodss/yaml"
 	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -53,11 +53,15 @@ type ImageSecret struct {
 // RuntimeInfo describe the runtime specifics (typical file suffix and dependency file name)
 // and the supported versions
 type RuntimeInfo struct {
-	ID                string           `yaml:"ID"`
-	Versions          []RuntimeVersion `yaml:"versions"`
-	LivenessProbeInfo *v1.Probe        `yaml:"livenessProbeInfo,omitempty"`
-	DepName           string           `yaml:"depName"`
-	FileNameSuffix    string           `yaml:"fileNameSuffix"`
+	ID                 string           `yaml:"ID"`
+	Versions           []RuntimeVersion `yaml:"versions"`
+	LivenessProbeInfo  *v1.Probe        `yaml:"livenessProbeInfo,omitempty"`
+	ReadinessProbeInfo *v1.Probe        `yaml:"readinessProbeInfo,omitempty"`
+	// FunctionProxy marks the runtimes whose functions are served through the Kubeless function
+	// proxy. They get a readiness probe on the /ready endpoint of the proxy by default
+	FunctionProxy  bool   `yaml:"functionProxy,omitempty"`
+	DepName        string `yaml:"depName"`
+	FileNameSuffix string `yaml:"fileNameSuffix"`
 }
 
 // New initializes a langruntime object
@@ -156,6 +160,29 @@ func (l *Langruntimes) GetLivenessProbeInfo(runtime string, port int) *v1.Probe
 	return livenessProbe
 }
 
+// GetReadinessProbeInfo returns the readiness probe of a runtime. Runtimes based on the function
+// proxy use its /ready endpoint by default, the rest of the runtimes don't have a readiness probe
+func (l *Langruntimes) GetReadinessProbeInfo(runtime string, port int) *v1.Probe {
+	runtimeID := regexp.MustCompile("^[a-zA-Z]+").FindString(runtime)
+	for _, runtimeInf := range l.AvailableRuntimes {
+		if runtimeInf.ID == runtimeID {
+			if runtimeInf.ReadinessProbeInfo != nil || !runtimeInf.FunctionProxy {
+				return runtimeInf.ReadinessProbeInfo
+			}
+			return &v1.Probe{
+				PeriodSeconds: int32(2),
+				Handler: v1.Handler{
+					HTTPGet: &v1.HTTPGetAction{
+						Path: "/ready",
+						Port: intstr.FromInt(port),
+					},
+				},
+			}
+		}
+	}
+	return nil
+}
+
 func (l *Langruntimes) findRuntimeVersion(runtimeWithVersion string) (RuntimeVersion, error) {
 	version := l.getVersionFromRuntime(runtimeWithVersion)
 	runtimeInf, err := l.GetRuntimeInfo(runtimeWithVersion)
@@ -293,7 +320,7 @@ func (l *Langruntimes) GetBuildContainer(runtime, depsChecksum string, env []v1.
 }
 
 // UpdateDeployment object in case of custom runtime
-func (l *Langruntimes) UpdateDeployment(dpm *v1beta1.Deployment, volPath, runtime string) {
+func (l *Langruntimes) UpdateDeployment(dpm *appsv1.Deployment, volPath, runtime string) {
 	versionInf, err := l.findRuntimeVersion(runtime)
 	if err != nil {
 		// Not found an image for the given runtime
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -89,6 +90,43 @@ func TestGetLivenessProbe(t *testing.T) {
 	}
 }
 
+func TestGetReadinessProbe(t *testing.T) {
+	lr := SetupLangRuntime(clientset)
+	lr.ReadConfigMap()
+	if probe := lr.GetReadinessProbeInfo("python2.7", 8080); probe != nil {
+		t.Errorf("Expecting no readiness probe, found %v", probe)
+	}
+
+	// Runtimes based on the function proxy use its /ready endpoint
+	lr.AvailableRuntimes[0].FunctionProxy = true
+	expectedReadinessProbe := &v1.Probe{
+		PeriodSeconds: int32(2),
+		Handler: v1.Handler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path: "/ready",
+				Port: intstr.FromInt(9090),
+			},
+		},
+	}
+	if probe := lr.GetReadinessProbeInfo("python2.7", 9090); !reflect.DeepEqual(probe, expectedReadinessProbe) {
+		t.Errorf("Expected readinessProbeInfo to be %v, but found %v", expectedReadinessProbe, probe)
+	}
+
+	expectedReadinessProbe = &v1.Probe{
+		PeriodSeconds: int32(5),
+		Handler: v1.Handler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path: "/custom",
+				Port: intstr.FromInt(8080),
+			},
+		},
+	}
+	lr.AvailableRuntimes[0].ReadinessProbeInfo = expectedReadinessProbe
+	if probe := lr.GetReadinessProbeInfo("python2.7", 8080); !reflect.DeepEqual(probe, expectedReadinessProbe) {
+		t.Errorf("Expected readinessProbeInfo to be %v, but found %v", expectedReadinessProbe, probe)
+	}
+}
+
 func TestGetRuntimes(t *testing.T) {
 	lr := SetupLangRuntime(clientset)
 	lr.ReadConfigMap()
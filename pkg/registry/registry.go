@@ -17,12 +17,17 @@ limitations under the License.
 package registry
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
+	"strings"
 	"time"
 
 	"k8s.io/api/core/v1"
@@ -40,7 +45,32 @@ type Credentials struct {
 type Registry struct {
 	Endpoint string
 	Version  string
-	Creds    Credentials
+	// Repository is the path of the registry URL under which the function images are pushed
+	Repository string
+	Creds      Credentials
+	// CABundle contains PEM encoded certificates trusted to verify the registry
+	// in addition to the system ones
+	CABundle []byte
+	// Insecure disables the verification of the registry certificate
+	Insecure bool
+
+	client *http.Client
+	// authorization is the last Authorization header accepted by the registry
+	authorization string
+}
+
+// CABundleKey is the key of the registry secret that contains the CA bundle of the registry
+const CABundleKey = "ca.crt"
+
+// tokenUsernames are the usernames used by registries that authenticate with tokens (ECR, GCR,
+// Azure...) instead of user accounts, so they can't be used as the repository of the images
+var tokenUsernames = map[string]bool{
+	"AWS":                                  true,
+	"_json_key":                            true,
+	"_json_key_base64":                     true,
+	"oauth2accesstoken":                    true,
+	"_token":                               true,
+	"00000000-0000-0000-0000-000000000000": true,
 }
 
 type tagv1 struct {
@@ -57,7 +87,18 @@ type dockerCfg struct {
 	Auths map[string]Credentials `json:"auths"`
 }
 
-// New returns a Registry struct parsing its URL and storing the required credentials
+// manifestTypes are the manifest formats accepted when checking if an image exists
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// New returns a Registry struct parsing its URL and storing the required credentials.
+// Registry URLs without an API version (like the ones used for ECR or GCR) use the v2 API.
+// The path that follows the host and the API version is the repository of the images
 func New(config v1.Secret) (*Registry, error) {
 	// Parse secret
 	cfg := dockerCfg{}
@@ -69,18 +110,86 @@ func New(config v1.Secret) (*Registry, error) {
 	if len(regs) > 1 {
 		return nil, fmt.Errorf("Found several registries: %q, unable to decide which one to use", regs)
 	}
+	if len(regs) == 0 {
+		return nil, fmt.Errorf("Unable to find any registry in the secret %s", config.Name)
+	}
 	registryURL := regs[0].String()
-	re := regexp.MustCompile("(https?://.*)/(v[0-9]+)/?")
-	parsedURL := re.FindStringSubmatch(registryURL)
+	endpoint := registryURL
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	re := regexp.MustCompile("^(https?://[^/]+)(/(v[0-9]+))?(/([^?#]*[^/?#]))?/?$")
+	parsedURL := re.FindStringSubmatch(endpoint)
 	if len(parsedURL) == 0 {
 		return nil, fmt.Errorf("Unable to parse registry URL %s", registryURL)
 	}
+	version := parsedURL[3]
+	if version == "" {
+		version = "v2"
+	}
+	creds := cfg.Auths[registryURL]
+	if creds.Username == "" && creds.Auth != "" {
+		// Some tools only store the encoded "user:password"
+		decoded, err := base64.StdEncoding.DecodeString(creds.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode the credentials of %s: %v", registryURL, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid credentials for %s", registryURL)
+		}
+		creds.Username, creds.Password = parts[0], parts[1]
+	}
 	reg := Registry{
-		Endpoint: parsedURL[1],
-		Version:  parsedURL[2],
-		Creds:    cfg.Auths[registryURL],
+		Endpoint:   parsedURL[1],
+		Version:    version,
+		Repository: parsedURL[5],
+		Creds:      creds,
+		CABundle:   config.Data[CABundleKey],
+	}
+	return &reg, nil
+}
+
+// ImageName returns the name (without the registry host) of the image of a function. Images
+// are pushed under the repository of the registry URL or, if it doesn't have one, under the
+// account of the registry user
+func (r *Registry) ImageName(name string) (string, error) {
+	if r.Repository != "" {
+		return fmt.Sprintf("%s/%s", r.Repository, name), nil
+	}
+	if r.Creds.Username == "" || tokenUsernames[r.Creds.Username] {
+		return "", fmt.Errorf("Unable to find the repository of the images, add it to the registry URL (e.g. %s/my-repository)", r.Endpoint)
+	}
+	return fmt.Sprintf("%s/%s", r.Creds.Username, name), nil
+}
+
+// httpClient returns the client used to connect to the registry
+func (r *Registry) httpClient() (*http.Client, error) {
+	if r.client != nil {
+		return r.client, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: r.Insecure}
+	if len(r.CABundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(r.CABundle) {
+			return nil, fmt.Errorf("Unable to parse the CA bundle of the registry %s", r.Endpoint)
+		}
+		tlsConfig.RootCAs = pool
 	}
-	return &reg, err
+	r.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:              http.ProxyFromEnvironment,
+			TLSClientConfig:    tlsConfig,
+			MaxIdleConns:       10,
+			IdleConnTimeout:    30 * time.Second,
+			DisableCompression: true,
+		},
+		Timeout: 30 * time.Second,
+	}
+	return r.client, nil
 }
 
 // getTags return the list of tags from an HTTP response to the tag/list API endpoint
@@ -121,6 +230,11 @@ func (r *Registry) tagURL(img string) (string, error) {
 	}
 }
 
+// manifestURL return the URL of the manifest of an image
+func (r *Registry) manifestURL(img, tag string) string {
+	return fmt.Sprintf("%s/%s/%s/manifests/%s", r.Endpoint, r.Version, img, tag)
+}
+
 // findProperty returns the value of a property from a list witht the format 'foo="bar",bar="foo"'
 func findProperty(src, property string) (string, error) {
 	re := regexp.MustCompile(fmt.Sprintf("%s=\"([^\"]*)\"", property))
@@ -132,101 +246,190 @@ func findProperty(src, property string) (string, error) {
 }
 
 type authResponse struct {
-	Token string `json:"token"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
 }
 
-// doRequestWithAuth does an HTTP GET agains the given url parsing the authInfo given
-func doRequestWithAuth(authInfo, url string, client *http.Client) ([]byte, error) {
-	bearer, err := findProperty(authInfo, "Bearer realm")
+// getToken requests a bearer token to the realm of the authInfo given, authenticating
+// with the credentials of the registry if there are any
+func (r *Registry) getToken(authInfo string, client *http.Client) (string, error) {
+	realm, err := findProperty(authInfo, "realm")
 	if err != nil {
-		return nil, fmt.Errorf("Unable to extract auth info: %v", err)
+		return "", fmt.Errorf("Unable to extract auth info: %v", err)
 	}
-	service, err := findProperty(authInfo, "service")
+	tokenURL, err := url.Parse(realm)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to extract auth info: %v", err)
+		return "", fmt.Errorf("Unable to parse the auth realm %s: %v", realm, err)
 	}
-	scope, err := findProperty(authInfo, "scope")
+	q := tokenURL.Query()
+	// The service and the scope are optional
+	if service, err := findProperty(authInfo, "service"); err == nil {
+		q.Set("service", service)
+	}
+	if scope, err := findProperty(authInfo, "scope"); err == nil {
+		q.Set("scope", scope)
+	}
+	tokenURL.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", tokenURL.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to extract auth info: %v", err)
+		return "", err
+	}
+	if r.Creds.Username != "" {
+		req.SetBasicAuth(r.Creds.Username, r.Creds.Password)
 	}
-	authResp, err := client.Get(fmt.Sprintf("%s?service=%s&scope=%s", bearer, service, scope))
+	authResp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to obtain auth token: %v", err)
+		return "", fmt.Errorf("Unable to obtain auth token: %v", err)
 	}
 	defer authResp.Body.Close()
+	if authResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to obtain auth token: %s", authResp.Status)
+	}
 	authb, err := ioutil.ReadAll(authResp.Body)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	authr := authResponse{}
 	err = json.Unmarshal(authb, &authr)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse auth token: %v", err)
+		return "", fmt.Errorf("Unable to parse auth token: %v", err)
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authr.Token))
-	respWithAuth, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if authr.Token == "" {
+		authr.Token = authr.AccessToken
 	}
-	defer respWithAuth.Body.Close()
-	body, err := ioutil.ReadAll(respWithAuth.Body)
-	if err != nil {
-		return nil, err
-	}
-	return body, nil
+	return authr.Token, nil
 }
 
-func (r *Registry) doRequest(url string) ([]byte, error) {
-	tr := &http.Transport{
-		MaxIdleConns:       10,
-		IdleConnTimeout:    30 * time.Second,
-		DisableCompression: true,
-	}
-	client := &http.Client{
-		Transport: tr,
+// authorize returns the Authorization header required by the challenge of a 401 response
+func (r *Registry) authorize(authInfo string, client *http.Client) (string, error) {
+	scheme := strings.ToLower(strings.SplitN(strings.TrimSpace(authInfo), " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if r.Creds.Username == "" {
+			return "", fmt.Errorf("Failed to authenticate: the registry %s requires credentials", r.Endpoint)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(r.Creds.Username+":"+r.Creds.Password)), nil
+	case "bearer":
+		token, err := r.getToken(authInfo, client)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("Failed to authenticate: unknown authentication format: %q", authInfo)
 	}
-	resp, err := client.Get(url)
+}
+
+// doRequest sends a request to the registry, authenticating it if the registry requires it
+func (r *Registry) doRequest(method, url string, header http.Header) (*http.Response, error) {
+	client, err := r.httpClient()
 	if err != nil {
 		return nil, err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if r.authorization != "" {
+			req.Header.Set("Authorization", r.authorization)
+		}
+		return client.Do(req)
+	}
+	resp, err := send()
 	if err != nil {
 		return nil, err
 	}
 	// Handle auth if needed
-	if resp.StatusCode == 401 {
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
 		// Get auth info from headers
 		authInfo := resp.Header.Get("Www-Authenticate")
 		if authInfo == "" {
-			return nil, fmt.Errorf("Failed to authenticate: unknown authentication format: %v", body)
+			return nil, fmt.Errorf("Failed to authenticate: the registry %s didn't return an authentication challenge", r.Endpoint)
 		}
-		body, err = doRequestWithAuth(authInfo, url, client)
+		r.authorization, err = r.authorize(authInfo, client)
 		if err != nil {
 			return nil, err
 		}
+		resp, err = send()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Failed to authenticate against %s: %s", r.Endpoint, resp.Status)
+		}
 	}
-	return body, nil
+	return resp, nil
 }
 
-// ImageExists checks if a certain image:tag exists in the registry
-func (r *Registry) ImageExists(id, tag string) (bool, error) {
-	url, err := r.tagURL(id)
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Tags returns the tags of an image. Paginated responses of the v2 API are followed until the last page
+func (r *Registry) Tags(id string) ([]string, error) {
+	next, err := r.tagURL(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	body, err := r.doRequest(url)
-	if err != nil {
-		return false, err
+	tags := []string{}
+	for next != "" {
+		resp, err := r.doRequest("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			// There is no image with that ID yet
+			return []string{}, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Unable to list the tags of %s: %s", id, resp.Status)
+		}
+		page, err := r.getTags(body)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page...)
+		next = ""
+		if link := nextLink.FindStringSubmatch(resp.Header.Get("Link")); link != nil {
+			nextURL, err := resp.Request.URL.Parse(link[1])
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse the next page of tags %s: %v", link[1], err)
+			}
+			next = nextURL.String()
+		}
 	}
-	if match, _ := regexp.MatchString("Resource not found", string(body)); match {
-		// There is no image with that ID yet
-		return false, nil
+	return tags, nil
+}
+
+// ImageExists checks if a certain image:tag exists in the registry
+func (r *Registry) ImageExists(id, tag string) (bool, error) {
+	if r.Version == "v2" {
+		resp, err := r.doRequest("HEAD", r.manifestURL(id, tag), http.Header{"Accept": {strings.Join(manifestTypes, ", ")}})
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		case http.StatusMethodNotAllowed:
+			// The registry doesn't support HEAD requests, fall back to the list of tags
+		default:
+			return false, fmt.Errorf("Unable to check the manifest of %s:%s: %s", id, tag, resp.Status)
+		}
 	}
-	tags, err := r.getTags(body)
+	tags, err := r.Tags(id)
 	if err != nil {
 		return false, err
 	}
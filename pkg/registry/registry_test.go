@@ -1,7 +1,14 @@
 package registry
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"k8s.io/api/core/v1"
@@ -90,3 +97,257 @@ func TestGetTagsV2(t *testing.T) {
 		t.Errorf("Unexpected tags: %v", tags)
 	}
 }
+
+func TestNewWithoutVersion(t *testing.T) {
+	s := v1.Secret{
+		Data: map[string][]byte{
+			".dockerconfigjson": []byte(`{"auths":{"123.dkr.ecr.us-east-1.amazonaws.com":{"auth":"QVdTOnRva2Vu"}}}`),
+			"ca.crt":            []byte("cert"),
+		},
+	}
+	r, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Endpoint != "https://123.dkr.ecr.us-east-1.amazonaws.com" || r.Version != "v2" {
+		t.Errorf("Unexpected endpoint %s/%s", r.Endpoint, r.Version)
+	}
+	if r.Creds.Username != "AWS" || r.Creds.Password != "token" {
+		t.Errorf("Unexpected credentials %s:%s", r.Creds.Username, r.Creds.Password)
+	}
+	if string(r.CABundle) != "cert" {
+		t.Errorf("Unexpected CA bundle %s", r.CABundle)
+	}
+}
+
+func TestNewWithRepository(t *testing.T) {
+	tests := []struct {
+		url        string
+		endpoint   string
+		version    string
+		repository string
+	}{
+		{"https://index.docker.io/v1/", "https://index.docker.io", "v1", ""},
+		{"gcr.io/my-project", "https://gcr.io", "v2", "my-project"},
+		{"https://gcr.io/my-project/", "https://gcr.io", "v2", "my-project"},
+		{"https://registry.example.com/v2/team/functions", "https://registry.example.com", "v2", "team/functions"},
+		{"https://registry.example.com/v1beta", "https://registry.example.com", "v2", "v1beta"},
+	}
+	for _, tt := range tests {
+		s := v1.Secret{
+			Data: map[string][]byte{
+				".dockerconfigjson": []byte(fmt.Sprintf(`{"auths":{%q:{"username":"_json_key","password":"{}"}}}`, tt.url)),
+			},
+		}
+		r, err := New(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.url, err)
+			continue
+		}
+		if r.Endpoint != tt.endpoint || r.Version != tt.version || r.Repository != tt.repository {
+			t.Errorf("%s: unexpected registry %s/%s repository %q", tt.url, r.Endpoint, r.Version, r.Repository)
+		}
+	}
+}
+
+func TestImageName(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry Registry
+		expected string
+	}{
+		{"docker hub", Registry{Creds: Credentials{Username: "user"}}, "user/foo"},
+		{"repository", Registry{Repository: "my-project", Creds: Credentials{Username: "_json_key"}}, "my-project/foo"},
+		{"ecr", Registry{Creds: Credentials{Username: "AWS"}}, ""},
+		{"gcr", Registry{Creds: Credentials{Username: "_json_key"}}, ""},
+		{"anonymous", Registry{}, ""},
+	}
+	for _, tt := range tests {
+		image, err := tt.registry.ImageName("foo")
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error, received %s", tt.name, image)
+			}
+			continue
+		}
+		if err != nil || image != tt.expected {
+			t.Errorf("%s: expecting %s, received %s (%v)", tt.name, tt.expected, image, err)
+		}
+	}
+}
+
+// fakeRegistry serves the manifests and tags of a set of images. Depending on the auth
+// property requests should use basic authentication, a bearer token or nothing
+type fakeRegistry struct {
+	auth     string
+	images   map[string][]string
+	pageSize int
+	noHead   bool
+}
+
+func (f *fakeRegistry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	switch f.auth {
+	case "basic":
+		if user, pass, ok := req.BasicAuth(); ok && user == "user" && pass == "pass" {
+			return true
+		}
+		w.Header().Set("Www-Authenticate", `Basic realm="fake"`)
+	case "bearer":
+		if req.Header.Get("Authorization") == "Bearer valid-token" {
+			return true
+		}
+		w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake",scope="repository:user/image:pull"`, req.Host))
+	default:
+		return true
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "user" || pass != "pass" || req.URL.Query().Get("scope") != "repository:user/image:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "valid-token"})
+		return
+	}
+	if !f.authorized(w, req) {
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		tags, ok := f.images[strings.TrimSuffix(path, "/tags/list")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"}]}`)
+			return
+		}
+		start := 0
+		if last := req.URL.Query().Get("last"); last != "" {
+			for i, t := range tags {
+				if t == last {
+					start = i + 1
+				}
+			}
+		}
+		end := len(tags)
+		if f.pageSize > 0 && start+f.pageSize < end {
+			end = start + f.pageSize
+			w.Header().Set("Link", fmt.Sprintf(`<%s?n=%d&last=%s>; rel="next"`, req.URL.Path, f.pageSize, tags[end-1]))
+		}
+		json.NewEncoder(w).Encode(tagListV2{Name: path, Tags: tags[start:end]})
+	case strings.Contains(path, "/manifests/"):
+		if req.Method == "HEAD" && f.noHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.SplitN(path, "/manifests/", 2)
+		for _, t := range f.images[parts[0]] {
+			if t == parts[1] {
+				w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestImageExists(t *testing.T) {
+	images := map[string][]string{"user/image": {"a", "b", "c", "d", "e"}}
+	tests := []struct {
+		name     string
+		registry fakeRegistry
+		creds    Credentials
+		image    string
+		tag      string
+		exists   bool
+		err      string
+	}{
+		{name: "anonymous", registry: fakeRegistry{images: images}, image: "user/image", tag: "c", exists: true},
+		{name: "missing tag", registry: fakeRegistry{images: images}, image: "user/image", tag: "z"},
+		{name: "missing image", registry: fakeRegistry{images: images}, image: "user/other", tag: "a"},
+		{name: "basic auth", registry: fakeRegistry{auth: "basic", images: images}, creds: Credentials{Username: "user", Password: "pass"}, image: "user/image", tag: "a", exists: true},
+		{name: "basic auth without credentials", registry: fakeRegistry{auth: "basic", images: images}, image: "user/image", tag: "a", err: "requires credentials"},
+		{name: "basic auth with wrong credentials", registry: fakeRegistry{auth: "basic", images: images}, creds: Credentials{Username: "user", Password: "wrong"}, image: "user/image", tag: "a", err: "401"},
+		{name: "token auth", registry: fakeRegistry{auth: "bearer", images: images}, creds: Credentials{Username: "user", Password: "pass"}, image: "user/image", tag: "e", exists: true},
+		{name: "token auth with encoded credentials", registry: fakeRegistry{auth: "bearer", images: images}, creds: Credentials{Auth: base64.StdEncoding.EncodeToString([]byte("user:pass"))}, image: "user/image", tag: "e", exists: true},
+		{name: "token auth without credentials", registry: fakeRegistry{auth: "bearer", images: images}, image: "user/image", tag: "e", err: "Unable to obtain auth token"},
+		{name: "paginated tags", registry: fakeRegistry{noHead: true, pageSize: 2, images: images}, image: "user/image", tag: "e", exists: true},
+		{name: "paginated tags with auth", registry: fakeRegistry{auth: "bearer", noHead: true, pageSize: 2, images: images}, creds: Credentials{Username: "user", Password: "pass"}, image: "user/image", tag: "e", exists: true},
+		{name: "missing paginated image", registry: fakeRegistry{noHead: true, pageSize: 2, images: images}, image: "user/other", tag: "e"},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(&test.registry)
+		defer server.Close()
+		secretCfg, _ := json.Marshal(dockerCfg{Auths: map[string]Credentials{server.URL + "/v2/": test.creds}})
+		r, err := New(v1.Secret{Data: map[string][]byte{".dockerconfigjson": secretCfg}})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		exists, err := r.ImageExists(test.image, test.tag)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expecting error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if exists != test.exists {
+			t.Errorf("%s: expecting %v, got %v", test.name, test.exists, exists)
+		}
+	}
+}
+
+func TestTagsPagination(t *testing.T) {
+	f := fakeRegistry{pageSize: 2, images: map[string][]string{"user/image": {"a", "b", "c", "d", "e"}}}
+	server := httptest.NewServer(&f)
+	defer server.Close()
+	r := Registry{Endpoint: server.URL, Version: "v2"}
+	tags, err := r.Tags("user/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	f := fakeRegistry{images: map[string][]string{"user/image": {"a"}}}
+	server := httptest.NewTLSServer(&f)
+	defer server.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	tests := []struct {
+		name     string
+		caBundle []byte
+		insecure bool
+		err      string
+	}{
+		{name: "untrusted certificate", err: "certificate"},
+		{name: "CA bundle", caBundle: caBundle},
+		{name: "insecure", insecure: true},
+		{name: "invalid CA bundle", caBundle: []byte("invalid"), err: "Unable to parse the CA bundle"},
+	}
+	for _, test := range tests {
+		r := Registry{Endpoint: server.URL, Version: "v2", CABundle: test.caBundle, Insecure: test.insecure}
+		exists, err := r.ImageExists("user/image", "a")
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expecting error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil || !exists {
+			t.Errorf("%s: expecting the image to exist, got %v, %v", test.name, exists, err)
+		}
+	}
+}
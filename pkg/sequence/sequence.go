@@ -0,0 +1,411 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/tracing"
+)
+
+// Validate checks that the steps of a sequence are well formed
+func Validate(spec *kubelessApi.SequenceSpec) error {
+	if len(spec.Steps) == 0 {
+		return fmt.Errorf("A sequence requires at least one step")
+	}
+	if spec.Timeout != "" {
+		if t, err := strconv.Atoi(spec.Timeout); err != nil || t < 0 {
+			return fmt.Errorf("Invalid timeout %s. It should be a number of seconds", spec.Timeout)
+		}
+	}
+	return validateSteps(spec.Steps)
+}
+
+func validateSteps(steps []kubelessApi.SequenceStep) error {
+	for _, step := range steps {
+		kinds := 0
+		if step.Function != "" {
+			kinds++
+		}
+		if len(step.Branches) > 0 {
+			kinds++
+		}
+		if len(step.Parallel) > 0 {
+			kinds++
+		}
+		if kinds != 1 {
+			return fmt.Errorf("Step %q should define exactly one of function, branches or parallel", step.Name)
+		}
+		if err := validateBranches(step.Branches, false); err != nil {
+			return fmt.Errorf("Step %q: %v", step.Name, err)
+		}
+		if err := validateBranches(step.Parallel, true); err != nil {
+			return fmt.Errorf("Step %q: %v", step.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateBranches(branches []kubelessApi.SequenceBranch, parallel bool) error {
+	names := map[string]bool{}
+	for _, b := range branches {
+		if parallel {
+			// The name of a parallel branch is the key of its output
+			if b.Name == "" {
+				return fmt.Errorf("Parallel branches require a name")
+			}
+			if names[b.Name] {
+				return fmt.Errorf("Duplicated branch %q", b.Name)
+			}
+			names[b.Name] = true
+		}
+		if b.When != nil && b.When.Matches != "" {
+			if _, err := regexp.Compile(b.When.Matches); err != nil {
+				return fmt.Errorf("Invalid expression in branch %q: %v", b.Name, err)
+			}
+		}
+		if len(b.Steps) == 0 {
+			return fmt.Errorf("Branch %q has no steps", b.Name)
+		}
+		if err := validateSteps(b.Steps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Functions returns the sorted list of functions invoked by a sequence
+func Functions(spec *kubelessApi.SequenceSpec) []string {
+	found := map[string]bool{}
+	collectFunctions(spec.Steps, found)
+	functions := []string{}
+	for f := range found {
+		functions = append(functions, f)
+	}
+	sort.Strings(functions)
+	return functions
+}
+
+func collectFunctions(steps []kubelessApi.SequenceStep, found map[string]bool) {
+	for _, step := range steps {
+		if step.Function != "" {
+			found[step.Function] = true
+		}
+		for _, b := range step.Branches {
+			collectFunctions(b.Steps, found)
+		}
+		for _, b := range step.Parallel {
+			collectFunctions(b.Steps, found)
+		}
+	}
+}
+
+// StepError is returned when a function of a sequence fails
+type StepError struct {
+	Step       string
+	Function   string
+	StatusCode int
+	Message    string
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("Step %q (function %s) failed with status %d: %s", e.Step, e.Function, e.StatusCode, e.Message)
+}
+
+// message is the input or output of a step
+type message struct {
+	data        []byte
+	contentType string
+}
+
+// Executor runs the steps of a sequence invoking its functions through HTTP
+type Executor struct {
+	spec      kubelessApi.SequenceSpec
+	functions map[string]string
+	timeout   time.Duration
+	client    *http.Client
+	tracer    *tracing.Tracer
+	logger    *logrus.Entry
+}
+
+// NewExecutor returns an executor of the given sequence. functions contains the URL
+// of each function invoked by the sequence. A timeout of 0 means no timeout
+func NewExecutor(spec kubelessApi.SequenceSpec, functions map[string]string, timeout time.Duration) (*Executor, error) {
+	if err := Validate(&spec); err != nil {
+		return nil, err
+	}
+	for _, f := range Functions(&spec) {
+		if functions[f] == "" {
+			return nil, fmt.Errorf("Unknown URL for function %s", f)
+		}
+	}
+	return &Executor{
+		spec:      spec,
+		functions: functions,
+		timeout:   timeout,
+		client:    &http.Client{},
+		tracer:    tracing.NewTracerFromEnv("sequence"),
+		logger:    logrus.WithField("pkg", "sequence"),
+	}, nil
+}
+
+// ServeHTTP executes the sequence with the body of the request and returns the output of the last step
+func (e *Executor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the request: %v", err), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	span := e.tracer.StartSpan(r.Method+" "+r.URL.Path, tracing.KindServer, tracing.Extract(r.Header))
+	defer span.End()
+	header := http.Header{}
+	for _, h := range []string{"event-id", "event-time", "event-namespace"} {
+		if v := r.Header.Get(h); v != "" {
+			header.Set(h, v)
+		}
+	}
+	// The calls to the functions are children of the span of the sequence
+	tracing.Inject(span.Context(), header)
+	out, err := e.run(ctx, e.spec.Steps, message{data: data, contentType: r.Header.Get("Content-Type")}, header)
+	if err != nil {
+		e.logger.Errorf("Sequence failed: %v", err)
+		span.SetTag("error", err.Error())
+		code := http.StatusBadGateway
+		if ctx.Err() == context.DeadlineExceeded {
+			code = http.StatusGatewayTimeout
+		} else if stepErr, ok := err.(*StepError); ok && stepErr.StatusCode >= 400 {
+			code = stepErr.StatusCode
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if out.contentType != "" {
+		w.Header().Set("Content-Type", out.contentType)
+	}
+	w.Write(out.data)
+}
+
+// run executes a list of steps passing the output of each step to the next one
+func (e *Executor) run(ctx context.Context, steps []kubelessApi.SequenceStep, in message, header http.Header) (message, error) {
+	var err error
+	for _, step := range steps {
+		switch {
+		case step.Function != "":
+			in, err = e.invoke(ctx, step, in, header)
+		case len(step.Branches) > 0:
+			in, err = e.branch(ctx, step, in, header)
+		default:
+			in, err = e.parallel(ctx, step, in, header)
+		}
+		if err != nil {
+			return message{}, err
+		}
+	}
+	return in, nil
+}
+
+// invoke calls the function of a step
+func (e *Executor) invoke(ctx context.Context, step kubelessApi.SequenceStep, in message, header http.Header) (message, error) {
+	req, err := http.NewRequest("POST", e.functions[step.Function], bytes.NewReader(in.data))
+	if err != nil {
+		return message{}, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	span := e.tracer.StartSpan(step.Function, tracing.KindClient, tracing.Extract(header))
+	defer span.End()
+	span.SetTag("kubeless.step", step.Name)
+	tracing.Inject(span.Context(), req.Header)
+	if in.contentType != "" {
+		req.Header.Set("Content-Type", in.contentType)
+		req.Header.Set("event-type", in.contentType)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		span.SetTag("error", err.Error())
+		return message{}, &StepError{Step: step.Name, Function: step.Function, StatusCode: http.StatusBadGateway, Message: err.Error()}
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return message{}, err
+	}
+	span.SetTag("http.status_code", strconv.Itoa(res.StatusCode))
+	if res.StatusCode >= 300 {
+		span.SetTag("error", "true")
+		return message{}, &StepError{Step: step.Name, Function: step.Function, StatusCode: res.StatusCode, Message: string(data)}
+	}
+	return message{data: data, contentType: res.Header.Get("Content-Type")}, nil
+}
+
+// branch executes the first branch of a step whose condition matches the input
+func (e *Executor) branch(ctx context.Context, step kubelessApi.SequenceStep, in message, header http.Header) (message, error) {
+	for _, b := range step.Branches {
+		match, err := Match(b.When, in.data)
+		if err != nil {
+			return message{}, fmt.Errorf("Step %q: %v", step.Name, err)
+		}
+		if match {
+			return e.run(ctx, b.Steps, in, header)
+		}
+	}
+	return in, nil
+}
+
+// parallel executes the branches of a step that match the input at the same time and
+// combines their outputs in a JSON object
+func (e *Executor) parallel(ctx context.Context, step kubelessApi.SequenceStep, in message, header http.Header) (message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	outputs := make([]json.RawMessage, len(step.Parallel))
+	// Only the first error is returned since the rest of branches fail because they are cancelled
+	var firstErr error
+	var errOnce sync.Once
+	// The conditions are evaluated before starting any branch so an error doesn't leave
+	// branches running in the background
+	matched := make([]bool, len(step.Parallel))
+	for i, b := range step.Parallel {
+		match, err := Match(b.When, in.data)
+		if err != nil {
+			return message{}, fmt.Errorf("Step %q: %v", step.Name, err)
+		}
+		matched[i] = match
+	}
+	var wg sync.WaitGroup
+	for i, b := range step.Parallel {
+		if !matched[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b kubelessApi.SequenceBranch) {
+			defer wg.Done()
+			out, err := e.run(ctx, b.Steps, in, header)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					// Stop the rest of branches
+					cancel()
+				})
+				return
+			}
+			outputs[i] = toJSON(out.data)
+		}(i, b)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return message{}, firstErr
+	}
+	result := map[string]json.RawMessage{}
+	for i, b := range step.Parallel {
+		if outputs[i] != nil {
+			result[b.Name] = outputs[i]
+		}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return message{}, err
+	}
+	return message{data: data, contentType: "application/json"}, nil
+}
+
+// toJSON returns the data as is if it is a JSON document or encoded as a string in other case
+func toJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	s, _ := json.Marshal(string(data))
+	return json.RawMessage(s)
+}
+
+// Match returns true if the condition matches the given input. A nil condition always matches
+func Match(c *kubelessApi.SequenceCondition, data []byte) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	value, found := lookup(c.Path, data)
+	if c.Exists != nil && *c.Exists != found {
+		return false, nil
+	}
+	if c.Equals != "" && (!found || value != c.Equals) {
+		return false, nil
+	}
+	if c.Matches != "" {
+		re, err := regexp.Compile(c.Matches)
+		if err != nil {
+			return false, err
+		}
+		if !found || !re.MatchString(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// lookup returns the value of a field of a JSON document as a string. Fields are separated by dots
+func lookup(path string, data []byte) (string, bool) {
+	if path == "" {
+		var s string
+		if err := json.Unmarshal(data, &s); err == nil {
+			return s, true
+		}
+		return string(data), true
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", false
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		doc, ok = obj[key]
+		if !ok {
+			return "", false
+		}
+	}
+	if s, ok := doc.(string); ok {
+		return s, true
+	}
+	v, err := json.Marshal(doc)
+	if err != nil {
+		return "", false
+	}
+	return string(v), true
+}
@@ -0,0 +1,302 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sequence
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/tracing"
+)
+
+// functionServer returns a server that emulates the functions used in the tests
+func functionServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("event-id") != "1234" {
+			t.Errorf("Expecting the event ID to be forwarded, received %v", r.Header)
+		}
+		switch r.URL.Path {
+		case "/upper":
+			w.Write([]byte(strings.ToUpper(string(body))))
+		case "/exclaim":
+			w.Write(append(body, '!'))
+		case "/order":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status": "` + string(body) + `", "total": 3}`))
+		case "/slow":
+			time.Sleep(time.Second)
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+		}
+	}))
+}
+
+func call(t *testing.T, e *Executor, data string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(data))
+	req.Header.Set("event-id", "1234")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w
+}
+
+func newTestExecutor(t *testing.T, url string, timeout time.Duration, steps ...kubelessApi.SequenceStep) *Executor {
+	functions := map[string]string{}
+	for _, f := range []string{"upper", "exclaim", "order", "slow", "fail"} {
+		functions[f] = url + "/" + f
+	}
+	e, err := NewExecutor(kubelessApi.SequenceSpec{Steps: steps}, functions, timeout)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return e
+}
+
+func TestSequentialSteps(t *testing.T) {
+	s := functionServer(t)
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0,
+		kubelessApi.SequenceStep{Name: "first", Function: "upper"},
+		kubelessApi.SequenceStep{Name: "second", Function: "exclaim"},
+	)
+	w := call(t, e, "hello")
+	if w.Code != http.StatusOK || w.Body.String() != "HELLO!" {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSequenceTracing(t *testing.T) {
+	received := make(chan tracing.SpanContext, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- tracing.Extract(r.Header)
+	}))
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0,
+		kubelessApi.SequenceStep{Name: "first", Function: "upper"},
+		kubelessApi.SequenceStep{Name: "second", Function: "exclaim"},
+	)
+	exporter := &tracing.InMemoryExporter{}
+	e.tracer = tracing.NewTracer("sequence", exporter)
+	parent := tracing.SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+	tracing.Inject(parent, req.Header)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expecting a span per step and one for the sequence, received %d", len(spans))
+	}
+	server := spans[2]
+	if server.Kind != tracing.KindServer || server.TraceID != parent.TraceID || server.ParentID != parent.SpanID {
+		t.Errorf("Unexpected sequence span %+v", server)
+	}
+	for i, step := range []string{"upper", "exclaim"} {
+		span := spans[i]
+		if span.Name != step || span.Kind != tracing.KindClient || span.ParentID != server.SpanID || span.TraceID != parent.TraceID {
+			t.Errorf("Unexpected span for step %s: %+v", step, span)
+		}
+		if forwarded := <-received; forwarded != span.SpanContext {
+			t.Errorf("Expecting the function %s to receive %+v, received %+v", step, span.SpanContext, forwarded)
+		}
+	}
+}
+
+func TestBranches(t *testing.T) {
+	s := functionServer(t)
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0,
+		kubelessApi.SequenceStep{Name: "order", Function: "order"},
+		kubelessApi.SequenceStep{Name: "route", Branches: []kubelessApi.SequenceBranch{
+			{
+				Name:  "paid",
+				When:  &kubelessApi.SequenceCondition{Path: "status", Equals: "paid"},
+				Steps: []kubelessApi.SequenceStep{{Name: "ship", Function: "upper"}},
+			},
+			{
+				Name:  "pending",
+				When:  &kubelessApi.SequenceCondition{Path: "status", Matches: "^pend"},
+				Steps: []kubelessApi.SequenceStep{{Name: "remind", Function: "exclaim"}},
+			},
+		}},
+	)
+	for data, expected := range map[string]string{
+		"paid":      `{"STATUS": "PAID", "TOTAL": 3}`,
+		"pending":   `{"status": "pending", "total": 3}!`,
+		"cancelled": `{"status": "cancelled", "total": 3}`,
+	} {
+		w := call(t, e, data)
+		if w.Code != http.StatusOK || w.Body.String() != expected {
+			t.Errorf("Expecting %s for %s, received %d: %s", expected, data, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestParallelSteps(t *testing.T) {
+	s := functionServer(t)
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0,
+		kubelessApi.SequenceStep{Name: "fan-out", Parallel: []kubelessApi.SequenceBranch{
+			{Name: "upper", Steps: []kubelessApi.SequenceStep{{Name: "upper", Function: "upper"}}},
+			{Name: "exclaim", Steps: []kubelessApi.SequenceStep{{Name: "exclaim", Function: "exclaim"}}},
+			{Name: "order", Steps: []kubelessApi.SequenceStep{{Name: "order", Function: "order"}}},
+			{
+				Name:  "skipped",
+				When:  &kubelessApi.SequenceCondition{Equals: "bye"},
+				Steps: []kubelessApi.SequenceStep{{Name: "fail", Function: "fail"}},
+			},
+		}},
+	)
+	w := call(t, e, "hi")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	res := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"upper":   "HI",
+		"exclaim": "hi!",
+		"order":   map[string]interface{}{"status": "hi", "total": float64(3)},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Expecting %v, received %v", expected, res)
+	}
+}
+
+func TestFailedStep(t *testing.T) {
+	s := functionServer(t)
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0,
+		kubelessApi.SequenceStep{Name: "first", Function: "fail"},
+		kubelessApi.SequenceStep{Name: "second", Function: "upper"},
+	)
+	w := call(t, e, "hello")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `Step "first" (function fail) failed`) {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	// The error of a parallel branch is returned instead of the cancellation of the rest of branches
+	e = newTestExecutor(t, s.URL, 0, kubelessApi.SequenceStep{Name: "fan-out", Parallel: []kubelessApi.SequenceBranch{
+		{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "wait", Function: "slow"}}},
+		{Name: "b", Steps: []kubelessApi.SequenceStep{{Name: "boom", Function: "fail"}}},
+	}})
+	w = call(t, e, "hello")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `Step "boom" (function fail) failed`) {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	e = newTestExecutor(t, s.URL, 50*time.Millisecond, kubelessApi.SequenceStep{Name: "first", Function: "slow"})
+	w = call(t, e, "hello")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expecting a timeout, received %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestParallelStepWithInvalidCondition(t *testing.T) {
+	calls := int32(0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer s.Close()
+	e := newTestExecutor(t, s.URL, 0, kubelessApi.SequenceStep{Name: "fan-out", Parallel: []kubelessApi.SequenceBranch{
+		{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "upper", Function: "upper"}}},
+		{
+			Name:  "b",
+			When:  &kubelessApi.SequenceCondition{Matches: "h.*"},
+			Steps: []kubelessApi.SequenceStep{{Name: "exclaim", Function: "exclaim"}},
+		},
+	}})
+	// The validation of the spec is skipped to make the condition fail
+	e.spec.Steps[0].Parallel[1].When.Matches = "h("
+	w := call(t, e, "hello")
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `Step "fan-out"`) {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("Expecting no branch to be started, received %d calls", n)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := [][]kubelessApi.SequenceStep{
+		{},
+		{{Name: "empty"}},
+		{{Name: "both", Function: "foo", Parallel: []kubelessApi.SequenceBranch{{Name: "a", Steps: []kubelessApi.SequenceStep{{Function: "bar"}}}}}},
+		{{Name: "unnamed", Parallel: []kubelessApi.SequenceBranch{{Steps: []kubelessApi.SequenceStep{{Function: "bar"}}}}}},
+		{{Name: "no-steps", Branches: []kubelessApi.SequenceBranch{{Name: "a"}}}},
+		{{Name: "regexp", Branches: []kubelessApi.SequenceBranch{{Name: "a", When: &kubelessApi.SequenceCondition{Matches: "("}, Steps: []kubelessApi.SequenceStep{{Function: "bar"}}}}}},
+	}
+	for _, steps := range invalid {
+		if err := Validate(&kubelessApi.SequenceSpec{Steps: steps}); err == nil {
+			t.Errorf("Expecting an error for %v", steps)
+		}
+	}
+
+	spec := &kubelessApi.SequenceSpec{Steps: []kubelessApi.SequenceStep{
+		{Name: "a", Function: "foo"},
+		{Name: "b", Branches: []kubelessApi.SequenceBranch{{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "c", Function: "bar"}}}}},
+		{Name: "d", Parallel: []kubelessApi.SequenceBranch{{Name: "a", Steps: []kubelessApi.SequenceStep{{Name: "e", Function: "foo"}, {Name: "f", Function: "baz"}}}}},
+	}}
+	if err := Validate(spec); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if functions := Functions(spec); !reflect.DeepEqual(functions, []string{"bar", "baz", "foo"}) {
+		t.Errorf("Unexpected functions %v", functions)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	yes, no := true, false
+	doc := []byte(`{"order": {"status": "paid", "items": 2, "express": true}}`)
+	tests := []struct {
+		condition *kubelessApi.SequenceCondition
+		data      []byte
+		expected  bool
+	}{
+		{nil, doc, true},
+		{&kubelessApi.SequenceCondition{Path: "order.status", Equals: "paid"}, doc, true},
+		{&kubelessApi.SequenceCondition{Path: "order.status", Equals: "pending"}, doc, false},
+		{&kubelessApi.SequenceCondition{Path: "order.items", Equals: "2"}, doc, true},
+		{&kubelessApi.SequenceCondition{Path: "order.express", Equals: "true"}, doc, true},
+		{&kubelessApi.SequenceCondition{Path: "order.coupon", Exists: &yes}, doc, false},
+		{&kubelessApi.SequenceCondition{Path: "order.coupon", Exists: &no}, doc, true},
+		{&kubelessApi.SequenceCondition{Path: "order.status.code", Exists: &yes}, doc, false},
+		{&kubelessApi.SequenceCondition{Matches: "^hel+o$"}, []byte("hello"), true},
+		{&kubelessApi.SequenceCondition{Equals: "hello"}, []byte(`"hello"`), true},
+		{&kubelessApi.SequenceCondition{Path: "status", Equals: "paid"}, []byte("paid"), false},
+	}
+	for _, test := range tests {
+		match, err := Match(test.condition, test.data)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if match != test.expected {
+			t.Errorf("Expecting %v for %v with %s", test.expected, test.condition, test.data)
+		}
+	}
+}
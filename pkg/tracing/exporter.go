@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Exporter sends the finished spans to a tracing backend
+type Exporter interface {
+	ExportSpan(s *SpanData)
+	Flush()
+}
+
+// InMemoryExporter stores the spans in memory. It is meant to be used in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpan stores a span
+func (e *InMemoryExporter) ExportSpan(s *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, *s)
+}
+
+// Flush is a no-op for the in memory exporter
+func (e *InMemoryExporter) Flush() {}
+
+// Spans returns the spans exported so far
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
+
+const (
+	zipkinQueueSize     = 1000
+	zipkinBatchSize     = 100
+	zipkinBatchInterval = time.Second
+)
+
+// ZipkinExporter sends the spans to a collector using the Zipkin v2 JSON API. Spans are
+// queued and sent in batches by a single goroutine. They are dropped if the queue is full
+type ZipkinExporter struct {
+	url       string
+	client    *http.Client
+	spans     chan zipkinSpan
+	flush     chan chan struct{}
+	batchSize int
+	dropped   int64
+}
+
+// NewZipkinExporter returns an exporter that sends the spans to the given URL,
+// for example http://zipkin:9411/api/v2/spans
+func NewZipkinExporter(url string) *ZipkinExporter {
+	return newZipkinExporter(url, zipkinQueueSize, zipkinBatchSize, zipkinBatchInterval)
+}
+
+func newZipkinExporter(url string, queueSize, batchSize int, interval time.Duration) *ZipkinExporter {
+	e := &ZipkinExporter{
+		url:       url,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		spans:     make(chan zipkinSpan, queueSize),
+		flush:     make(chan chan struct{}),
+		batchSize: batchSize,
+	}
+	go e.run(interval)
+	return e
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind,omitempty"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint *zipkinEndpoint   `json:"localEndpoint,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func newZipkinSpan(s *SpanData) zipkinSpan {
+	duration := s.Duration.Nanoseconds() / 1000
+	if duration < 1 {
+		// Zipkin requires a positive duration
+		duration = 1
+	}
+	return zipkinSpan{
+		TraceID:       s.TraceID,
+		ID:            s.SpanID,
+		ParentID:      s.ParentID,
+		Name:          s.Name,
+		Kind:          s.Kind,
+		Timestamp:     s.Start.UnixNano() / 1000,
+		Duration:      duration,
+		LocalEndpoint: &zipkinEndpoint{ServiceName: s.Service},
+		Tags:          s.Tags,
+	}
+}
+
+// ExportSpan queues a span to be sent in the background
+func (e *ZipkinExporter) ExportSpan(s *SpanData) {
+	select {
+	case e.spans <- newZipkinSpan(s):
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// run sends the queued spans when a batch is complete, periodically and when flushed
+func (e *ZipkinExporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := []zipkinSpan{}
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				batch = e.sendBatch(batch)
+			}
+		case <-ticker.C:
+			batch = e.sendBatch(batch)
+		case done := <-e.flush:
+			// Send the spans queued before the flush
+			for pending := len(e.spans); pending > 0; pending-- {
+				batch = append(batch, <-e.spans)
+				if len(batch) >= e.batchSize {
+					batch = e.sendBatch(batch)
+				}
+			}
+			batch = e.sendBatch(batch)
+			close(done)
+		}
+	}
+}
+
+// sendBatch sends a batch of spans and returns an empty batch
+func (e *ZipkinExporter) sendBatch(batch []zipkinSpan) []zipkinSpan {
+	if dropped := atomic.SwapInt64(&e.dropped, 0); dropped > 0 {
+		logrus.Warningf("Dropped %d spans since the export queue was full", dropped)
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		logrus.Errorf("Unable to encode %d spans: %v", len(batch), err)
+	} else if err := e.send(data); err != nil {
+		logrus.Errorf("Unable to export %d spans: %v", len(batch), err)
+	}
+	return batch[:0]
+}
+
+func (e *ZipkinExporter) send(data []byte) error {
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("the collector returned %s", res.Status)
+	}
+	return nil
+}
+
+// Flush waits until the spans queued so far are sent
+func (e *ZipkinExporter) Flush() {
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Headers used to propagate the trace context
+const (
+	TraceparentHeader = "traceparent"
+	B3Header          = "b3"
+	B3TraceIDHeader   = "X-B3-TraceId"
+	B3SpanIDHeader    = "X-B3-SpanId"
+	B3ParentIDHeader  = "X-B3-ParentSpanId"
+	B3SampledHeader   = "X-B3-Sampled"
+	B3FlagsHeader     = "X-B3-Flags"
+)
+
+const (
+	zeroTraceID = "00000000000000000000000000000000"
+	zeroSpanID  = "0000000000000000"
+)
+
+var (
+	traceparentRegexp = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+	traceIDRegexp     = regexp.MustCompile(`^([0-9a-f]{16}|[0-9a-f]{32})$`)
+	spanIDRegexp      = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// Extract returns the trace context of a request. The W3C traceparent header has
+// precedence over the B3 headers. The returned context is invalid if the request
+// doesn't contain a trace context
+func Extract(h http.Header) SpanContext {
+	if sc, ok := extractTraceparent(h.Get(TraceparentHeader)); ok {
+		return sc
+	}
+	if sc, ok := extractB3Single(h.Get(B3Header)); ok {
+		return sc
+	}
+	sc, _ := extractB3(h.Get(B3TraceIDHeader), h.Get(B3SpanIDHeader), h.Get(B3SampledHeader), h.Get(B3FlagsHeader))
+	return sc
+}
+
+// Inject sets the trace context headers of a request in both the W3C and the B3 formats
+func Inject(sc SpanContext, h http.Header) {
+	if !sc.IsValid() {
+		return
+	}
+	flags, sampled := "00", "0"
+	if sc.Sampled {
+		flags, sampled = "01", "1"
+	}
+	h.Set(TraceparentHeader, fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags))
+	h.Set(B3TraceIDHeader, sc.TraceID)
+	h.Set(B3SpanIDHeader, sc.SpanID)
+	h.Set(B3SampledHeader, sampled)
+	// Remove the headers that would describe a different span
+	h.Del(B3Header)
+	h.Del(B3ParentIDHeader)
+	h.Del(B3FlagsHeader)
+}
+
+func extractTraceparent(value string) (SpanContext, bool) {
+	m := traceparentRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	// Version ff is forbidden and version 00 doesn't allow extra fields
+	if m == nil || m[1] == "ff" || (m[1] == "00" && m[5] != "") {
+		return SpanContext{}, false
+	}
+	var flags byte
+	fmt.Sscanf(m[4], "%02x", &flags)
+	sc := SpanContext{TraceID: m[2], SpanID: m[3], Sampled: flags&1 == 1}
+	return sc, sc.IsValid()
+}
+
+func extractB3Single(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 {
+		return SpanContext{}, false
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return extractB3(parts[0], parts[1], sampled, "")
+}
+
+func extractB3(traceID, spanID, sampled, flags string) (SpanContext, bool) {
+	traceID, spanID = strings.ToLower(traceID), strings.ToLower(spanID)
+	if !traceIDRegexp.MatchString(traceID) || !spanIDRegexp.MatchString(spanID) {
+		return SpanContext{}, false
+	}
+	if len(traceID) == 16 {
+		// 64 bit trace IDs are padded to the 128 bit format
+		traceID = zeroSpanID + traceID
+	}
+	sc := SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		// The sampling decision is deferred to this service if it is not present
+		Sampled: sampled == "" || sampled == "1" || sampled == "d" || strings.EqualFold(sampled, "true") || flags == "1",
+	}
+	return sc, sc.IsValid()
+}
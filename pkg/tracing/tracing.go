@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing implements the minimal distributed tracing support used in the
+// invocation path of the functions. Spans are propagated using the W3C Trace Context
+// and B3 headers and exported in the Zipkin v2 format, which is also accepted by
+// OpenTelemetry collectors.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+)
+
+// CollectorEnv is the environment variable with the URL of the collector in which the spans are exported
+const CollectorEnv = "TRACING_COLLECTOR"
+
+// Kinds of spans
+const (
+	KindServer = "SERVER"
+	KindClient = "CLIENT"
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// IsValid returns true if the context has both a trace and a span ID
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceID) == 32 && len(sc.SpanID) == 16 && sc.TraceID != zeroTraceID && sc.SpanID != zeroSpanID
+}
+
+// SpanData contains the information of a finished span
+type SpanData struct {
+	SpanContext
+	ParentID string
+	Name     string
+	Kind     string
+	Service  string
+	Start    time.Time
+	Duration time.Duration
+	Tags     map[string]string
+}
+
+// Span is an operation that is being traced
+type Span struct {
+	mu     sync.Mutex
+	data   SpanData
+	tracer *Tracer
+	ended  bool
+}
+
+// Context returns the context that should be propagated to the children of the span
+func (s *Span) Context() SpanContext {
+	return s.data.SpanContext
+}
+
+// SetTag adds a tag to the span
+func (s *Span) SetTag(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Tags[key] = value
+}
+
+// End finishes the span and exports it if the trace is sampled
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.Duration = time.Since(s.data.Start)
+	data := s.data
+	s.mu.Unlock()
+	if data.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(&data)
+	}
+}
+
+// Tracer creates the spans of a service
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a tracer for the given service. Spans are propagated but not
+// exported if the exporter is nil
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// NewTracerFromEnv returns a tracer that exports its spans to the collector
+// configured in the environment
+func NewTracerFromEnv(service string) *Tracer {
+	var exporter Exporter
+	if url := os.Getenv(CollectorEnv); url != "" {
+		exporter = NewZipkinExporter(url)
+	}
+	return NewTracer(service, exporter)
+}
+
+// StartSpan starts a span. If parent is a valid context the span belongs to its trace,
+// otherwise a new trace is started
+func (t *Tracer) StartSpan(name, kind string, parent SpanContext) *Span {
+	data := SpanData{
+		Name:    name,
+		Kind:    kind,
+		Service: t.service,
+		Start:   time.Now(),
+		Tags:    map[string]string{},
+	}
+	if parent.IsValid() {
+		data.TraceID = parent.TraceID
+		data.ParentID = parent.SpanID
+		data.Sampled = parent.Sampled
+	} else {
+		data.TraceID = randomID(16)
+		data.Sampled = true
+	}
+	data.SpanID = randomID(8)
+	return &Span{data: data, tracer: t}
+}
+
+// Shutdown waits until the spans are exported
+func (t *Tracer) Shutdown() {
+	if t.exporter != nil {
+		t.exporter.Flush()
+	}
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		// An ID with all the bytes set to zero is invalid
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
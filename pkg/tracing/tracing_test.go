@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID := "00f067aa0ba902b7"
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected SpanContext
+	}{
+		{
+			name:     "traceparent",
+			headers:  map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-01"},
+			expected: SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true},
+		},
+		{
+			name:     "traceparent not sampled",
+			headers:  map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-00"},
+			expected: SpanContext{TraceID: traceID, SpanID: spanID},
+		},
+		{
+			name: "traceparent has precedence",
+			headers: map[string]string{
+				"traceparent":  "00-" + traceID + "-" + spanID + "-01",
+				"X-B3-TraceId": "a3ce929d0e0e4736",
+				"X-B3-SpanId":  "a3ce929d0e0e4736",
+			},
+			expected: SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true},
+		},
+		{
+			name:     "invalid traceparent",
+			headers:  map[string]string{"traceparent": "00-" + zeroTraceID + "-" + spanID + "-01"},
+			expected: SpanContext{},
+		},
+		{
+			name:     "b3 single header",
+			headers:  map[string]string{"b3": traceID + "-" + spanID + "-0"},
+			expected: SpanContext{TraceID: traceID, SpanID: spanID},
+		},
+		{
+			name: "b3 multiple headers with a 64 bit trace ID",
+			headers: map[string]string{
+				"X-B3-TraceId": "a3ce929d0e0e4736",
+				"X-B3-SpanId":  spanID,
+			},
+			expected: SpanContext{TraceID: "0000000000000000a3ce929d0e0e4736", SpanID: spanID, Sampled: true},
+		},
+		{
+			name:     "no context",
+			headers:  map[string]string{},
+			expected: SpanContext{},
+		},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		for k, v := range tt.headers {
+			h.Set(k, v)
+		}
+		if sc := Extract(h); sc != tt.expected {
+			t.Errorf("%s: expecting %+v, received %+v", tt.name, tt.expected, sc)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	h := http.Header{}
+	h.Set("b3", "a3ce929d0e0e4736-a3ce929d0e0e4736")
+	Inject(sc, h)
+	if h.Get("traceparent") != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Unexpected traceparent %s", h.Get("traceparent"))
+	}
+	if h.Get("X-B3-TraceId") != sc.TraceID || h.Get("X-B3-SpanId") != sc.SpanID || h.Get("X-B3-Sampled") != "1" {
+		t.Errorf("Unexpected B3 headers %v", h)
+	}
+	if h.Get("b3") != "" {
+		t.Error("The single B3 header should be removed")
+	}
+	if Extract(h) != sc {
+		t.Errorf("Expecting %+v, received %+v", sc, Extract(h))
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer("test", exporter)
+	root := tracer.StartSpan("root", KindClient, SpanContext{})
+	child := tracer.StartSpan("child", KindServer, root.Context())
+	child.SetTag("foo", "bar")
+	child.End()
+	root.End()
+	root.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expecting 2 spans, received %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].TraceID != root.Context().TraceID || spans[0].ParentID != root.Context().SpanID {
+		t.Errorf("Unexpected child span %+v", spans[0])
+	}
+	if spans[0].Tags["foo"] != "bar" || spans[0].Service != "test" || spans[0].Kind != KindServer {
+		t.Errorf("Unexpected child span %+v", spans[0])
+	}
+	if spans[1].ParentID != "" || !spans[1].Sampled {
+		t.Errorf("Unexpected root span %+v", spans[1])
+	}
+
+	// Traces that are not sampled are not exported
+	notSampled := root.Context()
+	notSampled.Sampled = false
+	tracer.StartSpan("ignored", KindServer, notSampled).End()
+	if len(exporter.Spans()) != 2 {
+		t.Error("Spans of traces not sampled should not be exported")
+	}
+}
+
+func TestZipkinExporter(t *testing.T) {
+	received := make(chan []zipkinSpan, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		spans := []zipkinSpan{}
+		if err := json.Unmarshal(body, &spans); err != nil {
+			t.Errorf("Unexpected body %s: %v", body, err)
+		}
+		received <- spans
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("test", NewZipkinExporter(collector.URL))
+	span := tracer.StartSpan("GET /", KindServer, SpanContext{})
+	span.SetTag("http.status_code", "200")
+	span.End()
+	tracer.Shutdown()
+
+	spans := <-received
+	if len(spans) != 1 {
+		t.Fatalf("Expecting a span, received %v", spans)
+	}
+	s := spans[0]
+	if s.TraceID != span.Context().TraceID || s.ID != span.Context().SpanID || s.Name != "GET /" || s.Kind != KindServer {
+		t.Errorf("Unexpected span %+v", s)
+	}
+	if s.LocalEndpoint.ServiceName != "test" || s.Tags["http.status_code"] != "200" || s.Duration < 1 {
+		t.Errorf("Unexpected span %+v", s)
+	}
+}
+
+func TestZipkinExporterDropsSpans(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	received := make(chan []zipkinSpan, 2)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		spans := []zipkinSpan{}
+		if err := json.Unmarshal(body, &spans); err != nil {
+			t.Errorf("Unexpected body %s: %v", body, err)
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+		received <- spans
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	exporter := newZipkinExporter(collector.URL, 1, 1, time.Hour)
+	exporter.ExportSpan(&SpanData{SpanID: "1"})
+	// The worker is busy sending the first span so the second one fills the queue
+	<-started
+	exporter.ExportSpan(&SpanData{SpanID: "2"})
+	exporter.ExportSpan(&SpanData{SpanID: "3"})
+	if dropped := atomic.LoadInt64(&exporter.dropped); dropped != 1 {
+		t.Errorf("Expecting a span to be dropped, %d dropped", dropped)
+	}
+	close(unblock)
+	exporter.Flush()
+
+	ids := []string{}
+	for len(received) > 0 {
+		for _, s := range <-received {
+			ids = append(ids, s.ID)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Errorf("Expecting the spans 1 and 2, received %v", ids)
+	}
+}
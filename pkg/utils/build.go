@@ -0,0 +1,337 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// buildAttemptAnnotation counts the jobs that have tried to build the same image
+	buildAttemptAnnotation = "kubeless.io/build-attempt"
+	// buildGenerationAnnotation is the generation of the function that created a build job
+	buildGenerationAnnotation = "kubeless.io/function-generation"
+	// buildRetryBackoff is the time to wait before replacing a failed build job. It is doubled
+	// after every failed attempt up to maxBuildRetryBackoff
+	buildRetryBackoff    = time.Minute
+	maxBuildRetryBackoff = time.Hour
+)
+
+// BuildJobName returns the name of the Job that builds the image of a function with the given tag
+func BuildJobName(funcName, tag string) string {
+	return fmt.Sprintf("build-%s-%s", funcName, tag[0:10])
+}
+
+// DepsImage returns the image that caches the dependencies layer of a function. The image is
+// stored next to the function image and its tag is derived from the runtime, the runtime image,
+// the image that installs the dependencies and their checksum so functions with the same
+// dependencies share it
+func DepsImage(registryHost, imageName, runtime, runtimeImage, installImage, deps string) (string, error) {
+	depsChecksum, err := getChecksum(deps)
+	if err != nil {
+		return "", err
+	}
+	key, err := getChecksum(runtimeImage + "\n" + installImage + "\n" + depsChecksum)
+	if err != nil {
+		return "", err
+	}
+	repository := "kubeless-deps"
+	if i := strings.LastIndex(imageName, "/"); i >= 0 {
+		repository = imageName[:i+1] + repository
+	}
+	return fmt.Sprintf("%s/%s:%s-%s", registryHost, repository, runtime, key), nil
+}
+
+// CreateBuildJob creates a Job that builds the image of a function without deploying it. The function
+// files are stored in a ConfigMap owned by the Job. If an identical build has been already created
+// its Job is returned unless it has failed, in which case it is replaced. The previous builds of the
+// function that have finished are removed
+func CreateBuildJob(client kubernetes.Interface, funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage, artifactServer string, registryTLSEnabled bool, imagePullSecrets []v1.LocalObjectReference) (*batchv1.Job, error) {
+	ns := funcObj.ObjectMeta.Namespace
+	files, err := funcConfigMapData(funcObj, lr)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := getChecksum(fmt.Sprintf("%s/%s:%s %s %v", registryHost, imageName, tag, funcObj.Spec.Runtime, files))
+	if err != nil {
+		return nil, err
+	}
+	jobName := fmt.Sprintf("kubeless-build-%s-%s", funcObj.ObjectMeta.Name, checksum[0:10])
+	propagation := metav1.DeletePropagationBackground
+	job, err := client.BatchV1().Jobs(ns).Get(jobName, metav1.GetOptions{})
+	if err == nil {
+		if !jobFailed(job) {
+			logrus.Infof("Found a previous job for building %s/%s:%s", registryHost, imageName, tag)
+			return job, nil
+		}
+		logrus.Infof("Replacing the failed build job %s", jobName)
+		err = client.BatchV1().Jobs(ns).Delete(jobName, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return nil, err
+		}
+	} else if !k8sErrors.IsNotFound(err) {
+		return nil, err
+	}
+	deleteFinishedBuildJobs(client, ns, funcObj.ObjectMeta.Name)
+
+	job, err = NewFunctionBuildJob(jobName, funcObj, lr, nil, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage, artifactServer, registryTLSEnabled, imagePullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	// The job is not related to a Function object so it reads the function files
+	// from its own ConfigMap
+	job.ObjectMeta.Labels = addDefaultLabel(map[string]string{
+		"function-build": funcObj.ObjectMeta.Name,
+	})
+	for i := range job.Spec.Template.Spec.Volumes {
+		if cm := job.Spec.Template.Spec.Volumes[i].ConfigMap; cm != nil && cm.Name == funcObj.ObjectMeta.Name {
+			cm.Name = jobName
+		}
+	}
+	// The ConfigMap is created before the Job so its pod can mount it. A ConfigMap left by a
+	// replaced job is overwritten, removing its owner so it isn't garbage collected
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: ns,
+			Labels:    job.ObjectMeta.Labels,
+		},
+		Data: files,
+	}
+	cm, err = client.CoreV1().ConfigMaps(ns).Create(cm)
+	if k8sErrors.IsAlreadyExists(err) {
+		var previous *v1.ConfigMap
+		previous, err = client.CoreV1().ConfigMaps(ns).Get(jobName, metav1.GetOptions{})
+		if err == nil {
+			previous.ObjectMeta.Labels = job.ObjectMeta.Labels
+			previous.ObjectMeta.OwnerReferences = nil
+			previous.Data = files
+			cm, err = client.CoreV1().ConfigMaps(ns).Update(previous)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to store the function files: %v", err)
+	}
+	job, err = client.BatchV1().Jobs(ns).Create(job)
+	if err != nil {
+		client.CoreV1().ConfigMaps(ns).Delete(jobName, &metav1.DeleteOptions{})
+		return nil, err
+	}
+	// The ConfigMap is removed with the Job
+	cm.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       job.ObjectMeta.Name,
+			UID:        job.ObjectMeta.UID,
+		},
+	}
+	if _, err := client.CoreV1().ConfigMaps(ns).Update(cm); err != nil {
+		logrus.Warnf("Unable to set the owner of the ConfigMap %s, it should be deleted manually: %v", jobName, err)
+	}
+	logrus.Infof("Started function build job %s", jobName)
+	return job, nil
+}
+
+// deleteFinishedBuildJobs removes the jobs created by CreateBuildJob for a function that have
+// finished. Their pods and ConfigMaps are garbage collected
+func deleteFinishedBuildJobs(client kubernetes.Interface, ns, funcName string) {
+	jobs, err := client.BatchV1().Jobs(ns).List(metav1.ListOptions{
+		LabelSelector: "function-build=" + funcName,
+	})
+	if err != nil {
+		logrus.Warnf("Unable to list the previous build jobs of %s: %v", funcName, err)
+		return
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs.Items {
+		if !jobFailed(&job) && job.Status.Succeeded == 0 {
+			continue
+		}
+		err := client.BatchV1().Jobs(ns).Delete(job.ObjectMeta.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			logrus.Warnf("Unable to delete the build job %s: %v", job.ObjectMeta.Name, err)
+		}
+	}
+}
+
+// GetFunctionBuildJob returns the last Job created to build the image of a function
+func GetFunctionBuildJob(client kubernetes.Interface, ns, funcName string) (*batchv1.Job, error) {
+	jobs, err := client.BatchV1().Jobs(ns).List(metav1.ListOptions{
+		LabelSelector: "function=" + funcName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var last *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !strings.HasPrefix(job.ObjectMeta.Name, fmt.Sprintf("build-%s-", funcName)) {
+			continue
+		}
+		if last == nil || last.ObjectMeta.CreationTimestamp.Before(&job.ObjectMeta.CreationTimestamp) {
+			last = job
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("There is no build job for the function %s", funcName)
+	}
+	return last, nil
+}
+
+// buildAttempt returns the number of jobs that have tried to build the image of a job
+func buildAttempt(job *batchv1.Job) int {
+	attempt, err := strconv.Atoi(job.ObjectMeta.Annotations[buildAttemptAnnotation])
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// jobFailed returns true if a job has reached its backoff limit
+func jobFailed(job *batchv1.Job) bool {
+	_, failed := BuildRetryTime(job)
+	return failed
+}
+
+// BuildRetryTime returns when a failed build job should be replaced by a new one. It returns
+// false if the job has not failed
+func BuildRetryTime(job *batchv1.Job) (time.Time, bool) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type != batchv1.JobFailed || cond.Status != v1.ConditionTrue {
+			continue
+		}
+		backoff := buildRetryBackoff
+		for i := 1; i < buildAttempt(job) && backoff < maxBuildRetryBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxBuildRetryBackoff {
+			backoff = maxBuildRetryBackoff
+		}
+		return cond.LastTransitionTime.Add(backoff), true
+	}
+	return time.Time{}, false
+}
+
+// LatestBuildPod returns the pod of a build job that is running or, if all of them have
+// finished, the last one created. The pods should be sorted as returned by GetBuildJobPods
+func LatestBuildPod(pods []v1.Pod) *v1.Pod {
+	if len(pods) == 0 {
+		return nil
+	}
+	for i := range pods {
+		if pods[i].Status.Phase != v1.PodSucceeded && pods[i].Status.Phase != v1.PodFailed {
+			return &pods[i]
+		}
+	}
+	return &pods[0]
+}
+
+// GetBuildJobPods returns the pods of a build Job sorted by creation time, the last one first
+func GetBuildJobPods(client kubernetes.Interface, ns, jobName string) ([]v1.Pod, error) {
+	pods, err := GetPodsByLabel(client, ns, "job-name", jobName)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pods.Items, func(i, j int) bool {
+		return pods.Items[j].ObjectMeta.CreationTimestamp.Before(&pods.Items[i].ObjectMeta.CreationTimestamp)
+	})
+	return pods.Items, nil
+}
+
+// BuildContainers returns the names of the containers of a build pod in the order they are executed
+func BuildContainers(pod *v1.Pod) []string {
+	names := []string{}
+	for _, c := range pod.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// BuildFailure describes why a container of a build pod failed
+type BuildFailure struct {
+	Container string
+	Reason    string
+	// Previous is true if the failure belongs to a previous execution of the container
+	Previous bool
+}
+
+func (f *BuildFailure) String() string {
+	return fmt.Sprintf("container %s failed: %s", f.Container, f.Reason)
+}
+
+// GetBuildFailure returns the first container of a build pod that has failed or can't be started.
+// It returns nil if no container has failed
+func GetBuildFailure(pod *v1.Pod) *BuildFailure {
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		if t := s.State.Terminated; t != nil && t.ExitCode != 0 {
+			return &BuildFailure{Container: s.Name, Reason: terminationReason(t)}
+		}
+		if t := s.LastTerminationState.Terminated; t != nil && t.ExitCode != 0 {
+			return &BuildFailure{Container: s.Name, Reason: terminationReason(t), Previous: true}
+		}
+		if w := s.State.Waiting; w != nil && w.Reason != "" && w.Reason != "PodInitializing" && w.Reason != "ContainerCreating" {
+			reason := w.Reason
+			if w.Message != "" {
+				reason = fmt.Sprintf("%s: %s", w.Reason, w.Message)
+			}
+			return &BuildFailure{Container: s.Name, Reason: reason}
+		}
+	}
+	return nil
+}
+
+func terminationReason(t *v1.ContainerStateTerminated) string {
+	reason := fmt.Sprintf("exit code %d", t.ExitCode)
+	if t.Reason != "" {
+		reason = fmt.Sprintf("%s (%s)", reason, t.Reason)
+	}
+	if t.Message != "" {
+		reason = fmt.Sprintf("%s: %s", reason, t.Message)
+	}
+	return reason
+}
+
+// GetPodLogs returns the last lines of the log of a container
+func GetPodLogs(client kubernetes.Interface, ns, podName, container string, tailLines int64, previous bool) (string, error) {
+	logs, err := client.CoreV1().Pods(ns).GetLogs(podName, &v1.PodLogOptions{
+		Container: container,
+		TailLines: &tailLines,
+		Previous:  previous,
+	}).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestGetFunctionBuildJob(t *testing.T) {
+	job := func(name string, created time.Time) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "myns",
+			Name:              name,
+			Labels:            map[string]string{"function": "foo"},
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		job("build-foo-0123456789", now.Add(-time.Hour)),
+		job("build-foo-abcdef0123", now),
+		job("other-foo", now.Add(time.Hour)),
+	)
+	j, err := GetFunctionBuildJob(client, "myns", "foo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if j.ObjectMeta.Name != "build-foo-abcdef0123" {
+		t.Errorf("Expecting the last build job, received %s", j.ObjectMeta.Name)
+	}
+	if _, err := GetFunctionBuildJob(client, "myns", "bar"); err == nil {
+		t.Error("Expecting an error for a function without build jobs")
+	}
+}
+
+func TestGetBuildFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []v1.ContainerStatus
+		expected *BuildFailure
+	}{
+		{"running", []v1.ContainerStatus{
+			{Name: "prepare", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}}},
+			{Name: "install", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+		}, nil},
+		{"initializing", []v1.ContainerStatus{
+			{Name: "prepare", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+		}, nil},
+		{"failed", []v1.ContainerStatus{
+			{Name: "prepare", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2, Reason: "Error"}}},
+		}, &BuildFailure{Container: "prepare", Reason: "exit code 2 (Error)"}},
+		{"restarted", []v1.ContainerStatus{
+			{
+				Name:                 "install",
+				State:                v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+				LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}},
+			},
+		}, &BuildFailure{Container: "install", Reason: "exit code 1", Previous: true}},
+		{"image not found", []v1.ContainerStatus{
+			{Name: "build", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "not found"}}},
+		}, &BuildFailure{Container: "build", Reason: "ErrImagePull: not found"}},
+	}
+	for _, tt := range tests {
+		failure := GetBuildFailure(&v1.Pod{Status: v1.PodStatus{InitContainerStatuses: tt.statuses}})
+		if (failure == nil) != (tt.expected == nil) || (failure != nil && *failure != *tt.expected) {
+			t.Errorf("%s: expecting %v, received %v", tt.name, tt.expected, failure)
+		}
+	}
+}
+
+func TestBuildRetryTime(t *testing.T) {
+	failedAt := time.Now().Add(-time.Minute)
+	job := func(attempt string, status v1.ConditionStatus) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{buildAttemptAnnotation: attempt}},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: status, LastTransitionTime: metav1.NewTime(failedAt)},
+			}},
+		}
+	}
+	tests := []struct {
+		name     string
+		job      *batchv1.Job
+		failed   bool
+		expected time.Duration
+	}{
+		{"running", &batchv1.Job{}, false, 0},
+		{"not failed", job("1", v1.ConditionFalse), false, 0},
+		{"first attempt", job("1", v1.ConditionTrue), true, time.Minute},
+		{"third attempt", job("3", v1.ConditionTrue), true, 4 * time.Minute},
+		{"maximum backoff", job("20", v1.ConditionTrue), true, time.Hour},
+		{"without annotation", job("", v1.ConditionTrue), true, time.Minute},
+	}
+	for _, tt := range tests {
+		retryAt, failed := BuildRetryTime(tt.job)
+		if failed != tt.failed {
+			t.Errorf("%s: expecting failed %v, received %v", tt.name, tt.failed, failed)
+			continue
+		}
+		if failed && !retryAt.Equal(metav1.NewTime(failedAt).Add(tt.expected)) {
+			t.Errorf("%s: expecting a retry after %s, received %s", tt.name, tt.expected, retryAt.Sub(failedAt))
+		}
+	}
+}
+
+func TestLatestBuildPod(t *testing.T) {
+	pod := func(name string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: v1.PodStatus{Phase: phase}}
+	}
+	if LatestBuildPod(nil) != nil {
+		t.Error("Expecting no pod")
+	}
+	pods := []v1.Pod{pod("c", v1.PodFailed), pod("b", v1.PodRunning), pod("a", v1.PodFailed)}
+	if p := LatestBuildPod(pods); p.ObjectMeta.Name != "b" {
+		t.Errorf("Expecting the running pod, received %s", p.ObjectMeta.Name)
+	}
+	pods = []v1.Pod{pod("c", v1.PodFailed), pod("b", v1.PodFailed)}
+	if p := LatestBuildPod(pods); p.ObjectMeta.Name != "c" {
+		t.Errorf("Expecting the last pod, received %s", p.ObjectMeta.Name)
+	}
+}
+
+func TestDepsImage(t *testing.T) {
+	image, err := DepsImage("registry.local", "user/func", "python2.7", "kubeless/python:2.7", "python:2.7", "requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(image, "registry.local/user/kubeless-deps:python2.7-") || len(image) != len("registry.local/user/kubeless-deps:python2.7-")+64 {
+		t.Errorf("Unexpected image %s", image)
+	}
+	// Functions with the same dependencies share the image
+	other, _ := DepsImage("registry.local", "user/other", "python2.7", "kubeless/python:2.7", "python:2.7", "requests")
+	if other != image {
+		t.Errorf("Expecting %s, got %s", image, other)
+	}
+	// The image changes with the dependencies, the runtime image and the installation image
+	for _, changed := range [][3]string{
+		{"kubeless/python:2.7", "python:2.7", "requests==2.0"},
+		{"kubeless/python:2.7.1", "python:2.7", "requests"},
+		{"kubeless/python:2.7", "python:2.7.18", "requests"},
+	} {
+		other, _ := DepsImage("registry.local", "user/func", "python2.7", changed[0], changed[1], changed[2])
+		if other == image {
+			t.Errorf("Expecting a different image for %v", changed)
+		}
+	}
+}
+
+func TestCreateBuildJob(t *testing.T) {
+	clientset, _, ns, lr := prepareDeploymentTest("f1")
+	f := getDefaultFunc("f1", ns)
+
+	job, err := CreateBuildJob(clientset, f, lr, "user/f1", "v1", "kubeless/builder", "registry.local", "registry-creds", "unzip", "", true, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(job.ObjectMeta.Name, "kubeless-build-f1-") || job.ObjectMeta.Labels["function-build"] != "f1" {
+		t.Errorf("Unexpected job %s %v", job.ObjectMeta.Name, job.ObjectMeta.Labels)
+	}
+	if _, ok := job.ObjectMeta.Labels["function"]; ok {
+		t.Error("The job should not be related to a deployed function")
+	}
+	// The function files are read from a ConfigMap owned by the job
+	cm, err := clientset.CoreV1().ConfigMaps(ns).Get(job.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cm.Data["foo.py"] != "function" || len(cm.OwnerReferences) != 1 || cm.OwnerReferences[0].Kind != "Job" || cm.OwnerReferences[0].Name != job.ObjectMeta.Name {
+		t.Errorf("Unexpected ConfigMap %v", cm)
+	}
+	found := false
+	for _, v := range job.Spec.Template.Spec.Volumes {
+		if v.ConfigMap != nil {
+			found = v.ConfigMap.Name == job.ObjectMeta.Name
+		}
+	}
+	if !found {
+		t.Errorf("The job should mount its ConfigMap: %v", job.Spec.Template.Spec.Volumes)
+	}
+
+	// The same build returns the existing job
+	again, err := CreateBuildJob(clientset, f, lr, "user/f1", "v1", "kubeless/builder", "registry.local", "registry-creds", "unzip", "", true, nil)
+	if err != nil || again.ObjectMeta.Name != job.ObjectMeta.Name {
+		t.Errorf("Expecting the job %s, got %v, %v", job.ObjectMeta.Name, again, err)
+	}
+	// The ConfigMap exists before the job is created
+	created := []string{}
+	clientset.PrependReactor("create", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+		created = append(created, action.GetResource().Resource)
+		return false, nil, nil
+	})
+
+	// A failed job is replaced
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue}}
+	if _, err := clientset.BatchV1().Jobs(ns).Update(job); err != nil {
+		t.Fatal(err)
+	}
+	again, err = CreateBuildJob(clientset, f, lr, "user/f1", "v1", "kubeless/builder", "registry.local", "registry-creds", "unzip", "", true, nil)
+	if err != nil || again.ObjectMeta.Name != job.ObjectMeta.Name || len(again.Status.Conditions) != 0 {
+		t.Errorf("Expecting a new job %s, got %v, %v", job.ObjectMeta.Name, again, err)
+	}
+	if !reflect.DeepEqual(created, []string{"configmaps", "jobs"}) {
+		t.Errorf("Expecting the ConfigMap to be created before the job, got %v", created)
+	}
+	cm, err = clientset.CoreV1().ConfigMaps(ns).Get(job.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil || len(cm.OwnerReferences) != 1 || cm.OwnerReferences[0].Name != job.ObjectMeta.Name {
+		t.Errorf("Unexpected ConfigMap %v: %v", cm, err)
+	}
+
+	// A different image is a different build. The finished builds are removed
+	again.Status.Succeeded = 1
+	if _, err := clientset.BatchV1().Jobs(ns).Update(again); err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreateBuildJob(clientset, f, lr, "user/f1", "v2", "kubeless/builder", "registry.local", "registry-creds", "unzip", "", true, nil)
+	if err != nil || other.ObjectMeta.Name == job.ObjectMeta.Name {
+		t.Errorf("Expecting a new job, got %v, %v", other, err)
+	}
+	if _, err := clientset.BatchV1().Jobs(ns).Get(job.ObjectMeta.Name, metav1.GetOptions{}); !k8sErrors.IsNotFound(err) {
+		t.Errorf("Expecting the finished job to be deleted: %v", err)
+	}
+}
@@ -35,7 +35,9 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -52,6 +54,8 @@ import (
 
 const (
 	defaultTimeout = "180"
+	// defaultNATSURL is the NATS server that receives the failed events of a function unless specified otherwise
+	defaultNATSURL = "nats://nats.nats-io.svc.cluster.local:4222"
 )
 
 // GetClient returns a k8s clientset to the request from inside of cluster
@@ -220,6 +224,29 @@ func PatchFunctionCustomResource(kubelessClient versioned.Interface, f *kubeless
 	return err
 }
 
+// UpdateFunctionStatus stores the given status in a function object. It retries in case of
+// conflicts and falls back to a regular update if the API server doesn't serve the status subresource.
+// In that case the update increments the generation of the function, so a status that observes the
+// current generation is stored with the generation that results from the update
+func UpdateFunctionStatus(kubelessClient versioned.Interface, ns, funcName string, status *kubelessApi.FunctionStatus) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		f, err := kubelessClient.KubelessV1beta1().Functions(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed := !apiequality.Semantic.DeepEqual(&f.Status, status)
+		f.Status = *status.DeepCopy()
+		_, err = kubelessClient.KubelessV1beta1().Functions(ns).UpdateStatus(f)
+		if err != nil && k8sErrors.IsNotFound(err) {
+			if changed && f.ObjectMeta.Generation > 0 && f.Status.ObservedGeneration == f.ObjectMeta.Generation {
+				f.Status.ObservedGeneration++
+			}
+			_, err = kubelessClient.KubelessV1beta1().Functions(ns).Update(f)
+		}
+		return err
+	})
+}
+
 // DeleteFunctionCustomResource will delete custom function object
 func DeleteFunctionCustomResource(kubelessClient versioned.Interface, funcName, ns string) error {
 	err := kubelessClient.KubelessV1beta1().Functions(ns).Delete(funcName, &metav1.DeleteOptions{})
@@ -317,13 +344,32 @@ func doRESTReq(restIface rest.Interface, groupVersion, verb, resource, elem, nam
 	return nil
 }
 
-// CreateAutoscale creates HPA object for function
+// DeploymentAPIVersion is the API version of the Deployments created for functions
+const DeploymentAPIVersion = "apps/v1"
+
+// CreateAutoscale creates or updates the HPA object for function
 func CreateAutoscale(client kubernetes.Interface, hpa v2beta1.HorizontalPodAutoscaler) error {
+	// Autoscalers created before the migration to apps/v1 reference Deployment APIs that
+	// are no longer served
+	if hpa.Spec.ScaleTargetRef.Kind == "Deployment" {
+		hpa.Spec.ScaleTargetRef.APIVersion = DeploymentAPIVersion
+	}
 	_, err := client.AutoscalingV2beta1().HorizontalPodAutoscalers(hpa.ObjectMeta.Namespace).Create(&hpa)
-	if err != nil {
-		return err
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			current, err := client.AutoscalingV2beta1().HorizontalPodAutoscalers(hpa.ObjectMeta.Namespace).Get(hpa.ObjectMeta.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if apiequality.Semantic.DeepEqual(current.Spec, hpa.Spec) {
+				return nil
+			}
+			current.Spec = hpa.Spec
+			current.ObjectMeta.OwnerReferences = hpa.ObjectMeta.OwnerReferences
+			_, err = client.AutoscalingV2beta1().HorizontalPodAutoscalers(hpa.ObjectMeta.Namespace).Update(current)
+			return err
+		})
 	}
-
 	return err
 }
 
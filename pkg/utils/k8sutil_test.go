@@ -7,11 +7,15 @@ import (
 	"io/ioutil"
 	"testing"
 
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	kFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
 	v2beta1 "k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/api/extensions/v1beta1"
 	extensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	fakeextensionsapi "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -76,6 +80,48 @@ func TestCreateAutoscaleResource(t *testing.T) {
 	}
 }
 
+func TestCreateAutoscaleUpdatesScaleTarget(t *testing.T) {
+	myNsFoo := metav1.ObjectMeta{
+		Namespace: "myns",
+		Name:      "foo",
+	}
+	oldTarget := v2beta1.CrossVersionObjectReference{
+		Kind:       "Deployment",
+		Name:       "foo",
+		APIVersion: "extensions/v1beta1",
+	}
+	maxReplicas := int32(3)
+	clientset := fake.NewSimpleClientset(&v2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: myNsFoo,
+		Spec: v2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: oldTarget,
+			MaxReplicas:    maxReplicas,
+		},
+	})
+
+	hpaDef := v2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: myNsFoo,
+		Spec: v2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: oldTarget,
+			MaxReplicas:    5,
+		},
+	}
+	if err := CreateAutoscale(clientset, hpaDef); err != nil {
+		t.Fatalf("Updating autoscale returned err: %v", err)
+	}
+
+	hpa, err := clientset.AutoscalingV2beta1().HorizontalPodAutoscalers("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hpa.Spec.ScaleTargetRef.APIVersion != "apps/v1" {
+		t.Errorf("Expecting the scale target to use apps/v1, got %s", hpa.Spec.ScaleTargetRef.APIVersion)
+	}
+	if hpa.Spec.MaxReplicas != 5 {
+		t.Errorf("Expecting max replicas to be updated to 5, got %d", hpa.Spec.MaxReplicas)
+	}
+}
+
 func TestDeleteAutoscaleResource(t *testing.T) {
 	myNsFoo := metav1.ObjectMeta{
 		Namespace: "myns",
@@ -227,3 +273,26 @@ func TestGetAnnotationsFromCRD(t *testing.T) {
 	}
 
 }
+
+func TestUpdateFunctionStatusWithoutSubresource(t *testing.T) {
+	f := &kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "foo", Generation: 2}}
+	client := kFake.NewSimpleClientset(f)
+	client.PrependReactor("update", "functions", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "status" {
+			return true, nil, k8sErrors.NewNotFound(schema.GroupResource{Group: "kubeless.io", Resource: "functions"}, "foo")
+		}
+		return false, nil, nil
+	})
+	// The regular update increments the generation that the status observes
+	status := &kubelessApi.FunctionStatus{Phase: kubelessApi.FunctionPhaseReady, ObservedGeneration: 2}
+	if err := UpdateFunctionStatus(client, "myns", "foo", status); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stored, err := client.KubelessV1beta1().Functions("myns").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Phase != kubelessApi.FunctionPhaseReady || stored.Status.ObservedGeneration != 3 {
+		t.Errorf("Unexpected status %+v", stored.Status)
+	}
+}
@@ -23,21 +23,29 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	monitoringv1alpha1 "github.com/coreos/prometheus-operator/pkg/client/monitoring/v1alpha1"
 	"github.com/ghodss/yaml"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/artifacts"
+	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+	"github.com/kubeless/kubeless/pkg/jsonschema"
 	"github.com/kubeless/kubeless/pkg/langruntime"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	clientsetAPIExtensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
@@ -67,7 +75,7 @@ func appendToCommand(orig string, command ...string) string {
 	return strings.Join(command, " && ")
 }
 
-func getProvisionContainer(function, checksum, fileName, handler, contentType, runtime, prepareImage string, runtimeVolume, depsVolume v1.VolumeMount, lr *langruntime.Langruntimes) (v1.Container, error) {
+func getProvisionContainer(function, checksum, fileName, handler, contentType, runtime, prepareImage, artifactServer string, runtimeVolume, depsVolume v1.VolumeMount, lr *langruntime.Langruntimes) (v1.Container, error) {
 	prepareCommand := ""
 	originFile := path.Join(depsVolume.MountPath, fileName)
 
@@ -77,6 +85,17 @@ func getProvisionContainer(function, checksum, fileName, handler, contentType, r
 		decodedFile := "/tmp/func.decoded"
 		prepareCommand = appendToCommand(prepareCommand, fmt.Sprintf("base64 -d < %s > %s", originFile, decodedFile))
 		originFile = decodedFile
+	} else if strings.Contains(contentType, "artifact") {
+		// The function is stored in the artifact server
+		if artifactServer == "" {
+			return v1.Container{}, fmt.Errorf("Unable to download artifact %s: the artifact server is not configured", function)
+		}
+		fromArtifactFile := "/tmp/func.fromartifact"
+		artifactURL := fmt.Sprintf("%s/artifacts/%s", strings.TrimSuffix(artifactServer, "/"), function)
+		// The artifact server only serves the artifacts of a namespace to its service accounts
+		tokenHeader := fmt.Sprintf("%s: $(cat %s)", artifacts.TokenHeader, artifacts.ServiceAccountTokenFile)
+		prepareCommand = appendToCommand(prepareCommand, fmt.Sprintf("curl -f %s -H \"%s\" -L --silent --show-error --output %s", artifactURL, tokenHeader, fromArtifactFile))
+		originFile = fromArtifactFile
 	} else if strings.Contains(contentType, "url") {
 		fromURLFile := "/tmp/func.fromurl"
 		prepareCommand = appendToCommand(prepareCommand, fmt.Sprintf("curl %s -L --silent --output %s", function, fromURLFile))
@@ -173,7 +192,7 @@ func getFileName(handler, funcContentType, runtime string, lr *langruntime.Langr
 		return "", err
 	}
 	filename := modName
-	if funcContentType == "text" || funcContentType == "" || funcContentType == "url" {
+	if funcContentType == "text" || funcContentType == "" || funcContentType == "url" || funcContentType == "artifact" {
 		// We can only guess the extension if the function is specified as plain text
 		runtimeInf, err := lr.GetRuntimeInfo(runtime)
 		if err == nil {
@@ -183,14 +202,14 @@ func getFileName(handler, funcContentType, runtime string, lr *langruntime.Langr
 	return filename, nil
 }
 
-// EnsureFuncConfigMap creates/updates a config map with a function specification
-func EnsureFuncConfigMap(client kubernetes.Interface, funcObj *kubelessApi.Function, or []metav1.OwnerReference, lr *langruntime.Langruntimes) error {
+// funcConfigMapData returns the files that the provision container reads: the
+// function, its dependencies and its handler
+func funcConfigMapData(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes) (map[string]string, error) {
 	configMapData := map[string]string{}
-	var err error
 	if funcObj.Spec.Handler != "" {
 		fileName, err := getFileName(funcObj.Spec.Handler, funcObj.Spec.FunctionContentType, funcObj.Spec.Runtime, lr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		configMapData = map[string]string{
 			"handler": funcObj.Spec.Handler,
@@ -201,6 +220,15 @@ func EnsureFuncConfigMap(client kubernetes.Interface, funcObj *kubelessApi.Funct
 			configMapData[runtimeInfo.DepName] = funcObj.Spec.Deps
 		}
 	}
+	return configMapData, nil
+}
+
+// EnsureFuncConfigMap creates/updates a config map with a function specification
+func EnsureFuncConfigMap(client kubernetes.Interface, funcObj *kubelessApi.Function, or []metav1.OwnerReference, lr *langruntime.Langruntimes) error {
+	configMapData, err := funcConfigMapData(funcObj, lr)
+	if err != nil {
+		return err
+	}
 
 	configMap := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -238,13 +266,14 @@ func EnsureFuncConfigMap(client kubernetes.Interface, funcObj *kubelessApi.Funct
 
 // this function resolves backward incompatibility in case user uses old client which doesn't include serviceSpec into funcSpec.
 // if serviceSpec is empty, we will use the default serviceSpec whose port is 8080
+// The first port is named after the protocol of the function unless the user gave it another name.
 func serviceSpec(funcObj *kubelessApi.Function) v1.ServiceSpec {
 	if len(funcObj.Spec.ServiceSpec.Ports) == 0 {
 		return v1.ServiceSpec{
 			Ports: []v1.ServicePort{
 				{
-					// Note: Prefix: "http-" is added to adapt to Istio so that it can discover the function services
-					Name:       "http-function-port",
+					// Note: Prefix: "http-" (or "grpc-") is added to adapt to Istio so that it can discover the function services
+					Name:       FunctionPortName(funcObj.Spec.Protocol),
 					Protocol:   v1.ProtocolTCP,
 					Port:       8080,
 					TargetPort: intstr.FromInt(8080),
@@ -254,7 +283,11 @@ func serviceSpec(funcObj *kubelessApi.Function) v1.ServiceSpec {
 			Type:     v1.ServiceTypeClusterIP,
 		}
 	}
-	return funcObj.Spec.ServiceSpec
+	spec := *funcObj.Spec.ServiceSpec.DeepCopy()
+	if IsDefaultFunctionPortName(spec.Ports[0].Name) {
+		spec.Ports[0].Name = FunctionPortName(funcObj.Spec.Protocol)
+	}
+	return spec
 }
 
 // EnsureFuncService creates/updates a function service
@@ -313,7 +346,7 @@ func getChecksum(content string) (string, error) {
 // The caller should define the runtime container(s).
 // It accepts a prepopulated podSpec with default information and volume that the
 // runtime container should mount
-func populatePodSpec(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, podSpec *v1.PodSpec, runtimeVolumeMount v1.VolumeMount, provisionImage string, imagePullSecrets []v1.LocalObjectReference) error {
+func populatePodSpec(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, podSpec *v1.PodSpec, runtimeVolumeMount v1.VolumeMount, provisionImage, artifactServer string, imagePullSecrets []v1.LocalObjectReference) error {
 	depsVolumeName := funcObj.ObjectMeta.Name + "-deps"
 	result := podSpec
 	if len(imagePullSecrets) > 0 {
@@ -358,6 +391,7 @@ func populatePodSpec(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes
 			funcObj.Spec.FunctionContentType,
 			funcObj.Spec.Runtime,
 			provisionImage,
+			artifactServer,
 			runtimeVolumeMount,
 			srcVolumeMount,
 			lr,
@@ -417,61 +451,72 @@ func populatePodSpec(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes
 	return nil
 }
 
-// EnsureFuncImage creates a Job to build a function image
-func EnsureFuncImage(client kubernetes.Interface, funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, or []metav1.OwnerReference, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage string, registryTLSEnabled bool, imagePullSecrets []v1.LocalObjectReference) error {
+// EnsureFuncImage creates a Job to build a function image. A failed job is replaced by a new one
+// when the function is modified or, if it isn't, after a backoff
+func EnsureFuncImage(client kubernetes.Interface, funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, or []metav1.OwnerReference, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage, artifactServer string, registryTLSEnabled bool, imagePullSecrets []v1.LocalObjectReference) error {
 	if len(tag) < 64 {
 		return fmt.Errorf("Expecting sha256 as image tag")
 	}
-	jobName := fmt.Sprintf("build-%s-%s", funcObj.ObjectMeta.Name, tag[0:10])
-	_, err := client.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Get(jobName, metav1.GetOptions{})
+	jobName := BuildJobName(funcObj.ObjectMeta.Name, tag)
+	generation := strconv.FormatInt(funcObj.ObjectMeta.Generation, 10)
+	attempt := 1
+	job, err := client.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Get(jobName, metav1.GetOptions{})
 	if err == nil {
-		// The job already exists
-		logrus.Infof("Found a previous job for building %s:%s", imageName, tag)
-		return nil
-	}
-	podSpec := v1.PodSpec{
-		RestartPolicy: v1.RestartPolicyOnFailure,
+		// A failed job is replaced when the function changes or after a backoff
+		retryAt, failed := BuildRetryTime(job)
+		if !failed {
+			logrus.Infof("Found a previous job for building %s:%s", imageName, tag)
+			return nil
+		}
+		if job.ObjectMeta.Annotations[buildGenerationAnnotation] == generation {
+			if time.Now().Before(retryAt) {
+				logrus.Infof("The job %s failed, it will be retried at %s", jobName, retryAt)
+				return nil
+			}
+			attempt = buildAttempt(job) + 1
+		}
+		logrus.Infof("Replacing the failed build job %s", jobName)
+		propagation := metav1.DeletePropagationBackground
+		err = client.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Delete(jobName, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	} else if !k8sErrors.IsNotFound(err) {
+		return err
 	}
-	runtimeVolumeMount := getRuntimeVolumeMount(funcObj.ObjectMeta.Name)
-	err = populatePodSpec(funcObj, lr, &podSpec, runtimeVolumeMount, provisionImage, imagePullSecrets)
+	buildJob, err := NewFunctionBuildJob(jobName, funcObj, lr, or, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage, artifactServer, registryTLSEnabled, imagePullSecrets)
 	if err != nil {
 		return err
 	}
+	buildJob.ObjectMeta.Annotations = map[string]string{
+		buildAttemptAnnotation:    strconv.Itoa(attempt),
+		buildGenerationAnnotation: generation,
+	}
 
-	// Add a final initContainer to create the function bundle.tar
-	prepareContainer := v1.Container{}
-	for _, c := range podSpec.InitContainers {
-		if c.Name == "prepare" {
-			prepareContainer = c
-		}
+	// Create the job if doesn't exists yet
+	_, err = client.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Create(buildJob)
+	if err == nil {
+		logrus.Infof("Started function build job %s", jobName)
 	}
-	podSpec.InitContainers = append(podSpec.InitContainers, v1.Container{
-		Name:         "bundle",
-		Command:      []string{"sh", "-c"},
-		Args:         []string{fmt.Sprintf("tar cvf %s/bundle.tar %s/*", runtimeVolumeMount.MountPath, runtimeVolumeMount.MountPath)},
-		VolumeMounts: prepareContainer.VolumeMounts,
-		Image:        provisionImage,
-	})
+	return err
+}
 
-	buildJob := batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            jobName,
-			Namespace:       funcObj.ObjectMeta.Namespace,
-			OwnerReferences: or,
-			Labels: addDefaultLabel(map[string]string{
-				"function": funcObj.ObjectMeta.Name,
-			}),
-		},
-		Spec: batchv1.JobSpec{
-			Template: v1.PodTemplateSpec{
-				Spec: podSpec,
-			},
-		},
+// NewFunctionBuildJob returns a Job that provisions a function, installs its dependencies, compiles
+// it and pushes the result as the image registryHost/imageName:tag. The function files are read from
+// the ConfigMap of the function
+func NewFunctionBuildJob(jobName string, funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, or []metav1.OwnerReference, imageName, tag, builderImage, registryHost, dockerSecretName, provisionImage, artifactServer string, registryTLSEnabled bool, imagePullSecrets []v1.LocalObjectReference) (*batchv1.Job, error) {
+	podSpec := v1.PodSpec{
+		RestartPolicy: v1.RestartPolicyOnFailure,
+	}
+	runtimeVolumeMount := getRuntimeVolumeMount(funcObj.ObjectMeta.Name)
+	err := populatePodSpec(funcObj, lr, &podSpec, runtimeVolumeMount, provisionImage, artifactServer, imagePullSecrets)
+	if err != nil {
+		return nil, err
 	}
 
 	baseImage, err := lr.GetFunctionImage(funcObj.Spec.Runtime)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Registry volume
@@ -485,47 +530,106 @@ func EnsureFuncImage(client kubernetes.Interface, funcObj *kubelessApi.Function,
 			},
 		},
 	}
-	buildJob.Spec.Template.Spec.Volumes = append(buildJob.Spec.Template.Spec.Volumes, registryCredsVolume)
-
-	args := []string{
-		"/imbuilder",
-		"add-layer",
+	// Build volume, it stores the state of the build between containers
+	buildVolumeMount := v1.VolumeMount{
+		Name:      funcObj.ObjectMeta.Name + "-build",
+		MountPath: "/build",
+	}
+	buildVolume := v1.Volume{
+		Name: buildVolumeMount.Name,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
 	}
+	podSpec.Volumes = append(podSpec.Volumes, registryCredsVolume, buildVolume)
+
+	registryArgs := []string{}
 	if !registryTLSEnabled {
-		args = append(args, "--insecure")
+		registryArgs = append(registryArgs, "--insecure")
 	}
-	args = append(args,
-		"--src", fmt.Sprintf("docker://%s", baseImage),
-		"--dst", fmt.Sprintf("docker://%s/%s:%s", registryHost, imageName, tag),
-		fmt.Sprintf("%s/bundle.tar", podSpec.InitContainers[0].VolumeMounts[0].MountPath),
-	)
-	// Add main container
-	buildJob.Spec.Template.Spec.Containers = []v1.Container{
-		{
-			Name:  "build",
+	// builderContainer returns a container that runs the image builder with access to the
+	// function files and the registry
+	builderContainer := func(name string, args ...string) v1.Container {
+		return v1.Container{
+			Name:  name,
 			Image: builderImage,
-			VolumeMounts: append(prepareContainer.VolumeMounts,
-				v1.VolumeMount{
+			VolumeMounts: []v1.VolumeMount{
+				runtimeVolumeMount,
+				buildVolumeMount,
+				{
 					Name:      dockerCredsVol,
 					MountPath: dockerCredsVolMountPath,
 				},
-			),
+			},
 			Env: []v1.EnvVar{
 				{
 					Name:  "DOCKER_CONFIG_FOLDER",
 					Value: dockerCredsVolMountPath,
 				},
 			},
-			Args: args,
+			Args: append([]string{"/imbuilder"}, args...),
+		}
+	}
+	stateArgs := []string{"--dir", runtimeVolumeMount.MountPath, "--state", buildVolumeMount.MountPath}
+
+	// If the dependencies are installed in a container, they are stored in a separate layer that
+	// is cached in the registry. The install step is skipped if the layer has been restored
+	depsImage := ""
+	initContainers := []v1.Container{}
+	for _, c := range podSpec.InitContainers {
+		if c.Name == "install" {
+			depsImage, err = DepsImage(registryHost, imageName, funcObj.Spec.Runtime, baseImage, c.Image, funcObj.Spec.Deps)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to obtain dependencies checksum: %v", err)
+			}
+			restoreArgs := append([]string{"restore-deps", "--image", fmt.Sprintf("docker://%s", depsImage)}, registryArgs...)
+			initContainers = append(initContainers, builderContainer("restore-deps", append(restoreArgs, stateArgs...)...))
+			c.VolumeMounts = append(c.VolumeMounts, buildVolumeMount)
+			c.Args = []string{fmt.Sprintf("if [ -f %s/deps.list ]; then echo 'Using cached dependencies'; exit 0; fi; %s", buildVolumeMount.MountPath, c.Args[0])}
+			initContainers = append(initContainers, c, builderContainer("save-deps", stateArgs...))
+			continue
+		}
+		initContainers = append(initContainers, c)
+	}
+	// Add a final initContainer to create the function bundle.tar
+	bundleTar := fmt.Sprintf("%s/bundle.tar", buildVolumeMount.MountPath)
+	podSpec.InitContainers = append(initContainers, builderContainer("bundle", append([]string{"bundle", bundleTar}, stateArgs...)...))
+
+	buildJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            jobName,
+			Namespace:       funcObj.ObjectMeta.Namespace,
+			OwnerReferences: or,
+			Labels: addDefaultLabel(map[string]string{
+				"function": funcObj.ObjectMeta.Name,
+			}),
+		},
+		Spec: batchv1.JobSpec{
+			Template: v1.PodTemplateSpec{
+				// The default label allows the pods to reach the artifact server
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: addDefaultLabel(nil),
+				},
+				Spec: podSpec,
+			},
 		},
 	}
 
-	// Create the job if doesn't exists yet
-	_, err = client.BatchV1().Jobs(funcObj.ObjectMeta.Namespace).Create(&buildJob)
-	if err == nil {
-		logrus.Infof("Started function build job %s", jobName)
+	args := append([]string{"add-layer"}, registryArgs...)
+	args = append(args,
+		"--src", fmt.Sprintf("docker://%s", baseImage),
+		"--dst", fmt.Sprintf("docker://%s/%s:%s", registryHost, imageName, tag),
+	)
+	if depsImage != "" {
+		args = append(args,
+			"--cache", fmt.Sprintf("docker://%s", depsImage),
+			"--deps", fmt.Sprintf("%s/deps.tar", buildVolumeMount.MountPath),
+		)
 	}
-	return err
+	// Add main container
+	buildContainer := builderContainer("build", append(args, bundleTar)...)
+	buildJob.Spec.Template.Spec.Containers = []v1.Container{buildContainer}
+	return &buildJob, nil
 }
 
 func svcPort(funcObj *kubelessApi.Function) int32 {
@@ -535,6 +639,250 @@ func svcPort(funcObj *kubelessApi.Function) int32 {
 	return funcObj.Spec.ServiceSpec.Ports[0].Port
 }
 
+// ValidateFunctionDestination checks that a destination of failed events has the fields required by its type
+func ValidateFunctionDestination(d *kubelessApi.FunctionDestination) error {
+	switch d.Type {
+	case kubelessApi.DestinationFunction, kubelessApi.DestinationNATS:
+		if d.Name == "" {
+			return fmt.Errorf("A %s destination requires a name", d.Type)
+		}
+	case kubelessApi.DestinationKafka:
+		if d.Name == "" || d.URL == "" {
+			return fmt.Errorf("A kafka destination requires the name of the topic and the URL of a Kafka REST proxy")
+		}
+	case kubelessApi.DestinationHTTP:
+		if d.URL == "" {
+			return fmt.Errorf("An http destination requires a URL")
+		}
+	default:
+		return fmt.Errorf("Unknown destination type %q", d.Type)
+	}
+	return nil
+}
+
+// ValidateFunctionPayload checks that the payload limits are not negative and that the
+// request schema is a valid JSON Schema
+func ValidateFunctionPayload(p *kubelessApi.FunctionPayload) error {
+	if p.MaxRequestSize != nil && p.MaxRequestSize.Sign() < 0 {
+		return fmt.Errorf("Invalid maximum request size %s", p.MaxRequestSize.String())
+	}
+	if p.MaxResponseSize != nil && p.MaxResponseSize.Sign() < 0 {
+		return fmt.Errorf("Invalid maximum response size %s", p.MaxResponseSize.String())
+	}
+	if p.RequestSchema != nil && len(p.RequestSchema.Raw) > 0 {
+		if _, err := jsonschema.Compile(p.RequestSchema.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payloadEnv returns the environment that configures the checks of the payloads in the function proxy
+func payloadEnv(funcObj *kubelessApi.Function) ([]v1.EnvVar, error) {
+	env := []v1.EnvVar{}
+	p := funcObj.Spec.Payload
+	if p == nil {
+		return env, nil
+	}
+	if err := ValidateFunctionPayload(p); err != nil {
+		return nil, err
+	}
+	if p.MaxRequestSize != nil && !p.MaxRequestSize.IsZero() {
+		env = append(env, v1.EnvVar{Name: "FUNC_MAX_REQUEST_SIZE", Value: strconv.FormatInt(p.MaxRequestSize.Value(), 10)})
+	}
+	if p.MaxResponseSize != nil && !p.MaxResponseSize.IsZero() {
+		env = append(env, v1.EnvVar{Name: "FUNC_MAX_RESPONSE_SIZE", Value: strconv.FormatInt(p.MaxResponseSize.Value(), 10)})
+	}
+	if p.RequestSchema != nil && len(p.RequestSchema.Raw) > 0 {
+		env = append(env, v1.EnvVar{Name: "FUNC_REQUEST_SCHEMA", Value: string(p.RequestSchema.Raw)})
+	}
+	return env, nil
+}
+
+// QueueMountPath is the directory in which the invocations of functions with a volume queue are stored
+const QueueMountPath = "/var/lib/kubeless/invocations"
+
+var defaultQueueSize = resource.MustParse("1Gi")
+
+// ValidateFunctionQueue checks the storage of the asynchronous invocations of a function
+func ValidateFunctionQueue(q *kubelessApi.FunctionQueue) error {
+	switch q.Storage {
+	case "", kubelessApi.QueueStorageMemory, kubelessApi.QueueStorageVolume:
+	default:
+		return fmt.Errorf("Unknown queue storage %s. It should be %s or %s", q.Storage, kubelessApi.QueueStorageMemory, kubelessApi.QueueStorageVolume)
+	}
+	if q.Size != nil && q.Size.Sign() <= 0 {
+		return fmt.Errorf("The size of the queue volume should be positive")
+	}
+	return nil
+}
+
+// functionService returns the name of the service of a function. The canary revision of a function
+// has its own name but shares the service (and the label "function") of the function
+func functionService(funcObj *kubelessApi.Function) string {
+	if name := funcObj.ObjectMeta.Labels["function"]; name != "" {
+		return name
+	}
+	return funcObj.ObjectMeta.Name
+}
+
+// QueueClaimName returns the name of the PersistentVolumeClaim that stores the asynchronous
+// invocations of a function
+func QueueClaimName(funcName string) string {
+	return funcName + "-invocations"
+}
+
+func hasQueueVolume(funcObj *kubelessApi.Function) bool {
+	return funcObj.Spec.AsyncQueue != nil && funcObj.Spec.AsyncQueue.Storage == kubelessApi.QueueStorageVolume
+}
+
+// EnsureFuncQueueClaim creates the PersistentVolumeClaim shared by the replicas of a function to store
+// its asynchronous invocations. The claim is kept (until the function is deleted) if the function
+// stops using it so the invocations that are not finished are not lost
+func EnsureFuncQueueClaim(client kubernetes.Interface, funcObj *kubelessApi.Function, or []metav1.OwnerReference) error {
+	q := funcObj.Spec.AsyncQueue
+	if q == nil {
+		return nil
+	}
+	if err := ValidateFunctionQueue(q); err != nil {
+		return err
+	}
+	if !hasQueueVolume(funcObj) {
+		return nil
+	}
+	size := defaultQueueSize
+	if q.Size != nil {
+		size = *q.Size
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            QueueClaimName(funcObj.ObjectMeta.Name),
+			Labels:          addDefaultLabel(map[string]string{"function": funcObj.ObjectMeta.Name}),
+			OwnerReferences: or,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			// All the replicas of the function claim invocations from the same volume
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			StorageClassName: q.StorageClassName,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+	_, err := client.CoreV1().PersistentVolumeClaims(funcObj.ObjectMeta.Namespace).Create(pvc)
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		// The spec of a claim can't be modified
+		return nil
+	}
+	return err
+}
+
+// queueVolume returns the volume and the environment of a function that stores its
+// asynchronous invocations in a volume
+func queueVolume(funcObj *kubelessApi.Function) (*v1.Volume, *v1.VolumeMount, []v1.EnvVar) {
+	if !hasQueueVolume(funcObj) {
+		return nil, nil, nil
+	}
+	volume := &v1.Volume{
+		Name: "invocations",
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: QueueClaimName(functionService(funcObj)),
+			},
+		},
+	}
+	mount := &v1.VolumeMount{Name: volume.Name, MountPath: QueueMountPath}
+	env := []v1.EnvVar{
+		{Name: "FUNC_QUEUE", Value: "file"},
+		{Name: "FUNC_QUEUE_DIR", Value: QueueMountPath},
+	}
+	return volume, mount, env
+}
+
+// FunctionProtocols are the protocols that a function can serve
+var FunctionProtocols = []string{
+	string(kubelessApi.ProtocolHTTP),
+	string(kubelessApi.ProtocolWebSocket),
+	string(kubelessApi.ProtocolGRPC),
+}
+
+// ValidateFunctionProtocol returns an error if the function proxy can't serve the given protocol
+func ValidateFunctionProtocol(p kubelessApi.FunctionProtocol) error {
+	for _, protocol := range FunctionProtocols {
+		if string(p) == protocol {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown protocol %q, it should be one of %s", p, strings.Join(FunctionProtocols, ", "))
+}
+
+// FunctionPortName returns the name of the service port of a function that serves the given protocol.
+// Istio and most ingress controllers choose how to route the traffic of a port from the prefix of its name
+func FunctionPortName(p kubelessApi.FunctionProtocol) string {
+	if p == kubelessApi.ProtocolGRPC {
+		return "grpc-function-port"
+	}
+	return "http-function-port"
+}
+
+// IsDefaultFunctionPortName returns true if the name is empty or one of the names that kubeless gives to the function port
+func IsDefaultFunctionPortName(name string) bool {
+	return name == "" || name == FunctionPortName(kubelessApi.ProtocolHTTP) || name == FunctionPortName(kubelessApi.ProtocolGRPC)
+}
+
+// failurePolicyEnv returns the environment variables that configure how the runtime retries
+// the failed invocations of a function and where it sends the events that keep failing
+func failurePolicyEnv(client kubernetes.Interface, funcObj *kubelessApi.Function) ([]v1.EnvVar, error) {
+	env := []v1.EnvVar{}
+	if p := funcObj.Spec.RetryPolicy; p != nil {
+		if p.MaxAttempts < 0 {
+			return nil, fmt.Errorf("Invalid number of attempts %d", p.MaxAttempts)
+		}
+		for _, d := range []string{p.Backoff, p.MaxBackoff} {
+			if _, err := time.ParseDuration(d); d != "" && err != nil {
+				return nil, fmt.Errorf("Invalid backoff %s: %v", d, err)
+			}
+		}
+		if p.MaxAttempts > 0 {
+			env = append(env, v1.EnvVar{Name: "FUNC_RETRY_MAX_ATTEMPTS", Value: strconv.Itoa(int(p.MaxAttempts))})
+		}
+		if p.Backoff != "" {
+			env = append(env, v1.EnvVar{Name: "FUNC_RETRY_BACKOFF", Value: p.Backoff})
+		}
+		if p.MaxBackoff != "" {
+			env = append(env, v1.EnvVar{Name: "FUNC_RETRY_MAX_BACKOFF", Value: p.MaxBackoff})
+		}
+	}
+	if funcObj.Spec.OnFailure != nil {
+		dest := *funcObj.Spec.OnFailure
+		if err := ValidateFunctionDestination(&dest); err != nil {
+			return nil, err
+		}
+		switch dest.Type {
+		case kubelessApi.DestinationFunction:
+			// The runtime sends the events to the service of the function
+			port := int32(8080)
+			svc, err := client.CoreV1().Services(funcObj.ObjectMeta.Namespace).Get(dest.Name, metav1.GetOptions{})
+			if err == nil && len(svc.Spec.Ports) > 0 {
+				port = svc.Spec.Ports[0].Port
+			}
+			dest.URL = fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", dest.Name, funcObj.ObjectMeta.Namespace, port)
+		case kubelessApi.DestinationNATS:
+			if dest.URL == "" {
+				dest.URL = defaultNATSURL
+			}
+		}
+		destination, err := json.Marshal(dest)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env,
+			v1.EnvVar{Name: "FUNC_ON_FAILURE", Value: string(destination)},
+		)
+	}
+	return env, nil
+}
+
 func mergeMap(dst, src map[string]string) map[string]string {
 	if len(dst) == 0 {
 		dst = make(map[string]string)
@@ -545,8 +893,76 @@ func mergeMap(dst, src map[string]string) map[string]string {
 	return dst
 }
 
+// terminationGracePeriod returns the seconds that a function pod needs to stop: the proxy waits
+// 5 seconds for the pod to be removed from the endpoints and then up to the function timeout
+// for the requests in flight
+func terminationGracePeriod(funcObj *kubelessApi.Function) int64 {
+	timeout, err := strconv.Atoi(funcObj.Spec.Timeout)
+	if err != nil || timeout < 0 {
+		timeout, _ = strconv.Atoi(defaultTimeout)
+	}
+	return int64(5 + timeout)
+}
+
+// handlerEnv returns the environment variables that tell the runtime which function to load
+func handlerEnv(funcObj *kubelessApi.Function, modName, handlerName string, resources v1.ResourceRequirements) []v1.EnvVar {
+	timeout := funcObj.Spec.Timeout
+	if timeout == "" {
+		// Set default timeout to 180 seconds
+		timeout = defaultTimeout
+	}
+	return []v1.EnvVar{
+		{
+			Name:  "FUNC_HANDLER",
+			Value: handlerName,
+		},
+		{
+			Name:  "MOD_NAME",
+			Value: modName,
+		},
+		{
+			Name:  "FUNC_TIMEOUT",
+			Value: timeout,
+		},
+		{
+			Name:  "FUNC_RUNTIME",
+			Value: funcObj.Spec.Runtime,
+		},
+		{
+			Name:  "FUNC_MEMORY_LIMIT",
+			Value: resources.Limits.Memory().String(),
+		},
+	}
+}
+
+// identityEnv returns the environment variables that identify the function in its logs and events
+// and, if it is not plain HTTP, the protocol that the function proxy should serve
+func identityEnv(funcObj *kubelessApi.Function) []v1.EnvVar {
+	env := []v1.EnvVar{
+		{
+			Name:  "FUNC_NAME",
+			Value: funcObj.ObjectMeta.Name,
+		},
+		{
+			Name:  "FUNC_NAMESPACE",
+			Value: funcObj.ObjectMeta.Namespace,
+		},
+		{
+			Name:  "FUNC_SERVICE",
+			Value: functionService(funcObj),
+		},
+	}
+	if funcObj.Spec.Protocol != "" {
+		env = append(env, v1.EnvVar{
+			Name:  "FUNC_PROTOCOL",
+			Value: string(funcObj.Spec.Protocol),
+		})
+	}
+	return env
+}
+
 // EnsureFuncDeployment creates/updates a function deployment
-func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Function, or []metav1.OwnerReference, lr *langruntime.Langruntimes, prebuiltRuntimeImage, provisionImage string, imagePullSecrets []v1.LocalObjectReference) error {
+func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Function, or []metav1.OwnerReference, lr *langruntime.Langruntimes, prebuiltRuntimeImage, provisionImage, artifactServer string, imagePullSecrets []v1.LocalObjectReference) error {
 
 	var err error
 
@@ -563,22 +979,28 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 	maxUnavailable := intstr.FromInt(0)
 
 	//add deployment and copy all func's Spec.Deployment to the deployment
-	dpm := funcObj.Spec.Deployment.DeepCopy()
+	dpm := functionDeployment(&funcObj.Spec.Deployment)
 	dpm.OwnerReferences = or
 	dpm.ObjectMeta.Name = funcObj.ObjectMeta.Name
+	// The pods of the stable revision are labeled with track=stable so the Deployment doesn't select
+	// the pods of the canary revision (labeled with track=canary). The service selects both of them
+	podLabels := funcObj.ObjectMeta.Labels
+	if _, ok := podLabels["track"]; !ok {
+		podLabels = mergeMap(map[string]string{"track": "stable"}, funcObj.ObjectMeta.Labels)
+	}
 	dpm.Spec.Selector = &metav1.LabelSelector{
-		MatchLabels: funcObj.ObjectMeta.Labels,
+		MatchLabels: podLabels,
 	}
 
-	dpm.Spec.Strategy = v1beta1.DeploymentStrategy{
-		RollingUpdate: &v1beta1.RollingUpdateDeployment{
+	dpm.Spec.Strategy = appsv1.DeploymentStrategy{
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
 			MaxUnavailable: &maxUnavailable,
 		},
 	}
 
 	//append data to dpm deployment
 	dpm.Labels = addDefaultLabel(mergeMap(dpm.Labels, funcObj.Labels))
-	dpm.Spec.Template.Labels = mergeMap(dpm.Spec.Template.Labels, funcObj.Labels)
+	dpm.Spec.Template.Labels = mergeMap(dpm.Spec.Template.Labels, podLabels)
 	dpm.Annotations = mergeMap(dpm.Annotations, funcObj.Annotations)
 	dpm.Spec.Template.Annotations = mergeMap(dpm.Spec.Template.Annotations, funcObj.Annotations)
 	dpm.Spec.Template.Annotations = mergeMap(dpm.Spec.Template.Annotations, podAnnotations)
@@ -595,7 +1017,7 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 		}
 		//only resolve the image name and build the function if it has not been built already
 		if dpm.Spec.Template.Spec.Containers[0].Image == "" && prebuiltRuntimeImage == "" {
-			err := populatePodSpec(funcObj, lr, &dpm.Spec.Template.Spec, runtimeVolumeMount, provisionImage, imagePullSecrets)
+			err := populatePodSpec(funcObj, lr, &dpm.Spec.Template.Spec, runtimeVolumeMount, provisionImage, artifactServer, imagePullSecrets)
 			if err != nil {
 				return err
 			}
@@ -614,32 +1036,8 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 			}
 			dpm.Spec.Template.Spec.ImagePullSecrets = imagePullSecrets
 		}
-		timeout := funcObj.Spec.Timeout
-		if timeout == "" {
-			// Set default timeout to 180 seconds
-			timeout = defaultTimeout
-		}
 		dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env,
-			v1.EnvVar{
-				Name:  "FUNC_HANDLER",
-				Value: handlerName,
-			},
-			v1.EnvVar{
-				Name:  "MOD_NAME",
-				Value: modName,
-			},
-			v1.EnvVar{
-				Name:  "FUNC_TIMEOUT",
-				Value: timeout,
-			},
-			v1.EnvVar{
-				Name:  "FUNC_RUNTIME",
-				Value: funcObj.Spec.Runtime,
-			},
-			v1.EnvVar{
-				Name:  "FUNC_MEMORY_LIMIT",
-				Value: dpm.Spec.Template.Spec.Containers[0].Resources.Limits.Memory().String(),
-			},
+			handlerEnv(funcObj, modName, handlerName, dpm.Spec.Template.Spec.Containers[0].Resources)...,
 		)
 	} else {
 		logrus.Warn("Expected non-empty handler and non-empty function content")
@@ -651,6 +1049,35 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 			Value: strconv.Itoa(int(svcPort(funcObj))),
 		},
 	)
+	dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env, identityEnv(funcObj)...)
+	if funcObj.Spec.ContainerConcurrency > 0 {
+		// The function proxy queues the requests that exceed the concurrency of the container
+		dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env, v1.EnvVar{
+			Name:  "FUNC_CONTAINER_CONCURRENCY",
+			Value: strconv.Itoa(int(funcObj.Spec.ContainerConcurrency)),
+		})
+	}
+
+	failureEnv, err := failurePolicyEnv(client, funcObj)
+	if err != nil {
+		return err
+	}
+	dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env, failureEnv...)
+
+	payloadEnv, err := payloadEnv(funcObj)
+	if err != nil {
+		return err
+	}
+	dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env, payloadEnv...)
+
+	if volume, mount, env := queueVolume(funcObj); volume != nil {
+		if err := ValidateFunctionQueue(funcObj.Spec.AsyncQueue); err != nil {
+			return err
+		}
+		dpm.Spec.Template.Spec.Volumes = append(dpm.Spec.Template.Spec.Volumes, *volume)
+		dpm.Spec.Template.Spec.Containers[0].VolumeMounts = append(dpm.Spec.Template.Spec.Containers[0].VolumeMounts, *mount)
+		dpm.Spec.Template.Spec.Containers[0].Env = append(dpm.Spec.Template.Spec.Containers[0].Env, env...)
+	}
 
 	dpm.Spec.Template.Spec.Containers[0].Name = funcObj.ObjectMeta.Name
 	dpm.Spec.Template.Spec.Containers[0].Ports = append(dpm.Spec.Template.Spec.Containers[0].Ports, v1.ContainerPort{
@@ -665,6 +1092,13 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 	if dpm.Spec.Template.Spec.Containers[0].LivenessProbe == nil {
 		dpm.Spec.Template.Spec.Containers[0].LivenessProbe = livenessProbeInfo
 	}
+	if dpm.Spec.Template.Spec.Containers[0].ReadinessProbe == nil {
+		dpm.Spec.Template.Spec.Containers[0].ReadinessProbe = lr.GetReadinessProbeInfo(funcObj.Spec.Runtime, int(svcPort(funcObj)))
+	}
+	if dpm.Spec.Template.Spec.TerminationGracePeriodSeconds == nil {
+		gracePeriod := terminationGracePeriod(funcObj)
+		dpm.Spec.Template.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	}
 
 	// Add security context
 	runtimeUser := int64(1000)
@@ -675,12 +1109,12 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 		}
 	}
 
-	_, err = client.ExtensionsV1beta1().Deployments(funcObj.ObjectMeta.Namespace).Create(dpm)
+	_, err = client.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Create(dpm)
 	if err != nil && k8sErrors.IsAlreadyExists(err) {
 		// In case the Deployment already exists we should update
 		// just certain fields (to avoid race conditions)
-		var newDpm *v1beta1.Deployment
-		newDpm, err = client.ExtensionsV1beta1().Deployments(funcObj.ObjectMeta.Namespace).Get(funcObj.ObjectMeta.Name, metav1.GetOptions{})
+		var newDpm *appsv1.Deployment
+		newDpm, err = client.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Get(funcObj.ObjectMeta.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
@@ -690,7 +1124,9 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 		newDpm.ObjectMeta.Labels = funcObj.ObjectMeta.Labels
 		newDpm.ObjectMeta.Annotations = funcObj.Spec.Deployment.ObjectMeta.Annotations
 		newDpm.ObjectMeta.OwnerReferences = or
-		// We should maintain previous selector to avoid duplicated ReplicaSets
+		// We should maintain previous selector to avoid duplicated ReplicaSets. Deployments created
+		// with the extensions/v1beta1 API (or without the track label) keep their selector since it is
+		// immutable in apps/v1
 		selector := newDpm.Spec.Selector
 		newDpm.Spec = dpm.Spec
 		newDpm.Spec.Selector = selector
@@ -699,7 +1135,7 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 			return err
 		}
 		// Use `Patch` to do a rolling update
-		_, err = client.ExtensionsV1beta1().Deployments(funcObj.ObjectMeta.Namespace).Patch(newDpm.Name, types.MergePatchType, data)
+		_, err = client.AppsV1().Deployments(funcObj.ObjectMeta.Namespace).Patch(newDpm.Name, types.MergePatchType, data)
 		if err != nil {
 			return err
 		}
@@ -708,6 +1144,245 @@ func EnsureFuncDeployment(client kubernetes.Interface, funcObj *kubelessApi.Func
 	return err
 }
 
+// functionDeployment returns the apps/v1 version of the Deployment of a function spec
+func functionDeployment(d *v1beta1.Deployment) *appsv1.Deployment {
+	d = d.DeepCopy()
+	dpm := &appsv1.Deployment{
+		ObjectMeta: d.ObjectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas:                d.Spec.Replicas,
+			Selector:                d.Spec.Selector,
+			Template:                d.Spec.Template,
+			Strategy:                appsv1.DeploymentStrategy{Type: appsv1.DeploymentStrategyType(d.Spec.Strategy.Type)},
+			MinReadySeconds:         d.Spec.MinReadySeconds,
+			RevisionHistoryLimit:    d.Spec.RevisionHistoryLimit,
+			Paused:                  d.Spec.Paused,
+			ProgressDeadlineSeconds: d.Spec.ProgressDeadlineSeconds,
+		},
+	}
+	if ru := d.Spec.Strategy.RollingUpdate; ru != nil {
+		dpm.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{
+			MaxUnavailable: ru.MaxUnavailable,
+			MaxSurge:       ru.MaxSurge,
+		}
+	}
+	return dpm
+}
+
+// CanaryName returns the name used for the resources of the canary revision of a function
+func CanaryName(funcName string) string {
+	return funcName + "-canary"
+}
+
+// CanaryFunction returns a function object describing the canary revision of the given function.
+// It shares the labels of the function (plus the label track=canary) so the function service
+// routes requests to both revisions
+func CanaryFunction(funcObj *kubelessApi.Function) *kubelessApi.Function {
+	labels := mergeMap(map[string]string{}, funcObj.ObjectMeta.Labels)
+	labels["track"] = "canary"
+	canary := &kubelessApi.Function{
+		TypeMeta: funcObj.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        CanaryName(funcObj.ObjectMeta.Name),
+			Namespace:   funcObj.ObjectMeta.Namespace,
+			Labels:      labels,
+			Annotations: funcObj.ObjectMeta.Annotations,
+			UID:         funcObj.ObjectMeta.UID,
+		},
+	}
+	if funcObj.Spec.Canary != nil {
+		canary.Spec = *funcObj.Spec.Canary.Spec.DeepCopy()
+		canary.Spec.Canary = nil
+	}
+	return canary
+}
+
+// CanaryWeightTolerance is the maximum difference (in percentage points) between the weight of a
+// canary and the share of the replicas that it receives
+const CanaryWeightTolerance = 5
+
+// SplitReplicas distributes the replicas of a function between its stable and canary revisions
+// so the canary receives the given percentage of the traffic. Unless the weight is 0 or 100 both
+// revisions need at least one replica so it returns an error if the weight can't be honored
+// with the given number of replicas
+func SplitReplicas(total, weight int32) (int32, int32, error) {
+	if weight < 0 || weight > 100 {
+		return 0, 0, fmt.Errorf("Invalid canary weight %d. It should be a percentage between 0 and 100", weight)
+	}
+	if total <= 0 {
+		return 0, 0, nil
+	}
+	if weight == 0 {
+		return total, 0, nil
+	}
+	if weight == 100 {
+		return 0, total, nil
+	}
+	if total == 1 {
+		return 0, 0, fmt.Errorf("A canary weight of %d%% can't be honored with a single replica. Increase the replicas of the function or use a weight of 100%%", weight)
+	}
+	// Round to the closest number of replicas keeping at least one replica in each revision
+	canary := (total*weight + 50) / 100
+	if canary == 0 {
+		canary = 1
+	}
+	if canary == total {
+		canary = total - 1
+	}
+	share := canary * 100 / total
+	if share-weight > CanaryWeightTolerance || weight-share > CanaryWeightTolerance {
+		return 0, 0, fmt.Errorf("A canary weight of %d%% can't be honored with %d replicas. Increase the replicas of the function or use a weight closer to %d%%", weight, total, share)
+	}
+	return total - canary, canary, nil
+}
+
+// FunctionReplicas returns the replicas requested for a function (one by default)
+func FunctionReplicas(spec *kubelessApi.FunctionSpec) int32 {
+	if spec.Deployment.Spec.Replicas != nil {
+		return *spec.Deployment.Spec.Replicas
+	}
+	return 1
+}
+
+// ValidateCanary returns an error if the canary release of a function can't be deployed. The
+// traffic is split by the replicas of each revision so the weight should be achievable with the
+// replicas of the function and these replicas can't be managed by a HorizontalPodAutoscaler
+func ValidateCanary(spec *kubelessApi.FunctionSpec) error {
+	if spec.Canary == nil {
+		return nil
+	}
+	if spec.HorizontalPodAutoscaler.Name != "" {
+		return fmt.Errorf("Canary releases are not supported for functions with a HorizontalPodAutoscaler. Remove the autoscaler before starting the rollout")
+	}
+	_, _, err := SplitReplicas(FunctionReplicas(spec), spec.Canary.Weight)
+	return err
+}
+
+// ListFuncRevisions returns the revisions recorded for a function sorted by their number
+func ListFuncRevisions(client kubernetes.Interface, ns, funcName string) ([]appsv1.ControllerRevision, error) {
+	revisions, err := client.AppsV1().ControllerRevisions(ns).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("created-by=kubeless,function=%s", funcName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(revisions.Items, func(i, j int) bool {
+		return revisions.Items[i].Revision < revisions.Items[j].Revision
+	})
+	return revisions.Items, nil
+}
+
+// ReferencedArtifacts returns the keys of the artifacts used by the functions, their canaries and
+// the revisions that they can be rolled back to
+func ReferencedArtifacts(client kubernetes.Interface, kubelessClient versioned.Interface) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	addSpec := func(spec *kubelessApi.FunctionSpec) {
+		if strings.Contains(spec.FunctionContentType, "artifact") {
+			referenced[spec.Function] = true
+		}
+		if spec.Canary != nil && strings.Contains(spec.Canary.Spec.FunctionContentType, "artifact") {
+			referenced[spec.Canary.Spec.Function] = true
+		}
+	}
+	functions, err := kubelessClient.KubelessV1beta1().Functions(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range functions.Items {
+		addSpec(&functions.Items[i].Spec)
+	}
+	revisions, err := client.AppsV1().ControllerRevisions(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: "created-by=kubeless,function",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisions.Items {
+		spec, err := GetFuncRevisionSpec(&revisions.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		addSpec(spec)
+	}
+	return referenced, nil
+}
+
+// GetFuncRevisionSpec returns the function spec stored in a revision
+func GetFuncRevisionSpec(revision *appsv1.ControllerRevision) (*kubelessApi.FunctionSpec, error) {
+	spec := &kubelessApi.FunctionSpec{}
+	err := json.Unmarshal(revision.Data.Raw, spec)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse revision %d: %v", revision.Revision, err)
+	}
+	return spec, nil
+}
+
+// EnsureFuncRevision records the given spec of a function as a ControllerRevision and returns its number.
+// If the spec is equal to the one of an existing revision, that revision becomes the latest one.
+// The oldest revisions are removed so at most historyLimit revisions are kept
+func EnsureFuncRevision(client kubernetes.Interface, funcObj *kubelessApi.Function, spec *kubelessApi.FunctionSpec, or []metav1.OwnerReference, historyLimit int) (int64, error) {
+	// The canary is not part of the revision, it is just a temporary state of the rollout
+	revisionSpec := spec.DeepCopy()
+	revisionSpec.Canary = nil
+	data, err := json.Marshal(revisionSpec)
+	if err != nil {
+		return 0, err
+	}
+	name := fmt.Sprintf("%s-%s", funcObj.ObjectMeta.Name, fmt.Sprintf("%x", sha256.Sum256(data))[0:10])
+
+	revisions, err := ListFuncRevisions(client, funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name)
+	if err != nil {
+		return 0, err
+	}
+	latest := int64(0)
+	var existing *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Revision > latest {
+			latest = revisions[i].Revision
+		}
+		if revisions[i].Name == name {
+			existing = revisions[i].DeepCopy()
+		}
+	}
+
+	if existing != nil {
+		if existing.Revision == latest {
+			return latest, nil
+		}
+		existing.Revision = latest + 1
+		_, err = client.AppsV1().ControllerRevisions(funcObj.ObjectMeta.Namespace).Update(existing)
+	} else {
+		revision := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: funcObj.ObjectMeta.Namespace,
+				Labels: addDefaultLabel(map[string]string{
+					"function": funcObj.ObjectMeta.Name,
+				}),
+				OwnerReferences: or,
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: latest + 1,
+		}
+		_, err = client.AppsV1().ControllerRevisions(funcObj.ObjectMeta.Namespace).Create(revision)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	revisions, err = ListFuncRevisions(client, funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(revisions)-historyLimit; i++ {
+		err = client.AppsV1().ControllerRevisions(funcObj.ObjectMeta.Namespace).Delete(revisions[i].Name, &metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return 0, err
+		}
+	}
+	return latest + 1, nil
+}
+
 // CreateServiceMonitor creates a Service Monitor for the given function
 func CreateServiceMonitor(smclient monitoringv1alpha1.MonitoringV1alpha1Client, funcObj *kubelessApi.Function, ns string, or []metav1.OwnerReference) error {
 	_, err := smclient.ServiceMonitors(ns).Get(funcObj.ObjectMeta.Name, metav1.GetOptions{})
@@ -730,7 +1405,7 @@ func CreateServiceMonitor(smclient monitoringv1alpha1.MonitoringV1alpha1Client,
 					},
 					Endpoints: []monitoringv1alpha1.Endpoint{
 						{
-							Port: "http-function-port",
+							Port: FunctionPortName(funcObj.Spec.Protocol),
 						},
 					},
 				},
@@ -1,19 +1,28 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	kFake "github.com/kubeless/kubeless/pkg/client/clientset/versioned/fake"
 	"github.com/kubeless/kubeless/pkg/langruntime"
 
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
 )
 
 func getEnvValueFromList(envName string, l []v1.EnvVar) string {
@@ -347,7 +356,7 @@ func TestEnsureImage(t *testing.T) {
 	pullSecrets := []v1.LocalObjectReference{
 		{Name: "creds"},
 	}
-	err := EnsureFuncImage(clientset, f1, lr, or, "user/image", "4840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a", "kubeless/builder", "registry.docker.io", "registry-creds", "unzip", true, pullSecrets)
+	err := EnsureFuncImage(clientset, f1, lr, or, "user/image", "4840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a", "kubeless/builder", "registry.docker.io", "registry-creds", "unzip", "", true, pullSecrets)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -358,6 +367,9 @@ func TestEnsureImage(t *testing.T) {
 	if len(jobs.Items) != 1 {
 		t.Errorf("It should have created the build job")
 	}
+	if !hasDefaultLabel(jobs.Items[0].Spec.Template.Labels) {
+		t.Errorf("The pods of the build job should have the default label: %v", jobs.Items[0].Spec.Template.Labels)
+	}
 	buildContainer := jobs.Items[0].Spec.Template.Spec.Containers[0]
 	if buildContainer.Image != "kubeless/builder" {
 		t.Errorf("Image %s of build job is not recognised", jobs.Items[0].Spec.Template.Spec.Containers[0].Image)
@@ -378,6 +390,86 @@ func TestEnsureImage(t *testing.T) {
 	if reflect.DeepEqual(jobs.Items[0].Spec.Template.Spec.ImagePullSecrets, pullSecrets) {
 		t.Error("Missing ImagePullSecrets")
 	}
+	// The dependencies are installed in a cached layer
+	containers := []string{}
+	for _, c := range jobs.Items[0].Spec.Template.Spec.InitContainers {
+		containers = append(containers, c.Name)
+	}
+	if !reflect.DeepEqual(containers, []string{"prepare", "restore-deps", "install", "save-deps", "bundle"}) {
+		t.Errorf("Unexpected init containers %v", containers)
+	}
+	depsImage, err := DepsImage("registry.docker.io", "user/image", "python2.7", "bar", jobs.Items[0].Spec.Template.Spec.InitContainers[2].Image, "deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreArgs := strings.Join(jobs.Items[0].Spec.Template.Spec.InitContainers[1].Args, " ")
+	if !strings.Contains(restoreArgs, "--image docker://"+depsImage) {
+		t.Errorf("Unexpected restore-deps args %s", restoreArgs)
+	}
+	installArgs := jobs.Items[0].Spec.Template.Spec.InitContainers[2].Args[0]
+	if !strings.HasPrefix(installArgs, "if [ -f /build/deps.list ]") {
+		t.Errorf("The install step should be skipped if the dependencies are cached: %s", installArgs)
+	}
+	buildArgs := strings.Join(buildContainer.Args, " ")
+	expectedArgs := fmt.Sprintf("/imbuilder add-layer --src docker://bar --dst docker://registry.docker.io/user/image:4840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a --cache docker://%s --deps /build/deps.tar /build/bundle.tar", depsImage)
+	if buildArgs != expectedArgs {
+		t.Errorf("Unexpected build args %s", buildArgs)
+	}
+
+	// Functions without dependencies don't use the cache
+	f2 := f1.DeepCopy()
+	f2.ObjectMeta.Name = "f2"
+	f2.Spec.Deps = ""
+	err = EnsureFuncImage(clientset, f2, lr, or, "user/image", "5840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a", "kubeless/builder", "registry.docker.io", "registry-creds", "unzip", "", true, pullSecrets)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	job, err := clientset.BatchV1().Jobs(ns).Get(BuildJobName("f2", "5840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(job.Spec.Template.Spec.InitContainers); n != 2 {
+		t.Errorf("Expecting the prepare and bundle steps, got %d containers", n)
+	}
+	if args := strings.Join(job.Spec.Template.Spec.Containers[0].Args, " "); strings.Contains(args, "--cache") {
+		t.Errorf("Unexpected build args %s", args)
+	}
+
+	// Failed jobs are replaced after a backoff or when the function changes
+	fail := func(failedAt time.Time) {
+		job.Status.Conditions = []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(failedAt)},
+		}
+		if _, err := clientset.BatchV1().Jobs(ns).Update(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure := func(f *kubelessApi.Function) *batchv1.Job {
+		err := EnsureFuncImage(clientset, f, lr, or, "user/image", "5840d87600137157493ba43a24f0b4bb6cf524ebbf095ce96c79f85bf5a3ff5a", "kubeless/builder", "registry.docker.io", "registry-creds", "unzip", "", true, pullSecrets)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		j, err := clientset.BatchV1().Jobs(ns).Get(job.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	fail(time.Now())
+	if job = ensure(f2); len(job.Status.Conditions) == 0 {
+		t.Error("Expecting the failed job to be kept during the backoff")
+	}
+	fail(time.Now().Add(-2 * time.Minute))
+	job = ensure(f2)
+	if len(job.Status.Conditions) != 0 || job.ObjectMeta.Annotations[buildAttemptAnnotation] != "2" {
+		t.Errorf("Expecting a second build attempt, received %v", job.ObjectMeta.Annotations)
+	}
+	fail(time.Now())
+	f2.ObjectMeta.Generation = 2
+	job = ensure(f2)
+	if len(job.Status.Conditions) != 0 || job.ObjectMeta.Annotations[buildAttemptAnnotation] != "1" || job.ObjectMeta.Annotations[buildGenerationAnnotation] != "2" {
+		t.Errorf("Expecting a new build job for the modified function, received %v", job.ObjectMeta.Annotations)
+	}
 }
 
 func getDefaultFunc(name, ns string) *kubelessApi.Function {
@@ -464,11 +556,11 @@ func TestEnsureDeployment(t *testing.T) {
 	pullSecrets := []v1.LocalObjectReference{
 		{Name: "creds"},
 	}
-	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", pullSecrets)
+	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", "", pullSecrets)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(f1Name, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(f1Name, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -533,6 +625,18 @@ func TestEnsureDeployment(t *testing.T) {
 				Name:  "FUNC_PORT",
 				Value: strconv.Itoa(int(f1Port)),
 			},
+			{
+				Name:  "FUNC_NAME",
+				Value: f1Name,
+			},
+			{
+				Name:  "FUNC_NAMESPACE",
+				Value: ns,
+			},
+			{
+				Name:  "FUNC_SERVICE",
+				Value: f1Name,
+			},
 			{
 				Name:  "KUBELESS_INSTALL_VOLUME",
 				Value: "/kubeless",
@@ -562,6 +666,11 @@ func TestEnsureDeployment(t *testing.T) {
 		t.Errorf("Unexpected container definition. Received:\n %+v\nExpecting:\n %+v", dpm.Spec.Template.Spec.Containers[0], expectedContainer)
 	}
 
+	// The pod has time to drain the requests in flight before being killed
+	if gracePeriod := dpm.Spec.Template.Spec.TerminationGracePeriodSeconds; gracePeriod == nil || *gracePeriod != 185 {
+		t.Errorf("Expecting a termination grace period of 185 seconds, received %v", gracePeriod)
+	}
+
 	secrets := dpm.Spec.Template.Spec.ImagePullSecrets
 	if secrets[0].Name != "creds" && secrets[1].Name != "p1" && secrets[2].Name != "p2" {
 		t.Errorf("Expected first secret to be 'p1' but found %v and second secret to be 'p2' and found %v", secrets[0], secrets[1])
@@ -583,11 +692,11 @@ func TestEnsureDeploymentWithoutFuncNorHandler(t *testing.T) {
 	f2 := getDefaultFunc(funcName, ns)
 	f2.Spec.Function = ""
 	f2.Spec.Handler = ""
-	err := EnsureFuncDeployment(clientset, f2, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f2, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	_, err = clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	_, err = clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -599,11 +708,11 @@ func TestEnsureDeploymentWithImage(t *testing.T) {
 	// If the Image has been already provided it should not resolve it
 	f3 := getDefaultFunc(funcName, ns)
 	f3.Spec.Deployment.Spec.Template.Spec.Containers[0].Image = "test-image"
-	err := EnsureFuncDeployment(clientset, f3, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f3, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -619,11 +728,11 @@ func TestEnsureDeploymentWithoutFunc(t *testing.T) {
 	f4 := getDefaultFunc(funcName, ns)
 	f4.Spec.Function = ""
 	f4.Spec.Deps = ""
-	err := EnsureFuncDeployment(clientset, f4, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f4, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -646,7 +755,7 @@ func TestEnsureUpdateDeployment(t *testing.T) {
 	f1.Spec.Deployment.Spec.Template.ObjectMeta = metav1.ObjectMeta{
 		Annotations: funcAnno,
 	}
-	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -654,7 +763,7 @@ func TestEnsureUpdateDeployment(t *testing.T) {
 	f6 = *f1
 	f6.Spec.Handler = "foo.bar2"
 	f6.Spec.Deployment.ObjectMeta.Annotations["new-key"] = "value"
-	err = EnsureFuncDeployment(clientset, &f6, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err = EnsureFuncDeployment(clientset, &f6, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -665,19 +774,61 @@ func TestEnsureUpdateDeployment(t *testing.T) {
 func TestAvoidDeploymentOverwrite(t *testing.T) {
 	f1Name := "f1"
 	clientset, or, ns, lr := prepareDeploymentTest(f1Name)
-	clientset.ExtensionsV1beta1().Deployments(ns).Create(&v1beta1.Deployment{
+	clientset.AppsV1().Deployments(ns).Create(&appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      f1Name,
 			Namespace: ns,
 		},
 	})
 	f1 := getDefaultFunc(f1Name, ns)
-	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err == nil && strings.Contains(err.Error(), "conflicting object") {
 		t.Errorf("It should fail because a conflict")
 	}
 }
 
+func TestEnsureDeploymentKeepsMigratedSelector(t *testing.T) {
+	f1Name := "f1"
+	clientset, or, ns, lr := prepareDeploymentTest(f1Name)
+	// Deployments created with extensions/v1beta1 may have a selector that
+	// doesn't match the function labels, it is immutable in apps/v1
+	oldSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"function": f1Name},
+	}
+	clientset.AppsV1().Deployments(ns).Create(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      f1Name,
+			Namespace: ns,
+			Labels:    map[string]string{"created-by": "kubeless"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: oldSelector,
+		},
+	})
+	f1 := getDefaultFunc(f1Name, ns)
+	f1.ObjectMeta.Labels = map[string]string{"function": f1Name, "created-by": "kubeless"}
+	err := EnsureFuncDeployment(clientset, f1, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var patch ktesting.PatchAction
+	for _, a := range clientset.Actions() {
+		if p, ok := a.(ktesting.PatchAction); ok && a.GetResource().Group == "apps" {
+			patch = p
+		}
+	}
+	if patch == nil {
+		t.Fatal("Expecting the apps/v1 deployment to be patched")
+	}
+	patched := appsv1.Deployment{}
+	if err := json.Unmarshal(patch.GetPatch(), &patched); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(patched.Spec.Selector, oldSelector) {
+		t.Errorf("Expecting selector %v, received %v", oldSelector, patched.Spec.Selector)
+	}
+}
+
 func TestDeploymentWithUnsupportedRuntime(t *testing.T) {
 	funcName := "func"
 	clientset, or, ns, lr := prepareDeploymentTest(funcName)
@@ -685,7 +836,7 @@ func TestDeploymentWithUnsupportedRuntime(t *testing.T) {
 	f7 := getDefaultFunc("func7", ns)
 	f7.Spec.Deps = "deps"
 	f7.Spec.Runtime = "cobol"
-	err := EnsureFuncDeployment(clientset, f7, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f7, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 
 	if err == nil {
 		t.Fatal("An error should be thrown")
@@ -698,11 +849,11 @@ func TestDeploymentWithTimeout(t *testing.T) {
 	// If a timeout is specified it should set an environment variable FUNC_TIMEOUT
 	f8 := getDefaultFunc(funcName, ns)
 	f8.Spec.Timeout = "10"
-	err := EnsureFuncDeployment(clientset, f8, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f8, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -711,16 +862,198 @@ func TestDeploymentWithTimeout(t *testing.T) {
 	}
 }
 
+func TestDeploymentWithFailurePolicy(t *testing.T) {
+	funcName := "func"
+	clientset, or, ns, lr := prepareDeploymentTest(funcName)
+	f := getDefaultFunc(funcName, ns)
+	f.Spec.RetryPolicy = &kubelessApi.FunctionRetryPolicy{MaxAttempts: 3, Backoff: "2s"}
+	f.Spec.OnFailure = &kubelessApi.FunctionDestination{Type: kubelessApi.DestinationFunction, Name: "handle-errors"}
+	err := EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	env := dpm.Spec.Template.Spec.Containers[0].Env
+	if getEnvValueFromList("FUNC_RETRY_MAX_ATTEMPTS", env) != "3" || getEnvValueFromList("FUNC_RETRY_BACKOFF", env) != "2s" {
+		t.Errorf("Unexpected retry policy in %v", env)
+	}
+	expected := `{"type":"function","name":"handle-errors","url":"http://handle-errors.` + ns + `.svc.cluster.local:8080"}`
+	if getEnvValueFromList("FUNC_ON_FAILURE", env) != expected {
+		t.Errorf("Expecting destination %s, received %s", expected, getEnvValueFromList("FUNC_ON_FAILURE", env))
+	}
+	if getEnvValueFromList("FUNC_NAME", env) != funcName {
+		t.Errorf("Expecting the function name in the environment")
+	}
+
+	// Invalid destinations are rejected
+	f.Spec.OnFailure = &kubelessApi.FunctionDestination{Type: kubelessApi.DestinationKafka, Name: "errors"}
+	err = EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
+	if err == nil {
+		t.Error("Expecting an error for a kafka destination without URL")
+	}
+}
+
+func TestFunctionWithProtocol(t *testing.T) {
+	funcName := "func"
+	clientset, or, ns, lr := prepareDeploymentTest(funcName)
+	f := getDefaultFunc(funcName, ns)
+	f.Spec.Protocol = kubelessApi.ProtocolGRPC
+	f.Spec.ServiceSpec.Ports = []v1.ServicePort{{Name: "http-function-port", Port: 8080}}
+	if err := EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if protocol := getEnvValueFromList("FUNC_PROTOCOL", dpm.Spec.Template.Spec.Containers[0].Env); protocol != "grpc" {
+		t.Errorf("Expecting the grpc protocol in the environment, got %q", protocol)
+	}
+	if err := EnsureFuncService(clientset, f, or); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	svc, err := clientset.CoreV1().Services(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if svc.Spec.Ports[0].Name != "grpc-function-port" {
+		t.Errorf("Expecting the port to be named after the protocol, got %s", svc.Spec.Ports[0].Name)
+	}
+	if f.Spec.ServiceSpec.Ports[0].Name != "http-function-port" {
+		t.Error("The service spec of the function should not be modified")
+	}
+
+	if err := ValidateFunctionProtocol("smtp"); err == nil {
+		t.Error("Expecting an error for an unknown protocol")
+	}
+}
+
+func TestDeploymentWithContainerConcurrency(t *testing.T) {
+	funcName := "func"
+	clientset, or, ns, lr := prepareDeploymentTest(funcName)
+	f := getDefaultFunc(funcName, ns)
+	f.Spec.ContainerConcurrency = 2
+	if err := EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if c := getEnvValueFromList("FUNC_CONTAINER_CONCURRENCY", dpm.Spec.Template.Spec.Containers[0].Env); c != "2" {
+		t.Errorf("Expecting the container concurrency in the environment, got %q", c)
+	}
+}
+
+func TestDeploymentWithPayload(t *testing.T) {
+	funcName := "func"
+	clientset, or, ns, lr := prepareDeploymentTest(funcName)
+	f := getDefaultFunc(funcName, ns)
+	maxRequestSize := resource.MustParse("1Mi")
+	f.Spec.Payload = &kubelessApi.FunctionPayload{
+		MaxRequestSize: &maxRequestSize,
+		RequestSchema:  &runtime.RawExtension{Raw: []byte(`{"type":"object"}`)},
+	}
+	if err := EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	env := dpm.Spec.Template.Spec.Containers[0].Env
+	if s := getEnvValueFromList("FUNC_MAX_REQUEST_SIZE", env); s != "1048576" {
+		t.Errorf("Expecting the maximum request size in bytes, got %q", s)
+	}
+	if s := getEnvValueFromList("FUNC_REQUEST_SCHEMA", env); s != `{"type":"object"}` {
+		t.Errorf("Expecting the request schema in the environment, got %q", s)
+	}
+	for _, e := range env {
+		if e.Name == "FUNC_MAX_RESPONSE_SIZE" {
+			t.Error("Unexpected maximum response size")
+		}
+	}
+
+	f.Spec.Payload.RequestSchema = &runtime.RawExtension{Raw: []byte(`{"required": "name"}`)}
+	if err := EnsureFuncDeployment(clientset, f, or, lr, "", "unzip", "", []v1.LocalObjectReference{}); err == nil {
+		t.Error("Expecting an error for an invalid request schema")
+	}
+}
+
+func TestDeploymentWithQueueVolume(t *testing.T) {
+	funcName := "func"
+	clientset, or, ns, lr := prepareDeploymentTest(funcName)
+	f := getDefaultFunc(funcName, ns)
+	// The controller labels the function with its name
+	f.ObjectMeta.Labels = map[string]string{"function": funcName}
+	storageClass := "nfs"
+	f.Spec.AsyncQueue = &kubelessApi.FunctionQueue{Storage: kubelessApi.QueueStorageVolume, StorageClassName: &storageClass}
+	if err := EnsureFuncQueueClaim(clientset, f, or); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(ns).Get("func-invocations", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expecting a claim for the invocations: %s", err)
+	}
+	if pvc.Spec.AccessModes[0] != v1.ReadWriteMany || *pvc.Spec.StorageClassName != "nfs" || pvc.Spec.Resources.Requests[v1.ResourceStorage] != resource.MustParse("1Gi") {
+		t.Errorf("Unexpected claim %v", pvc.Spec)
+	}
+	if len(pvc.OwnerReferences) != 1 {
+		t.Errorf("The claim should be owned by the function")
+	}
+	// The claim is not modified once created
+	if err := EnsureFuncQueueClaim(clientset, f, or); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The canary stores its invocations in the same volume
+	replicas := int32(2)
+	f.Spec.Deployment.Spec.Replicas = &replicas
+	f.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 50, Spec: *f.Spec.DeepCopy()}
+	canary := CanaryFunction(f)
+	for _, obj := range []*kubelessApi.Function{f, canary} {
+		if err := EnsureFuncDeployment(clientset, obj, or, lr, "", "unzip", "", []v1.LocalObjectReference{}); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		dpm, err := clientset.AppsV1().Deployments(ns).Get(obj.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		podSpec := dpm.Spec.Template.Spec
+		found := false
+		for _, v := range podSpec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == "func-invocations" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expecting the invocations volume in %s, received %v", obj.ObjectMeta.Name, podSpec.Volumes)
+		}
+		env := podSpec.Containers[0].Env
+		if getEnvValueFromList("FUNC_QUEUE", env) != "file" || getEnvValueFromList("FUNC_QUEUE_DIR", env) != QueueMountPath || getEnvValueFromList("FUNC_SERVICE", env) != funcName {
+			t.Errorf("Unexpected environment %v", env)
+		}
+	}
+
+	f.Spec.AsyncQueue.Storage = "redis"
+	if err := EnsureFuncQueueClaim(clientset, f, or); err == nil {
+		t.Error("Expecting an error for an unknown storage")
+	}
+}
+
 func TestDeploymentWithPrebuiltImage(t *testing.T) {
 	funcName := "func"
 	clientset, or, ns, lr := prepareDeploymentTest(funcName)
 	// If a prebuilt image is specified it should not build the function using init containers
 	f9 := getDefaultFunc(funcName, ns)
-	err := EnsureFuncDeployment(clientset, f9, or, lr, "user/image:test", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f9, or, lr, "user/image:test", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -749,11 +1082,11 @@ func TestDeploymentWithVolumes(t *testing.T) {
 			MountPath: "/tmp/test",
 		},
 	}
-	err := EnsureFuncDeployment(clientset, f10, or, lr, "", "unzip", []v1.LocalObjectReference{})
+	err := EnsureFuncDeployment(clientset, f10, or, lr, "", "unzip", "", []v1.LocalObjectReference{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	dpm, err := clientset.ExtensionsV1beta1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	dpm, err := clientset.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -782,7 +1115,7 @@ func TestGetProvisionContainer(t *testing.T) {
 
 	rvol := v1.VolumeMount{Name: "runtime", MountPath: "/runtime"}
 	dvol := v1.VolumeMount{Name: "deps", MountPath: "/deps"}
-	c, err := getProvisionContainer("test", "sha256:abc1234", "test.func", "test.foo", "text", "python2.7", "unzip", rvol, dvol, lr)
+	c, err := getProvisionContainer("test", "sha256:abc1234", "test.func", "test.foo", "text", "python2.7", "unzip", "", rvol, dvol, lr)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -799,7 +1132,7 @@ func TestGetProvisionContainer(t *testing.T) {
 	}
 
 	// If the content type is encoded it should decode it
-	c, err = getProvisionContainer("Zm9vYmFyCg==", "sha256:abc1234", "test.func", "test.foo", "base64", "python2.7", "unzip", rvol, dvol, lr)
+	c, err = getProvisionContainer("Zm9vYmFyCg==", "sha256:abc1234", "test.func", "test.foo", "base64", "python2.7", "unzip", "", rvol, dvol, lr)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -817,7 +1150,7 @@ func TestGetProvisionContainer(t *testing.T) {
 	}
 
 	// It should skip the dependencies installation if the runtime is not supported
-	c, err = getProvisionContainer("function", "sha256:abc1234", "test.func", "test.foo", "text", "cobol", "unzip", rvol, dvol, lr)
+	c, err = getProvisionContainer("function", "sha256:abc1234", "test.func", "test.foo", "text", "cobol", "unzip", "", rvol, dvol, lr)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -826,13 +1159,13 @@ func TestGetProvisionContainer(t *testing.T) {
 	}
 
 	// It should extract the file in case it is a Zip
-	c, err = getProvisionContainer("Zm9vYmFyCg==", "sha256:abc1234", "test.zip", "test.foo", "base64+zip", "python2.7", "unzip", rvol, dvol, lr)
+	c, err = getProvisionContainer("Zm9vYmFyCg==", "sha256:abc1234", "test.zip", "test.foo", "base64+zip", "python2.7", "unzip", "", rvol, dvol, lr)
 	if !strings.Contains(c.Args[0], "unzip -o /tmp/func.decoded -d /runtime") {
 		t.Errorf("Unexpected command: %s", c.Args[0])
 	}
 
 	// If the content type is url it should use curl
-	c, err = getProvisionContainer("https://raw.githubusercontent.com/test/test/test/test.py", "sha256:abc1234", "", "test.foo", "url", "python2.7", "unzip", rvol, dvol, lr)
+	c, err = getProvisionContainer("https://raw.githubusercontent.com/test/test/test/test.py", "sha256:abc1234", "", "test.foo", "url", "python2.7", "unzip", "", rvol, dvol, lr)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -841,11 +1174,184 @@ func TestGetProvisionContainer(t *testing.T) {
 	}
 
 	// If the content type is url it should use curl
-	c, err = getProvisionContainer("https://raw.githubusercontent.com/test/test/test/test.py", "sha256:abc1234", "", "test.foo", "url+zip", "python2.7", "unzip", rvol, dvol, lr)
+	c, err = getProvisionContainer("https://raw.githubusercontent.com/test/test/test/test.py", "sha256:abc1234", "", "test.foo", "url+zip", "python2.7", "unzip", "", rvol, dvol, lr)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
 	if !strings.HasPrefix(c.Args[0], "curl https://raw.githubusercontent.com/test/test/test/test.py -L --silent --output /tmp/func.fromurl") {
 		t.Errorf("Unexpected command: %s", c.Args[0])
 	}
+
+	// If the content type is artifact it should download it from the artifact server
+	c, err = getProvisionContainer("default/test/abc1234", "sha256:abc1234", "test.func", "test.foo", "artifact+zip", "python2.7", "unzip", "http://artifacts.kubeless:8080/", rvol, dvol, lr)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	expectedCommand := "curl -f http://artifacts.kubeless:8080/artifacts/default/test/abc1234 -H \"X-Kubeless-Token: $(cat /var/run/secrets/kubernetes.io/serviceaccount/token)\" -L --silent --show-error --output /tmp/func.fromartifact && " +
+		"echo 'abc1234  /tmp/func.fromartifact' > /tmp/func.sha256 && sha256sum -c /tmp/func.sha256 && unzip -o /tmp/func.fromartifact -d /runtime"
+	if !strings.HasPrefix(c.Args[0], expectedCommand) {
+		t.Errorf("Unexpected command: %s", c.Args[0])
+	}
+	_, err = getProvisionContainer("default/test/abc1234", "sha256:abc1234", "test.func", "test.foo", "artifact", "python2.7", "unzip", "", rvol, dvol, lr)
+	if err == nil {
+		t.Error("Expecting an error if the artifact server is not configured")
+	}
+}
+
+func TestSplitReplicas(t *testing.T) {
+	tests := []struct {
+		total, weight, stable, canary int32
+		err                           bool
+	}{
+		{total: 4, weight: 0, stable: 4, canary: 0},
+		{total: 4, weight: 25, stable: 3, canary: 1},
+		{total: 4, weight: 30, stable: 3, canary: 1},
+		{total: 4, weight: 50, stable: 2, canary: 2},
+		{total: 4, weight: 10, err: true},
+		{total: 4, weight: 95, err: true},
+		{total: 10, weight: 10, stable: 9, canary: 1},
+		{total: 20, weight: 1, stable: 19, canary: 1},
+		{total: 1, weight: 10, err: true},
+		{total: 1, weight: 50, err: true},
+		{total: 1, weight: 100, stable: 0, canary: 1},
+		{total: 0, weight: 50, stable: 0, canary: 0},
+		{total: 4, weight: 101, err: true},
+	}
+	for _, test := range tests {
+		stable, canary, err := SplitReplicas(test.total, test.weight)
+		if test.err {
+			if err == nil {
+				t.Errorf("Splitting %d replicas with weight %d: expecting an error, received %d/%d", test.total, test.weight, stable, canary)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Splitting %d replicas with weight %d: unexpected error %v", test.total, test.weight, err)
+		} else if stable != test.stable || canary != test.canary {
+			t.Errorf("Splitting %d replicas with weight %d: expecting %d/%d, received %d/%d", test.total, test.weight, test.stable, test.canary, stable, canary)
+		}
+	}
+}
+
+func TestCanaryFunction(t *testing.T) {
+	f := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "myns",
+			Labels:    map[string]string{"function": "foo"},
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Function: "old",
+			Canary: &kubelessApi.FunctionCanary{
+				Weight: 10,
+				Spec: kubelessApi.FunctionSpec{
+					Function: "new",
+				},
+			},
+		},
+	}
+	canary := CanaryFunction(f)
+	if canary.ObjectMeta.Name != "foo-canary" || canary.ObjectMeta.Namespace != "myns" {
+		t.Errorf("Unexpected canary %s/%s", canary.ObjectMeta.Namespace, canary.ObjectMeta.Name)
+	}
+	expectedLabels := map[string]string{"function": "foo", "track": "canary"}
+	if !reflect.DeepEqual(canary.ObjectMeta.Labels, expectedLabels) {
+		t.Errorf("Expecting labels %v, received %v", expectedLabels, canary.ObjectMeta.Labels)
+	}
+	if f.ObjectMeta.Labels["track"] != "" {
+		t.Error("The labels of the function should not be modified")
+	}
+	if canary.Spec.Function != "new" || canary.Spec.Canary != nil {
+		t.Errorf("Unexpected canary spec %v", canary.Spec)
+	}
+}
+
+func TestEnsureFuncRevision(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	or := []metav1.OwnerReference{{Kind: "Function", Name: "foo"}}
+	f := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "myns",
+		},
+	}
+	specs := []kubelessApi.FunctionSpec{
+		{Handler: "foo.bar", Function: "v1"},
+		{Handler: "foo.bar", Function: "v2"},
+		{Handler: "foo.bar", Function: "v2", Canary: &kubelessApi.FunctionCanary{Weight: 10}},
+		{Handler: "foo.bar", Function: "v1"},
+		{Handler: "foo.bar", Function: "v3"},
+	}
+	// A canary doesn't generate a new revision and going back to a previous
+	// spec reuses its revision
+	expectedRevisions := []int64{1, 2, 2, 3, 4}
+	for i := range specs {
+		rev, err := EnsureFuncRevision(clientset, f, &specs[i], or, 2)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rev != expectedRevisions[i] {
+			t.Errorf("Expecting revision %d, received %d", expectedRevisions[i], rev)
+		}
+	}
+
+	revisions, err := ListFuncRevisions(clientset, "myns", "foo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Expecting the revision history to be limited to 2, received %d", len(revisions))
+	}
+	if revisions[0].Revision != 3 || revisions[1].Revision != 4 {
+		t.Errorf("Expecting revisions 3 and 4, received %d and %d", revisions[0].Revision, revisions[1].Revision)
+	}
+	if !reflect.DeepEqual(revisions[1].OwnerReferences, or) || revisions[1].Labels["function"] != "foo" {
+		t.Errorf("Unexpected revision metadata %v", revisions[1].ObjectMeta)
+	}
+	spec, err := GetFuncRevisionSpec(&revisions[0])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.Function != "v1" {
+		t.Errorf("Expecting revision 3 to contain the first spec, received %v", spec)
+	}
+}
+
+func TestReferencedArtifacts(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	kubelessClient := kFake.NewSimpleClientset(
+		&kubelessApi.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"},
+			Spec: kubelessApi.FunctionSpec{
+				Function:            "myns/foo/v3",
+				FunctionContentType: "artifact+zip",
+				Canary: &kubelessApi.FunctionCanary{
+					Weight: 10,
+					Spec:   kubelessApi.FunctionSpec{Function: "myns/foo/v4", FunctionContentType: "artifact"},
+				},
+			},
+		},
+		&kubelessApi.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "default"},
+			Spec:       kubelessApi.FunctionSpec{Function: "code", FunctionContentType: "text"},
+		},
+	)
+	f := &kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "myns"}}
+	for _, spec := range []kubelessApi.FunctionSpec{
+		{Function: "myns/foo/v1", FunctionContentType: "artifact+zip"},
+		{Function: "inline", FunctionContentType: "base64"},
+	} {
+		if _, err := EnsureFuncRevision(clientset, f, &spec, nil, 10); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	referenced, err := ReferencedArtifacts(clientset, kubelessClient)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]bool{"myns/foo/v1": true, "myns/foo/v3": true, "myns/foo/v4": true}
+	if !reflect.DeepEqual(referenced, expected) {
+		t.Errorf("Expecting %v, received %v", expected, referenced)
+	}
 }
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+)
+
+// LocalFunctionPod describes the containers needed to run a function outside of a cluster
+type LocalFunctionPod struct {
+	// Files are the contents of the function ConfigMap, keyed by file name
+	Files map[string]string
+	// SourceVolume is the name of the volume in which Files should be available
+	SourceVolume string
+	// RuntimeVolume is the name of the volume shared between the init containers and the runtime
+	RuntimeVolume string
+	// InitContainers prepare, install and compile the function, in the order they should run
+	InitContainers []v1.Container
+	// Container serves the function, or pushes its image for build pods
+	Container v1.Container
+	// BuildVolume is the name of the volume that stores the state of a build. Empty unless the
+	// pod builds the image of the function
+	BuildVolume string
+	// RegistryVolume is the name of the volume with the docker configuration used to push the
+	// image of the function. Empty unless the pod builds the image of the function
+	RegistryVolume string
+}
+
+// GetLocalFunctionPod returns the same init containers and runtime container that the controller
+// would deploy for the given function, so they can be executed locally
+func GetLocalFunctionPod(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, provisionImage string) (*LocalFunctionPod, error) {
+	if funcObj.Spec.Handler == "" || funcObj.Spec.Function == "" {
+		return nil, fmt.Errorf("Expected non-empty handler and non-empty function content")
+	}
+	modName, handlerName, err := splitHandler(funcObj.Spec.Handler)
+	if err != nil {
+		return nil, err
+	}
+	files, err := funcConfigMapData(funcObj, lr)
+	if err != nil {
+		return nil, err
+	}
+
+	dpm := &appsv1.Deployment{}
+	dpm.Spec.Template.Spec = *funcObj.Spec.Deployment.Spec.Template.Spec.DeepCopy()
+	if len(dpm.Spec.Template.Spec.Containers) == 0 {
+		dpm.Spec.Template.Spec.Containers = []v1.Container{{}}
+	}
+	runtimeVolumeMount := getRuntimeVolumeMount(funcObj.ObjectMeta.Name)
+	container := &dpm.Spec.Template.Spec.Containers[0]
+	if container.Image == "" {
+		if err := populatePodSpec(funcObj, lr, &dpm.Spec.Template.Spec, runtimeVolumeMount, provisionImage, "", nil); err != nil {
+			return nil, err
+		}
+		container = &dpm.Spec.Template.Spec.Containers[0]
+		container.Image, err = lr.GetFunctionImage(funcObj.Spec.Runtime)
+		if err != nil {
+			return nil, err
+		}
+		container.VolumeMounts = append(container.VolumeMounts, runtimeVolumeMount)
+	}
+	container.Name = funcObj.ObjectMeta.Name
+	container.Env = append(container.Env, handlerEnv(funcObj, modName, handlerName, container.Resources)...)
+	container.Env = append(container.Env, v1.EnvVar{
+		Name:  "FUNC_PORT",
+		Value: strconv.Itoa(int(svcPort(funcObj))),
+	})
+	container.Env = append(container.Env, identityEnv(funcObj)...)
+	lr.UpdateDeployment(dpm, runtimeVolumeMount.MountPath, funcObj.Spec.Runtime)
+
+	return &LocalFunctionPod{
+		Files:          files,
+		SourceVolume:   funcObj.ObjectMeta.Name + "-deps",
+		RuntimeVolume:  runtimeVolumeMount.Name,
+		InitContainers: dpm.Spec.Template.Spec.InitContainers,
+		Container:      dpm.Spec.Template.Spec.Containers[0],
+	}, nil
+}
+
+// GetLocalBuildPod returns the same containers that the build Job of a function would run, so the
+// image of the function can be built outside of a cluster
+func GetLocalBuildPod(funcObj *kubelessApi.Function, lr *langruntime.Langruntimes, imageName, tag, builderImage, registryHost, provisionImage string, registryTLSEnabled bool) (*LocalFunctionPod, error) {
+	if funcObj.Spec.Handler == "" || funcObj.Spec.Function == "" {
+		return nil, fmt.Errorf("Expected non-empty handler and non-empty function content")
+	}
+	files, err := funcConfigMapData(funcObj, lr)
+	if err != nil {
+		return nil, err
+	}
+	job, err := NewFunctionBuildJob(funcObj.ObjectMeta.Name, funcObj, lr, nil, imageName, tag, builderImage, registryHost, "registry-credentials", provisionImage, "", registryTLSEnabled, nil)
+	if err != nil {
+		return nil, err
+	}
+	pod := &LocalFunctionPod{
+		Files:          files,
+		SourceVolume:   funcObj.ObjectMeta.Name + "-deps",
+		RuntimeVolume:  getRuntimeVolumeMount(funcObj.ObjectMeta.Name).Name,
+		InitContainers: job.Spec.Template.Spec.InitContainers,
+		Container:      job.Spec.Template.Spec.Containers[0],
+	}
+	for _, v := range job.Spec.Template.Spec.Volumes {
+		switch {
+		case v.Secret != nil:
+			pod.RegistryVolume = v.Name
+		case v.EmptyDir != nil && v.Name != pod.RuntimeVolume:
+			pod.BuildVolume = v.Name
+		}
+	}
+	return pod, nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetLocalFunctionPod(t *testing.T) {
+	_, _, ns, lr := prepareDeploymentTest("f1")
+	f := getDefaultFunc("f1", ns)
+	f.Spec.Timeout = "10"
+
+	pod, err := GetLocalFunctionPod(f, lr, "unzip")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pod.Files["foo.py"] != "function" || pod.Files["requirements.txt"] != "deps" || pod.Files["handler"] != "foo.bar" {
+		t.Errorf("Unexpected files %v", pod.Files)
+	}
+	names := []string{}
+	for _, c := range pod.InitContainers {
+		names = append(names, c.Name)
+	}
+	if len(names) != 2 || names[0] != "prepare" || names[1] != "install" {
+		t.Errorf("Unexpected init containers %v", names)
+	}
+	if pod.InitContainers[0].VolumeMounts[1].Name != pod.SourceVolume || pod.InitContainers[0].VolumeMounts[0].Name != pod.RuntimeVolume {
+		t.Errorf("Unexpected volumes %v", pod.InitContainers[0].VolumeMounts)
+	}
+	c := pod.Container
+	if c.Image == "" {
+		t.Error("The runtime image should be set")
+	}
+	for env, value := range map[string]string{
+		"foo":                     "bar",
+		"FUNC_HANDLER":            "bar",
+		"MOD_NAME":                "foo",
+		"FUNC_TIMEOUT":            "10",
+		"FUNC_PORT":               "8080",
+		"FUNC_NAME":               f.ObjectMeta.Name,
+		"KUBELESS_INSTALL_VOLUME": "/kubeless",
+	} {
+		if v := getEnvValueFromList(env, c.Env); v != value {
+			t.Errorf("Expecting %s=%s, received %q", env, value, v)
+		}
+	}
+
+	f.Spec.Handler = ""
+	if _, err := GetLocalFunctionPod(f, lr, "unzip"); err == nil {
+		t.Error("Expecting an error for a function without handler")
+	}
+}
+
+func TestGetLocalBuildPod(t *testing.T) {
+	_, _, ns, lr := prepareDeploymentTest("f1")
+	f := getDefaultFunc("f1", ns)
+
+	pod, err := GetLocalBuildPod(f, lr, "user/f1", "v1", "kubeless/builder", "localhost:5000", "unzip", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pod.Files["foo.py"] != "function" || pod.Files["requirements.txt"] != "deps" {
+		t.Errorf("Unexpected files %v", pod.Files)
+	}
+	names := []string{}
+	for _, c := range pod.InitContainers {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "prepare,restore-deps,install,save-deps,bundle" {
+		t.Errorf("Unexpected init containers %v", names)
+	}
+	if pod.BuildVolume == "" || pod.RegistryVolume == "" || pod.BuildVolume == pod.RuntimeVolume {
+		t.Errorf("Unexpected volumes %s, %s", pod.BuildVolume, pod.RegistryVolume)
+	}
+	args := strings.Join(pod.Container.Args, " ")
+	if pod.Container.Image != "kubeless/builder" || !strings.Contains(args, "--insecure") || !strings.Contains(args, "--dst docker://localhost:5000/user/f1:v1") {
+		t.Errorf("Unexpected build container %s %s", pod.Container.Image, args)
+	}
+}
@@ -18,7 +18,12 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
 
+	"k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/prometheus/common/expfmt"
@@ -138,3 +143,34 @@ func GetFunctionMetrics(apiV1Client kubernetes.Interface, h MetricsRetriever, na
 	}
 	return metrics
 }
+
+var podMetricsClient = &http.Client{Timeout: 5 * time.Second}
+
+// GetPodCalls returns the number of requests served by a function pod reading its metrics
+func GetPodCalls(pod v1.Pod) (float64, error) {
+	port := int32(8080)
+	if len(pod.Spec.Containers) > 0 && len(pod.Spec.Containers[0].Ports) > 0 {
+		port = pod.Spec.Containers[0].Ports[0].ContainerPort
+	}
+	resp, err := podMetricsClient.Get(fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, port))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Function does not expose metrics (%s)", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	metrics, err := parseMetrics(pod.ObjectMeta.Namespace, "", body)
+	if err != nil {
+		return 0, err
+	}
+	calls := float64(0)
+	for _, m := range metrics {
+		calls += m.TotalCalls
+	}
+	return calls, nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// IdleLabel is set in the service of a function that has been scaled to zero
+	IdleLabel = "kubeless.io/idle"
+	// ActivatorPortAnnotation is the port of the activator that receives the requests of an idle function
+	ActivatorPortAnnotation = "kubeless.io/activator-port"
+	// IdleReplicasAnnotation stores the replicas that a function had before being scaled to zero
+	IdleReplicasAnnotation = "kubeless.io/idle-replicas"
+	// IdleSelectorAnnotation stores the selector of the function service while it points to the activator
+	IdleSelectorAnnotation = "kubeless.io/idle-selector"
+	// ActivatorPortBase is the first port used by the activator to receive requests of idle functions
+	ActivatorPortBase = 10000
+)
+
+// IsFuncServiceIdle returns true if the given function service points to the activator
+func IsFuncServiceIdle(svc *v1.Service) bool {
+	return svc.ObjectMeta.Labels[IdleLabel] == "true"
+}
+
+// IsPodReady returns true if the given pod is ready to receive requests
+func IsPodReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// GetActivatorPort returns the activator port assigned to an idle function service
+func GetActivatorPort(svc *v1.Service) (int32, error) {
+	port, err := strconv.Atoi(svc.ObjectMeta.Annotations[ActivatorPortAnnotation])
+	if err != nil {
+		return 0, fmt.Errorf("Service %s/%s has a wrong activator port: %v", svc.ObjectMeta.Namespace, svc.ObjectMeta.Name, err)
+	}
+	return int32(port), nil
+}
+
+// ListIdleFuncServices returns the services of all the functions that have been scaled to zero
+func ListIdleFuncServices(client kubernetes.Interface) ([]v1.Service, error) {
+	svcs, err := client.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: IdleLabel + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return svcs.Items, nil
+}
+
+// AllocateActivatorPort returns the first activator port not used by any idle function
+func AllocateActivatorPort(client kubernetes.Interface) (int32, error) {
+	svcs, err := ListIdleFuncServices(client)
+	if err != nil {
+		return 0, err
+	}
+	used := map[int32]bool{}
+	for i := range svcs {
+		port, err := GetActivatorPort(&svcs[i])
+		if err == nil {
+			used[port] = true
+		}
+	}
+	port := int32(ActivatorPortBase)
+	for used[port] {
+		port++
+	}
+	return port, nil
+}
+
+// ScaleFuncToZero routes the service of a function to the activator (listening in the given addresses
+// and port) and then scales the function deployment to zero. The previous replicas and selector
+// are stored in the service so they can be restored when the function is activated
+func ScaleFuncToZero(client kubernetes.Interface, ns, funcName string, activatorIPs []string, port int32) error {
+	dpm, err := client.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	replicas := int32(1)
+	if dpm.Spec.Replicas != nil && *dpm.Spec.Replicas > 0 {
+		replicas = *dpm.Spec.Replicas
+	}
+
+	var svc *v1.Service
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		svc, err = client.CoreV1().Services(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if IsFuncServiceIdle(svc) {
+			// Keep the port already assigned
+			port, err = GetActivatorPort(svc)
+			return err
+		}
+		selector, err := json.Marshal(svc.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		svc.ObjectMeta.Labels = addDefaultLabel(svc.ObjectMeta.Labels)
+		svc.ObjectMeta.Labels[IdleLabel] = "true"
+		svc.ObjectMeta.Annotations = mergeMap(svc.ObjectMeta.Annotations, map[string]string{
+			ActivatorPortAnnotation: strconv.Itoa(int(port)),
+			IdleReplicasAnnotation:  strconv.Itoa(int(replicas)),
+			IdleSelectorAnnotation:  string(selector),
+		})
+		// Without selector the endpoints of the service are not managed by Kubernetes
+		svc.Spec.Selector = nil
+		svc, err = client.CoreV1().Services(ns).Update(svc)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	addresses := []v1.EndpointAddress{}
+	for _, ip := range activatorIPs {
+		addresses = append(addresses, v1.EndpointAddress{IP: ip})
+	}
+	ports := []v1.EndpointPort{}
+	for _, p := range svc.Spec.Ports {
+		ports = append(ports, v1.EndpointPort{Name: p.Name, Port: port, Protocol: v1.ProtocolTCP})
+	}
+	subsets := []v1.EndpointSubset{{Addresses: addresses, Ports: ports}}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		endpoints, err := client.CoreV1().Endpoints(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			if !k8sErrors.IsNotFound(err) {
+				return err
+			}
+			_, err = client.CoreV1().Endpoints(ns).Create(&v1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      funcName,
+					Namespace: ns,
+					Labels:    svc.ObjectMeta.Labels,
+				},
+				Subsets: subsets,
+			})
+			return err
+		}
+		endpoints.Subsets = subsets
+		_, err = client.CoreV1().Endpoints(ns).Update(endpoints)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dpm, err := client.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		zero := int32(0)
+		dpm.Spec.Replicas = &zero
+		_, err = client.AppsV1().Deployments(ns).Update(dpm)
+		return err
+	})
+}
+
+// ScaleFuncFromZero scales the deployment of an idle function to the replicas it had before being scaled to zero
+func ScaleFuncFromZero(client kubernetes.Interface, ns, funcName string) error {
+	svc, err := client.CoreV1().Services(ns).Get(funcName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	replicas, err := strconv.Atoi(svc.ObjectMeta.Annotations[IdleReplicasAnnotation])
+	if err != nil || replicas <= 0 {
+		replicas = 1
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dpm, err := client.AppsV1().Deployments(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if dpm.Spec.Replicas != nil && *dpm.Spec.Replicas > 0 {
+			return nil
+		}
+		r := int32(replicas)
+		dpm.Spec.Replicas = &r
+		_, err = client.AppsV1().Deployments(ns).Update(dpm)
+		return err
+	})
+}
+
+// RestoreFuncService routes the service of an idle function back to the function pods
+func RestoreFuncService(client kubernetes.Interface, ns, funcName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		svc, err := client.CoreV1().Services(ns).Get(funcName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !IsFuncServiceIdle(svc) {
+			return nil
+		}
+		selector := map[string]string{}
+		err = json.Unmarshal([]byte(svc.ObjectMeta.Annotations[IdleSelectorAnnotation]), &selector)
+		if err != nil {
+			return fmt.Errorf("Unable to restore the selector of the service %s/%s: %v", ns, funcName, err)
+		}
+		// Once the selector is set the endpoints are managed by Kubernetes again
+		svc.Spec.Selector = selector
+		delete(svc.ObjectMeta.Labels, IdleLabel)
+		delete(svc.ObjectMeta.Annotations, ActivatorPortAnnotation)
+		delete(svc.ObjectMeta.Annotations, IdleReplicasAnnotation)
+		delete(svc.ObjectMeta.Annotations, IdleSelectorAnnotation)
+		_, err = client.CoreV1().Services(ns).Update(svc)
+		return err
+	})
+}
+
+// WakeUpFunc scales up an idle function and routes its service back to the function pods
+func WakeUpFunc(client kubernetes.Interface, ns, funcName string) error {
+	err := ScaleFuncFromZero(client, ns, funcName)
+	if err != nil {
+		return err
+	}
+	return RestoreFuncService(client, ns, funcName)
+}
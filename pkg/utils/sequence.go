@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/client/clientset/versioned"
+)
+
+const (
+	// SequencePort is the port in which the sequences are served
+	SequencePort = 8080
+)
+
+// CreateSequenceCustomResource creates a sequence object
+func CreateSequenceCustomResource(kubelessClient versioned.Interface, s *kubelessApi.Sequence) error {
+	_, err := kubelessClient.KubelessV1beta1().Sequences(s.Namespace).Create(s)
+	return err
+}
+
+// UpdateSequenceStatus stores the given status in a sequence object. It retries in case of
+// conflicts and falls back to a regular update if the API server doesn't serve the status subresource
+func UpdateSequenceStatus(kubelessClient versioned.Interface, ns, name string, status *kubelessApi.SequenceStatus) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		s, err := kubelessClient.KubelessV1beta1().Sequences(ns).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		s.Status = *status.DeepCopy()
+		_, err = kubelessClient.KubelessV1beta1().Sequences(ns).UpdateStatus(s)
+		if err != nil && k8sErrors.IsNotFound(err) {
+			_, err = kubelessClient.KubelessV1beta1().Sequences(ns).Update(s)
+		}
+		return err
+	})
+}
+
+// SequenceFunctionURLs returns the URL of the service of each function
+func SequenceFunctionURLs(client kubernetes.Interface, ns string, functions []string) (map[string]string, error) {
+	urls := map[string]string{}
+	for _, f := range functions {
+		svc, err := client.CoreV1().Services(ns).Get(f, metav1.GetOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				return nil, fmt.Errorf("Function %s not found", f)
+			}
+			return nil, err
+		}
+		port := int32(8080)
+		if len(svc.Spec.Ports) > 0 {
+			port = svc.Spec.Ports[0].Port
+		}
+		urls[f] = fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", f, ns, port)
+	}
+	return urls, nil
+}
+
+func sequenceLabels(s *kubelessApi.Sequence) map[string]string {
+	labels := map[string]string{}
+	for k, v := range s.ObjectMeta.Labels {
+		labels[k] = v
+	}
+	labels["sequence"] = s.ObjectMeta.Name
+	return addDefaultLabel(labels)
+}
+
+// EnsureSequenceService creates/updates the service that exposes a sequence
+func EnsureSequenceService(client kubernetes.Interface, s *kubelessApi.Sequence, or []metav1.OwnerReference) error {
+	labels := sequenceLabels(s)
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.ObjectMeta.Name,
+			Labels:          labels,
+			OwnerReferences: or,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name:       "http-sequence-port",
+					Protocol:   v1.ProtocolTCP,
+					Port:       SequencePort,
+					TargetPort: intstr.FromInt(SequencePort),
+				},
+			},
+			Selector: map[string]string{"sequence": s.ObjectMeta.Name},
+			Type:     v1.ServiceTypeClusterIP,
+		},
+	}
+	_, err := client.CoreV1().Services(s.ObjectMeta.Namespace).Create(svc)
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		newSvc, err := client.CoreV1().Services(s.ObjectMeta.Namespace).Get(s.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !hasDefaultLabel(newSvc.ObjectMeta.Labels) || newSvc.ObjectMeta.Labels["sequence"] != s.ObjectMeta.Name {
+			return fmt.Errorf("Found a conflicting service object %s/%s. Aborting", s.ObjectMeta.Namespace, s.ObjectMeta.Name)
+		}
+		newSvc.ObjectMeta.Labels = labels
+		newSvc.ObjectMeta.OwnerReferences = or
+		newSvc.Spec.Ports = svc.Spec.Ports
+		newSvc.Spec.Selector = svc.Spec.Selector
+		_, err = client.CoreV1().Services(s.ObjectMeta.Namespace).Update(newSvc)
+		return err
+	}
+	return err
+}
+
+// EnsureSequenceDeployment creates/updates the Deployment that runs a sequence. The
+// steps and the URLs of the functions are passed to the runner as environment variables
+func EnsureSequenceDeployment(client kubernetes.Interface, s *kubelessApi.Sequence, or []metav1.OwnerReference, image string, functions map[string]string, imagePullSecrets []v1.LocalObjectReference, env []v1.EnvVar) error {
+	spec, err := json.Marshal(s.Spec)
+	if err != nil {
+		return err
+	}
+	urls, err := json.Marshal(functions)
+	if err != nil {
+		return err
+	}
+	labels := sequenceLabels(s)
+	replicas := int32(1)
+	dpm := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.ObjectMeta.Name,
+			Labels:          labels,
+			OwnerReferences: or,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"sequence": s.ObjectMeta.Name}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ImagePullSecrets: imagePullSecrets,
+					Containers: []v1.Container{
+						{
+							Name:            "sequence",
+							Image:           image,
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Args:            []string{fmt.Sprintf("--port=%d", SequencePort)},
+							Env: append([]v1.EnvVar{
+								{Name: "SEQUENCE_SPEC", Value: string(spec)},
+								{Name: "SEQUENCE_FUNCTIONS", Value: string(urls)},
+							}, env...),
+							Ports: []v1.ContainerPort{{ContainerPort: SequencePort}},
+							ReadinessProbe: &v1.Probe{
+								Handler: v1.Handler{
+									HTTPGet: &v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(SequencePort)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	_, err = client.AppsV1().Deployments(s.ObjectMeta.Namespace).Create(dpm)
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		newDpm, err := client.AppsV1().Deployments(s.ObjectMeta.Namespace).Get(s.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !hasDefaultLabel(newDpm.ObjectMeta.Labels) || newDpm.ObjectMeta.Labels["sequence"] != s.ObjectMeta.Name {
+			return fmt.Errorf("Found a conflicting deployment object %s/%s. Aborting", s.ObjectMeta.Namespace, s.ObjectMeta.Name)
+		}
+		newDpm.ObjectMeta.Labels = labels
+		newDpm.ObjectMeta.OwnerReferences = or
+		// Keep the replicas in case the sequence has been scaled
+		newDpm.Spec.Template = dpm.Spec.Template
+		data, err := json.Marshal(newDpm)
+		if err != nil {
+			return err
+		}
+		_, err = client.AppsV1().Deployments(s.ObjectMeta.Namespace).Patch(newDpm.Name, types.MergePatchType, data)
+		return err
+	}
+	return err
+}
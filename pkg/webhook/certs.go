@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+)
+
+// CertSecretName is the secret storing the certificate of the webhook server
+const CertSecretName = "kubeless-webhook-certs"
+
+// Certificates are renewed when they are about to expire
+const certRenewalPeriod = 30 * 24 * time.Hour
+
+// EnsureCertificate returns the certificate that the webhook server should use for the given
+// service. The certificate is self-signed and stored in a secret so it is shared between
+// restarts of the controller. It also returns the CA bundle that the API server should trust.
+func EnsureCertificate(client kubernetes.Interface, namespace, service string) (tls.Certificate, []byte, error) {
+	existing, err := client.CoreV1().Secrets(namespace).Get(CertSecretName, metav1.GetOptions{})
+	if err == nil {
+		crt, err := parseCertificate(existing.Data[v1.TLSCertKey], existing.Data[v1.TLSPrivateKeyKey], time.Now().Add(certRenewalPeriod))
+		if err == nil {
+			return crt, existing.Data[v1.TLSCertKey], nil
+		}
+		// The certificate is invalid or about to expire, it is replaced below
+	} else if k8sErrors.IsNotFound(err) {
+		existing = nil
+	} else {
+		return tls.Certificate{}, nil, err
+	}
+
+	host := fmt.Sprintf("%s.%s.svc", service, namespace)
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(host, nil, []string{service, fmt.Sprintf("%s.%s", service, namespace)})
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("Unable to generate the webhook certificate: %v", err)
+	}
+	data := map[string][]byte{
+		v1.TLSCertKey:       certPEM,
+		v1.TLSPrivateKeyKey: keyPEM,
+	}
+	if existing != nil {
+		existing.Data = data
+		_, err = client.CoreV1().Secrets(namespace).Update(existing)
+	} else {
+		_, err = client.CoreV1().Secrets(namespace).Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   CertSecretName,
+				Labels: map[string]string{"created-by": "kubeless"},
+			},
+			Type: v1.SecretTypeTLS,
+			Data: data,
+		})
+	}
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("Unable to store the webhook certificate: %v", err)
+	}
+	crt, err := tls.X509KeyPair(certPEM, keyPEM)
+	return crt, certPEM, err
+}
+
+// RenewCertificate checks the certificate of the webhook server every period until stopCh is
+// closed. When the stored certificate is replaced (because it is about to expire), renewed is
+// called with the new certificate and CA bundle.
+func RenewCertificate(client kubernetes.Interface, namespace, service string, caBundle []byte, period time.Duration, stopCh <-chan struct{}, renewed func(tls.Certificate, []byte)) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		crt, newCABundle, err := EnsureCertificate(client, namespace, service)
+		if err != nil {
+			logrus.Errorf("Unable to renew the webhook certificate: %v", err)
+			continue
+		}
+		if !bytes.Equal(newCABundle, caBundle) {
+			caBundle = newCABundle
+			renewed(crt, caBundle)
+		}
+	}
+}
+
+// parseCertificate loads a key pair checking that it is still valid at the given time
+func parseCertificate(certPEM, keyPEM []byte, validAt time.Time) (tls.Certificate, error) {
+	crt, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return crt, err
+	}
+	leaf, err := x509.ParseCertificate(crt.Certificate[0])
+	if err != nil {
+		return crt, err
+	}
+	if validAt.After(leaf.NotAfter) {
+		return crt, fmt.Errorf("The certificate expires at %s", leaf.NotAfter)
+	}
+	return crt, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	clientsetAPIExtensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	kubelessv1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1"
+	kubelessv1beta1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+)
+
+// ConvertPath is the path of the conversion webhook of the Function CRD
+const ConvertPath = "/convert-function"
+
+// The following types mirror the ConversionReview of the apiextensions.k8s.io/v1beta1 API
+
+// ConversionReview describes a conversion request/response
+type ConversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *ConversionRequest  `json:"request,omitempty"`
+	Response        *ConversionResponse `json:"response,omitempty"`
+}
+
+// ConversionRequest contains the objects to convert
+type ConversionRequest struct {
+	UID               types.UID              `json:"uid"`
+	DesiredAPIVersion string                 `json:"desiredAPIVersion"`
+	Objects           []runtime.RawExtension `json:"objects"`
+}
+
+// ConversionResponse contains the converted objects
+type ConversionResponse struct {
+	UID              types.UID              `json:"uid"`
+	ConvertedObjects []runtime.RawExtension `json:"convertedObjects"`
+	Result           metav1.Status          `json:"result"`
+}
+
+// ConvertFunction converts a serialized function to the given API version
+func ConvertFunction(raw []byte, apiVersion string) ([]byte, error) {
+	meta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	if meta.APIVersion == apiVersion {
+		return raw, nil
+	}
+	switch {
+	case meta.APIVersion == kubelessv1beta1.SchemeGroupVersion.String() && apiVersion == kubelessv1.SchemeGroupVersion.String():
+		f := &kubelessv1beta1.Function{}
+		if err := json.Unmarshal(raw, f); err != nil {
+			return nil, err
+		}
+		out, err := kubelessv1.ConvertFromV1beta1(f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(out)
+	case meta.APIVersion == kubelessv1.SchemeGroupVersion.String() && apiVersion == kubelessv1beta1.SchemeGroupVersion.String():
+		f := &kubelessv1.Function{}
+		if err := json.Unmarshal(raw, f); err != nil {
+			return nil, err
+		}
+		out, err := kubelessv1.ConvertToV1beta1(f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(out)
+	}
+	return nil, fmt.Errorf("Unable to convert a function from %s to %s", meta.APIVersion, apiVersion)
+}
+
+func (s *Server) convert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := ConversionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("Unable to parse the conversion review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	res := &ConversionResponse{
+		UID:    review.Request.UID,
+		Result: metav1.Status{Status: metav1.StatusSuccess},
+	}
+	for _, obj := range review.Request.Objects {
+		converted, err := ConvertFunction(obj.Raw, review.Request.DesiredAPIVersion)
+		if err != nil {
+			s.logger.Errorf("Unable to convert function: %v", err)
+			res.ConvertedObjects = nil
+			res.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+		res.ConvertedObjects = append(res.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+
+	out, err := json.Marshal(ConversionReview{TypeMeta: review.TypeMeta, Response: res})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// supportsConversion returns whether the cluster supports conversion webhooks (Kubernetes 1.15 or later)
+func supportsConversion(client clientsetAPIExtensions.Interface) (bool, error) {
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	major, err := strconv.Atoi(info.Major)
+	if err != nil {
+		return false, fmt.Errorf("Unable to parse the server version %q: %v", info.Major, err)
+	}
+	// Some providers add a suffix to the minor version (e.g. "15+")
+	minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if err != nil {
+		return false, fmt.Errorf("Unable to parse the server version %q: %v", info.Minor, err)
+	}
+	return major > 1 || (major == 1 && minor >= 15), nil
+}
+
+// RegisterConversion serves the v1 version of the Function CRD using the conversion webhook of the
+// given service. The manifests only include v1beta1 since conversion webhooks (and the structural
+// schema they require) are not available before Kubernetes 1.15, so the CRD is patched when the
+// cluster supports them. The vendored API doesn't include the conversion settings.
+func RegisterConversion(client clientsetAPIExtensions.Interface, namespace, service string, caBundle []byte) error {
+	supported, err := supportsConversion(client)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("Conversion webhooks require Kubernetes 1.15 or later, only the v1beta1 API is available")
+	}
+	preserveUnknownFields := map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"versions": []map[string]interface{}{
+				{"name": kubelessv1beta1.SchemeGroupVersion.Version, "served": true, "storage": true},
+				{"name": kubelessv1.SchemeGroupVersion.Version, "served": true, "storage": false},
+			},
+			"preserveUnknownFields": false,
+			"validation": map[string]interface{}{
+				"openAPIV3Schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"spec":   preserveUnknownFields,
+						"status": preserveUnknownFields,
+					},
+				},
+			},
+			"conversion": map[string]interface{}{
+				"strategy": "Webhook",
+				"webhookClientConfig": map[string]interface{}{
+					"service": map[string]interface{}{
+						"namespace": namespace,
+						"name":      service,
+						"path":      ConvertPath,
+					},
+					"caBundle": caBundle,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.ApiextensionsV1beta1().CustomResourceDefinitions().Patch("functions.kubeless.io", types.MergePatchType, patch)
+	return err
+}
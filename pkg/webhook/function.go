@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/jsonschema"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+	"github.com/kubeless/kubeless/pkg/utils"
+)
+
+const defaultFunctionPort = 8080
+
+var (
+	handlerRegexp  = regexp.MustCompile(`^[^.]+\.[^.]+$`)
+	checksumRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	contentTypes   = []string{"text", "base64", "url", "artifact"}
+)
+
+// ValidateFunction returns the errors of the fields of a function that the controller
+// would otherwise find while deploying it
+func ValidateFunction(f *kubelessApi.Function, lr *langruntime.Langruntimes) field.ErrorList {
+	specPath := field.NewPath("spec")
+	allErrs := validateFunctionSpec(&f.Spec, specPath, lr)
+	if f.Spec.Canary != nil {
+		canaryPath := specPath.Child("canary")
+		if f.Spec.Canary.Weight < 0 || f.Spec.Canary.Weight > 100 {
+			allErrs = append(allErrs, field.Invalid(canaryPath.Child("weight"), f.Spec.Canary.Weight, "should be a percentage between 0 and 100"))
+		} else if f.Spec.HorizontalPodAutoscaler.Name != "" {
+			allErrs = append(allErrs, field.Forbidden(canaryPath, "canary releases are not supported for functions with a HorizontalPodAutoscaler"))
+		} else if err := utils.ValidateCanary(&f.Spec); err != nil {
+			allErrs = append(allErrs, field.Invalid(canaryPath.Child("weight"), f.Spec.Canary.Weight, err.Error()))
+		}
+		allErrs = append(allErrs, validateFunctionSpec(&f.Spec.Canary.Spec, canaryPath.Child("spec"), lr)...)
+	}
+	return allErrs
+}
+
+func validateFunctionSpec(spec *kubelessApi.FunctionSpec, path *field.Path, lr *langruntime.Langruntimes) field.ErrorList {
+	allErrs := field.ErrorList{}
+	hasImage := len(spec.Deployment.Spec.Template.Spec.Containers) > 0 && spec.Deployment.Spec.Template.Spec.Containers[0].Image != ""
+
+	if spec.Runtime != "" && !lr.IsValidRuntime(spec.Runtime) {
+		allErrs = append(allErrs, field.NotSupported(path.Child("runtime"), spec.Runtime, lr.GetRuntimes()))
+	} else if spec.Runtime == "" && spec.Function != "" && !hasImage {
+		allErrs = append(allErrs, field.Required(path.Child("runtime"), "a runtime or a runtime image is required"))
+	}
+
+	if spec.Function != "" || spec.Handler != "" {
+		if !handlerRegexp.MatchString(spec.Handler) {
+			allErrs = append(allErrs, field.Invalid(path.Child("handler"), spec.Handler, "should have the format <module_name>.<function_name>"))
+		}
+	}
+
+	if spec.Timeout != "" {
+		if t, err := strconv.Atoi(spec.Timeout); err != nil || t <= 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("timeout"), spec.Timeout, "should be a positive number of seconds"))
+		}
+	}
+
+	if spec.FunctionContentType != "" {
+		contentType := strings.TrimSuffix(spec.FunctionContentType, "+zip")
+		valid := false
+		for _, t := range contentTypes {
+			if contentType == t {
+				valid = true
+			}
+		}
+		if !valid {
+			allErrs = append(allErrs, field.NotSupported(path.Child("function-content-type"), spec.FunctionContentType, contentTypes))
+		}
+	}
+
+	if spec.Checksum != "" && !checksumRegexp.MatchString(spec.Checksum) {
+		allErrs = append(allErrs, field.Invalid(path.Child("checksum"), spec.Checksum, "should have the format sha256:<hex digest>"))
+	}
+
+	for i, port := range spec.ServiceSpec.Ports {
+		if port.Port < 1 || port.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(path.Child("service", "ports").Index(i).Child("port"), port.Port, "should be between 1 and 65535"))
+		}
+	}
+
+	if hpa := spec.HorizontalPodAutoscaler; hpa.Name != "" && len(hpa.Spec.Metrics) == 0 {
+		allErrs = append(allErrs, field.Required(path.Child("horizontalPodAutoscaler", "spec", "metrics"), "the autoscaler needs a target metric"))
+	}
+
+	if spec.IdleTimeout != "" {
+		if _, err := time.ParseDuration(spec.IdleTimeout); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("idleTimeout"), spec.IdleTimeout, err.Error()))
+		}
+	}
+
+	if p := spec.RetryPolicy; p != nil {
+		retryPath := path.Child("retryPolicy")
+		if p.MaxAttempts < 0 {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("maxAttempts"), p.MaxAttempts, "should not be negative"))
+		}
+		if _, err := time.ParseDuration(p.Backoff); p.Backoff != "" && err != nil {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("backoff"), p.Backoff, err.Error()))
+		}
+		if _, err := time.ParseDuration(p.MaxBackoff); p.MaxBackoff != "" && err != nil {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("maxBackoff"), p.MaxBackoff, err.Error()))
+		}
+	}
+
+	if spec.Protocol != "" {
+		if err := utils.ValidateFunctionProtocol(spec.Protocol); err != nil {
+			allErrs = append(allErrs, field.NotSupported(path.Child("protocol"), spec.Protocol, utils.FunctionProtocols))
+		}
+	}
+
+	if spec.ContainerConcurrency < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("containerConcurrency"), spec.ContainerConcurrency, "should not be negative"))
+	}
+
+	if p := spec.Payload; p != nil {
+		payloadPath := path.Child("payload")
+		if p.MaxRequestSize != nil && p.MaxRequestSize.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(payloadPath.Child("maxRequestSize"), p.MaxRequestSize.String(), "should not be negative"))
+		}
+		if p.MaxResponseSize != nil && p.MaxResponseSize.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(payloadPath.Child("maxResponseSize"), p.MaxResponseSize.String(), "should not be negative"))
+		}
+		if p.RequestSchema != nil && len(p.RequestSchema.Raw) > 0 {
+			if _, err := jsonschema.Compile(p.RequestSchema.Raw); err != nil {
+				allErrs = append(allErrs, field.Invalid(payloadPath.Child("requestSchema"), string(p.RequestSchema.Raw), err.Error()))
+			}
+		}
+	}
+
+	if spec.AsyncQueue != nil {
+		if err := utils.ValidateFunctionQueue(spec.AsyncQueue); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("asyncQueue"), *spec.AsyncQueue, err.Error()))
+		}
+	}
+
+	if spec.OnFailure != nil {
+		if err := utils.ValidateFunctionDestination(spec.OnFailure); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("onFailure"), *spec.OnFailure, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// DefaultFunction returns the JSON patch that sets the default labels and service of a function
+func DefaultFunction(f *kubelessApi.Function) []patchOperation {
+	patch := []patchOperation{}
+
+	labels := map[string]string{}
+	for k, v := range f.ObjectMeta.Labels {
+		labels[k] = v
+	}
+	changed := false
+	for k, v := range map[string]string{"created-by": "kubeless", "function": f.ObjectMeta.Name} {
+		if _, ok := labels[k]; !ok && v != "" {
+			labels[k] = v
+			changed = true
+		}
+	}
+	if changed {
+		patch = append(patch, patchOperation{Op: "add", Path: "/metadata/labels", Value: labels})
+	}
+
+	svc := f.Spec.ServiceSpec.DeepCopy()
+	changed = false
+	if len(svc.Ports) == 0 {
+		svc.Ports = []v1.ServicePort{{Port: defaultFunctionPort}}
+		changed = true
+	}
+	for i := range svc.Ports {
+		port := &svc.Ports[i]
+		if i == 0 && utils.IsDefaultFunctionPortName(port.Name) && port.Name != utils.FunctionPortName(f.Spec.Protocol) {
+			port.Name = utils.FunctionPortName(f.Spec.Protocol)
+			changed = true
+		}
+		if port.Protocol == "" {
+			port.Protocol = v1.ProtocolTCP
+			changed = true
+		}
+		if port.Port == 0 {
+			port.Port = defaultFunctionPort
+			changed = true
+		}
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+			port.TargetPort = intstr.FromInt(int(port.Port))
+			changed = true
+		}
+	}
+	if len(svc.Selector) == 0 {
+		svc.Selector = labels
+		changed = true
+	}
+	if svc.Type == "" {
+		svc.Type = v1.ServiceTypeClusterIP
+		changed = true
+	}
+	if changed {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/service", Value: svc})
+	}
+	return patch
+}
+
+// formatErrors returns a message with all the errors of a function
+func formatErrors(errs field.ErrorList) string {
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("Invalid function: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/sirupsen/logrus"
+	admissionregistration "k8s.io/api/admissionregistration/v1beta1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ConfigurationName is the name of the webhook configurations included in the manifests
+	ConfigurationName   = "kubeless-function-webhook"
+	validateWebhookName = "validate.functions.kubeless.io"
+	mutateWebhookName   = "mutate.functions.kubeless.io"
+)
+
+// configureWebhooks points the webhooks with the given name to the service of the controller
+func configureWebhooks(webhooks []admissionregistration.Webhook, name, path, namespace, service string, caBundle []byte) {
+	for i := range webhooks {
+		if webhooks[i].Name != name {
+			continue
+		}
+		webhooks[i].ClientConfig.Service = &admissionregistration.ServiceReference{
+			Namespace: namespace,
+			Name:      service,
+			Path:      &path,
+		}
+		webhooks[i].ClientConfig.CABundle = caBundle
+	}
+}
+
+// RegisterWebhooks sets the CA bundle and the service of the webhook configurations. The
+// configurations are cluster-wide so they are not created by the controller: they are part of
+// the manifests and removed with them. Missing configurations are skipped.
+func RegisterWebhooks(client kubernetes.Interface, namespace, service string, caBundle []byte) error {
+	vClient := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	validating, err := vClient.Get(ConfigurationName, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		logrus.Warnf("The ValidatingWebhookConfiguration %s is not installed, functions are not validated on admission", ConfigurationName)
+	} else if err != nil {
+		return err
+	} else {
+		configureWebhooks(validating.Webhooks, validateWebhookName, ValidatePath, namespace, service, caBundle)
+		if _, err := vClient.Update(validating); err != nil {
+			return err
+		}
+	}
+
+	mClient := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	mutating, err := mClient.Get(ConfigurationName, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		logrus.Warnf("The MutatingWebhookConfiguration %s is not installed, defaults are applied by the controller", ConfigurationName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	configureWebhooks(mutating.Webhooks, mutateWebhookName, MutatePath, namespace, service, caBundle)
+	_, err = mClient.Update(mutating)
+	return err
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubelessv1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+)
+
+const (
+	// ValidatePath is the path of the validating webhook
+	ValidatePath = "/validate-function"
+	// MutatePath is the path of the defaulting webhook
+	MutatePath = "/mutate-function"
+)
+
+// Server handles the admission reviews of Function objects
+type Server struct {
+	langRuntime *langruntime.Langruntimes
+	logger      *logrus.Entry
+
+	certMutex sync.RWMutex
+	cert      *tls.Certificate
+}
+
+// NewServer returns a webhook server that validates functions using the given runtimes
+func NewServer(lr *langruntime.Langruntimes) *Server {
+	return &Server{
+		langRuntime: lr,
+		logger:      logrus.WithField("pkg", "webhook"),
+	}
+}
+
+// Handler returns the handler serving both webhooks
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, s.serve(s.validate))
+	mux.HandleFunc(MutatePath, s.serve(s.mutate))
+	mux.HandleFunc(ConvertPath, s.convert)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// SetCertificate replaces the certificate used by the server for new connections
+func (s *Server) SetCertificate(cert tls.Certificate) {
+	s.certMutex.Lock()
+	defer s.certMutex.Unlock()
+	s.cert = &cert
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMutex.RLock()
+	defer s.certMutex.RUnlock()
+	return s.cert, nil
+}
+
+// ListenAndServeTLS serves the webhooks in the given port using the certificate
+func (s *Server) ListenAndServeTLS(port int, cert tls.Certificate) error {
+	s.SetCertificate(cert)
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   s.Handler(),
+		TLSConfig: &tls.Config{GetCertificate: s.getCertificate},
+	}
+	s.logger.Infof("Serving admission webhooks on port %d", port)
+	return server.ListenAndServeTLS("", "")
+}
+
+func (s *Server) serve(review func(*AdmissionRequest) *AdmissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Expected a JSON body", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ar := AdmissionReview{}
+		if err := json.Unmarshal(body, &ar); err != nil || ar.Request == nil {
+			http.Error(w, fmt.Sprintf("Unable to parse the admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		res := review(ar.Request)
+		res.UID = ar.Request.UID
+		out, err := json.Marshal(AdmissionReview{TypeMeta: ar.TypeMeta, Response: res})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// decodeFunction returns the v1beta1 representation of the function of a request
+func decodeFunction(req *AdmissionRequest, raw []byte) (*kubelessApi.Function, error) {
+	if req.Kind.Version == kubelessv1.SchemeGroupVersion.Version {
+		f := &kubelessv1.Function{}
+		if err := json.Unmarshal(raw, f); err != nil {
+			return nil, err
+		}
+		return kubelessv1.ConvertToV1beta1(f)
+	}
+	f := &kubelessApi.Function{}
+	err := json.Unmarshal(raw, f)
+	return f, err
+}
+
+func (s *Server) validate(req *AdmissionRequest) *AdmissionResponse {
+	f, err := decodeFunction(req, req.Object.Raw)
+	if err != nil {
+		return deny(metav1.StatusReasonBadRequest, fmt.Sprintf("Unable to parse the function: %v", err))
+	}
+	if req.Operation == "UPDATE" && len(req.OldObject.Raw) > 0 {
+		old, err := decodeFunction(req, req.OldObject.Raw)
+		if err == nil && reflect.DeepEqual(old.Spec, f.Spec) {
+			// Objects stored before the webhook was enabled should not be blocked
+			// when only their metadata changes
+			return &AdmissionResponse{Allowed: true}
+		}
+	}
+	if errs := ValidateFunction(f, s.langRuntime); len(errs) > 0 {
+		s.logger.Infof("Rejected function %s/%s: %v", req.Namespace, f.ObjectMeta.Name, errs.ToAggregate())
+		return deny(metav1.StatusReasonInvalid, formatErrors(errs))
+	}
+	return &AdmissionResponse{Allowed: true}
+}
+
+func (s *Server) mutate(req *AdmissionRequest) *AdmissionResponse {
+	if req.Kind.Version == kubelessv1.SchemeGroupVersion.Version {
+		// The patch is only valid for v1beta1 objects. The controller applies the same defaults
+		return &AdmissionResponse{Allowed: true}
+	}
+	f := &kubelessApi.Function{}
+	if err := json.Unmarshal(req.Object.Raw, f); err != nil {
+		return deny(metav1.StatusReasonBadRequest, fmt.Sprintf("Unable to parse the function: %v", err))
+	}
+	patch := DefaultFunction(f)
+	if len(patch) == 0 {
+		return &AdmissionResponse{Allowed: true}
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return deny(metav1.StatusReasonInternalError, err.Error())
+	}
+	return &AdmissionResponse{Allowed: true, Patch: raw, PatchType: &patchTypeJSONPatch}
+}
+
+func deny(reason metav1.StatusReason, msg string) *AdmissionResponse {
+	code := int32(http.StatusBadRequest)
+	if reason == metav1.StatusReasonInvalid {
+		code = http.StatusUnprocessableEntity
+	} else if reason == metav1.StatusReasonInternalError {
+		code = http.StatusInternalServerError
+	}
+	return &AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: msg,
+			Reason:  reason,
+			Code:    code,
+		},
+	}
+}
@@ -0,0 +1,496 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	admissionregistration "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	fakeAPIExtensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakeDiscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	kubelessv1 "github.com/kubeless/kubeless/pkg/apis/kubeless/v1"
+	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/kubeless/pkg/langruntime"
+)
+
+func fakeLangRuntime() *langruntime.Langruntimes {
+	clientset := fake.NewSimpleClientset()
+	langruntime.AddFakeConfig(clientset)
+	lr := langruntime.SetupLangRuntime(clientset)
+	lr.ReadConfigMap()
+	return lr
+}
+
+func validFunction() *kubelessApi.Function {
+	return &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Handler:             "foo.bar",
+			Function:            "def bar(event, context): return 'hello'",
+			FunctionContentType: "text",
+			Checksum:            "sha256:d8f7c3e7ee1e4f9b2b5de22e5af4a4b0b0b4e8a3e4d2bb8e1c7e1c3f2f3e1b5a",
+			Runtime:             "python2.7",
+			Timeout:             "180",
+		},
+	}
+}
+
+func TestValidateFunction(t *testing.T) {
+	lr := fakeLangRuntime()
+	tests := []struct {
+		name   string
+		update func(f *kubelessApi.Function)
+		field  string
+	}{
+		{"valid", func(f *kubelessApi.Function) {}, ""},
+		{"unknown runtime", func(f *kubelessApi.Function) { f.Spec.Runtime = "cobol1" }, "spec.runtime"},
+		{"missing runtime", func(f *kubelessApi.Function) { f.Spec.Runtime = "" }, "spec.runtime"},
+		{"custom image", func(f *kubelessApi.Function) {
+			f.Spec.Runtime = ""
+			f.Spec.Deployment.Spec.Template.Spec.Containers = []v1.Container{{Image: "my-runtime"}}
+		}, ""},
+		{"wrong handler", func(f *kubelessApi.Function) { f.Spec.Handler = "foo" }, "spec.handler"},
+		{"wrong timeout", func(f *kubelessApi.Function) { f.Spec.Timeout = "-1" }, "spec.timeout"},
+		{"zip content", func(f *kubelessApi.Function) { f.Spec.FunctionContentType = "base64+zip" }, ""},
+		{"wrong content type", func(f *kubelessApi.Function) { f.Spec.FunctionContentType = "tar" }, "spec.function-content-type"},
+		{"wrong checksum", func(f *kubelessApi.Function) { f.Spec.Checksum = "md5:1234" }, "spec.checksum"},
+		{"wrong idle timeout", func(f *kubelessApi.Function) { f.Spec.IdleTimeout = "10" }, "spec.idleTimeout"},
+		{"wrong backoff", func(f *kubelessApi.Function) {
+			f.Spec.RetryPolicy = &kubelessApi.FunctionRetryPolicy{Backoff: "soon"}
+		}, "spec.retryPolicy.backoff"},
+		{"wrong destination", func(f *kubelessApi.Function) {
+			f.Spec.OnFailure = &kubelessApi.FunctionDestination{Type: kubelessApi.DestinationHTTP}
+		}, "spec.onFailure"},
+		{"grpc protocol", func(f *kubelessApi.Function) { f.Spec.Protocol = kubelessApi.ProtocolGRPC }, ""},
+		{"wrong protocol", func(f *kubelessApi.Function) { f.Spec.Protocol = "smtp" }, "spec.protocol"},
+		{"zero port", func(f *kubelessApi.Function) {
+			f.Spec.ServiceSpec.Ports = []v1.ServicePort{{Name: "http-function-port", Port: 0}}
+		}, "spec.service.ports[0].port"},
+		{"wrong concurrency", func(f *kubelessApi.Function) { f.Spec.ContainerConcurrency = -1 }, "spec.containerConcurrency"},
+		{"wrong request size", func(f *kubelessApi.Function) {
+			size := resource.MustParse("-1Mi")
+			f.Spec.Payload = &kubelessApi.FunctionPayload{MaxRequestSize: &size}
+		}, "spec.payload.maxRequestSize"},
+		{"wrong request schema", func(f *kubelessApi.Function) {
+			f.Spec.Payload = &kubelessApi.FunctionPayload{RequestSchema: &runtime.RawExtension{Raw: []byte(`{"type": "text"}`)}}
+		}, "spec.payload.requestSchema"},
+		{"wrong canary", func(f *kubelessApi.Function) {
+			f.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 100, Spec: f.Spec}
+			f.Spec.Canary.Spec.Runtime = "cobol1"
+		}, "spec.canary.spec.runtime"},
+		{"canary weight without enough replicas", func(f *kubelessApi.Function) {
+			f.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 10, Spec: f.Spec}
+		}, "spec.canary.weight"},
+		{"canary weight", func(f *kubelessApi.Function) {
+			replicas := int32(10)
+			f.Spec.Deployment.Spec.Replicas = &replicas
+			f.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 10, Spec: f.Spec}
+		}, ""},
+		{"canary with autoscaler", func(f *kubelessApi.Function) {
+			f.Spec.HorizontalPodAutoscaler.Name = "foo"
+			f.Spec.HorizontalPodAutoscaler.Spec.Metrics = []v2beta1.MetricSpec{{Type: v2beta1.ResourceMetricSourceType}}
+			f.Spec.Canary = &kubelessApi.FunctionCanary{Weight: 100, Spec: f.Spec}
+			f.Spec.Canary.Spec.HorizontalPodAutoscaler = v2beta1.HorizontalPodAutoscaler{}
+		}, "spec.canary"},
+	}
+	for _, tt := range tests {
+		f := validFunction()
+		tt.update(f)
+		errs := ValidateFunction(f, lr)
+		if tt.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("%s: unexpected errors %v", tt.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%s: expecting an error in %s, got %v", tt.name, tt.field, errs)
+		}
+	}
+}
+
+func TestDefaultFunction(t *testing.T) {
+	f := validFunction()
+	patch := DefaultFunction(f)
+	if len(patch) != 2 {
+		t.Fatalf("Expecting a patch for the labels and the service, got %v", patch)
+	}
+	labels := patch[0].Value.(map[string]string)
+	if patch[0].Path != "/metadata/labels" || labels["function"] != "foo" || labels["created-by"] != "kubeless" {
+		t.Errorf("Unexpected labels patch %v", patch[0])
+	}
+	svc := patch[1].Value.(*v1.ServiceSpec)
+	if patch[1].Path != "/spec/service" || len(svc.Ports) != 1 {
+		t.Fatalf("Unexpected service patch %v", patch[1])
+	}
+	port := svc.Ports[0]
+	if port.Name != "http-function-port" || port.Port != 8080 || port.TargetPort.IntVal != 8080 || port.Protocol != v1.ProtocolTCP {
+		t.Errorf("Unexpected default port %v", port)
+	}
+	if svc.Type != v1.ServiceTypeClusterIP || svc.Selector["function"] != "foo" {
+		t.Errorf("Unexpected default service %v", svc)
+	}
+
+	// A function with the defaults doesn't need to be patched
+	f.ObjectMeta.Labels = labels
+	f.Spec.ServiceSpec = *svc
+	if patch := DefaultFunction(f); len(patch) != 0 {
+		t.Errorf("Expecting an empty patch, got %v", patch)
+	}
+
+	// Custom ports are kept
+	f = validFunction()
+	f.Spec.ServiceSpec.Ports = []v1.ServicePort{{Name: "custom", Port: 9090}}
+	patch = DefaultFunction(f)
+	port = patch[1].Value.(*v1.ServiceSpec).Ports[0]
+	if port.Name != "custom" || port.Port != 9090 || port.TargetPort.IntVal != 9090 {
+		t.Errorf("Unexpected port %v", port)
+	}
+
+	// The default port of a gRPC function is named after its protocol
+	f = validFunction()
+	f.Spec.Protocol = kubelessApi.ProtocolGRPC
+	f.Spec.ServiceSpec.Ports = []v1.ServicePort{{Name: "http-function-port", Port: 8080}}
+	patch = DefaultFunction(f)
+	port = patch[1].Value.(*v1.ServiceSpec).Ports[0]
+	if port.Name != "grpc-function-port" {
+		t.Errorf("Unexpected port %v", port)
+	}
+}
+
+func review(t *testing.T, ts *httptest.Server, path, operation string, f, old *kubelessApi.Function) *AdmissionResponse {
+	req := &AdmissionRequest{UID: "1234", Operation: operation}
+	raw, _ := json.Marshal(f)
+	req.Object = runtime.RawExtension{Raw: raw}
+	if old != nil {
+		raw, _ = json.Marshal(old)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	body, _ := json.Marshal(AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request:  req,
+	})
+	res, err := http.Post(ts.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", res.StatusCode)
+	}
+	ar := AdmissionReview{}
+	if err := json.NewDecoder(res.Body).Decode(&ar); err != nil {
+		t.Fatal(err)
+	}
+	if ar.Kind != "AdmissionReview" || ar.Response == nil || ar.Response.UID != "1234" {
+		t.Fatalf("Unexpected review %v", ar)
+	}
+	return ar.Response
+}
+
+func TestServer(t *testing.T) {
+	ts := httptest.NewServer(NewServer(fakeLangRuntime()).Handler())
+	defer ts.Close()
+
+	if res := review(t, ts, ValidatePath, "CREATE", validFunction(), nil); !res.Allowed {
+		t.Errorf("Expecting the function to be allowed, got %v", res.Result)
+	}
+
+	invalid := validFunction()
+	invalid.Spec.Runtime = "cobol1"
+	res := review(t, ts, ValidatePath, "CREATE", invalid, nil)
+	if res.Allowed || res.Result.Code != http.StatusUnprocessableEntity || !strings.Contains(res.Result.Message, "spec.runtime") {
+		t.Errorf("Expecting the function to be rejected, got %v", res.Result)
+	}
+
+	// Functions stored before enabling the webhook can be updated if the spec doesn't change
+	invalid.ObjectMeta.Labels = map[string]string{"foo": "bar"}
+	if res := review(t, ts, ValidatePath, "UPDATE", invalid, invalid); !res.Allowed {
+		t.Errorf("Expecting the update to be allowed, got %v", res.Result)
+	}
+
+	res = review(t, ts, MutatePath, "CREATE", validFunction(), nil)
+	if !res.Allowed || res.PatchType == nil || *res.PatchType != "JSONPatch" {
+		t.Fatalf("Unexpected response %v", res)
+	}
+	patch := []patchOperation{}
+	if err := json.Unmarshal(res.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 2 || patch[1].Path != "/spec/service" {
+		t.Errorf("Unexpected patch %s", res.Patch)
+	}
+
+	r, err := http.Post(ts.URL+ValidatePath, "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expecting a bad request, got %d", r.StatusCode)
+	}
+}
+
+func TestEnsureCertificate(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	cert, caBundle, err := EnsureCertificate(clientset, "kubeless", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) == 0 || len(caBundle) == 0 {
+		t.Fatal("Expecting a certificate")
+	}
+	secret, err := clientset.CoreV1().Secrets("kubeless").Get(CertSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data[v1.TLSCertKey], caBundle) {
+		t.Error("The certificate should be stored in the secret")
+	}
+
+	// The stored certificate is reused
+	cert2, caBundle2, err := EnsureCertificate(clientset, "kubeless", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(caBundle, caBundle2) || !bytes.Equal(cert.Certificate[0], cert2.Certificate[0]) {
+		t.Error("Expecting the same certificate")
+	}
+
+	// An invalid certificate is replaced
+	secret.Data[v1.TLSCertKey] = []byte("foo")
+	clientset.CoreV1().Secrets("kubeless").Update(secret)
+	_, caBundle3, err := EnsureCertificate(clientset, "kubeless", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(caBundle3, []byte("foo")) || bytes.Equal(caBundle3, caBundle) {
+		t.Error("Expecting a new certificate")
+	}
+}
+
+func TestRenewCertificate(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	_, caBundle, err := EnsureCertificate(clientset, "kubeless", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The certificate stored in the secret replaces the one in use
+	renewed := make(chan []byte, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go RenewCertificate(clientset, "kubeless", "webhook", []byte("old"), time.Millisecond, stopCh, func(cert tls.Certificate, ca []byte) {
+		select {
+		case renewed <- ca:
+		default:
+		}
+	})
+	select {
+	case ca := <-renewed:
+		if !bytes.Equal(ca, caBundle) {
+			t.Error("Expecting the stored certificate")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The certificate was not renewed")
+	}
+}
+
+func TestRegisterWebhooks(t *testing.T) {
+	failurePolicy := admissionregistration.Fail
+	service := &admissionregistration.ServiceReference{Namespace: "kubeless", Name: "kubeless-controller-webhook"}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"foo": "bar"}}
+	clientset := fake.NewSimpleClientset(
+		&admissionregistration.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: ConfigurationName},
+			Webhooks: []admissionregistration.Webhook{{
+				Name:              validateWebhookName,
+				ClientConfig:      admissionregistration.WebhookClientConfig{Service: service},
+				FailurePolicy:     &failurePolicy,
+				NamespaceSelector: selector,
+			}},
+		},
+		&admissionregistration.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: ConfigurationName},
+			Webhooks: []admissionregistration.Webhook{{
+				Name:         mutateWebhookName,
+				ClientConfig: admissionregistration.WebhookClientConfig{Service: service},
+			}},
+		},
+	)
+	for _, ca := range []string{"ca1", "ca2"} {
+		if err := RegisterWebhooks(clientset, "kubeless", "webhook", []byte(ca)); err != nil {
+			t.Fatal(err)
+		}
+		validating, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ConfigurationName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := validating.Webhooks[0]
+		if string(w.ClientConfig.CABundle) != ca || *w.ClientConfig.Service.Path != ValidatePath || w.ClientConfig.Service.Namespace != "kubeless" || w.ClientConfig.Service.Name != "webhook" {
+			t.Errorf("Unexpected validating webhook %v", w)
+		}
+		// The rest of the configuration is kept
+		if *w.FailurePolicy != admissionregistration.Fail || !reflect.DeepEqual(w.NamespaceSelector, selector) {
+			t.Errorf("Unexpected validating webhook %v", w)
+		}
+		mutating, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(ConfigurationName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = mutating.Webhooks[0]
+		if string(w.ClientConfig.CABundle) != ca || *w.ClientConfig.Service.Path != MutatePath {
+			t.Errorf("Unexpected mutating webhook %v", w)
+		}
+	}
+
+	// The configurations are not created by the controller
+	clientset = fake.NewSimpleClientset()
+	if err := RegisterWebhooks(clientset, "kubeless", "webhook", []byte("ca")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(ConfigurationName, metav1.GetOptions{}); err == nil {
+		t.Error("The validating webhook configuration should not be created")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ts := httptest.NewServer(NewServer(fakeLangRuntime()).Handler())
+	defer ts.Close()
+
+	f := validFunction()
+	f.TypeMeta = metav1.TypeMeta{APIVersion: "kubeless.io/v1beta1", Kind: "Function"}
+	raw, _ := json.Marshal(f)
+	convert := func(raw []byte, apiVersion string) *ConversionResponse {
+		body, _ := json.Marshal(ConversionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1beta1", Kind: "ConversionReview"},
+			Request:  &ConversionRequest{UID: "1234", DesiredAPIVersion: apiVersion, Objects: []runtime.RawExtension{{Raw: raw}}},
+		})
+		res, err := http.Post(ts.URL+ConvertPath, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		review := ConversionReview{}
+		if err := json.NewDecoder(res.Body).Decode(&review); err != nil {
+			t.Fatal(err)
+		}
+		if review.Kind != "ConversionReview" || review.Response == nil || review.Response.UID != "1234" {
+			t.Fatalf("Unexpected review %v", review)
+		}
+		return review.Response
+	}
+
+	res := convert(raw, "kubeless.io/v1")
+	if res.Result.Status != metav1.StatusSuccess || len(res.ConvertedObjects) != 1 {
+		t.Fatalf("Unexpected response %v", res)
+	}
+	v1Function := &kubelessv1.Function{}
+	if err := json.Unmarshal(res.ConvertedObjects[0].Raw, v1Function); err != nil {
+		t.Fatal(err)
+	}
+	if v1Function.APIVersion != "kubeless.io/v1" || v1Function.Spec.Source.Type != kubelessv1.SourceInline || *v1Function.Spec.TimeoutSeconds != 180 {
+		t.Errorf("Unexpected function %v", v1Function)
+	}
+
+	res = convert(res.ConvertedObjects[0].Raw, "kubeless.io/v1beta1")
+	back := &kubelessApi.Function{}
+	if err := json.Unmarshal(res.ConvertedObjects[0].Raw, back); err != nil {
+		t.Fatal(err)
+	}
+	if back.APIVersion != "kubeless.io/v1beta1" || !reflect.DeepEqual(back.Spec, f.Spec) {
+		t.Errorf("Expecting %v, got %v", f.Spec, back.Spec)
+	}
+
+	res = convert(raw, "kubeless.io/v2")
+	if res.Result.Status != metav1.StatusFailure || len(res.ConvertedObjects) != 0 {
+		t.Errorf("Expecting the conversion to fail, got %v", res)
+	}
+}
+
+func TestValidateV1Function(t *testing.T) {
+	f, err := kubelessv1.ConvertFromV1beta1(validFunction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Spec.Runtime = "cobol1"
+	raw, _ := json.Marshal(f)
+	req := &AdmissionRequest{
+		UID:       "1234",
+		Kind:      metav1.GroupVersionKind{Group: "kubeless.io", Version: "v1", Kind: "Function"},
+		Operation: "CREATE",
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+	res := NewServer(fakeLangRuntime()).validate(req)
+	if res.Allowed || !strings.Contains(res.Result.Message, "spec.runtime") {
+		t.Errorf("Expecting the function to be rejected, got %v", res.Result)
+	}
+	// Defaults are only applied to v1beta1 objects
+	if res := NewServer(fakeLangRuntime()).mutate(req); !res.Allowed || res.Patch != nil {
+		t.Errorf("Unexpected response %v", res)
+	}
+}
+
+func TestRegisterConversion(t *testing.T) {
+	clientset := fakeAPIExtensions.NewSimpleClientset(&v1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "functions.kubeless.io"},
+	})
+	discovery := clientset.Discovery().(*fakeDiscovery.FakeDiscovery)
+
+	// Older clusters keep the CRD of the manifests
+	discovery.FakedServerVersion = &version.Info{Major: "1", Minor: "14+"}
+	if err := RegisterConversion(clientset, "kubeless", "webhook", []byte("ca")); err == nil {
+		t.Error("Expecting an error in a cluster without conversion webhooks")
+	}
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Fatalf("The CRD should not be modified, got %v", actions)
+	}
+
+	discovery.FakedServerVersion = &version.Info{Major: "1", Minor: "15+"}
+	if err := RegisterConversion(clientset, "kubeless", "webhook", []byte("ca")); err != nil {
+		t.Fatal(err)
+	}
+	actions := clientset.Actions()
+	if len(actions) != 1 || actions[0].GetVerb() != "patch" {
+		t.Fatalf("Expecting the CRD to be patched, got %v", actions)
+	}
+	patch := string(actions[0].(ktesting.PatchAction).GetPatch())
+	for _, expected := range []string{`"path":"/convert-function"`, `"caBundle":"Y2E="`, `"preserveUnknownFields":false`, `{"name":"v1","served":true,"storage":false}`} {
+		if !strings.Contains(patch, expected) {
+			t.Errorf("Expecting %s in the patch %s", expected, patch)
+		}
+	}
+}